@@ -6,6 +6,9 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,6 +20,7 @@ import (
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/store"
 	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
 	"blockwatch.cc/tzindex/etl"
 	"blockwatch.cc/tzindex/etl/index"
 	"blockwatch.cc/tzindex/etl/model"
@@ -49,6 +53,10 @@ var (
 var (
 	statedb store.DB
 	indexer *etl.Indexer
+	customs []model.BlockIndexer
+	params  *index.ParamIndex
+	fields  *index.BigmapFieldIndex
+	filter  *model.IndexFilter
 	cancel  context.CancelFunc
 	ctx     context.Context
 )
@@ -79,6 +87,10 @@ func openReadOnlyBlockchain() (*etl.Crawler, error) {
 	log.Infof("Using %s database %s", engine, pathname)
 	var err error
 
+	if err := loadIndexFilter(); err != nil {
+		return nil, err
+	}
+
 	statedb, err = store.Open(engine, filepath.Join(pathname, etl.StateDBName), DBOpts(engine, true, unsafe))
 	if err != nil {
 		return nil, fmt.Errorf("error opening %s database: %v", etl.StateDBName, err)
@@ -91,6 +103,7 @@ func openReadOnlyBlockchain() (*etl.Crawler, error) {
 		StateDB:   statedb,
 		Indexes:   enabledIndexes(),
 		LightMode: lightIndex,
+		Filter:    filter,
 	})
 
 	bc := etl.NewCrawler(etl.CrawlerConfig{
@@ -112,6 +125,10 @@ func openReadWriteBlockchain() (*etl.Crawler, error) {
 	log.Infof("Using %s database %s", engine, pathname)
 	var err error
 
+	if err := loadIndexFilter(); err != nil {
+		return nil, err
+	}
+
 	statedb, err = store.Open(engine, filepath.Join(pathname, etl.StateDBName), DBOpts(engine, false, unsafe))
 	if err != nil {
 		return nil, fmt.Errorf("error opening %s database: %v", etl.StateDBName, err)
@@ -124,6 +141,8 @@ func openReadWriteBlockchain() (*etl.Crawler, error) {
 		StateDB:   statedb,
 		Indexes:   enabledIndexes(),
 		LightMode: lightIndex,
+		Filter:    filter,
+		Retain:    config.GetInt64("crawler.retain_cycles"),
 	})
 
 	bc := etl.NewCrawler(etl.CrawlerConfig{
@@ -273,11 +292,14 @@ func parseRPCFlags() error {
 }
 
 func enabledIndexes() []model.BlockIndexer {
+	var idx []model.BlockIndexer
 	if lightIndex {
-		return []model.BlockIndexer{
+		idx = []model.BlockIndexer{
 			index.NewAccountIndex(tableOptions("account"), indexOptions("account")),
 			index.NewBalanceIndex(tableOptions("balance")),
+			index.NewDelegationIndex(tableOptions("delegation")),
 			index.NewContractIndex(tableOptions("contract"), indexOptions("contract")),
+			index.NewGasStatsIndex(tableOptions("gas_stats")),
 			index.NewStorageIndex(tableOptions("storage")),
 			index.NewConstantIndex(tableOptions("constant"), indexOptions("constant")),
 			index.NewBlockIndex(tableOptions("block")),
@@ -286,13 +308,18 @@ func enabledIndexes() []model.BlockIndexer {
 			index.NewChainIndex(tableOptions("chain")),
 			index.NewSupplyIndex(tableOptions("supply")),
 			index.NewBigmapIndex(tableOptions("bigmap")),
+			index.NewRollupIndex(tableOptions("rollup"), indexOptions("rollup")),
+			index.NewTicketIndex(tableOptions("ticket"), indexOptions("ticket")),
+			index.NewSaplingIndex(tableOptions("sapling")),
 			index.NewMetadataIndex(tableOptions("metadata"), indexOptions("metadata")),
 		}
 	} else {
-		return []model.BlockIndexer{
+		idx = []model.BlockIndexer{
 			index.NewAccountIndex(tableOptions("account"), indexOptions("account")),
 			index.NewBalanceIndex(tableOptions("balance")),
+			index.NewDelegationIndex(tableOptions("delegation")),
 			index.NewContractIndex(tableOptions("contract"), indexOptions("contract")),
+			index.NewGasStatsIndex(tableOptions("gas_stats")),
 			index.NewStorageIndex(tableOptions("storage")),
 			index.NewConstantIndex(tableOptions("constant"), indexOptions("constant")),
 			index.NewBlockIndex(tableOptions("block")),
@@ -305,7 +332,159 @@ func enabledIndexes() []model.BlockIndexer {
 			index.NewIncomeIndex(tableOptions("income")),
 			index.NewGovIndex(tableOptions("gov")),
 			index.NewBigmapIndex(tableOptions("bigmap")),
+			index.NewRollupIndex(tableOptions("rollup"), indexOptions("rollup")),
+			index.NewTicketIndex(tableOptions("ticket"), indexOptions("ticket")),
+			index.NewSaplingIndex(tableOptions("sapling")),
 			index.NewMetadataIndex(tableOptions("metadata"), indexOptions("metadata")),
 		}
 	}
+	// parameter, field and custom indexes must run after the op and bigmap indexes
+	if params != nil {
+		idx = append(idx, params)
+	}
+	if fields != nil {
+		idx = append(idx, fields)
+	}
+	return append(idx, customs...)
+}
+
+// replicaIndexes returns enabled indexes whose data is contained in the
+// change stream of a primary indexer.
+func replicaIndexes() []model.BlockIndexer {
+	var idx []model.BlockIndexer
+	for _, v := range enabledIndexes() {
+		if _, ok := v.(*index.CustomIndex); ok {
+			idx = append(idx, v)
+			continue
+		}
+		for _, key := range etl.ReplicaIndexes {
+			if v.Key() == key {
+				idx = append(idx, v)
+				break
+			}
+		}
+	}
+	return idx
+}
+
+// loadCustomIndexes reads user-defined contract indexes from config.
+func loadCustomIndexes() error {
+	// set a fallback type that's compatible with ForEach()
+	config.SetDefault("custom_indexes", []interface{}{})
+
+	// the parameter search index is enabled for selected contracts only
+	pf, err := readIndexFilter("param_index", "param index")
+	if err != nil {
+		return err
+	}
+	params = nil
+	if !pf.IsEmpty() {
+		params = index.NewParamIndex(pf, tableOptions("param"))
+		log.Infof("Registered %s index.", params.Key())
+	}
+
+	// the bigmap field search index is enabled for selected bigmaps only
+	fields = nil
+	if ids := config.GetInt64Slice("bigmap_index.bigmaps"); len(ids) > 0 {
+		fields = index.NewBigmapFieldIndex(ids, tableOptions("bigmap_field"))
+		log.Infof("Registered %s index.", fields.Key())
+	}
+
+	customs = customs[:0]
+	return config.ForEach("custom_indexes", func(c *config.Config) error {
+		name := c.GetString("name")
+		cfg := index.CustomIndexConfig{
+			Name:       name,
+			Bigmap:     c.GetString("bigmap"),
+			BigmapId:   c.GetInt64("bigmap_id"),
+			Entrypoint: c.GetString("entrypoint"),
+		}
+		var err error
+		if s := c.GetString("contract"); s != "" {
+			if cfg.Contract, err = tezos.ParseAddress(s); err != nil {
+				return fmt.Errorf("custom index %s: invalid contract: %w", name, err)
+			}
+		}
+		if s := c.GetString("code_hash"); s != "" {
+			if cfg.CodeHash, err = parseHash64(s); err != nil {
+				return fmt.Errorf("custom index %s: invalid code_hash: %w", name, err)
+			}
+		}
+		if s := c.GetString("iface_hash"); s != "" {
+			if cfg.IfaceHash, err = parseHash64(s); err != nil {
+				return fmt.Errorf("custom index %s: invalid iface_hash: %w", name, err)
+			}
+		}
+		buf, err := json.Marshal(c.GetInterface("columns"))
+		if err != nil {
+			return fmt.Errorf("custom index %s: reading columns: %w", name, err)
+		}
+		if err := json.Unmarshal(buf, &cfg.Columns); err != nil {
+			return fmt.Errorf("custom index %s: reading columns: %w", name, err)
+		}
+		idx, err := index.NewCustomIndex(cfg, tableOptions("custom"))
+		if err != nil {
+			return err
+		}
+		customs = append(customs, idx)
+		log.Infof("Registered %s index.", idx.Key())
+		return nil
+	})
+}
+
+// loadIndexFilter reads the optional account allow-list for selective indexing.
+func loadIndexFilter() error {
+	var err error
+	filter, err = readIndexFilter("crawler.filter", "index filter")
+	if err != nil {
+		return err
+	}
+	if !filter.IsEmpty() {
+		log.Infof("Using filtered mode for %d addresses, %d code hashes and %d interface hashes.",
+			len(filter.Addresses), len(filter.CodeHashes), len(filter.IfaceHashes))
+	}
+	return nil
+}
+
+// readIndexFilter reads addresses, code hashes and interface hashes below
+// config key prefix.
+func readIndexFilter(prefix, name string) (*model.IndexFilter, error) {
+	var (
+		addrs         []tezos.Address
+		codes, ifaces []uint64
+	)
+	for _, v := range config.GetStringSlice(prefix + ".addresses") {
+		a, err := tezos.ParseAddress(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid address %q: %w", name, v, err)
+		}
+		addrs = append(addrs, a)
+	}
+	for _, v := range config.GetStringSlice(prefix + ".code_hashes") {
+		h, err := parseHash64(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid code hash %q: %w", name, v, err)
+		}
+		codes = append(codes, h)
+	}
+	for _, v := range config.GetStringSlice(prefix + ".iface_hashes") {
+		h, err := parseHash64(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid interface hash %q: %w", name, v, err)
+		}
+		ifaces = append(ifaces, h)
+	}
+	return model.NewIndexFilter(addrs, codes, ifaces), nil
+}
+
+// parseHash64 decodes a hex encoded 8 byte code or interface hash.
+func parseHash64(s string) (uint64, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, fmt.Errorf("invalid length %d", len(buf))
+	}
+	return binary.BigEndian.Uint64(buf), nil
 }
@@ -23,6 +23,14 @@ func init() {
 	config.SetDefault("crawler.snapshot_path", "./db/snapshots/")
 	config.SetDefault("crawler.snapshot_blocks", nil)
 	config.SetDefault("crawler.snapshot_interval", 0)
+	config.SetDefault("crawler.retain_cycles", 0)
+	config.SetDefault("crawler.persist_caches", true)
+
+	// change data capture
+	config.SetDefault("cdc.enable", false)
+	config.SetDefault("cdc.sinks", nil)
+	config.SetDefault("cdc.retain_blocks", 0)
+	config.SetDefault("replica.primary", "")
 
 	// HTTP API server
 	config.SetDefault("server.addr", "127.0.0.1")
@@ -31,6 +39,7 @@ func init() {
 	config.SetDefault("server.host", "127.0.0.1")
 	config.SetDefault("server.workers", 64)
 	config.SetDefault("server.queue", 128)
+	config.SetDefault("server.max_streams", 16)
 	config.SetDefault("server.read_timeout", 5*time.Second)
 	config.SetDefault("server.header_timeout", 2*time.Second)
 	config.SetDefault("server.write_timeout", 90*time.Second)
@@ -41,6 +50,9 @@ func init() {
 	config.SetDefault("server.max_series_duration", 0)
 	config.SetDefault("server.max_explore_count", 100)
 	config.SetDefault("server.default_explore_count", 20)
+	config.SetDefault("server.max_batch_count", 500)
+	config.SetDefault("server.max_query_cost", 10000)
+	config.SetDefault("server.max_query_budget", 100000)
 	config.SetDefault("server.cors_enable", false)
 	config.SetDefault("server.cors_origin", "*")
 	config.SetDefault("server.cors_allow_headers", "Authorization, Accept, Content-Type, X-Api-Key, X-Requested-With")
@@ -52,6 +64,13 @@ func init() {
 	config.SetDefault("server.cache_expires", 30*time.Second)
 	config.SetDefault("server.cache_max", 24*time.Hour)
 
+	// health checks
+	config.SetDefault("health.max_block_lag", 2)
+	config.SetDefault("health.max_block_age", 5*time.Minute)
+	config.SetDefault("health.rpc_timeout", 2*time.Second)
+	config.SetDefault("health.max_queue_ratio", 0.9)
+	config.SetDefault("health.min_disk_free", 1<<30)
+
 	// logging
 	config.SetDefault("logging.progress", 10*time.Second)
 	config.SetDefault("logging.backend", "stdout")
@@ -12,11 +12,13 @@ import (
 	"blockwatch.cc/tzgo/rpc"
 	"blockwatch.cc/tzindex/etl"
 	"blockwatch.cc/tzindex/etl/cache"
+	"blockwatch.cc/tzindex/etl/cdc"
 	"blockwatch.cc/tzindex/etl/index"
 	"blockwatch.cc/tzindex/etl/metadata"
 	"blockwatch.cc/tzindex/etl/model"
 	"blockwatch.cc/tzindex/server"
 	"blockwatch.cc/tzindex/server/explorer"
+	"blockwatch.cc/tzindex/server/graphql"
 	"blockwatch.cc/tzindex/server/series"
 	"blockwatch.cc/tzindex/server/system"
 	"blockwatch.cc/tzindex/server/tables"
@@ -44,11 +46,13 @@ func init() {
 	model.UseLogger(blocLog)
 	index.UseLogger(blocLog)
 	metadata.UseLogger(blocLog)
+	cdc.UseLogger(blocLog)
 	store.UseLogger(dataLog)
 	pack.UseLogger(dataLog)
 	rpc.UseLogger(jrpcLog)
 	server.UseLogger(srvrLog)
 	explorer.UseLogger(srvrLog)
+	graphql.UseLogger(srvrLog)
 	tables.UseLogger(srvrLog)
 	series.UseLogger(srvrLog)
 	system.UseLogger(srvrLog)
@@ -97,11 +101,13 @@ func initLogging() {
 	model.UseLogger(blocLog)
 	index.UseLogger(blocLog)
 	metadata.UseLogger(blocLog)
+	cdc.UseLogger(blocLog)
 	store.UseLogger(dataLog)
 	pack.UseLogger(dataLog)
 	rpc.UseLogger(jrpcLog)
 	server.UseLogger(srvrLog)
 	explorer.UseLogger(srvrLog)
+	graphql.UseLogger(srvrLog)
 	tables.UseLogger(srvrLog)
 	server.UseLogger(srvrLog)
 	system.UseLogger(srvrLog)
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -16,6 +17,7 @@ import (
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/store"
 	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/cdc"
 	"blockwatch.cc/tzindex/etl/metadata"
 	"blockwatch.cc/tzindex/rpc"
 	"blockwatch.cc/tzindex/server"
@@ -31,6 +33,7 @@ var (
 	noapi     bool
 	cors      bool
 	stop      int64
+	primary   string
 
 	validate bool
 )
@@ -52,6 +55,7 @@ func init() {
 	serverCmd.Flags().BoolVar(&validate, "validate", false, "validate account balances")
 	serverCmd.Flags().Int64Var(&stop, "stop", 0, "stop indexing after `height`")
 	serverCmd.Flags().BoolVar(&cors, "enable-cors", false, "enable API CORS support")
+	serverCmd.Flags().StringVar(&primary, "replica", "", "follow primary indexer API at `url` as read-only replica")
 
 	rootCmd.AddCommand(serverCmd)
 }
@@ -81,6 +85,16 @@ func runServer(args []string) error {
 		return err
 	}
 
+	// load custom contract indexes
+	if err := loadCustomIndexes(); err != nil {
+		return err
+	}
+
+	// load selective indexing filter
+	if err := loadIndexFilter(); err != nil {
+		return err
+	}
+
 	engine := config.GetString("database.engine")
 	pathname := config.GetString("database.path")
 	log.Infof("Using %s database %s", engine, pathname)
@@ -112,6 +126,15 @@ func runServer(args []string) error {
 	}
 	defer statedb.Close()
 
+	// replicas follow a primary indexer instead of a Tezos node
+	if primary == "" {
+		primary = config.GetString("replica.primary")
+	}
+	if primary != "" {
+		log.Infof("Running as read-only replica of %s", primary)
+		norpc = true
+	}
+
 	// open RPC client when requested
 	var rpcclient *rpc.Client
 	if !norpc {
@@ -119,20 +142,58 @@ func runServer(args []string) error {
 		if err != nil {
 			return err
 		}
-	} else {
+	} else if primary == "" {
 		noindex = true
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// open change log and start sinks when enabled
+	var changes *cdc.Log
+	if config.GetBool("cdc.enable") && primary != "" {
+		log.Warnf("Change capture is not supported on replicas, ignoring cdc.enable")
+	} else if config.GetBool("cdc.enable") {
+		changes, err = cdc.Open(engine, filepath.Join(pathname, cdc.DBName), DBOpts(engine, false, unsafe))
+		if err != nil {
+			return fmt.Errorf("error opening %s database: %v", cdc.DBName, err)
+		}
+		defer changes.Close()
+		changes.SetRetention(config.GetInt64("cdc.retain_blocks"))
+		var wg sync.WaitGroup
+		pctx, pcancel := context.WithCancel(ctx)
+		defer func() {
+			pcancel()
+			wg.Wait()
+		}()
+		for _, addr := range config.GetStringSlice("cdc.sinks") {
+			sink, err := cdc.NewSink(addr)
+			if err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func(p *cdc.Pump) {
+				defer wg.Done()
+				p.Run(pctx)
+			}(cdc.NewPump(addr, changes, sink))
+		}
+	}
+
 	// enable index storage tables
+	indexes := enabledIndexes()
+	if primary != "" {
+		indexes = replicaIndexes()
+	}
 	indexer := etl.NewIndexer(etl.IndexerConfig{
 		DBPath:    pathname,
 		DBOpts:    DBOpts(engine, false, unsafe),
 		StateDB:   statedb,
-		Indexes:   enabledIndexes(),
+		Indexes:   indexes,
 		LightMode: lightIndex,
+		Filter:    filter,
+		Retain:    config.GetInt64("crawler.retain_cycles"),
+		Changes:   changes,
+		Persist:   config.GetBool("crawler.persist_caches"),
 	})
 	defer indexer.Close()
 
@@ -146,6 +207,7 @@ func runServer(args []string) error {
 		EnableMonitor: !nomonitor,
 		StopBlock:     stop,
 		Validate:      validate,
+		Primary:       primary,
 		Snapshot: &etl.SnapshotConfig{
 			Path:          config.GetString("crawler.snapshot_path"),
 			Blocks:        config.GetInt64Slice("crawler.snapshot_blocks"),
@@ -154,13 +216,20 @@ func runServer(args []string) error {
 	})
 	// not indexing means we do not auto-index, but allow access to
 	// existing indexes
-	if !noindex {
+	switch {
+	case primary != "":
+		if err := crawler.Init(ctx, etl.MODE_REPLICA); err != nil {
+			return fmt.Errorf("error initializing replica: %v", err)
+		}
+		crawler.Start()
+		defer crawler.Stop(ctx)
+	case !noindex:
 		if err := crawler.Init(ctx, etl.MODE_SYNC); err != nil {
 			return fmt.Errorf("error initializing crawler: %v", err)
 		}
 		crawler.Start()
 		defer crawler.Stop(ctx)
-	} else {
+	default:
 		if err := crawler.Init(ctx, etl.MODE_INFO); err != nil {
 			return fmt.Errorf("error initializing crawler: %v", err)
 		}
@@ -179,6 +248,7 @@ func runServer(args []string) error {
 				Host:                config.GetString("server.host"),
 				MaxWorkers:          config.GetInt("server.workers"),
 				MaxQueue:            config.GetInt("server.queue"),
+				MaxStreams:          config.GetInt("server.max_streams"),
 				ReadTimeout:         config.GetDuration("server.read_timeout"),
 				HeaderTimeout:       config.GetDuration("server.header_timeout"),
 				WriteTimeout:        config.GetDuration("server.write_timeout"),
@@ -188,6 +258,7 @@ func runServer(args []string) error {
 				MaxListCount:        config.GetUint("server.max_list_count"),
 				DefaultExploreCount: config.GetUint("server.default_explore_count"),
 				MaxExploreCount:     config.GetUint("server.max_explore_count"),
+				MaxBatchCount:       config.GetUint("server.max_batch_count"),
 				CorsEnable:          cors || config.GetBool("server.cors_enable"),
 				CorsOrigin:          config.GetString("server.cors_origin"),
 				CorsAllowHeaders:    config.GetString("server.cors_allow_headers"),
@@ -200,6 +271,16 @@ func runServer(args []string) error {
 				CacheExpires:        config.GetDuration("server.cache_expires"),
 				CacheMaxExpires:     config.GetDuration("server.cache_max"),
 				MaxSeriesDuration:   config.GetDuration("server.max_series_duration"),
+				MaxQueryCost:        config.GetInt64("server.max_query_cost"),
+				MaxQueryBudget:      config.GetInt64("server.max_query_budget"),
+			},
+			Health: server.HealthConfig{
+				MaxBlockLag:   config.GetInt64("health.max_block_lag"),
+				MaxBlockAge:   config.GetDuration("health.max_block_age"),
+				RpcTimeout:    config.GetDuration("health.rpc_timeout"),
+				MaxQueueRatio: config.GetFloat64("health.max_queue_ratio"),
+				MinDiskFree:   config.GetInt64("health.min_disk_free"),
+				DiskPath:      pathname,
 			},
 		})
 		if err != nil {
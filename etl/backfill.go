@@ -0,0 +1,502 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/store"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+// BackfillBatchSize is the max number of blocks replayed per index and call
+// to Backfill before progress is persisted.
+const BackfillBatchSize = 64
+
+// BackfillStatus reports progress of an index that is catching up.
+type BackfillStatus struct {
+	Index    string  `json:"index"`
+	Height   int64   `json:"height"`
+	Target   int64   `json:"target"`
+	Progress float64 `json:"progress"`
+}
+
+func (m *Indexer) canBackfill(key string) bool {
+	idx, err := m.Index(key)
+	if err != nil {
+		return false
+	}
+	_, ok := idx.(model.BlockBackfiller)
+	return ok
+}
+
+// NeedsBackfill returns true when at least one index is catching up.
+func (m *Indexer) NeedsBackfill() bool {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+	for _, v := range m.tips {
+		if v.Backfill {
+			return true
+		}
+	}
+	return false
+}
+
+// isBackfilling returns true when the index with key is catching up.
+func (m *Indexer) isBackfilling(key string) bool {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+	tip, ok := m.tips[key]
+	return ok && tip.Backfill
+}
+
+// BackfillStatus returns progress for all indexes that are catching up.
+func (m *Indexer) BackfillStatus() []BackfillStatus {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+	target := m.liveHeight()
+	list := make([]BackfillStatus, 0)
+	for _, idx := range m.indexes {
+		tip, ok := m.tips[idx.Key()]
+		if !ok || !tip.Backfill {
+			continue
+		}
+		s := BackfillStatus{
+			Index:  idx.Key(),
+			Height: tip.Height,
+			Target: target,
+		}
+		if target > 0 {
+			s.Progress = float64(tip.Height) / float64(target)
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// liveHeight returns the height of indexes that are not backfilled.
+// Must be called with bfmu held.
+func (m *Indexer) liveHeight() int64 {
+	var height int64
+	for _, v := range m.tips {
+		if !v.Backfill && v.Height > height {
+			height = v.Height
+		}
+	}
+	return height
+}
+
+// Backfill replays up to n stored blocks into each index that is catching up
+// and returns true when all indexes have reached the live indexes. The
+// backfill lock is only held while tips are read or updated, so live
+// indexes keep connecting blocks during replay. Live rollbacks skip indexes
+// that are catching up, instead each replayed block must extend the stored
+// chain. When a reorg breaks continuity, replayed blocks that are no longer
+// part of the stored chain are deleted and the backfill restarts from the
+// fork point.
+func (m *Indexer) Backfill(ctx context.Context, client *rpc.Client, n int) (bool, error) {
+	done := true
+	builder := newReplayBuilder(ctx, m, client)
+	for _, idx := range m.indexes {
+		key := idx.Key()
+		m.bfmu.Lock()
+		t, ok := m.tips[key]
+		if !ok || !t.Backfill {
+			m.bfmu.Unlock()
+			continue
+		}
+		tip := *t
+		live := m.liveHeight()
+		rollbacks := m.rollbacks
+		m.bfmu.Unlock()
+		bf := idx.(model.BlockBackfiller)
+
+		// skip history that has been pruned
+		if h := m.Horizon(); tip.Height+1 < h.Height {
+			tip.Height = h.Height - 1
+		}
+
+		// remember replayed hashes to find the fork point after a reorg
+		replayed := make(map[int64]tezos.BlockHash)
+		if tip.Hash != nil {
+			replayed[tip.Height] = *tip.Hash
+		}
+
+		var parent *model.Block
+		for i := 0; i < n && tip.Height < live; i++ {
+			block, err := m.loadReplayBlock(ctx, tip.Height+1, parent)
+			if err != nil && !errors.Is(err, index.ErrNoBlockEntry) {
+				return false, fmt.Errorf("%s backfill: loading block %d: %w", key, tip.Height+1, err)
+			}
+			if err != nil || !m.extendsReplay(block, parent, tip) {
+				log.Warnf("%s backfill: chain reorganized at height %d, restarting from fork point.", key, tip.Height+1)
+				if tip, err = m.rewindBackfill(ctx, idx, tip, replayed); err != nil {
+					return false, fmt.Errorf("%s backfill: rewind: %w", key, err)
+				}
+				break
+			}
+			if err := bf.BackfillBlock(ctx, block, builder); err != nil {
+				return false, fmt.Errorf("%s backfill: block %d: %w", key, block.Height, err)
+			}
+			cloned := block.Hash.Clone()
+			tip.Hash = &cloned
+			tip.Height = block.Height
+			replayed[block.Height] = block.Hash
+			parent = block
+			if interruptRequested(ctx) {
+				break
+			}
+		}
+
+		// persist index data before the tip moves
+		if err := idx.Flush(ctx); err != nil {
+			return false, err
+		}
+
+		// publish progress, live indexes may have moved on in the meantime
+		m.bfmu.Lock()
+		if m.rollbacks != rollbacks {
+			// a reorg during replay may have orphaned the last replayed
+			// blocks, verify the tip while live indexes are blocked
+			var err error
+			tip, err = m.rewindBackfill(ctx, idx, tip, replayed)
+			if err == nil {
+				err = idx.Flush(ctx)
+			}
+			if err != nil {
+				m.bfmu.Unlock()
+				return false, fmt.Errorf("%s backfill: rewind: %w", key, err)
+			}
+		}
+		if tip.Height >= m.liveHeight() {
+			tip.Backfill = false
+			log.Infof("Backfilling %s completed at height %d.", idx.Name(), tip.Height)
+		} else {
+			done = false
+		}
+		*t = tip
+		m.bfmu.Unlock()
+
+		err := m.statedb.Update(func(dbTx store.Tx) error {
+			return dbStoreIndexTip(dbTx, key, &tip)
+		})
+		if err != nil {
+			return false, err
+		}
+		if interruptRequested(ctx) {
+			return false, errInterruptRequested
+		}
+	}
+	return done, nil
+}
+
+// extendsReplay returns true when the stored block is a child of the last
+// replayed block.
+func (m *Indexer) extendsReplay(block, parent *model.Block, tip IndexTip) bool {
+	if parent != nil {
+		return block.ParentId == parent.RowId
+	}
+	if tip.Hash == nil || block.Parent == nil {
+		return true
+	}
+	return block.Parent.Hash.Equal(*tip.Hash)
+}
+
+// rewindBackfill deletes replayed blocks from idx until tip matches the
+// stored chain again and returns the tip at the fork point. Blocks below
+// the current batch were verified when they were published, so the stored
+// chain is trusted there.
+func (m *Indexer) rewindBackfill(ctx context.Context, idx model.BlockIndexer, tip IndexTip, replayed map[int64]tezos.BlockHash) (IndexTip, error) {
+	for tip.Height > 0 {
+		hash, err := m.BlockHashByHeight(ctx, tip.Height)
+		if err != nil && !errors.Is(err, index.ErrNoBlockEntry) {
+			return tip, err
+		}
+		if err == nil && tip.Hash != nil && hash.Equal(*tip.Hash) {
+			break
+		}
+		if err := idx.DeleteBlock(ctx, tip.Height); err != nil {
+			return tip, err
+		}
+		tip.Height--
+		prev, ok := replayed[tip.Height]
+		if !ok {
+			if prev, err = m.BlockHashByHeight(ctx, tip.Height); err != nil {
+				return tip, err
+			}
+		}
+		cloned := prev.Clone()
+		tip.Hash = &cloned
+		if !ok {
+			break
+		}
+	}
+	return tip, nil
+}
+
+// loadReplayBlock loads a stored block with its operations. Bigmap events
+// are restored from the bigmap update table when available.
+func (m *Indexer) loadReplayBlock(ctx context.Context, height int64, parent *model.Block) (*model.Block, error) {
+	block, err := m.BlockByHeight(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	if block.Params == nil {
+		block.Params = m.ParamsByHeight(height)
+	}
+	if parent == nil && height > 0 {
+		parent, err = m.BlockByHeight(ctx, height-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	block.Parent = parent
+	block.TZ = &rpc.Bundle{
+		Params: block.Params,
+		Cycle:  block.Cycle,
+	}
+
+	// load operations
+	if table, err := m.Table(index.OpTableKey); err == nil {
+		err = pack.NewQuery("etl.backfill.ops", table).
+			AndEqual("height", height).
+			Execute(ctx, &block.Ops)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if table, err := m.Table(index.EndorseOpTableKey); err == nil {
+		endorse := make([]*model.Endorsement, 0)
+		err = pack.NewQuery("etl.backfill.endorse", table).
+			AndEqual("height", height).
+			Execute(ctx, &endorse)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range endorse {
+			block.Ops = append(block.Ops, v.ToOp())
+		}
+	}
+	sort.Slice(block.Ops, func(i, j int) bool { return block.Ops[i].OpN < block.Ops[j].OpN })
+
+	// restore bigmap events
+	if table, err := m.Table(index.BigmapUpdateTableKey); err == nil && len(block.Ops) > 0 {
+		byId := make(map[model.OpID]*model.Op)
+		for _, op := range block.Ops {
+			byId[op.RowId] = op
+		}
+		upd := &model.BigmapUpdate{}
+		err = pack.NewQuery("etl.backfill.bigmap", table).
+			AndEqual("height", height).
+			Stream(ctx, func(r pack.Row) error {
+				if err := r.Decode(upd); err != nil {
+					return err
+				}
+				op, ok := byId[upd.OpId]
+				if !ok {
+					return nil
+				}
+				ev := upd.ToEvent()
+				if ev.Action == micheline.DiffActionRemove && len(upd.Key) > 0 {
+					ev.KeyHash = micheline.KeyHash(upd.Key)
+				}
+				op.BigmapEvents = append(op.BigmapEvents, ev)
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// replayBuilder implements model.BackfillBuilder on top of stored data.
+type replayBuilder struct {
+	ctx       context.Context
+	idx       *Indexer
+	rpc       *rpc.Client
+	accounts  map[model.AccountID]*model.Account
+	contracts map[model.AccountID]*model.Contract
+	selected  map[model.AccountID]bool
+}
+
+var _ model.BackfillBuilder = (*replayBuilder)(nil)
+
+func newReplayBuilder(ctx context.Context, m *Indexer, client *rpc.Client) *replayBuilder {
+	return &replayBuilder{
+		ctx:       ctx,
+		idx:       m,
+		rpc:       client,
+		accounts:  make(map[model.AccountID]*model.Account),
+		contracts: make(map[model.AccountID]*model.Contract),
+		selected:  make(map[model.AccountID]bool),
+	}
+}
+
+func (b *replayBuilder) RPC() *rpc.Client {
+	return b.rpc
+}
+
+// LoadReceipts matches stored operations to RPC operation contents. Stored
+// ops of one operation hash are ordered like contents, internal ops follow
+// their parent at an offset equal to their internal result position.
+func (b *replayBuilder) LoadReceipts(ctx context.Context, block *model.Block) error {
+	rb, err := b.rpc.GetBlock(ctx, block.Hash)
+	if err != nil {
+		return err
+	}
+	byHash := make(map[string]*rpc.Operation)
+	for _, ol := range rb.Operations {
+		for _, o := range ol {
+			byHash[o.Hash.String()] = o
+		}
+	}
+	var (
+		top  *model.Op
+		seen = make(map[string]int)
+	)
+	for _, op := range block.Ops {
+		if !op.Hash.IsValid() {
+			continue
+		}
+		h := op.Hash.String()
+		o, ok := byHash[h]
+		if !ok {
+			continue
+		}
+		var res rpc.OperationResult
+		if !op.IsInternal {
+			c := seen[h]
+			seen[h] = c + 1
+			if c >= len(o.Contents) {
+				top = nil
+				continue
+			}
+			top = op
+			op.OpC = c
+			op.Raw = o.Contents[c]
+			res = op.Raw.Result()
+		} else {
+			if top == nil || !top.Hash.Equal(op.Hash) {
+				continue
+			}
+			i := op.OpN - top.OpN - 1
+			meta := top.Raw.Meta()
+			if i < 0 || i >= len(meta.InternalResults) {
+				continue
+			}
+			op.OpC = top.OpC
+			op.OpI = i
+			op.Raw = top.Raw
+			res = meta.InternalResults[i].Result
+		}
+		op.TicketUpdates = res.TicketUpdates()
+		op.SaplingEvents = res.SaplingEvents()
+	}
+	return nil
+}
+
+func (b *replayBuilder) AccountByAddress(addr tezos.Address) (*model.Account, bool) {
+	acc, err := b.idx.LookupAccount(b.ctx, addr)
+	if err != nil {
+		return nil, false
+	}
+	b.accounts[acc.RowId] = acc
+	return acc, true
+}
+
+func (b *replayBuilder) AccountById(id model.AccountID) (*model.Account, bool) {
+	if acc, ok := b.accounts[id]; ok {
+		return acc, true
+	}
+	acc, err := b.idx.LookupAccountId(b.ctx, id)
+	if err != nil {
+		return nil, false
+	}
+	b.accounts[id] = acc
+	return acc, true
+}
+
+func (b *replayBuilder) BakerByAddress(addr tezos.Address) (*model.Baker, bool) {
+	bkr, err := b.idx.LookupBaker(b.ctx, addr)
+	if err != nil {
+		return nil, false
+	}
+	return bkr, true
+}
+
+func (b *replayBuilder) BakerById(id model.AccountID) (*model.Baker, bool) {
+	bkr, err := b.idx.LookupBakerId(b.ctx, id)
+	if err != nil {
+		return nil, false
+	}
+	return bkr, true
+}
+
+func (b *replayBuilder) ContractById(id model.AccountID) (*model.Contract, bool) {
+	if c, ok := b.contracts[id]; ok {
+		return c, c != nil
+	}
+	c, err := b.idx.LookupContractId(b.ctx, id)
+	if err != nil {
+		b.contracts[id] = nil
+		return nil, false
+	}
+	b.contracts[id] = c
+	return c, true
+}
+
+func (b *replayBuilder) Accounts() map[model.AccountID]*model.Account {
+	return map[model.AccountID]*model.Account{}
+}
+
+func (b *replayBuilder) Bakers() map[model.AccountID]*model.Baker {
+	return map[model.AccountID]*model.Baker{}
+}
+
+func (b *replayBuilder) Contracts() map[model.AccountID]*model.Contract {
+	return map[model.AccountID]*model.Contract{}
+}
+
+func (b *replayBuilder) Constants() micheline.ConstantDict {
+	return nil
+}
+
+func (b *replayBuilder) Params(height int64) *tezos.Params {
+	return b.idx.ParamsByHeight(height)
+}
+
+func (b *replayBuilder) Table(key string) (*pack.Table, error) {
+	return b.idx.Table(key)
+}
+
+func (b *replayBuilder) IsLightMode() bool {
+	return b.idx.lightMode
+}
+
+func (b *replayBuilder) IsFiltered() bool {
+	return b.idx.IsFiltered()
+}
+
+func (b *replayBuilder) IsSelected(id model.AccountID) bool {
+	if !b.idx.IsFiltered() {
+		return true
+	}
+	if sel, ok := b.selected[id]; ok {
+		return sel
+	}
+	acc, _ := b.AccountById(id)
+	c, _ := b.ContractById(id)
+	sel := b.idx.filter.Match(acc, c)
+	b.selected[id] = sel
+	return sel
+}
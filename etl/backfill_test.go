@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+// deleteRecorder is an index that records deleted heights.
+type deleteRecorder struct {
+	model.BlockIndexer
+	key     string
+	deleted []int64
+}
+
+func (r *deleteRecorder) Key() string { return r.key }
+
+func (r *deleteRecorder) DeleteBlock(_ context.Context, height int64) error {
+	r.deleted = append(r.deleted, height)
+	return nil
+}
+
+func testBlockHash(n byte) tezos.BlockHash {
+	buf := make([]byte, 32)
+	buf[0] = n
+	return tezos.NewBlockHash(buf)
+}
+
+// TestRewindBackfill checks that a backfilled index is rewound to the fork
+// point when replayed blocks are no longer part of the stored chain.
+func TestRewindBackfill(t *testing.T) {
+	ctx := context.Background()
+	blocks := index.NewBlockIndex(pack.Options{})
+	dir := t.TempDir()
+	if err := blocks.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocks.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { blocks.Close() })
+	m := &Indexer{tables: make(map[string]*pack.Table)}
+	for _, v := range blocks.Tables() {
+		m.tables[v.Name()] = v
+	}
+
+	// stored chain 1-5, test cases replay forks on top of it
+	ins := make([]pack.Item, 0)
+	for h := int64(1); h <= 5; h++ {
+		ins = append(ins, &model.Block{Height: h, Hash: testBlockHash(byte(h))})
+	}
+	if err := m.tables[index.BlockTableKey].Insert(ctx, ins); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		replayed []byte // hash seeds of replayed blocks from height 2
+		deleted  []int64
+		want     int64
+	}{
+		{"consistent", []byte{2, 3}, nil, 3},
+		{"fork in batch", []byte{2, 3, 40, 50}, []int64{5, 4}, 3},
+		{"missing block", []byte{2, 3, 40, 50, 60}, []int64{6, 5, 4}, 3},
+		{"fork below batch", []byte{20}, []int64{2}, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &deleteRecorder{key: "test"}
+			replayed := make(map[int64]tezos.BlockHash)
+			for i, v := range tc.replayed {
+				replayed[int64(i+2)] = testBlockHash(v)
+			}
+			height := int64(len(tc.replayed) + 1)
+			hash := replayed[height]
+			tip, err := m.rewindBackfill(ctx, r, IndexTip{Hash: &hash, Height: height, Backfill: true}, replayed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.deleted, tc.deleted) {
+				t.Errorf("deleted %v, want %v", r.deleted, tc.deleted)
+			}
+			if tip.Height != tc.want || !tip.Hash.Equal(testBlockHash(byte(tc.want))) {
+				t.Errorf("got tip %d %s, want %d", tip.Height, tip.Hash, tc.want)
+			}
+		})
+	}
+}
+
+// TestRollbackSkipsBackfill checks that live rollbacks leave indexes that are
+// catching up alone.
+func TestRollbackSkipsBackfill(t *testing.T) {
+	hash := testBlockHash(5)
+	live := &deleteRecorder{key: "live"}
+	bf := &deleteRecorder{key: "backfill"}
+	m := &Indexer{
+		indexes: []model.BlockIndexer{live, bf},
+		tips: map[string]*IndexTip{
+			"live":     {Hash: &hash, Height: 5},
+			"backfill": {Hash: &hash, Height: 5, Backfill: true},
+		},
+	}
+	tz := &rpc.Bundle{Block: &rpc.Block{Header: rpc.BlockHeader{Level: 5, Predecessor: testBlockHash(4)}}}
+	if err := m.DeleteBlock(context.Background(), tz); err != nil {
+		t.Fatal(err)
+	}
+	if len(live.deleted) != 1 || len(bf.deleted) != 0 {
+		t.Errorf("deleted live=%v backfill=%v, want live only", live.deleted, bf.deleted)
+	}
+	if m.tips["live"].Height != 4 || m.tips["backfill"].Height != 5 {
+		t.Errorf("got tips live=%d backfill=%d, want 4 5", m.tips["live"].Height, m.tips["backfill"].Height)
+	}
+	if m.rollbacks != 1 {
+		t.Errorf("got %d rollbacks, want 1", m.rollbacks)
+	}
+}
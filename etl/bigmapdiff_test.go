@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const testBigmapId = 1
+
+// bigmapTestOp is a single stored update, an empty key removes the bigmap.
+type bigmapTestOp struct {
+	height int64
+	key    string
+	value  int64 // < 0 removes the key
+}
+
+// openBigmapTestIndexer returns an indexer serving the tables of a fresh
+// bigmap index with an alloc at height 1 and ops stored in order.
+func openBigmapTestIndexer(t *testing.T, ops []bigmapTestOp) *Indexer {
+	t.Helper()
+	ctx := context.Background()
+	idx := index.NewBigmapIndex(pack.Options{})
+	dir := t.TempDir()
+	if err := idx.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	m := &Indexer{tables: make(map[string]*pack.Table)}
+	for _, v := range idx.Tables() {
+		m.tables[v.Name()] = v
+	}
+
+	alloc := &model.BigmapAlloc{BigmapId: testBigmapId, Height: 1}
+	if err := m.tables[index.BigmapAllocTableKey].Insert(ctx, alloc); err != nil {
+		t.Fatal(err)
+	}
+	ins := make([]pack.Item, 0, len(ops))
+	for _, op := range ops {
+		upd := &model.BigmapUpdate{
+			BigmapId: testBigmapId,
+			Action:   micheline.DiffActionUpdate,
+			Height:   op.height,
+		}
+		if op.key == "" {
+			upd.Action = micheline.DiffActionRemove
+		} else {
+			upd.Key, _ = micheline.NewString(op.key).MarshalBinary()
+			upd.KeyId = model.GetKeyId(testBigmapId, upd.GetKeyHash())
+			if op.value < 0 {
+				upd.Action = micheline.DiffActionRemove
+			} else {
+				upd.Value, _ = micheline.NewInt64(op.value).MarshalBinary()
+			}
+		}
+		ins = append(ins, upd)
+	}
+	if err := m.tables[index.BigmapUpdateTableKey].Insert(ctx, ins); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDiffBigmap(t *testing.T) {
+	ops := []bigmapTestOp{
+		{2, "a", 1},
+		{2, "b", 2},
+		{2, "c", 3},
+		{3, "d", 4},
+		// range starts after height 3
+		{4, "a", 10},  // changed
+		{4, "b", -1},  // removed
+		{5, "c", 30},  // changed back
+		{6, "c", 3},   // ..
+		{5, "e", 5},   // added
+		{6, "f", 6},   // added and removed again
+		{7, "f", -1},  // ..
+		{8, "g", 7},   // outside range
+		{8, "d", -1},  // outside range
+		{9, "", 0},    // bigmap removed outside range
+		{10, "h", 8},  // outside range
+		{10, "i", 99}, // outside range
+	}
+	m := openBigmapTestIndexer(t, ops)
+
+	type result struct {
+		key    string
+		old    int64 // -1 = added
+		action micheline.DiffAction
+	}
+	tests := []struct {
+		name         string
+		since, until int64
+		want         []result
+	}{
+		{"changes", 3, 7, []result{
+			{"a", 1, micheline.DiffActionUpdate},
+			{"b", 2, micheline.DiffActionRemove},
+			{"e", -1, micheline.DiffActionUpdate},
+		}},
+		{"bigmap removed", 7, 9, []result{ // g was added and removed again
+			{"d", 4, micheline.DiffActionRemove},
+			{"a", 10, micheline.DiffActionRemove}, // removed with bigmap
+			{"c", 3, micheline.DiffActionRemove},
+			{"e", 5, micheline.DiffActionRemove},
+		}},
+		{"empty range", 1, 1, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			items, err := m.DiffBigmap(context.Background(), ListRequest{
+				BigmapId: testBigmapId,
+				Since:    tc.since,
+				Until:    tc.until,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]result)
+			for _, v := range items {
+				var key string
+				prim := micheline.Prim{}
+				if err := prim.UnmarshalBinary(v.New.Key); err == nil {
+					key = prim.String
+				}
+				r := result{key: key, old: -1, action: v.New.Action}
+				if v.Old != nil {
+					old := micheline.Prim{}
+					old.UnmarshalBinary(v.Old.Value)
+					r.old = old.Int.Int64()
+				}
+				got[key] = r
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d keys %v, want %d", len(got), got, len(tc.want))
+			}
+			for _, w := range tc.want {
+				if g, ok := got[w.key]; !ok {
+					t.Errorf("key %s: missing", w.key)
+				} else if g != w {
+					t.Errorf("key %s: got %+v, want %+v", w.key, g, w)
+				}
+			}
+		})
+	}
+
+	// added keys have no known original value once history is pruned
+	t.Run("pruned", func(t *testing.T) {
+		m.horizon = PruneHorizon{Cycle: 1, Height: 2}
+		defer func() { m.horizon = PruneHorizon{} }()
+		_, err := m.DiffBigmap(context.Background(), ListRequest{BigmapId: testBigmapId, Since: 3, Until: 7})
+		if err != ErrPruned {
+			t.Errorf("got %v, want ErrPruned", err)
+		}
+	})
+
+	// every scanned row is charged
+	t.Run("cost", func(t *testing.T) {
+		errBudget := errors.New("budget")
+		var n int64
+		_, err := m.DiffBigmap(context.Background(), ListRequest{
+			BigmapId: testBigmapId,
+			Since:    3,
+			Until:    7,
+			Charge: func(c int64) error {
+				if n += c; n > 5 {
+					return errBudget
+				}
+				return nil
+			},
+		})
+		if err != errBudget {
+			t.Errorf("got %v, want budget error", err)
+		}
+	})
+}
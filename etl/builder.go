@@ -30,6 +30,7 @@ type Builder struct {
 	bakeRights      map[model.AccountID]*vec.BitSet
 	endorseRights   map[model.AccountID]*vec.BitSet
 	absentEndorsers map[model.AccountID]struct{}
+	selected        map[model.AccountID]bool // index filter decisions
 
 	// build state
 	validate bool
@@ -52,6 +53,7 @@ func NewBuilder(idx *Indexer, cachesz int, c *rpc.Client, validate bool) *Builde
 		bakeRights:      make(map[model.AccountID]*vec.BitSet),
 		endorseRights:   make(map[model.AccountID]*vec.BitSet),
 		absentEndorsers: make(map[model.AccountID]struct{}),
+		selected:        make(map[model.AccountID]bool),
 		validate:        validate,
 		rpc:             c,
 	}
@@ -65,6 +67,27 @@ func (b *Builder) IsLightMode() bool {
 	return b.idx.lightMode
 }
 
+func (b *Builder) IsFiltered() bool {
+	return !b.idx.filter.IsEmpty()
+}
+
+func (b *Builder) IsSelected(id model.AccountID) bool {
+	if b.idx.filter.IsEmpty() {
+		return true
+	}
+	if id == 0 {
+		return false
+	}
+	if ok, found := b.selected[id]; found {
+		return ok
+	}
+	acc, _ := b.AccountById(id)
+	con, _ := b.ContractById(id)
+	ok := b.idx.filter.Match(acc, con)
+	b.selected[id] = ok
+	return ok
+}
+
 func (b *Builder) ClearCache() {
 	b.accCache.Purge()
 }
@@ -370,6 +393,9 @@ func (b *Builder) Clean() {
 	for n, _ := range b.absentEndorsers {
 		delete(b.absentEndorsers, n)
 	}
+	for n, _ := range b.selected {
+		delete(b.selected, n)
+	}
 	// for n, _ := range b.conMap {
 	// 	delete(b.conMap, n)
 	// }
@@ -406,6 +432,7 @@ func (b *Builder) Purge() {
 	b.bakeRights = make(map[model.AccountID]*vec.BitSet)
 	b.endorseRights = make(map[model.AccountID]*vec.BitSet)
 	b.absentEndorsers = make(map[model.AccountID]struct{})
+	b.selected = make(map[model.AccountID]bool)
 
 	// free previous parent block
 	if b.parent != nil {
@@ -497,6 +524,9 @@ func (b *Builder) CleanReorg() {
 	for n, _ := range b.absentEndorsers {
 		delete(b.absentEndorsers, n)
 	}
+	for n, _ := range b.selected {
+		delete(b.selected, n)
+	}
 
 	for _, bkr := range b.bakerMap {
 		// reset flags
@@ -584,12 +614,22 @@ func (b *Builder) InitAccounts(ctx context.Context) error {
 					tx := op.(*rpc.Transaction)
 					addUnique(tx.Source)
 					addUnique(tx.Destination)
+					for _, v := range tx.Metadata.Result.TicketUpdates() {
+						for _, a := range v.Accounts() {
+							addUnique(a)
+						}
+					}
 					for _, res := range tx.Metadata.InternalResults {
 						addUnique(res.Destination)
 						addUnique(res.Delegate)
 						for _, v := range res.Result.OriginatedContracts {
 							addresses.AddUnique(v)
 						}
+						for _, v := range res.Result.TicketUpdates() {
+							for _, a := range v.Accounts() {
+								addUnique(a)
+							}
+						}
 					}
 
 				case tezos.OpTypeRegisterConstant:
@@ -628,7 +668,26 @@ func (b *Builder) InitAccounts(ctx context.Context) error {
 					if bal := tx.Metadata.Result.BalanceUpdates; len(bal) > 0 {
 						addUnique(bal[0].Address()) // offender
 					}
+					for _, v := range tx.Metadata.Result.TicketUpdates() {
+						for _, a := range v.Accounts() {
+							addUnique(a)
+						}
+					}
 					// log.Infof("Found %s in block %d", kind, b.block.Height)
+
+				case rpc.OpTypeSmartRollupOriginate,
+					rpc.OpTypeSmartRollupAddMessages,
+					rpc.OpTypeSmartRollupCement,
+					rpc.OpTypeSmartRollupPublish,
+					rpc.OpTypeSmartRollupRefute,
+					rpc.OpTypeSmartRollupTimeout,
+					rpc.OpTypeSmartRollupExecuteOutboxMessage,
+					rpc.OpTypeSmartRollupRecoverBond:
+					// smart rollups are not accounts, only collect stakers
+					for _, v := range op.(*rpc.SmartRollup).Accounts() {
+						addUnique(v)
+					}
+
 					// case tezos.OpTypeScruOriginate,
 					// 	tezos.OpTypeScruAdd_messages,
 					// 	tezos.OpTypeScruCement,
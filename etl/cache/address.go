@@ -6,6 +6,8 @@ package cache
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"sort"
 
 	"blockwatch.cc/packdb/pack"
@@ -163,3 +165,29 @@ func (c *AddressCache) Update(accounts map[model.AccountID]*model.Account) error
 	c.stats.CountInserts(int64(len(ins)))
 	return nil
 }
+
+// WriteTo writes a cache snapshot to w.
+func (c *AddressCache) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapWriter{w: w}
+	sw.bytes(c.hashes)
+	return sw.n, sw.err
+}
+
+// ReadFrom replaces cache contents with a snapshot read from r.
+func (c *AddressCache) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapReader{r: r}
+	n := sr.len(addrLen << 32)
+	if sr.err == nil && n%addrLen != 0 {
+		sr.err = fmt.Errorf("invalid address cache size %d", n)
+	}
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	hashes := NewAddressCache(n / addrLen).hashes[:n]
+	sr.read(hashes)
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	c.hashes = hashes
+	return sr.n, nil
+}
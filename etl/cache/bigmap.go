@@ -5,6 +5,7 @@ package cache
 
 import (
 	"context"
+	"io"
 	"sync/atomic"
 
 	"blockwatch.cc/packdb/cache/lru"
@@ -79,6 +80,34 @@ func (c BigmapCache) Stats() Stats {
 	return s
 }
 
+// WriteTo writes a cache snapshot to w.
+func (c *BigmapCache) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapWriter{w: w}
+	keys := c.cache.Keys()
+	sw.uint64(uint64(len(keys)))
+	for _, k := range keys {
+		val, _ := c.cache.Peek(k)
+		buf, _ := val.([]byte)
+		sw.uint64(uint64(k.(int64)))
+		sw.bytes(buf)
+	}
+	return sw.n, sw.err
+}
+
+// ReadFrom adds all entries from a snapshot read from r to the cache.
+func (c *BigmapCache) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapReader{r: r}
+	n := sr.len(1 << 24)
+	for i := 0; i < n && sr.err == nil; i++ {
+		b := &model.BigmapAlloc{BigmapId: int64(sr.uint64())}
+		b.Data = sr.bytes(1 << 24)
+		if sr.err == nil {
+			c.Add(b)
+		}
+	}
+	return sr.n, sr.err
+}
+
 type BigmapHistory struct {
 	BigmapId     int64
 	Height       int64
@@ -5,6 +5,8 @@ package cache
 
 import (
 	"context"
+	"encoding/binary"
+	"io"
 	"sort"
 	"time"
 
@@ -166,3 +168,40 @@ func (c *BlockCache) Update(block *model.Block) error {
 	c.stats.CountUpdates(1)
 	return nil
 }
+
+// WriteTo writes a cache snapshot to w.
+func (c *BlockCache) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapWriter{w: w}
+	times, hashes := c.times, c.hashes
+	buf := make([]byte, 4*len(times))
+	for i, v := range times {
+		binary.LittleEndian.PutUint32(buf[i*4:], v)
+	}
+	sw.uint64(uint64(len(times)))
+	sw.write(buf)
+	sw.write(hashes[:len(times)*blockHashLen])
+	return sw.n, sw.err
+}
+
+// ReadFrom replaces cache contents with a snapshot read from r.
+func (c *BlockCache) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapReader{r: r}
+	n := sr.len(1 << 30)
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	next := NewBlockCache(n)
+	buf := make([]byte, 4*n)
+	sr.read(buf)
+	times := next.times[:n]
+	for i := range times {
+		times[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	hashes := next.hashes[:n*blockHashLen]
+	sr.read(hashes)
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	c.times, c.hashes = times, hashes
+	return sr.n, nil
+}
@@ -4,6 +4,7 @@
 package cache
 
 import (
+    "io"
     "sync/atomic"
 
     "blockwatch.cc/packdb/cache/lru"
@@ -81,3 +82,45 @@ func (c ContractTypeCache) Stats() Stats {
     s.Bytes = c.size
     return s
 }
+
+// WriteTo writes a cache snapshot to w.
+func (c *ContractTypeCache) WriteTo(w io.Writer) (int64, error) {
+    sw := &snapWriter{w: w}
+    keys := c.cache.Keys()
+    sw.uint64(uint64(len(keys)))
+    for _, k := range keys {
+        val, _ := c.cache.Peek(k)
+        elem, _ := val.(*ContractTypeElem)
+        sw.uint64(k.(model.AccountID).Value())
+        for _, typ := range []micheline.Type{elem.ParamType, elem.StorageType} {
+            var buf []byte
+            if typ.IsValid() {
+                buf, sw.err = typ.MarshalBinary()
+            }
+            sw.bytes(buf)
+        }
+    }
+    return sw.n, sw.err
+}
+
+// ReadFrom adds all entries from a snapshot read from r to the cache.
+func (c *ContractTypeCache) ReadFrom(r io.Reader) (int64, error) {
+    sr := &snapReader{r: r}
+    n := sr.len(1 << 24)
+    for i := 0; i < n && sr.err == nil; i++ {
+        id := model.AccountID(sr.uint64())
+        elem := &ContractTypeElem{}
+        for _, typ := range []*micheline.Type{&elem.ParamType, &elem.StorageType} {
+            if buf := sr.bytes(1 << 24); len(buf) > 0 && sr.err == nil {
+                sr.err = typ.UnmarshalBinary(buf)
+            }
+        }
+        if sr.err != nil {
+            break
+        }
+        if updated, _ := c.cache.Add(id, elem); !updated {
+            c.size += elem.Size()
+        }
+    }
+    return sr.n, sr.err
+}
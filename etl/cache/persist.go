@@ -0,0 +1,214 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Cache snapshot file layout
+//
+// - 4 byte magic
+// - 1 byte format version
+// - 32 byte tip block hash the snapshot was taken at
+// - cache specific payload
+// - 4 byte CRC32 (Castagnoli) over all preceeding bytes
+const snapshotVersion byte = 1
+
+var (
+	snapshotMagic = []byte("TZIC")
+	crcTable      = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrSnapshotStale indicates a cache snapshot was taken at a different tip.
+	ErrSnapshotStale = errors.New("cache snapshot does not match chain tip")
+
+	// ErrSnapshotCorrupt indicates a cache snapshot is damaged or incompatible.
+	ErrSnapshotCorrupt = errors.New("cache snapshot corrupted")
+)
+
+// Snapshotter is implemented by caches that can be persisted across restarts.
+type Snapshotter interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// WriteSnapshot atomically writes cache c to file name tagged with block hash tip.
+func WriteSnapshot(name string, tip tezos.BlockHash, c Snapshotter) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	crc := crc32.New(crcTable)
+	w := bufio.NewWriterSize(io.MultiWriter(f, crc), 1<<20)
+	w.Write(snapshotMagic)
+	w.WriteByte(snapshotVersion)
+	w.Write(tip.Hash.Hash)
+	if _, err := c.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// ReadSnapshot loads cache c from file name when it was taken at block hash tip.
+func ReadSnapshot(name string, tip tezos.BlockHash, c Snapshotter) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdrLen := len(snapshotMagic) + 1 + tezos.HashTypeBlock.Len()
+	if fi.Size() < int64(hdrLen+4) {
+		return ErrSnapshotCorrupt
+	}
+
+	// check header before reading the payload
+	hdr := make([]byte, hdrLen)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[:4], snapshotMagic) || hdr[4] != snapshotVersion {
+		return ErrSnapshotCorrupt
+	}
+	if !bytes.Equal(hdr[5:], tip.Hash.Hash) {
+		return ErrSnapshotStale
+	}
+
+	// payload reads are checksummed
+	crc := crc32.New(crcTable)
+	crc.Write(hdr)
+	r := &crcReader{
+		r:   bufio.NewReaderSize(io.LimitReader(f, fi.Size()-int64(hdrLen+4)), 1<<20),
+		crc: crc,
+	}
+	if _, err := c.ReadFrom(r); err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	var sum uint32
+	if err := binary.Read(f, binary.LittleEndian, &sum); err != nil {
+		return err
+	}
+	if sum != crc.Sum32() {
+		return ErrSnapshotCorrupt
+	}
+	return nil
+}
+
+type crcReader struct {
+	r   io.Reader
+	crc hash.Hash32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	return n, err
+}
+
+// snapshot encoding helpers, errors are sticky
+
+type snapWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+	buf [8]byte
+}
+
+func (w *snapWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.n += int64(n)
+}
+
+func (w *snapWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.write(w.buf[:])
+}
+
+func (w *snapWriter) bytes(b []byte) {
+	w.uint64(uint64(len(b)))
+	w.write(b)
+}
+
+type snapReader struct {
+	r   io.Reader
+	n   int64
+	err error
+	buf [8]byte
+}
+
+func (r *snapReader) read(b []byte) {
+	if r.err != nil {
+		return
+	}
+	var n int
+	n, r.err = io.ReadFull(r.r, b)
+	r.n += int64(n)
+}
+
+func (r *snapReader) uint64() uint64 {
+	r.read(r.buf[:])
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.buf[:])
+}
+
+// len reads a length prefix and fails when it exceeds max
+func (r *snapReader) len(max int) int {
+	n := r.uint64()
+	if r.err == nil && n > uint64(max) {
+		r.err = fmt.Errorf("invalid length %d", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapReader) bytes(max int) []byte {
+	n := r.len(max)
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	r.read(b)
+	return b
+}
@@ -5,6 +5,7 @@ package cache
 
 import (
     "context"
+    "io"
 
     "blockwatch.cc/packdb/pack"
     "blockwatch.cc/tzgo/tezos"
@@ -66,3 +67,32 @@ func (c *ProposalCache) Build(ctx context.Context, table *pack.Table) error {
             return nil
         })
 }
+
+// WriteTo writes a cache snapshot to w.
+func (c *ProposalCache) WriteTo(w io.Writer) (int64, error) {
+    sw := &snapWriter{w: w}
+    sw.uint64(uint64(len(c.props)))
+    for id, h := range c.props {
+        sw.uint64(id.Value())
+        sw.write(h.Hash.Hash)
+    }
+    return sw.n, sw.err
+}
+
+// ReadFrom replaces cache contents with a snapshot read from r.
+func (c *ProposalCache) ReadFrom(r io.Reader) (int64, error) {
+    sr := &snapReader{r: r}
+    n := sr.len(1 << 16)
+    props := make(map[model.ProposalID]tezos.ProtocolHash, n)
+    for i := 0; i < n && sr.err == nil; i++ {
+        id := model.ProposalID(sr.uint64())
+        buf := make([]byte, tezos.HashTypeProtocol.Len())
+        sr.read(buf)
+        props[id] = tezos.NewProtocolHash(buf)
+    }
+    if sr.err != nil {
+        return sr.n, sr.err
+    }
+    c.props = props
+    return sr.n, nil
+}
@@ -5,6 +5,7 @@ package cache
 
 import (
 	"context"
+	"io"
 	"sort"
 	"sync"
 	"time"
@@ -241,3 +242,52 @@ func (h *RankCache) Build(ctx context.Context, accounts, ops *pack.Table) error
 	h.stats.CountUpdates(1)
 	return nil
 }
+
+// WriteTo writes a cache snapshot to w. Ranks are stored in rich list order.
+func (c *RankCache) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapWriter{w: w}
+	sw.uint64(uint64(len(c.rich)))
+	for _, v := range c.rich {
+		sw.uint64(v.AccountId.Value())
+		sw.uint64(uint64(v.Balance))
+		sw.uint64(uint64(v.TxVolume24h))
+		sw.uint64(uint64(v.TxTraffic24h))
+		sw.uint64(uint64(v.RichRank))
+		sw.uint64(uint64(v.VolumeRank))
+		sw.uint64(uint64(v.TrafficRank))
+	}
+	return sw.n, sw.err
+}
+
+// ReadFrom replaces cache contents with a snapshot read from r. Restored
+// ranks count as fresh and are rebuilt on the regular schedule.
+func (c *RankCache) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapReader{r: r}
+	n := sr.len(1 << 32)
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	next := NewRankCache()
+	for i := 0; i < n && sr.err == nil; i++ {
+		acc := &model.AccountRank{
+			AccountId:    model.AccountID(sr.uint64()),
+			Balance:      int64(sr.uint64()),
+			TxVolume24h:  int64(sr.uint64()),
+			TxTraffic24h: int64(sr.uint64()),
+			RichRank:     int(sr.uint64()),
+			VolumeRank:   int(sr.uint64()),
+			TrafficRank:  int(sr.uint64()),
+		}
+		next.idmap[acc.AccountId] = acc
+		next.rich = append(next.rich, acc)
+		next.volume = append(next.volume, acc)
+		next.traffic = append(next.traffic, acc)
+	}
+	if sr.err != nil {
+		return sr.n, sr.err
+	}
+	sort.Stable(next.volume)
+	sort.Stable(next.traffic)
+	c.idmap, c.rich, c.volume, c.traffic, c.ts = next.idmap, next.rich, next.volume, next.traffic, next.ts
+	return sr.n, nil
+}
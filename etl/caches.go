@@ -5,7 +5,10 @@ package etl
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"time"
 
@@ -410,3 +413,122 @@ func (m *Indexer) updateProposals(ctx context.Context, b *model.Block) error {
 	m.proposals.Store(next)
 	return nil
 }
+
+// CacheDirName is the directory below the database path where cache
+// snapshots are stored.
+const CacheDirName = "caches"
+
+// cacheSnapshots lists persistable caches by file name. Caches that have
+// not been built yet are nil.
+func (m *Indexer) cacheSnapshots() map[string]cache.Snapshotter {
+	snaps := map[string]cache.Snapshotter{
+		"bigmap_types":   m.bigmap_types,
+		"contract_types": m.contract_types,
+		"blocks":         nil,
+		"addresses":      nil,
+		"ranks":          nil,
+		"proposals":      nil,
+	}
+	if b := m.blocks.Load(); b != nil {
+		snaps["blocks"] = b.(*cache.BlockCache)
+	}
+	if b := m.addrs.Load(); b != nil {
+		snaps["addresses"] = b.(*cache.AddressCache)
+	}
+	if b := m.ranks.Load(); b != nil {
+		snaps["ranks"] = b.(*cache.RankCache)
+	}
+	if b := m.proposals.Load(); b != nil {
+		snaps["proposals"] = b.(*cache.ProposalCache)
+	}
+	return snaps
+}
+
+func (m *Indexer) cacheSnapshotPath(name string) string {
+	return filepath.Join(m.dbpath, CacheDirName, name+".cache")
+}
+
+// StoreCaches writes snapshots of all warm caches tagged with the current
+// chain tip. Must be called from the crawler goroutine while no block is
+// being processed.
+func (m *Indexer) StoreCaches(tip *model.ChainTip) error {
+	if !m.persistCaches || tip == nil || tip.BestHeight <= 0 {
+		return nil
+	}
+	startTime := time.Now()
+	for name, c := range m.cacheSnapshots() {
+		path := m.cacheSnapshotPath(name)
+		if c == nil {
+			_ = os.Remove(path)
+			continue
+		}
+		if err := cache.WriteSnapshot(path, tip.BestHash, c); err != nil {
+			return fmt.Errorf("storing %s cache: %w", name, err)
+		}
+	}
+	log.Infof("Stored caches at block %d in %s", tip.BestHeight, time.Since(startTime))
+	return nil
+}
+
+// loadCaches restores cache snapshots taken at tip. Stale or damaged
+// snapshots are removed and the respective caches are rebuilt on demand.
+func (m *Indexer) loadCaches(tip *model.ChainTip) {
+	if !m.persistCaches || tip == nil || tip.BestHeight <= 0 {
+		return
+	}
+	startTime := time.Now()
+	snaps := map[string]cache.Snapshotter{
+		"bigmap_types":   m.bigmap_types,
+		"contract_types": m.contract_types,
+		"blocks":         cache.NewBlockCache(0),
+		"addresses":      cache.NewAddressCache(0),
+		"ranks":          cache.NewRankCache(),
+	}
+	if !m.lightMode {
+		snaps["proposals"] = cache.NewProposalCache()
+	}
+	var n int
+	for name, c := range snaps {
+		path := m.cacheSnapshotPath(name)
+		err := cache.ReadSnapshot(path, tip.BestHash, c)
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case errors.Is(err, cache.ErrSnapshotStale):
+			log.Infof("Discarding %s cache snapshot from another chain tip.", name)
+			_ = os.Remove(path)
+			continue
+		default:
+			log.Warnf("Discarding %s cache snapshot: %v", name, err)
+			_ = os.Remove(path)
+			// shared lru caches may be partially filled
+			if p, ok := c.(interface{ Purge() }); ok {
+				p.Purge()
+			}
+			continue
+		}
+		n++
+		switch name {
+		case "blocks":
+			m.blocks.Store(c)
+		case "addresses":
+			m.addrs.Store(c)
+		case "ranks":
+			m.ranks.Store(c)
+		case "proposals":
+			m.proposals.Store(c)
+		}
+	}
+	if n > 0 {
+		log.Infof("Loaded %d cache snapshots at block %d in %s", n, tip.BestHeight, time.Since(startTime))
+	}
+}
+
+// DropCaches removes all cache snapshots, e.g. before a reorg changes
+// indexed history.
+func (m *Indexer) DropCaches() {
+	if err := os.RemoveAll(filepath.Join(m.dbpath, CacheDirName)); err != nil {
+		log.Warnf("Removing cache snapshots: %v", err)
+	}
+}
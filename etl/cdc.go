@@ -0,0 +1,667 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/cdc"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// Changes returns the change log or nil when change capture is disabled.
+func (m *Indexer) Changes() *cdc.Log {
+	return m.changes
+}
+
+type changeSet struct {
+	block *model.Block
+	recs  []cdc.Record
+}
+
+func newChangeSet(block *model.Block) *changeSet {
+	return &changeSet{
+		block: block,
+		recs:  make([]cdc.Record, 0, len(block.Ops)+len(block.Flows)+16),
+	}
+}
+
+func (s *changeSet) Add(table string, action cdc.Action, key string, data interface{}) {
+	s.recs = append(s.recs, cdc.NewRecord(s.block.Height, s.block.Hash, table, action, key, data))
+}
+
+func rowKey(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+func bigmapKey(id int64, kh string) string {
+	return strconv.FormatInt(id, 10) + ":" + kh
+}
+
+type bigmapCopy struct {
+	SourceId int64 `json:"source_id"`
+	DestId   int64 `json:"dest_id"`
+}
+
+// bigmapAllocRow exports the bigmap type definition which is hidden from
+// the regular JSON encoding.
+type bigmapAllocRow struct {
+	*model.BigmapAlloc
+	Data []byte `json:"data"`
+}
+
+// contractRow exports the contract feature bitset, the regular JSON encoding
+// only lists feature names. Interfaces are encoded as list that decodes
+// symmetrically.
+type contractRow struct {
+	*model.Contract
+	Features    json.RawMessage `json:"features"`
+	FeatureBits uint16          `json:"feature_bits"`
+	Interfaces  []string        `json:"interfaces"`
+}
+
+func newContractRow(c *model.Contract) contractRow {
+	names, _ := json.Marshal(c.Features)
+	row := contractRow{
+		Contract:    c,
+		Features:    names,
+		FeatureBits: uint16(c.Features),
+		Interfaces:  make([]string, len(c.Interfaces)),
+	}
+	for i, v := range c.Interfaces {
+		row.Interfaces[i] = string(v)
+	}
+	return row
+}
+
+// gasStatsRow exports encoded sketches which are hidden from the regular
+// JSON encoding.
+type gasStatsRow struct {
+	*model.GasStats
+	GasData     []byte `json:"gas_data"`
+	StorageData []byte `json:"storage_data"`
+	FeeData     []byte `json:"fee_data"`
+}
+
+// reorgRow exports the encoded orphan list.
+type reorgRow struct {
+	*model.Reorg
+	Data []byte `json:"data"`
+}
+
+// distributionRow exports the encoded top holder list.
+type distributionRow struct {
+	*model.Distribution
+	Data []byte `json:"data"`
+}
+
+// changeRow returns the change record representation of a table row.
+func changeRow(item pack.Item) interface{} {
+	switch v := item.(type) {
+	case *model.GasStats:
+		return gasStatsRow{v, v.GasData, v.StorageData, v.FeeData}
+	case *model.Reorg:
+		return reorgRow{v, v.Data}
+	case *model.Distribution:
+		return distributionRow{v, v.Data}
+	}
+	return item
+}
+
+// protocolChangeKey is the change record table name for protocol deployments.
+const protocolChangeKey = "protocol"
+
+// heightTables lists append-only tables whose rows are captured by block
+// height. Indexes remove these rows by height on rollback.
+var heightTables = []struct {
+	key   string
+	proto func() interface{}
+}{
+	{index.SupplyTableKey, func() interface{} { return &model.Supply{} }},
+	{index.ChainTableKey, func() interface{} { return &model.Chain{} }},
+	{index.StorageTableKey, func() interface{} { return &model.Storage{} }},
+	{index.BigmapUpdateTableKey, func() interface{} { return &model.BigmapUpdate{} }},
+	{index.RollupMessageTableKey, func() interface{} { return &model.RollupMessage{} }},
+	{index.TicketUpdateTableKey, func() interface{} { return &model.TicketUpdate{} }},
+	{index.SaplingTxTableKey, func() interface{} { return &model.SaplingTx{} }},
+}
+
+// stateTables lists tables whose rows are updated in place. Rows written by
+// a block are found by height columns. Indexes restore previous row state on
+// rollback, so touched rows are collected before and re-read after
+// disconnecting a block.
+var stateTables = []struct {
+	key     string
+	created string   // column holding the creation height, empty if unknown
+	heights []string // columns set to the height of a block writing the row
+	proto   func() pack.Item
+}{
+	{index.RollupTableKey, "first_seen", []string{"last_seen"}, func() pack.Item { return &model.SmartRollup{} }},
+	{index.RollupCommitmentTableKey, "height", []string{"height", "cement_height"}, func() pack.Item { return &model.RollupCommitment{} }},
+	{index.TicketTypeTableKey, "first_block", []string{"last_block"}, func() pack.Item { return &model.TicketType{} }},
+	{index.TicketBalanceTableKey, "first_block", []string{"last_block"}, func() pack.Item { return &model.TicketBalance{} }},
+	{index.SaplingPoolTableKey, "first_block", []string{"last_block"}, func() pack.Item { return &model.SaplingPool{} }},
+	{index.DelegationTableKey, "start_height", []string{"start_height", "end_height"}, func() pack.Item { return &model.DelegationPeriod{} }},
+	{index.GasStatsTableKey, "", []string{"height"}, func() pack.Item { return &model.GasStats{} }},
+}
+
+// customTables returns the keys of custom index tables which are append-only
+// and removed by height on rollback.
+func (m *Indexer) customTables() []string {
+	keys := make([]string, 0)
+	for _, idx := range m.indexes {
+		if _, ok := idx.(*index.CustomIndex); ok {
+			keys = append(keys, idx.Key())
+		}
+	}
+	return keys
+}
+
+// captureProtocol records a protocol deployment.
+func (m *Indexer) captureProtocol(params *tezos.Params) error {
+	if m.changes == nil {
+		return nil
+	}
+	r := cdc.NewRecord(params.StartHeight, tezos.BlockHash{}, protocolChangeKey, cdc.ActionInsert, params.Protocol.String(), params)
+	return m.changes.Append([]cdc.Record{r})
+}
+
+// captureConnect records all rows written by indexes when connecting block.
+// It must run after all indexes have processed the block so that row ids
+// are assigned.
+func (m *Indexer) captureConnect(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	if m.changes == nil {
+		return nil
+	}
+	s := newChangeSet(block)
+	s.Add(index.BlockTableKey, cdc.ActionInsert, rowKey(block.RowId), block)
+
+	for _, op := range block.Ops {
+		if op.RowId == 0 {
+			continue
+		}
+		switch op.Type {
+		case model.OpTypeEndorsement, model.OpTypePreendorsement:
+			s.Add(index.EndorseOpTableKey, cdc.ActionInsert, rowKey(op.RowId.Value()), op.ToEndorsement())
+		default:
+			s.Add(index.OpTableKey, cdc.ActionInsert, rowKey(op.RowId.Value()), op)
+		}
+	}
+	for _, f := range block.Flows {
+		if f.RowId == 0 {
+			continue
+		}
+		s.Add(index.FlowTableKey, cdc.ActionInsert, rowKey(f.RowId), f)
+	}
+
+	// accounts and bakers
+	for _, acc := range builder.Accounts() {
+		if !acc.IsDirty || !builder.IsSelected(acc.RowId) {
+			continue
+		}
+		action := cdc.ActionUpdate
+		if acc.IsNew {
+			action = cdc.ActionInsert
+		}
+		s.Add(index.AccountTableKey, action, rowKey(acc.RowId.Value()), acc)
+	}
+	for _, bkr := range builder.Bakers() {
+		if bkr.Account.IsDirty {
+			action := cdc.ActionUpdate
+			if bkr.Account.IsNew {
+				action = cdc.ActionInsert
+			}
+			s.Add(index.AccountTableKey, action, rowKey(bkr.Account.RowId.Value()), bkr.Account)
+		}
+		if bkr.IsNew {
+			s.Add(index.BakerTableKey, cdc.ActionInsert, rowKey(bkr.RowId.Value()), bkr)
+		} else if bkr.IsDirty {
+			s.Add(index.BakerTableKey, cdc.ActionUpdate, rowKey(bkr.RowId.Value()), bkr)
+		}
+	}
+
+	// contracts (the contract index resets dirty flags, so we follow ops)
+	seen := make(map[model.AccountID]struct{})
+	for _, op := range block.Ops {
+		if !op.IsSuccess || !op.IsContract || op.ReceiverId == 0 {
+			continue
+		}
+		if _, ok := seen[op.ReceiverId]; ok {
+			continue
+		}
+		seen[op.ReceiverId] = struct{}{}
+		con, ok := builder.ContractById(op.ReceiverId)
+		if !ok || con.RowId == 0 || !builder.IsSelected(con.AccountId) {
+			continue
+		}
+		action := cdc.ActionUpdate
+		if con.IsNew {
+			action = cdc.ActionInsert
+		}
+		s.Add(index.ContractTableKey, action, rowKey(con.RowId.Value()), newContractRow(con))
+	}
+
+	// bigmaps, temporary bigmaps are skipped
+	if err := m.captureBigmapConnect(ctx, s, builder); err != nil {
+		return err
+	}
+
+	// append-only tables without row references in block data
+	for _, v := range heightTables {
+		table, err := m.Table(v.key)
+		if err != nil {
+			continue
+		}
+		err = pack.NewQuery("etl.cdc.height", table).
+			AndEqual("height", block.Height).
+			Stream(ctx, func(r pack.Row) error {
+				item := v.proto()
+				if err := r.Decode(item); err != nil {
+					return err
+				}
+				s.Add(v.key, cdc.ActionInsert, rowKey(item.(pack.Item).ID()), item)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range m.customTables() {
+		table, err := m.Table(key)
+		if err != nil {
+			continue
+		}
+		err = pack.NewQuery("etl.cdc.custom", table).
+			AndEqual("height", block.Height).
+			Stream(ctx, func(r pack.Row) error {
+				row := &model.CustomRow{}
+				if err := r.Decode(row); err != nil {
+					return err
+				}
+				s.Add(key, cdc.ActionInsert, rowKey(row.ID()), row)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	// tables updated in place
+	for _, v := range stateTables {
+		table, err := m.Table(v.key)
+		if err != nil {
+			continue
+		}
+		err = streamTouched(ctx, table, v.created, v.heights, block.Height, v.proto, func(item pack.Item, isNew bool) error {
+			action := cdc.ActionUpdate
+			if isNew {
+				action = cdc.ActionInsert
+			}
+			s.Add(v.key, action, rowKey(item.ID()), changeRow(item))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return m.changes.Append(s.recs)
+}
+
+// streamTouched calls fn once for each row where the creation column or any
+// of the height columns equals height. Rows created at height are reported
+// as new.
+func streamTouched(ctx context.Context, table *pack.Table, created string, cols []string, height int64, proto func() pack.Item, fn func(pack.Item, bool) error) error {
+	seen := make(map[uint64]struct{})
+	if created != "" {
+		cols = append([]string{created}, cols...)
+	}
+	for i, col := range cols {
+		isNew := created != "" && i == 0
+		err := pack.NewQuery("etl.cdc.touched", table).
+			AndEqual(col, height).
+			Stream(ctx, func(r pack.Row) error {
+				item := proto()
+				if err := r.Decode(item); err != nil {
+					return err
+				}
+				if _, ok := seen[item.ID()]; ok {
+					return nil
+				}
+				seen[item.ID()] = struct{}{}
+				return fn(item, isNew)
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchedRows holds ids of state table rows written by a block before it is
+// disconnected.
+type touchedRows map[string][]uint64
+
+// captureTouched collects state table rows written by block before indexes
+// roll it back. Distribution snapshots at or after the block are removed by
+// rollback as well.
+func (m *Indexer) captureTouched(ctx context.Context, block *model.Block) (touchedRows, error) {
+	if m.changes == nil {
+		return nil, nil
+	}
+	touched := make(touchedRows)
+	for _, v := range stateTables {
+		table, err := m.Table(v.key)
+		if err != nil {
+			continue
+		}
+		err = streamTouched(ctx, table, v.created, v.heights, block.Height, v.proto, func(item pack.Item, _ bool) error {
+			touched[v.key] = append(touched[v.key], item.ID())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if table, err := m.Table(index.DistributionTableKey); err == nil {
+		d := &model.Distribution{}
+		err = pack.NewQuery("etl.cdc.distribution", table).
+			WithFields("row_id").
+			AndGte("height", block.Height).
+			Stream(ctx, func(r pack.Row) error {
+				if err := r.Decode(d); err != nil {
+					return err
+				}
+				touched[index.DistributionTableKey] = append(touched[index.DistributionTableKey], d.RowId)
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return touched, nil
+}
+
+// captureDistributions records newly stored balance distribution snapshots.
+// Snapshots are built outside block processing and sent as separate change
+// sets without block.
+func (m *Indexer) captureDistributions(list []*model.Distribution) error {
+	if m.changes == nil || len(list) == 0 {
+		return nil
+	}
+	recs := make([]cdc.Record, 0, len(list))
+	for _, d := range list {
+		recs = append(recs, cdc.NewRecord(d.Height, tezos.BlockHash{}, index.DistributionTableKey, cdc.ActionInsert, rowKey(d.RowId), changeRow(d)))
+	}
+	return m.changes.Append(recs)
+}
+
+// captureReorg records a stored reorg event as change set without block.
+func (m *Indexer) captureReorg(r *model.Reorg) error {
+	if m.changes == nil {
+		return nil
+	}
+	rec := cdc.NewRecord(r.ForkHeight, tezos.BlockHash{}, index.ReorgTableKey, cdc.ActionInsert, rowKey(r.RowId), changeRow(r))
+	return m.changes.Append([]cdc.Record{rec})
+}
+
+func (m *Indexer) captureBigmapConnect(ctx context.Context, s *changeSet, builder model.BlockBuilder) error {
+	values, _ := m.Table(index.BigmapValueTableKey)
+	touched := make(map[int64]cdc.Action)
+	for _, op := range s.block.Ops {
+		if len(op.BigmapEvents) == 0 || !op.IsSuccess || !builder.IsSelected(op.ReceiverId) {
+			continue
+		}
+		for _, ev := range op.BigmapEvents {
+			switch ev.Action {
+			case micheline.DiffActionAlloc:
+				if ev.Id < 0 {
+					continue
+				}
+				touched[ev.Id] = cdc.ActionInsert
+
+			case micheline.DiffActionCopy:
+				if ev.DestId < 0 {
+					continue
+				}
+				s.Add(index.BigmapAllocTableKey, cdc.ActionCopy, strconv.FormatInt(ev.DestId, 10), bigmapCopy{ev.SourceId, ev.DestId})
+				touched[ev.DestId] = cdc.ActionInsert
+				if values == nil {
+					continue
+				}
+				// copied keys are new live keys in the destination bigmap
+				err := pack.NewQuery("etl.cdc.bigmap_copy", values).
+					AndEqual("bigmap_id", ev.DestId).
+					Stream(ctx, func(r pack.Row) error {
+						kv := &model.BigmapKV{}
+						if err := r.Decode(kv); err != nil {
+							return err
+						}
+						kh := micheline.KeyHash(kv.Key)
+						s.Add(index.BigmapValueTableKey, cdc.ActionInsert, bigmapKey(kv.BigmapId, kh.String()), kv)
+						return nil
+					})
+				if err != nil {
+					return err
+				}
+
+			case micheline.DiffActionUpdate:
+				if ev.Id < 0 {
+					continue
+				}
+				if _, ok := touched[ev.Id]; !ok {
+					touched[ev.Id] = cdc.ActionUpdate
+				}
+				kv := model.NewBigmapUpdate(op, ev).ToKV()
+				s.Add(index.BigmapValueTableKey, cdc.ActionUpdate, bigmapKey(ev.Id, ev.KeyHash.String()), kv)
+
+			case micheline.DiffActionRemove:
+				if ev.Id < 0 {
+					continue
+				}
+				if _, ok := touched[ev.Id]; !ok {
+					touched[ev.Id] = cdc.ActionUpdate
+				}
+				if !ev.KeyHash.IsValid() {
+					// full bigmap removal drops all keys
+					s.Add(index.BigmapAllocTableKey, cdc.ActionDelete, strconv.FormatInt(ev.Id, 10), nil)
+				} else {
+					s.Add(index.BigmapValueTableKey, cdc.ActionDelete, bigmapKey(ev.Id, ev.KeyHash.String()), nil)
+				}
+			}
+		}
+	}
+
+	// emit allocation rows after counters have been updated
+	return m.captureBigmapAllocs(ctx, s, touched)
+}
+
+// captureBigmapAllocs records the current state of bigmap allocations. Rows
+// that no longer exist are skipped.
+func (m *Indexer) captureBigmapAllocs(ctx context.Context, s *changeSet, touched map[int64]cdc.Action) error {
+	if len(touched) == 0 {
+		return nil
+	}
+	allocs, err := m.Table(index.BigmapAllocTableKey)
+	if err != nil {
+		return nil
+	}
+	ids := make([]int64, 0, len(touched))
+	for id := range touched {
+		ids = append(ids, id)
+	}
+	return pack.NewQuery("etl.cdc.bigmap_alloc", allocs).
+		AndIn("bigmap_id", ids).
+		Stream(ctx, func(r pack.Row) error {
+			alloc := &model.BigmapAlloc{}
+			if err := r.Decode(alloc); err != nil {
+				return err
+			}
+			s.Add(index.BigmapAllocTableKey, touched[alloc.BigmapId], strconv.FormatInt(alloc.BigmapId, 10), bigmapAllocRow{alloc, alloc.Data})
+			return nil
+		})
+}
+
+// captureDisconnect records the reverse changes applied by indexes when
+// block is rolled back. Consumers are expected to drop all rows of append-only
+// tables at the rollback height.
+func (m *Indexer) captureDisconnect(ctx context.Context, block *model.Block, builder model.BlockBuilder, touched touchedRows) error {
+	if m.changes == nil {
+		return nil
+	}
+	s := newChangeSet(block)
+	s.Add(index.BlockTableKey, cdc.ActionRollback, strconv.FormatInt(block.Height, 10), nil)
+
+	for _, acc := range builder.Accounts() {
+		if acc.MustDelete {
+			s.Add(index.AccountTableKey, cdc.ActionDelete, rowKey(acc.RowId.Value()), nil)
+		} else if acc.IsDirty {
+			s.Add(index.AccountTableKey, cdc.ActionUpdate, rowKey(acc.RowId.Value()), acc)
+		}
+	}
+	for _, bkr := range builder.Bakers() {
+		if bkr.Account.MustDelete {
+			s.Add(index.AccountTableKey, cdc.ActionDelete, rowKey(bkr.Account.RowId.Value()), nil)
+			s.Add(index.BakerTableKey, cdc.ActionDelete, rowKey(bkr.RowId.Value()), nil)
+			continue
+		}
+		if bkr.Account.IsDirty {
+			s.Add(index.AccountTableKey, cdc.ActionUpdate, rowKey(bkr.Account.RowId.Value()), bkr.Account)
+		}
+		if bkr.IsDirty {
+			s.Add(index.BakerTableKey, cdc.ActionUpdate, rowKey(bkr.RowId.Value()), bkr)
+		}
+	}
+	for _, con := range builder.Contracts() {
+		if con.RowId == 0 {
+			continue
+		}
+		if con.FirstSeen == block.Height {
+			s.Add(index.ContractTableKey, cdc.ActionDelete, rowKey(con.RowId.Value()), nil)
+		} else if con.IsDirty {
+			s.Add(index.ContractTableKey, cdc.ActionUpdate, rowKey(con.RowId.Value()), newContractRow(con))
+		}
+	}
+
+	if err := m.captureBigmapDisconnect(ctx, s); err != nil {
+		return err
+	}
+
+	// re-read touched rows, rows that no longer exist have been deleted
+	for _, v := range stateTables {
+		if err := m.captureRestored(ctx, s, v.key, touched[v.key], v.proto); err != nil {
+			return err
+		}
+	}
+	err := m.captureRestored(ctx, s, index.DistributionTableKey, touched[index.DistributionTableKey], func() pack.Item {
+		return &model.Distribution{}
+	})
+	if err != nil {
+		return err
+	}
+	return m.changes.Append(s.recs)
+}
+
+// captureRestored records the state of rows after rollback.
+func (m *Indexer) captureRestored(ctx context.Context, s *changeSet, key string, ids []uint64, proto func() pack.Item) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	table, err := m.Table(key)
+	if err != nil {
+		return nil
+	}
+	found := make(map[uint64]struct{})
+	err = table.StreamLookup(ctx, ids, func(r pack.Row) error {
+		item := proto()
+		if err := r.Decode(item); err != nil {
+			return err
+		}
+		found[item.ID()] = struct{}{}
+		s.Add(key, cdc.ActionUpdate, rowKey(item.ID()), changeRow(item))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			s.Add(key, cdc.ActionDelete, rowKey(id), nil)
+		}
+	}
+	return nil
+}
+
+// captureBigmapDisconnect re-reads live keys touched by block after the
+// bigmap index has restored their previous state.
+func (m *Indexer) captureBigmapDisconnect(ctx context.Context, s *changeSet) error {
+	values, err := m.Table(index.BigmapValueTableKey)
+	if err != nil {
+		return nil
+	}
+	emit := func(q pack.Query) (int, error) {
+		var n int
+		err := q.Stream(ctx, func(r pack.Row) error {
+			kv := &model.BigmapKV{}
+			if err := r.Decode(kv); err != nil {
+				return err
+			}
+			kh := micheline.KeyHash(kv.Key)
+			s.Add(index.BigmapValueTableKey, cdc.ActionUpdate, bigmapKey(kv.BigmapId, kh.String()), kv)
+			n++
+			return nil
+		})
+		return n, err
+	}
+	touched := make(map[int64]cdc.Action)
+	for _, op := range s.block.Ops {
+		if len(op.BigmapEvents) == 0 || !op.IsSuccess {
+			continue
+		}
+		for _, ev := range op.BigmapEvents {
+			switch ev.Action {
+			case micheline.DiffActionAlloc:
+				if ev.Id >= 0 {
+					s.Add(index.BigmapAllocTableKey, cdc.ActionDelete, strconv.FormatInt(ev.Id, 10), nil)
+				}
+			case micheline.DiffActionCopy:
+				if ev.DestId >= 0 {
+					s.Add(index.BigmapAllocTableKey, cdc.ActionDelete, strconv.FormatInt(ev.DestId, 10), nil)
+				}
+			case micheline.DiffActionUpdate, micheline.DiffActionRemove:
+				if ev.Id < 0 {
+					continue
+				}
+				touched[ev.Id] = cdc.ActionUpdate
+				if !ev.KeyHash.IsValid() {
+					// restore all keys of a removed bigmap
+					_, err := emit(pack.NewQuery("etl.cdc.bigmap_restore", values).
+						AndEqual("bigmap_id", ev.Id))
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				n, err := emit(pack.NewQuery("etl.cdc.bigmap_rollback", values).
+					AndEqual("key_id", model.GetKeyId(ev.Id, ev.KeyHash)).
+					AndEqual("bigmap_id", ev.Id).
+					WithLimit(1))
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					s.Add(index.BigmapValueTableKey, cdc.ActionDelete, bigmapKey(ev.Id, ev.KeyHash.String()), nil)
+				}
+			}
+		}
+	}
+
+	// restore counters of bigmaps that still exist
+	return m.captureBigmapAllocs(ctx, s, touched)
+}
@@ -0,0 +1,441 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"blockwatch.cc/packdb/store"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+const DBName = "changes.db"
+
+const (
+	// readBatchSize is the number of records copied out of a read
+	// transaction at once.
+	readBatchSize = 1024
+
+	// trimBatchSize is the max number of records removed per transaction.
+	trimBatchSize = 16384
+
+	// trimInterval is the number of blocks between retention runs.
+	trimInterval = 64
+)
+
+var (
+	// ErrNoBlock is returned when a block is not contained in the change log.
+	ErrNoBlock = errors.New("cdc: block not found")
+
+	// ErrTrimmed is returned when reading from a position that has been
+	// removed by retention.
+	ErrTrimmed = errors.New("cdc: position is no longer retained")
+)
+
+var (
+	// recordsBucketName is the name of the bucket holding change records
+	// keyed by big-endian sequence number.
+	recordsBucketName = []byte("changes")
+
+	// cursorsBucketName is the name of the bucket holding sink positions.
+	cursorsBucketName = []byte("cursors")
+
+	// blocksBucketName is the name of the bucket mapping block hashes to
+	// the sequence number of their last record.
+	blocksBucketName = []byte("blocks")
+)
+
+// Log is an append-only change log. Records are stored in commit order and
+// can be read from any sequence number, which makes consumers resumable.
+// With retention enabled, records older than the retained block range are
+// removed once all sinks have consumed them.
+type Log struct {
+	db       store.DB
+	mu       sync.Mutex
+	first    uint64 // first retained sequence number
+	last     uint64
+	notify   chan struct{}
+	retain   int64 // number of blocks to keep, 0 = all
+	trimmed  int64 // height of the last retention run
+	trimming bool
+	wg       sync.WaitGroup
+}
+
+// Open opens or creates the change log database at path.
+func Open(engine, path string, opts interface{}) (*Log, error) {
+	db, err := store.Open(engine, path, opts)
+	if err != nil {
+		if !store.IsError(err, store.ErrDbDoesNotExist) {
+			return nil, err
+		}
+		db, err = store.Create(engine, path, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l := &Log{
+		db:     db,
+		notify: make(chan struct{}),
+	}
+	err = db.Update(func(dbTx store.Tx) error {
+		b, err := dbTx.Root().CreateBucketIfNotExists(recordsBucketName)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		if c.First() {
+			l.first = binary.BigEndian.Uint64(c.Key())
+		}
+		if c.Last() {
+			l.last = binary.BigEndian.Uint64(c.Key())
+		}
+		if _, err := dbTx.Root().CreateBucketIfNotExists(cursorsBucketName); err != nil {
+			return err
+		}
+		blocks, err := dbTx.Root().CreateBucketIfNotExists(blocksBucketName)
+		if err != nil {
+			return err
+		}
+		// build the block index for logs created without it
+		if l.last > 0 {
+			if c := blocks.Cursor(); !c.First() {
+				return b.ForEach(func(_, v []byte) error {
+					var r Record
+					if err := json.Unmarshal(v, &r); err != nil {
+						return err
+					}
+					return indexBlock(blocks, r)
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetRetention enables removal of records older than blocks behind the most
+// recently appended block. Records are only removed after all sinks have
+// consumed them. Zero disables retention.
+func (l *Log) SetRetention(blocks int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retain = blocks
+}
+
+func (l *Log) Close() error {
+	l.wg.Wait()
+	return l.db.Close()
+}
+
+// First returns the sequence number of the oldest retained record.
+func (l *Log) First() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.first
+}
+
+// Last returns the sequence number of the most recent record.
+func (l *Log) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Wait returns a channel that is closed on the next append.
+func (l *Log) Wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.notify
+}
+
+// Append atomically stores records and assigns sequence numbers.
+func (l *Log) Append(recs []Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := l.last
+	err := l.db.Update(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(recordsBucketName)
+		b.FillPercent(1.0)
+		blocks := dbTx.Bucket(blocksBucketName)
+		var key [8]byte
+		recs[len(recs)-1].Commit = true
+		for i := range recs {
+			seq++
+			recs[i].Seq = seq
+			buf, err := json.Marshal(recs[i])
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(key[:], seq)
+			if err := b.Put(key[:], buf); err != nil {
+				return err
+			}
+			if err := indexBlock(blocks, recs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if l.first == 0 {
+		l.first = recs[0].Seq
+	}
+	l.last = seq
+
+	// run retention in the background
+	if height := recs[len(recs)-1].Height; l.retain > 0 && !l.trimming && height-l.retain > l.trimmed+trimInterval {
+		l.trimming = true
+		l.wg.Add(1)
+		go l.trim(height - l.retain)
+	}
+
+	// wake up waiting readers
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return nil
+}
+
+// Read calls fn for each record with a sequence number larger than since
+// until fn returns an error or limit records (0 = unlimited) were read.
+// Returning io.EOF from fn stops reading without error. Records are copied
+// out of the database in batches, fn is never called while a database
+// transaction is open. Reading from a position that was removed by retention
+// fails with ErrTrimmed.
+func (l *Log) Read(since uint64, limit int, fn func(Record) error) error {
+	var n int
+	for {
+		size := readBatchSize
+		if limit > 0 && limit-n < size {
+			size = limit - n
+		}
+		recs, err := l.load(since, size)
+		if err != nil {
+			return err
+		}
+		for _, r := range recs {
+			if err := fn(r); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			since = r.Seq
+			n++
+		}
+		if len(recs) < size || (limit > 0 && n >= limit) {
+			return nil
+		}
+	}
+}
+
+// load returns up to n records with a sequence number larger than since.
+func (l *Log) load(since uint64, n int) ([]Record, error) {
+	l.mu.Lock()
+	first := l.first
+	l.mu.Unlock()
+	if since+1 < first {
+		return nil, ErrTrimmed
+	}
+	recs := make([]Record, 0, n)
+	err := l.db.View(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(recordsBucketName)
+		if b == nil {
+			return nil
+		}
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], since+1)
+		c := b.Cursor()
+		for ok := c.Seek(key[:]); ok && len(recs) < n; ok = c.Next() {
+			var r Record
+			if err := json.Unmarshal(c.Value(), &r); err != nil {
+				return err
+			}
+			recs = append(recs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// retention may have removed the requested position concurrently
+	if len(recs) > 0 && since+1 < recs[0].Seq {
+		return nil, ErrTrimmed
+	}
+	return recs, nil
+}
+
+// Find returns the sequence number of the last record written for block.
+// Blocks that have been rolled back or removed by retention since are
+// reported as not found.
+func (l *Log) Find(block tezos.BlockHash) (uint64, error) {
+	var seq uint64
+	err := l.db.View(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(blocksBucketName)
+		if b == nil {
+			return ErrNoBlock
+		}
+		buf := b.Get(block.Bytes())
+		if len(buf) != 8 {
+			return ErrNoBlock
+		}
+		seq = binary.BigEndian.Uint64(buf)
+		return nil
+	})
+	return seq, err
+}
+
+// indexBlock maintains the block index for record r. Rollbacks remove the
+// block from the index.
+func indexBlock(b store.Bucket, r Record) error {
+	if !r.Block.IsValid() {
+		return nil
+	}
+	if r.Action == ActionRollback {
+		return b.Delete(r.Block.Bytes())
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], r.Seq)
+	return b.Put(r.Block.Bytes(), buf[:])
+}
+
+// minCursor returns the smallest sink position or false when no sink
+// has been registered.
+func (l *Log) minCursor() (uint64, bool) {
+	var (
+		min uint64
+		ok  bool
+	)
+	_ = l.db.View(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(cursorsBucketName)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			if len(v) != 8 {
+				return nil
+			}
+			if seq := binary.BigEndian.Uint64(v); !ok || seq < min {
+				min = seq
+			}
+			ok = true
+			return nil
+		})
+	})
+	return min, ok
+}
+
+func (l *Log) trim(height int64) {
+	defer l.wg.Done()
+	n, err := l.Trim(height)
+	l.mu.Lock()
+	l.trimming = false
+	if err == nil {
+		l.trimmed = height
+	}
+	l.mu.Unlock()
+	if err != nil {
+		log.Errorf("Change log retention: %v", err)
+	} else if n > 0 {
+		log.Debugf("Change log retention removed %d records below height %d.", n, height)
+	}
+}
+
+// Trim removes records below height in sequence order. It stops at the first
+// record at or above height and never removes records that a registered sink
+// has not consumed yet. The most recent record is always kept so sequence
+// numbers continue after a restart. Returns the number of removed records.
+func (l *Log) Trim(height int64) (int, error) {
+	limit, hasSinks := l.minCursor()
+	last := l.Last()
+	var total int
+	for {
+		var (
+			n    int
+			next uint64
+		)
+		err := l.db.Update(func(dbTx store.Tx) error {
+			b := dbTx.Bucket(recordsBucketName)
+			blocks := dbTx.Bucket(blocksBucketName)
+			keys := make([][]byte, 0, trimBatchSize)
+			c := b.Cursor()
+			for ok := c.First(); ok && len(keys) < trimBatchSize; ok = c.Next() {
+				var r Record
+				if err := json.Unmarshal(c.Value(), &r); err != nil {
+					return err
+				}
+				if r.Height >= height || r.Seq >= last || (hasSinks && r.Seq > limit) {
+					break
+				}
+				keys = append(keys, append([]byte(nil), c.Key()...))
+				// drop the block index entry when it points to this record
+				if r.Block.IsValid() {
+					if buf := blocks.Get(r.Block.Bytes()); len(buf) == 8 && binary.BigEndian.Uint64(buf) <= r.Seq {
+						if err := blocks.Delete(r.Block.Bytes()); err != nil {
+							return err
+						}
+					}
+				}
+				next = r.Seq + 1
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		if n > 0 {
+			l.mu.Lock()
+			l.first = next
+			l.mu.Unlock()
+		}
+		total += n
+		if n < trimBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// Cursor returns the last sequence number delivered to sink name.
+func (l *Log) Cursor(name string) uint64 {
+	var seq uint64
+	_ = l.db.View(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(cursorsBucketName)
+		if b == nil {
+			return nil
+		}
+		if buf := b.Get([]byte(name)); len(buf) == 8 {
+			seq = binary.BigEndian.Uint64(buf)
+		}
+		return nil
+	})
+	return seq
+}
+
+// SetCursor stores the last sequence number delivered to sink name.
+func (l *Log) SetCursor(name string, seq uint64) error {
+	return l.db.Update(func(dbTx store.Tx) error {
+		b := dbTx.Bucket(cursorsBucketName)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], seq)
+		return b.Put([]byte(name), buf[:])
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+func openTestLog(t *testing.T) (*Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), DBName)
+	l, err := Open("bolt", path, nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return l, path
+}
+
+func testBlock(height int64) tezos.BlockHash {
+	var h tezos.BlockHash
+	h.Hash.Type = tezos.HashTypeBlock
+	h.Hash.Hash = make([]byte, 32)
+	h.Hash.Hash[0] = byte(height)
+	h.Hash.Hash[1] = byte(height >> 8)
+	return h
+}
+
+// appendBlocks appends one change set of n records per height.
+func appendBlocks(t *testing.T, l *Log, from, to int64, n int) {
+	t.Helper()
+	for h := from; h <= to; h++ {
+		recs := make([]Record, n)
+		for i := range recs {
+			recs[i] = NewRecord(h, testBlock(h), "op", ActionInsert, "1", map[string]int64{"height": h})
+		}
+		if err := l.Append(recs); err != nil {
+			t.Fatalf("append %d: %v", h, err)
+		}
+	}
+}
+
+func readAll(t *testing.T, l *Log, since uint64, limit int) []Record {
+	t.Helper()
+	recs := make([]Record, 0)
+	err := l.Read(since, limit, func(r Record) error {
+		recs = append(recs, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read since %d: %v", since, err)
+	}
+	return recs
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	r := NewRecord(42, testBlock(42), "account", ActionUpdate, "7", map[string]int64{"row_id": 7})
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 Record
+	if err := json.Unmarshal(buf, &r2); err != nil {
+		t.Fatal(err)
+	}
+	if r2.Height != r.Height || !r2.Block.Equal(r.Block) || r2.Table != r.Table ||
+		r2.Action != r.Action || r2.Key != r.Key || string(r2.Data) != string(r.Data) {
+		t.Errorf("got %+v, want %+v", r2, r)
+	}
+}
+
+func TestLogRead(t *testing.T) {
+	l, _ := openTestLog(t)
+	defer l.Close()
+
+	// more records than a single read batch
+	appendBlocks(t, l, 1, 10, 300)
+	if l.Last() != 3000 {
+		t.Fatalf("got last %d, want 3000", l.Last())
+	}
+
+	tests := []struct {
+		name  string
+		since uint64
+		limit int
+		first uint64
+		count int
+	}{
+		{"all", 0, 0, 1, 3000},
+		{"limit", 0, 1500, 1, 1500},
+		{"since", 2500, 0, 2501, 500},
+		{"since with limit", 1000, 1030, 1001, 1030},
+		{"tail", 3000, 0, 0, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recs := readAll(t, l, tc.since, tc.limit)
+			if len(recs) != tc.count {
+				t.Fatalf("got %d records, want %d", len(recs), tc.count)
+			}
+			for i, r := range recs {
+				if r.Seq != tc.first+uint64(i) {
+					t.Fatalf("record %d: got seq %d, want %d", i, r.Seq, tc.first+uint64(i))
+				}
+			}
+		})
+	}
+
+	// change sets end with a commit flag
+	for _, r := range readAll(t, l, 0, 0) {
+		if want := r.Seq%300 == 0; r.Commit != want {
+			t.Fatalf("seq %d: got commit %t, want %t", r.Seq, r.Commit, want)
+		}
+	}
+}
+
+func TestLogFind(t *testing.T) {
+	l, path := openTestLog(t)
+	appendBlocks(t, l, 1, 3, 2)
+
+	// roll back block 3
+	if err := l.Append([]Record{NewRecord(3, testBlock(3), "block", ActionRollback, "3", nil)}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, l *Log) {
+		tests := []struct {
+			height int64
+			seq    uint64
+			err    error
+		}{
+			{1, 2, nil},
+			{2, 4, nil},
+			{3, 0, ErrNoBlock},
+			{4, 0, ErrNoBlock},
+		}
+		for _, tc := range tests {
+			seq, err := l.Find(testBlock(tc.height))
+			if err != tc.err || seq != tc.seq {
+				t.Errorf("block %d: got seq=%d err=%v, want %d %v", tc.height, seq, err, tc.seq, tc.err)
+			}
+		}
+	}
+	check(t, l)
+
+	// the block index and sequence survive a restart
+	l.Close()
+	l, err := Open("bolt", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	check(t, l)
+	if l.Last() != 7 {
+		t.Errorf("got last %d after reopen, want 7", l.Last())
+	}
+}
+
+func TestLogTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		cursor  uint64 // sink position, 0 = no sink
+		height  int64
+		removed int
+		first   uint64
+	}{
+		{"no sink", 0, 5, 8, 9},
+		{"slow sink", 3, 5, 3, 4},
+		{"caught up sink", 20, 5, 8, 9},
+		{"keeps last record", 0, 100, 19, 20},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, _ := openTestLog(t)
+			defer l.Close()
+			appendBlocks(t, l, 1, 10, 2)
+			if tc.cursor > 0 {
+				if err := l.SetCursor("sink", tc.cursor); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n, err := l.Trim(tc.height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.removed || l.First() != tc.first {
+				t.Fatalf("got removed=%d first=%d, want %d %d", n, l.First(), tc.removed, tc.first)
+			}
+
+			// reading from a removed position fails, others succeed
+			if tc.first > 1 {
+				err := l.Read(tc.first-2, 0, func(Record) error { return nil })
+				if err != ErrTrimmed {
+					t.Errorf("got %v, want ErrTrimmed", err)
+				}
+			}
+			if recs := readAll(t, l, tc.first-1, 0); len(recs) != 20-int(tc.first)+1 {
+				t.Errorf("got %d records after trim, want %d", len(recs), 20-int(tc.first)+1)
+			}
+
+			// removed blocks are no longer found
+			for h := int64(1); h <= 10; h++ {
+				_, err := l.Find(testBlock(h))
+				if want := uint64(2 * h); want < tc.first {
+					if err != ErrNoBlock {
+						t.Errorf("block %d: got %v, want ErrNoBlock", h, err)
+					}
+				} else if err != nil {
+					t.Errorf("block %d: %v", h, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	PumpBatchSize  = 1024
+	PumpRetryDelay = 5 * time.Second
+)
+
+// Pump delivers records from the change log to a sink. The position of the
+// last delivered record is stored in the log so delivery resumes after a
+// restart. Records may be delivered more than once after a failure. Batches
+// always end at a change set boundary so sinks never see partial blocks.
+type Pump struct {
+	name string
+	log  *Log
+	sink Sink
+}
+
+func NewPump(name string, l *Log, s Sink) *Pump {
+	return &Pump{
+		name: name,
+		log:  l,
+		sink: s,
+	}
+}
+
+// Run delivers records until ctx is canceled.
+func (p *Pump) Run(ctx context.Context) {
+	defer p.sink.Close()
+	seq := p.log.Cursor(p.name)
+	log.Infof("Starting change sink %s at seq %d.", p.name, seq)
+	for {
+		// register for notification before reading to not miss appends
+		wait := p.log.Wait()
+		recs := make([]Record, 0, PumpBatchSize)
+		err := p.log.Read(seq, 0, func(r Record) error {
+			recs = append(recs, r)
+			if r.Commit && len(recs) >= PumpBatchSize {
+				return io.EOF
+			}
+			return nil
+		})
+		if err == nil && len(recs) > 0 {
+			if err = p.sink.Write(recs); err == nil {
+				seq = recs[len(recs)-1].Seq
+				err = p.log.SetCursor(p.name, seq)
+			}
+		}
+		if err != nil {
+			log.Errorf("Change sink %s: %v", p.name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(PumpRetryDelay):
+			}
+			continue
+		}
+		if len(recs) >= PumpBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wait:
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"encoding/json"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+type Action string
+
+const (
+	ActionInsert   Action = "insert"
+	ActionUpdate   Action = "update"
+	ActionDelete   Action = "delete"
+	ActionCopy     Action = "copy"     // bigmap copy, data contains source and destination
+	ActionRollback Action = "rollback" // block was disconnected, drop all rows at height
+)
+
+// Record is a single row-level change. Records are assigned a unique and
+// strictly increasing sequence number when appended to the change log.
+// Commit marks the last record of a change set, consumers that apply changes
+// atomically should buffer records until they see the commit flag.
+type Record struct {
+	Seq    uint64          `json:"seq"`
+	Height int64           `json:"height"`
+	Block  tezos.BlockHash `json:"block"`
+	Table  string          `json:"table"`
+	Action Action          `json:"action"`
+	Key    string          `json:"key"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Commit bool            `json:"commit,omitempty"`
+}
+
+// NewRecord creates a record for table row key with JSON encoded data.
+func NewRecord(height int64, block tezos.BlockHash, table string, action Action, key string, data interface{}) Record {
+	r := Record{
+		Height: height,
+		Block:  block,
+		Table:  table,
+		Action: action,
+		Key:    key,
+	}
+	if data != nil {
+		r.Data, _ = json.Marshal(data)
+	}
+	return r
+}
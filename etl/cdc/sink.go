@@ -0,0 +1,251 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives change records in sequence order. Write must only return
+// without error after all records have been handed over.
+type Sink interface {
+	Write(recs []Record) error
+	Close() error
+}
+
+// SinkFactory creates a sink from a configuration URL.
+type SinkFactory func(u *url.URL) (Sink, error)
+
+var sinkFactories = map[string]SinkFactory{}
+
+// RegisterSink makes a sink available under URL scheme.
+func RegisterSink(scheme string, fn SinkFactory) {
+	sinkFactories[scheme] = fn
+}
+
+func init() {
+	RegisterSink("file", newFileSink)
+	RegisterSink("stdout", newStdoutSink)
+	RegisterSink("tcp", newTCPSink)
+	RegisterSink("nats", newNatsSink)
+}
+
+// NewSink creates a sink from an URL like file:///var/log/changes.json,
+// stdout://, tcp://host:port or nats://host:port/subject.
+func NewSink(addr string) (Sink, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("cdc: invalid sink %q: %w", addr, err)
+	}
+	fn, ok := sinkFactories[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("cdc: unsupported sink type %q", u.Scheme)
+	}
+	return fn(u)
+}
+
+// writeLines writes records as newline delimited JSON.
+func writeLines(w io.Writer, recs []Record) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, r := range recs {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileSink appends newline delimited JSON records to a file.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(u *url.URL) (Sink, error) {
+	f, err := os.OpenFile(u.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(recs []Record) error {
+	w := bufio.NewWriter(s.f)
+	if err := writeLines(w, recs); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// stdoutSink writes newline delimited JSON records to standard output.
+type stdoutSink struct{}
+
+func newStdoutSink(_ *url.URL) (Sink, error) {
+	return stdoutSink{}, nil
+}
+
+func (s stdoutSink) Write(recs []Record) error {
+	return writeLines(os.Stdout, recs)
+}
+
+func (s stdoutSink) Close() error {
+	return nil
+}
+
+// tcpSink streams newline delimited JSON records to a TCP endpoint and
+// reconnects on the next write after a connection failure.
+type tcpSink struct {
+	addr string
+	conn net.Conn
+}
+
+func newTCPSink(u *url.URL) (Sink, error) {
+	return &tcpSink{addr: u.Host}, nil
+}
+
+func (s *tcpSink) Write(recs []Record) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	w := bufio.NewWriter(s.conn)
+	err := writeLines(w, recs)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *tcpSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// natsSink publishes records using the NATS text protocol. Each record is
+// published to subject <prefix>.<table>, the prefix is taken from the URL
+// path and defaults to tzindex.changes.
+type natsSink struct {
+	sync.Mutex
+	addr   string
+	prefix string
+	conn   net.Conn
+}
+
+func newNatsSink(u *url.URL) (Sink, error) {
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "tzindex.changes"
+	}
+	return &natsSink{addr: u.Host, prefix: prefix}, nil
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	// server greets with INFO
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("cdc: unexpected nats greeting %q", strings.TrimSpace(line))
+	}
+	conn.SetReadDeadline(time.Time{})
+	if _, err := io.WriteString(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"tzindex\"}\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+
+	// answer server keep-alive pings
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				s.Lock()
+				if s.conn == conn {
+					io.WriteString(conn, "PONG\r\n")
+				}
+				s.Unlock()
+			case strings.HasPrefix(line, "-ERR"):
+				log.Errorf("cdc: nats %s", strings.TrimSpace(line))
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *natsSink) Write(recs []Record) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(s.conn)
+	for _, r := range recs {
+		buf, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "PUB %s.%s %d\r\n", s.prefix, r.Table, len(buf))
+		w.Write(buf)
+		w.WriteString("\r\n")
+	}
+	if err := w.Flush(); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
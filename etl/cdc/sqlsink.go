@@ -0,0 +1,532 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"database/sql"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const sqlCheckpointTable = "tzindex_checkpoint"
+
+func init() {
+	RegisterSink("sqlite", newSQLiteSink)
+	RegisterSink("postgres", newPostgresSink)
+	RegisterSink("postgresql", newPostgresSink)
+}
+
+// sqlMirrorTables lists pack tables that can be mirrored with the columns
+// used as SQL primary key. Rows of append-only tables are removed by height
+// when a block is rolled back.
+var sqlMirrorTables = []struct {
+	name     string
+	proto    interface{}
+	keys     []string
+	rollback bool
+}{
+	{index.BlockTableKey, model.Block{}, []string{"row_id"}, true},
+	{index.OpTableKey, model.Op{}, []string{"row_id"}, true},
+	{index.EndorseOpTableKey, model.Endorsement{}, []string{"row_id"}, true},
+	{index.FlowTableKey, model.Flow{}, []string{"row_id"}, true},
+	{index.AccountTableKey, model.Account{}, []string{"row_id"}, false},
+	{index.BakerTableKey, model.Baker{}, []string{"row_id"}, false},
+	{index.ContractTableKey, model.Contract{}, []string{"row_id"}, false},
+	{index.BigmapAllocTableKey, model.BigmapAlloc{}, []string{"bigmap_id"}, false},
+	{index.BigmapValueTableKey, model.BigmapKV{}, []string{"bigmap_id", "key_id"}, false},
+}
+
+// sqlDialect captures differences between SQL databases.
+type sqlDialect struct {
+	driver string
+	types  map[pack.FieldType]string
+	bind   func(n int) string
+}
+
+var (
+	sqliteDialect = &sqlDialect{
+		driver: "sqlite",
+		types: map[pack.FieldType]string{
+			pack.FieldTypeBytes:    "BLOB",
+			pack.FieldTypeString:   "TEXT",
+			pack.FieldTypeDatetime: "TIMESTAMP",
+			pack.FieldTypeBoolean:  "BOOLEAN",
+			pack.FieldTypeFloat64:  "REAL",
+			pack.FieldTypeInt64:    "INTEGER",
+			pack.FieldTypeUint64:   "INTEGER",
+		},
+		bind: func(_ int) string { return "?" },
+	}
+	postgresDialect = &sqlDialect{
+		driver: "postgres",
+		types: map[pack.FieldType]string{
+			pack.FieldTypeBytes:    "BYTEA",
+			pack.FieldTypeString:   "TEXT",
+			pack.FieldTypeDatetime: "TIMESTAMPTZ",
+			pack.FieldTypeBoolean:  "BOOLEAN",
+			pack.FieldTypeFloat64:  "DOUBLE PRECISION",
+			pack.FieldTypeInt64:    "BIGINT",
+			pack.FieldTypeUint64:   "BIGINT",
+		},
+		bind: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+)
+
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// sqlColumn maps a stored struct field to a SQL column.
+type sqlColumn struct {
+	name string
+	typ  pack.FieldType
+	text bool // value uses a custom JSON encoding and is stored as text
+}
+
+// sqlTable holds generated statements for a mirrored table.
+type sqlTable struct {
+	name     string
+	cols     []sqlColumn
+	keys     []string
+	rollback bool
+	upsert   string
+	delete   string
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func newSQLTable(d *sqlDialect, name string, proto interface{}, keys []string, rollback bool) (*sqlTable, error) {
+	fields, err := pack.Fields(proto)
+	if err != nil {
+		return nil, err
+	}
+	t := &sqlTable{
+		name:     name,
+		keys:     keys,
+		rollback: rollback,
+	}
+
+	// use JSON names as column names and skip fields that are not
+	// contained in change records
+	typ := reflect.TypeOf(proto)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("pack"), ",")[0]
+		alias := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" || alias == "" || alias == "-" {
+			continue
+		}
+		field := fields.Find(tag)
+		if !field.IsValid() {
+			continue
+		}
+		col := sqlColumn{name: alias, typ: field.Type}
+		if f.Type != timeType {
+			pt := reflect.PtrTo(f.Type)
+			if pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+				// hashes, addresses and enums are stored in readable form
+				col.typ = pack.FieldTypeString
+				col.text = true
+			}
+		}
+		t.cols = append(t.cols, col)
+	}
+
+	names := make([]string, len(t.cols))
+	binds := make([]string, len(t.cols))
+	sets := make([]string, 0, len(t.cols))
+	for i, c := range t.cols {
+		names[i] = quote(c.name)
+		binds[i] = d.bind(i + 1)
+		sets = append(sets, quote(c.name)+" = EXCLUDED."+quote(c.name))
+	}
+	qkeys := make([]string, len(keys))
+	where := make([]string, len(keys))
+	for i, k := range keys {
+		qkeys[i] = quote(k)
+		where[i] = quote(k) + " = " + d.bind(i+1)
+	}
+	t.upsert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		quote(name),
+		strings.Join(names, ", "),
+		strings.Join(binds, ", "),
+		strings.Join(qkeys, ", "),
+		strings.Join(sets, ", "),
+	)
+	t.delete = fmt.Sprintf("DELETE FROM %s WHERE %s", quote(name), strings.Join(where, " AND "))
+	return t, nil
+}
+
+// DDL returns statements that create the table and its indexes.
+func (t *sqlTable) DDL(d *sqlDialect) []string {
+	cols := make([]string, 0, len(t.cols)+1)
+	for _, c := range t.cols {
+		cols = append(cols, quote(c.name)+" "+d.types[c.typ]+" NOT NULL")
+	}
+	qkeys := make([]string, len(t.keys))
+	for i, k := range t.keys {
+		qkeys[i] = quote(k)
+	}
+	cols = append(cols, "PRIMARY KEY ("+strings.Join(qkeys, ", ")+")")
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", quote(t.name), strings.Join(cols, ",\n\t")),
+	}
+	if t.rollback {
+		stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+			quote(t.name+"_height"), quote(t.name), quote("height")))
+	}
+	return stmts
+}
+
+// Values returns column values from JSON encoded record data.
+func (t *sqlTable) Values(data json.RawMessage) ([]interface{}, error) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(t.cols))
+	for i, c := range t.cols {
+		v, err := c.Value(row[c.name])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.name, c.name, err)
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// Value converts a JSON value into a SQL value. Missing values are
+// stored as zero values.
+func (c sqlColumn) Value(buf json.RawMessage) (interface{}, error) {
+	if len(buf) == 0 || string(buf) == "null" {
+		buf = nil
+	}
+	if c.text {
+		var str string
+		if buf == nil || json.Unmarshal(buf, &str) != nil {
+			// store non-string values as JSON text
+			str = string(buf)
+		}
+		return str, nil
+	}
+	// unquote numbers encoded as strings
+	raw := strings.Trim(string(buf), `"`)
+	switch c.typ {
+	case pack.FieldTypeInt64:
+		if raw == "" {
+			return int64(0), nil
+		}
+		return strconv.ParseInt(raw, 10, 64)
+	case pack.FieldTypeUint64:
+		if raw == "" {
+			return int64(0), nil
+		}
+		// SQL has no unsigned 64bit type, large values wrap around
+		u, err := strconv.ParseUint(raw, 10, 64)
+		return int64(u), err
+	case pack.FieldTypeFloat64:
+		if raw == "" {
+			return float64(0), nil
+		}
+		return strconv.ParseFloat(raw, 64)
+	case pack.FieldTypeBoolean:
+		return raw == "true", nil
+	case pack.FieldTypeDatetime:
+		var tm time.Time
+		if buf != nil {
+			if err := json.Unmarshal(buf, &tm); err != nil {
+				return nil, err
+			}
+		}
+		return tm.UTC(), nil
+	case pack.FieldTypeBytes:
+		b := []byte{}
+		if buf != nil {
+			if err := json.Unmarshal(buf, &b); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		var str string
+		if buf != nil {
+			if err := json.Unmarshal(buf, &str); err != nil {
+				return nil, err
+			}
+		}
+		return str, nil
+	}
+}
+
+// KeyValues parses the key of a change record into primary key values.
+func (t *sqlTable) KeyValues(key string) ([]interface{}, error) {
+	switch t.name {
+	case index.BigmapAllocTableKey:
+		id, err := strconv.ParseInt(key, 10, 64)
+		return []interface{}{id}, err
+	case index.BigmapValueTableKey:
+		n := strings.IndexByte(key, ':')
+		if n < 0 {
+			return nil, fmt.Errorf("invalid bigmap key %q", key)
+		}
+		id, err := strconv.ParseInt(key[:n], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		kh, err := tezos.ParseExprHash(key[n+1:])
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{id, int64(model.GetKeyId(id, kh))}, nil
+	default:
+		id, err := strconv.ParseUint(key, 10, 64)
+		return []interface{}{int64(id)}, err
+	}
+}
+
+// sqlSink mirrors change records into a SQL database. Each block is applied
+// in a single transaction together with a checkpoint that stores the last
+// applied record, block hash and height.
+type sqlSink struct {
+	db      *sql.DB
+	dialect *sqlDialect
+	tables  map[string]*sqlTable
+	seq     uint64
+}
+
+func newSQLiteSink(u *url.URL) (Sink, error) {
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+	return newSQLSink(sqliteDialect, path, u.Query().Get("tables"))
+}
+
+func newPostgresSink(u *url.URL) (Sink, error) {
+	q := u.Query()
+	tables := q.Get("tables")
+	q.Del("tables")
+	dsn := *u
+	dsn.Scheme = "postgres"
+	dsn.RawQuery = q.Encode()
+	return newSQLSink(postgresDialect, dsn.String(), tables)
+}
+
+func newSQLSink(d *sqlDialect, dsn, tables string) (Sink, error) {
+	want := make(map[string]bool)
+	for _, v := range strings.Split(tables, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			want[v] = true
+		}
+	}
+	s := &sqlSink{
+		dialect: d,
+		tables:  make(map[string]*sqlTable),
+	}
+	for _, v := range sqlMirrorTables {
+		if len(want) > 0 && !want[v.name] {
+			continue
+		}
+		delete(want, v.name)
+		t, err := newSQLTable(d, v.name, v.proto, v.keys, v.rollback)
+		if err != nil {
+			return nil, fmt.Errorf("cdc: sql table %s: %w", v.name, err)
+		}
+		s.tables[v.name] = t
+	}
+	for n := range want {
+		return nil, fmt.Errorf("cdc: sql mirror does not support table %q", n)
+	}
+
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d == sqliteDialect {
+		// sqlite supports a single writer only
+		db.SetMaxOpenConns(1)
+	}
+	s.db = db
+	if err := s.init(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cdc: sql mirror: %w", err)
+	}
+	return s, nil
+}
+
+// init creates tables as needed and loads the checkpoint.
+func (s *sqlSink) init() error {
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s INTEGER NOT NULL PRIMARY KEY,\n\t%s BIGINT NOT NULL,\n\t%s BIGINT NOT NULL,\n\t%s TEXT NOT NULL\n)",
+			quote(sqlCheckpointTable), quote("id"), quote("seq"), quote("height"), quote("block")),
+	}
+	for _, v := range sqlMirrorTables {
+		if t, ok := s.tables[v.name]; ok {
+			stmts = append(stmts, t.DDL(s.dialect)...)
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	var seq int64
+	err := s.db.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = 1", quote("seq"), quote(sqlCheckpointTable), quote("id"))).Scan(&seq)
+	switch err {
+	case nil:
+		s.seq = uint64(seq)
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+	return nil
+}
+
+func (s *sqlSink) Write(recs []Record) error {
+	for len(recs) > 0 {
+		// records of the same block are applied atomically
+		n := 1
+		for n < len(recs) && recs[n].Height == recs[0].Height && recs[n].Block.Equal(recs[0].Block) {
+			n++
+		}
+		if err := s.apply(recs[:n]); err != nil {
+			return fmt.Errorf("sql mirror block %d %s: %w", recs[0].Height, recs[0].Block, err)
+		}
+		recs = recs[n:]
+	}
+	return nil
+}
+
+func (s *sqlSink) apply(recs []Record) error {
+	// skip records that have been applied before
+	if recs[len(recs)-1].Seq <= s.seq {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range recs {
+		if r.Seq <= s.seq {
+			continue
+		}
+		if err := s.applyRecord(tx, r); err != nil {
+			return fmt.Errorf("%s %s %s: %w", r.Table, r.Action, r.Key, err)
+		}
+	}
+
+	last := recs[len(recs)-1]
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s) VALUES (1, %s, %s, %s) ON CONFLICT (%s) DO UPDATE SET %s = EXCLUDED.%s, %s = EXCLUDED.%s, %s = EXCLUDED.%s",
+		quote(sqlCheckpointTable), quote("id"), quote("seq"), quote("height"), quote("block"),
+		s.dialect.bind(1), s.dialect.bind(2), s.dialect.bind(3),
+		quote("id"),
+		quote("seq"), quote("seq"),
+		quote("height"), quote("height"),
+		quote("block"), quote("block"),
+	), int64(last.Seq), last.Height, last.Block.String())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.seq = last.Seq
+	return nil
+}
+
+func (s *sqlSink) applyRecord(tx *sql.Tx, r Record) error {
+	// rollback removes all rows of append-only tables at height
+	if r.Action == ActionRollback {
+		for _, t := range s.tables {
+			if !t.rollback {
+				continue
+			}
+			stmt := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", quote(t.name), quote("height"), s.dialect.bind(1))
+			if _, err := tx.Exec(stmt, r.Height); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t, ok := s.tables[r.Table]
+	if !ok {
+		return nil
+	}
+	switch r.Action {
+	case ActionInsert, ActionUpdate:
+		vals, err := t.Values(r.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(t.upsert, vals...)
+		return err
+
+	case ActionDelete:
+		keys, err := t.KeyValues(r.Key)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(t.delete, keys...); err != nil {
+			return err
+		}
+		// removing a bigmap also removes its keys
+		if vt, ok := s.tables[index.BigmapValueTableKey]; ok && t.name == index.BigmapAllocTableKey {
+			stmt := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", quote(vt.name), quote("bigmap_id"), s.dialect.bind(1))
+			if _, err := tx.Exec(stmt, keys[0]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case ActionCopy:
+		// copy the allocation, copied keys follow as inserts
+		var cp struct {
+			SourceId int64 `json:"source_id"`
+			DestId   int64 `json:"dest_id"`
+		}
+		if err := json.Unmarshal(r.Data, &cp); err != nil {
+			return err
+		}
+		names := make([]string, len(t.cols))
+		sel := make([]string, len(t.cols))
+		for i, c := range t.cols {
+			names[i] = quote(c.name)
+			sel[i] = quote(c.name)
+			if c.name == "bigmap_id" {
+				sel[i] = "CAST(" + s.dialect.bind(1) + " AS BIGINT)"
+			}
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s = %s ON CONFLICT DO NOTHING",
+			quote(t.name), strings.Join(names, ", "), strings.Join(sel, ", "),
+			quote(t.name), quote("bigmap_id"), s.dialect.bind(2))
+		_, err := tx.Exec(stmt, cp.DestId, cp.SourceId)
+		return err
+	}
+	return nil
+}
+
+func (s *sqlSink) Close() error {
+	return s.db.Close()
+}
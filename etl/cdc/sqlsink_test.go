@@ -0,0 +1,274 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cdc
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+func openTestSQLSink(t *testing.T, path, tables string) *sqlSink {
+	t.Helper()
+	s, err := newSQLSink(sqliteDialect, path, tables)
+	if err != nil {
+		t.Fatalf("open sql sink: %v", err)
+	}
+	return s.(*sqlSink)
+}
+
+// testRecord creates a record with sequence number seq.
+func testRecord(seq uint64, height int64, table string, action Action, key string, data interface{}) Record {
+	r := NewRecord(height, testBlock(height), table, action, key, data)
+	r.Seq = seq
+	return r
+}
+
+// queryInts returns the integer column col of all rows in table sorted
+// ascending.
+func queryInts(t *testing.T, s *sqlSink, table, col string) []int64 {
+	t.Helper()
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM %s", quote(col), quote(table)))
+	if err != nil {
+		t.Fatalf("query %s: %v", table, err)
+	}
+	defer rows.Close()
+	vals := make([]int64, 0)
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	return vals
+}
+
+func equalInts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSQLSinkCreateTables(t *testing.T) {
+	tests := []struct {
+		name   string
+		tables string
+		want   []string
+		err    bool
+	}{
+		{"all", "", []string{index.BlockTableKey, index.OpTableKey, index.AccountTableKey, index.BigmapValueTableKey}, false},
+		{"selected", "op, account", []string{index.OpTableKey, index.AccountTableKey}, false},
+		{"unsupported", "op,rights", nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mirror.db")
+			sink, err := newSQLSink(sqliteDialect, path, tc.tables)
+			if tc.err {
+				if err == nil {
+					sink.Close()
+					t.Fatal("expected error for unsupported table")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := sink.(*sqlSink)
+			if len(s.tables) < len(tc.want) {
+				t.Errorf("got %d mirrored tables, want at least %d", len(s.tables), len(tc.want))
+			}
+			for _, name := range append(tc.want, sqlCheckpointTable) {
+				var n int
+				err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+				if err != nil || n != 1 {
+					t.Errorf("table %s: got %d err=%v, want created", name, n, err)
+				}
+			}
+			if tc.tables == "" {
+				var n int
+				err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?", index.OpTableKey+"_height").Scan(&n)
+				if err != nil || n != 1 {
+					t.Errorf("height index: got %d err=%v, want created", n, err)
+				}
+			}
+
+			// opening an existing database keeps tables
+			s.Close()
+			s = openTestSQLSink(t, path, tc.tables)
+			s.Close()
+		})
+	}
+}
+
+func TestSQLSinkApply(t *testing.T) {
+	kh := tezos.NewExprHash(make([]byte, 32))
+	tests := []struct {
+		name  string
+		recs  []Record
+		table string
+		col   string
+		want  []int64
+	}{
+		{
+			name: "insert",
+			recs: []Record{
+				testRecord(1, 5, index.OpTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1, "height": 5, "volume": 100}),
+				testRecord(2, 5, index.OpTableKey, ActionInsert, "2", map[string]interface{}{"row_id": 2, "height": 5, "volume": 200}),
+			},
+			table: index.OpTableKey,
+			col:   "volume",
+			want:  []int64{100, 200},
+		},
+		{
+			name: "update upserts",
+			recs: []Record{
+				testRecord(1, 5, index.AccountTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1, "spendable_balance": "10"}),
+				testRecord(2, 6, index.AccountTableKey, ActionUpdate, "1", map[string]interface{}{"row_id": 1, "spendable_balance": "15"}),
+			},
+			table: index.AccountTableKey,
+			col:   "spendable_balance",
+			want:  []int64{15},
+		},
+		{
+			name: "delete",
+			recs: []Record{
+				testRecord(1, 5, index.AccountTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1}),
+				testRecord(2, 5, index.AccountTableKey, ActionInsert, "2", map[string]interface{}{"row_id": 2}),
+				testRecord(3, 6, index.AccountTableKey, ActionDelete, "1", nil),
+			},
+			table: index.AccountTableKey,
+			col:   "row_id",
+			want:  []int64{2},
+		},
+		{
+			name: "delete bigmap key",
+			recs: []Record{
+				testRecord(1, 5, index.BigmapValueTableKey, ActionInsert, "", map[string]interface{}{"bigmap_id": 7, "key_id": model.GetKeyId(7, kh), "height": 5}),
+				testRecord(2, 5, index.BigmapValueTableKey, ActionInsert, "", map[string]interface{}{"bigmap_id": 7, "key_id": 1, "height": 5}),
+				testRecord(3, 6, index.BigmapValueTableKey, ActionDelete, "7:"+kh.String(), nil),
+			},
+			table: index.BigmapValueTableKey,
+			col:   "key_id",
+			want:  []int64{1},
+		},
+		{
+			name: "delete bigmap removes keys",
+			recs: []Record{
+				testRecord(1, 5, index.BigmapAllocTableKey, ActionInsert, "7", map[string]interface{}{"bigmap_id": 7}),
+				testRecord(2, 5, index.BigmapValueTableKey, ActionInsert, "", map[string]interface{}{"bigmap_id": 7, "key_id": 1}),
+				testRecord(3, 5, index.BigmapValueTableKey, ActionInsert, "", map[string]interface{}{"bigmap_id": 8, "key_id": 2}),
+				testRecord(4, 6, index.BigmapAllocTableKey, ActionDelete, "7", nil),
+			},
+			table: index.BigmapValueTableKey,
+			col:   "bigmap_id",
+			want:  []int64{8},
+		},
+		{
+			name: "copy bigmap",
+			recs: []Record{
+				testRecord(1, 5, index.BigmapAllocTableKey, ActionInsert, "7", map[string]interface{}{"bigmap_id": 7, "height": 5}),
+				testRecord(2, 6, index.BigmapAllocTableKey, ActionCopy, "9", map[string]interface{}{"source_id": 7, "dest_id": 9}),
+			},
+			table: index.BigmapAllocTableKey,
+			col:   "bigmap_id",
+			want:  []int64{7, 9},
+		},
+		{
+			name: "rollback drops rows at height",
+			recs: []Record{
+				testRecord(1, 5, index.OpTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1, "height": 5}),
+				testRecord(2, 6, index.OpTableKey, ActionInsert, "2", map[string]interface{}{"row_id": 2, "height": 6}),
+				testRecord(3, 6, index.OpTableKey, ActionInsert, "3", map[string]interface{}{"row_id": 3, "height": 6}),
+				testRecord(4, 6, "", ActionRollback, "", nil),
+			},
+			table: index.OpTableKey,
+			col:   "row_id",
+			want:  []int64{1},
+		},
+		{
+			name: "rollback keeps state tables",
+			recs: []Record{
+				testRecord(1, 6, index.AccountTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1}),
+				testRecord(2, 6, "", ActionRollback, "", nil),
+			},
+			table: index.AccountTableKey,
+			col:   "row_id",
+			want:  []int64{1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := openTestSQLSink(t, filepath.Join(t.TempDir(), "mirror.db"), "")
+			defer s.Close()
+			if err := s.Write(tc.recs); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryInts(t, s, tc.table, tc.col); !equalInts(got, tc.want) {
+				t.Errorf("got %s %v, want %v", tc.col, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSQLSinkResume checks that a reopened sink continues after the stored
+// checkpoint and skips records that were applied before.
+func TestSQLSinkResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.db")
+	s := openTestSQLSink(t, path, index.OpTableKey)
+	err := s.Write([]Record{
+		testRecord(1, 5, index.OpTableKey, ActionInsert, "1", map[string]interface{}{"row_id": 1, "height": 5, "volume": 100}),
+		testRecord(2, 6, index.OpTableKey, ActionInsert, "2", map[string]interface{}{"row_id": 2, "height": 6, "volume": 200}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openTestSQLSink(t, path, index.OpTableKey)
+	defer s.Close()
+	if s.seq != 2 {
+		t.Fatalf("got checkpoint seq %d, want 2", s.seq)
+	}
+	var (
+		height int64
+		block  string
+	)
+	err = s.db.QueryRow(fmt.Sprintf("SELECT %s, %s FROM %s", quote("height"), quote("block"), quote(sqlCheckpointTable))).Scan(&height, &block)
+	if err != nil || height != 6 || block != testBlock(6).String() {
+		t.Errorf("got checkpoint height=%d block=%s err=%v, want 6 %s", height, block, err, testBlock(6))
+	}
+
+	// records up to the checkpoint are skipped when replayed
+	err = s.Write([]Record{
+		testRecord(2, 6, index.OpTableKey, ActionInsert, "2", map[string]interface{}{"row_id": 2, "height": 6, "volume": 999}),
+		testRecord(3, 7, index.OpTableKey, ActionInsert, "3", map[string]interface{}{"row_id": 3, "height": 7, "volume": 300}),
+		testRecord(4, 7, index.OpTableKey, ActionInsert, "4", map[string]interface{}{"row_id": 4, "height": 7, "volume": 400}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queryInts(t, s, index.OpTableKey, "volume"), []int64{100, 200, 300, 400}; !equalInts(got, want) {
+		t.Errorf("got volumes %v, want %v", got, want)
+	}
+	if s.seq != 4 {
+		t.Errorf("got checkpoint seq %d, want 4", s.seq)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// TestChangeRowRoundTrip checks that rows captured for the change stream
+// decode into equal rows on replicas, including fields that are hidden from
+// the regular JSON encoding.
+func TestChangeRowRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		item  pack.Item
+		proto func() pack.Item
+		check func(t *testing.T, in, out pack.Item)
+	}{
+		{
+			name: "gas stats",
+			item: &model.GasStats{
+				RowId: 3, AccountId: 5, Entrypoint: 1, Height: 100, NCalls: 2,
+				GasData: []byte{1, 2}, StorageData: []byte{3}, FeeData: []byte{4, 5, 6},
+			},
+			proto: func() pack.Item { return &model.GasStats{} },
+			check: func(t *testing.T, in, out pack.Item) {
+				a, b := in.(*model.GasStats), out.(*model.GasStats)
+				if a.RowId != b.RowId || a.NCalls != b.NCalls || a.Height != b.Height ||
+					!bytes.Equal(a.GasData, b.GasData) || !bytes.Equal(a.StorageData, b.StorageData) ||
+					!bytes.Equal(a.FeeData, b.FeeData) {
+					t.Errorf("got %+v, want %+v", b, a)
+				}
+			},
+		},
+		{
+			name:  "reorg",
+			item:  &model.Reorg{RowId: 1, ForkHeight: 42, Depth: 2, Data: []byte(`[{"height":43}]`)},
+			proto: func() pack.Item { return &model.Reorg{} },
+			check: func(t *testing.T, in, out pack.Item) {
+				a, b := in.(*model.Reorg), out.(*model.Reorg)
+				if a.RowId != b.RowId || a.ForkHeight != b.ForkHeight || a.Depth != b.Depth || !bytes.Equal(a.Data, b.Data) {
+					t.Errorf("got %+v, want %+v", b, a)
+				}
+			},
+		},
+		{
+			name: "contract",
+			item: &model.Contract{
+				RowId:      7,
+				Features:   micheline.FeatureTicket | micheline.FeatureSapling,
+				Interfaces: micheline.Interfaces{micheline.ITzip5},
+			},
+			proto: func() pack.Item { return &model.Contract{} },
+			check: func(t *testing.T, in, out pack.Item) {
+				a, b := in.(*model.Contract), out.(*model.Contract)
+				if a.RowId != b.RowId || a.Features != b.Features || len(b.Interfaces) != 1 || b.Interfaces[0] != a.Interfaces[0] {
+					t.Errorf("got %+v, want %+v", b, a)
+				}
+			},
+		},
+		{
+			name:  "delegation period",
+			item:  &model.DelegationPeriod{RowId: 9, AccountId: 2, BakerId: 3, StartHeight: 10, EndHeight: 20},
+			proto: func() pack.Item { return &model.DelegationPeriod{} },
+			check: func(t *testing.T, in, out pack.Item) {
+				if *in.(*model.DelegationPeriod) != *out.(*model.DelegationPeriod) {
+					t.Errorf("got %+v, want %+v", out, in)
+				}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var data interface{}
+			if c, ok := tc.item.(*model.Contract); ok {
+				data = newContractRow(c)
+			} else {
+				data = changeRow(tc.item)
+			}
+			buf, err := json.Marshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := tc.proto()
+			if err := decodeRow(buf, out); err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, tc.item, out)
+		})
+	}
+}
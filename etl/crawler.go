@@ -37,6 +37,7 @@ const (
 	MODE_INFO     Mode = "info"
 	MODE_LIGHT    Mode = "light"
 	MODE_ROLLBACK Mode = "rollback"
+	MODE_REPLICA  Mode = "replica"
 )
 
 const (
@@ -68,6 +69,7 @@ type CrawlerConfig struct {
 	Snapshot      *SnapshotConfig
 	EnableMonitor bool
 	Validate      bool
+	Primary       string
 }
 
 type SnapshotConfig struct {
@@ -99,6 +101,7 @@ type Crawler struct {
 	chainId   tezos.ChainIdHash
 	delay     int64
 	wasInSync bool
+	primary   string
 
 	// read-mostly thread-safe access
 	tipStore atomic.Value
@@ -130,6 +133,7 @@ func NewCrawler(cfg CrawlerConfig) *Crawler {
 		filter:        NewReorgDelayFilter(cfg.Delay, queue),
 		delay:         int64(cfg.Delay),
 		plog:          NewBlockProgressLogger("Processed"),
+		primary:       cfg.Primary,
 		quit:          make(chan struct{}),
 	}
 }
@@ -241,12 +245,19 @@ func (c *Crawler) CacheStats() map[string]interface{} {
 }
 
 type CrawlerStatus struct {
-	Mode      Mode    `json:"mode"`
-	Status    State   `json:"status"`
-	Blocks    int64   `json:"blocks"`
-	Finalized int64   `json:"finalized"`
-	Indexed   int64   `json:"indexed"`
-	Progress  float64 `json:"progress"`
+	Mode          Mode    `json:"mode"`
+	Status        State   `json:"status"`
+	Blocks        int64   `json:"blocks"`
+	Finalized     int64   `json:"finalized"`
+	Indexed       int64   `json:"indexed"`
+	Progress      float64 `json:"progress"`
+	Filtered      bool    `json:"filtered"`
+	Pruned        bool    `json:"pruned"`
+	HorizonHeight int64   `json:"horizon_height,omitempty"`
+	HorizonCycle  int64   `json:"horizon_cycle,omitempty"`
+
+	// indexes that are catching up with the chain tip
+	Backfill []BackfillStatus `json:"backfill,omitempty"`
 }
 
 func (c *Crawler) Status() CrawlerStatus {
@@ -261,6 +272,15 @@ func (c *Crawler) Status() CrawlerStatus {
 	if c.indexer.lightMode {
 		s.Mode = MODE_LIGHT
 	}
+	s.Filtered = c.indexer.IsFiltered()
+	if h := c.indexer.Horizon(); h.Height > 0 {
+		s.Pruned = true
+		s.HorizonHeight = h.Height
+		s.HorizonCycle = h.Cycle
+	}
+	if bf := c.indexer.BackfillStatus(); len(bf) > 0 {
+		s.Backfill = bf
+	}
 	if tip.BestHeight > 0 && c.bchead != nil && c.bchead.Level > 0 {
 		s.Blocks = c.bchead.Level
 		s.Finalized = c.bchead.Level - 1
@@ -317,6 +337,9 @@ func (c *Crawler) Init(ctx context.Context, mode Mode) error {
 	if err != nil {
 		return err
 	}
+	if firstRun && mode == MODE_REPLICA {
+		return fmt.Errorf("Replica requires a copy of the primary database.")
+	}
 	if firstRun {
 		// create initial state
 		log.Info("Creating blockchain storage.")
@@ -359,7 +382,7 @@ func (c *Crawler) Init(ctx context.Context, mode Mode) error {
 	}
 
 	// skip RPC init if not required
-	if c.rpc == nil || mode == MODE_INFO {
+	if c.rpc == nil || mode == MODE_INFO || mode == MODE_REPLICA {
 		c.setState(STATE_STOPPED, MONITOR_DISABLE)
 		return nil
 	}
@@ -454,6 +477,11 @@ func (c *Crawler) Init(ctx context.Context, mode Mode) error {
 
 // run goroutine
 func (c *Crawler) Start() {
+	if c.mode == MODE_REPLICA {
+		log.Info("Starting replica.")
+		go c.runReplica()
+		return
+	}
 	log.Info("Starting blockchain crawler.")
 	go c.syncBlockchain()
 }
@@ -600,6 +628,41 @@ func (c *Crawler) runMonitor(next chan<- tezos.BlockHash) {
 	}
 }
 
+func (c *Crawler) runBackfill(ctx context.Context) {
+	log.Infof("Starting index backfill.")
+	c.wg.Add(1)
+	defer c.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.quit:
+			return
+		default:
+		}
+		done, err := c.indexer.Backfill(ctx, c.rpc, BackfillBatchSize)
+		if err != nil {
+			if err == errInterruptRequested {
+				return
+			}
+			// retry later, RPC errors are often transient
+			log.Errorf("Backfill: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.quit:
+				return
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+		if done {
+			log.Infof("Index backfill completed.")
+			return
+		}
+	}
+}
+
 func (c *Crawler) runIngest(next chan tezos.BlockHash) {
 	// on shutdown wait for this goroutine to stop
 	log.Infof("Starting blockchain ingest.")
@@ -838,6 +901,11 @@ func (c *Crawler) syncBlockchain() {
 	c.ingest(ctx)
 	defer drain(c.finalized)
 
+	// catch up indexes that were enabled on an existing database
+	if c.indexer.NeedsBackfill() {
+		go c.runBackfill(ctx)
+	}
+
 	var (
 		tzblock    *rpc.Bundle
 		ctxNonStop = context.Background()
@@ -886,6 +954,13 @@ func (c *Crawler) syncBlockchain() {
 		if err != nil {
 			log.Errorf("Updating database for block %d: %s", tip.BestHeight, err)
 		}
+
+		// keep warm caches for next start
+		if state, _ := c.getState(); state != STATE_FAILED {
+			if err := c.indexer.StoreCaches(tip); err != nil {
+				log.Errorf("Storing caches: %s", err)
+			}
+		}
 		c.cancel()
 	}()
 
@@ -1038,6 +1113,18 @@ func (c *Crawler) syncBlockchain() {
 			if err := c.indexer.updateRights(ctx, block.Height); err != nil {
 				log.Errorf("updating rights cache: %s", err)
 			}
+
+			// checkpoint warm caches once per cycle
+			if block.Params.IsCycleStart(block.Height) {
+				if err := c.indexer.StoreCaches(tip); err != nil {
+					log.Errorf("storing caches: %s", err)
+				}
+
+				// snapshot holder distribution of completed cycles
+				if err := c.indexer.UpdateDistributions(ctx, block.Height); err != nil {
+					log.Errorf("updating balance distribution: %s", err)
+				}
+			}
 		}
 
 		// log progress once every 10sec
@@ -1059,6 +1146,11 @@ func (c *Crawler) syncBlockchain() {
 			log.Errorf("Snapshot failed at block %d: %s", tip.BestHeight, err)
 		}
 
+		// delete history outside the retention window
+		if err := c.indexer.Prune(ctx, block); err != nil {
+			log.Errorf("Pruning history at block %d: %s", tip.BestHeight, err)
+		}
+
 		if c.stopHeight > 0 && tip.BestHeight >= c.stopHeight {
 			log.Infof("Stopping blockchain sync after block height %d.", tip.BestHeight)
 			return
@@ -0,0 +1,345 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// distCacheSize is the number of distributions reconstructed on demand that
+// are kept in memory.
+const distCacheSize = 16
+
+// distEntry is a distribution reconstructed on demand. Concurrent requests
+// for the same height wait for a single build.
+type distEntry struct {
+	done chan struct{}
+	dist *model.Distribution
+	err  error
+}
+
+// BalanceDistribution returns holder statistics and top holders at the end of
+// block height. Precomputed cycle snapshots are used when available, other
+// heights are reconstructed from balance history once and then served from
+// a small in-memory cache.
+func (m *Indexer) BalanceDistribution(ctx context.Context, height int64) (*model.Distribution, error) {
+	table, err := m.Table(index.DistributionTableKey)
+	if err != nil {
+		return nil, err
+	}
+	d := &model.Distribution{}
+	err = pack.NewQuery("find_distribution", table).
+		WithLimit(1).
+		AndEqual("height", height).
+		Execute(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	if d.RowId > 0 {
+		if err := d.Decode(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	m.dmu.Lock()
+	e, ok := m.dists[height]
+	if !ok {
+		if m.dists == nil {
+			m.dists = make(map[int64]*distEntry)
+		}
+		e = &distEntry{done: make(chan struct{})}
+		m.dists[height] = e
+		m.distOrder = append(m.distOrder, height)
+		if len(m.distOrder) > distCacheSize {
+			delete(m.dists, m.distOrder[0])
+			m.distOrder = m.distOrder[1:]
+		}
+	}
+	m.dmu.Unlock()
+
+	if !ok {
+		var list []*model.Distribution
+		list, e.err = m.buildDistributions(ctx, []int64{height})
+		if e.err == nil {
+			e.dist = list[0]
+		} else {
+			m.dropDistribution(height, e)
+		}
+		close(e.done)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.done:
+		return e.dist, e.err
+	}
+}
+
+// dropDistributions removes cached distributions at or above height, e.g.
+// after a block was rolled back.
+func (m *Indexer) dropDistributions(height int64) {
+	m.dmu.Lock()
+	defer m.dmu.Unlock()
+	order := m.distOrder[:0]
+	for _, h := range m.distOrder {
+		if h >= height {
+			delete(m.dists, h)
+		} else {
+			order = append(order, h)
+		}
+	}
+	m.distOrder = order
+}
+
+// dropDistribution removes a single cache entry unless it was replaced.
+func (m *Indexer) dropDistribution(height int64, e *distEntry) {
+	m.dmu.Lock()
+	defer m.dmu.Unlock()
+	if m.dists[height] != e {
+		return
+	}
+	delete(m.dists, height)
+	for i, h := range m.distOrder {
+		if h == height {
+			m.distOrder = append(m.distOrder[:i], m.distOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// UpdateDistributions stores snapshots for all completed cycles before height
+// that are not yet indexed, back to the pruning horizon. All missing cycles
+// are reconstructed in a single pass over balance history. The pass runs in
+// the background so that block indexing continues, calls made while a pass
+// is still running are ignored and missing cycles are picked up next time.
+func (m *Indexer) UpdateDistributions(ctx context.Context, height int64) error {
+	table, err := m.Table(index.DistributionTableKey)
+	if err != nil {
+		return nil
+	}
+	params := m.ParamsByHeight(height)
+	if params == nil {
+		return nil
+	}
+	first, last := m.Horizon().Cycle, params.CycleFromHeight(height)-1
+	if last < first {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.distributing {
+		return nil
+	}
+	m.distributing = true
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := m.updateDistributions(ctx, table, params, first, last, height)
+		m.mu.Lock()
+		m.distributing = false
+		m.mu.Unlock()
+		if err != nil && err != errInterruptRequested {
+			log.Errorf("Updating balance distribution: %s", err)
+		}
+	}()
+	return nil
+}
+
+func (m *Indexer) updateDistributions(ctx context.Context, table *pack.Table, params *tezos.Params, first, last, height int64) error {
+	// find indexed cycles
+	have := make(map[int64]struct{})
+	d := &model.Distribution{}
+	err := pack.NewQuery("list_distribution_cycles", table).
+		WithFields("cycle").
+		AndGte("cycle", first).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(d); err != nil {
+				return err
+			}
+			have[d.Cycle] = struct{}{}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// collect missing cycle end heights, most recent first
+	heights := make([]int64, 0)
+	for c := last; c >= first; c-- {
+		if _, ok := have[c]; ok {
+			continue
+		}
+		if h := params.CycleEndHeight(c); h > 0 && h < height {
+			heights = append(heights, h)
+		}
+	}
+	if len(heights) == 0 {
+		return nil
+	}
+	log.Infof("Building %d balance distribution snapshot(s).", len(heights))
+
+	list, err := m.buildDistributions(ctx, heights)
+	if err != nil {
+		return err
+	}
+	if interruptRequested(ctx) {
+		return errInterruptRequested
+	}
+	ins := make([]pack.Item, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if err := list[i].Encode(); err != nil {
+			return err
+		}
+		ins = append(ins, list[i])
+	}
+	if err := table.Insert(ctx, ins); err != nil {
+		return err
+	}
+	if err := table.Flush(ctx); err != nil {
+		return err
+	}
+	return m.captureDistributions(list)
+}
+
+// buildDistributions reconstructs holder balances at each of the given heights
+// starting from current account balances and walking balance history backwards.
+// Balance history entries are valid until the block where a balance changed,
+// so the balance at height h is the first entry after h or the current balance.
+// Accounts are read without blocking block processing. History entries
+// store the balance before each change, so replaying all entries up to the
+// live height reached after the account scan undoes any change the scan may
+// have seen. Later entries are skipped. The pass fails when a block is rolled
+// back meanwhile because rolled back history is no longer available.
+func (m *Indexer) buildDistributions(ctx context.Context, heights []int64) ([]*model.Distribution, error) {
+	accounts, err := m.Table(index.AccountTableKey)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := m.Table(index.BalanceTableKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	params := m.ParamsByHeight(heights[0])
+	if params == nil {
+		return nil, fmt.Errorf("missing params for height %d", heights[0])
+	}
+	unit := int64(math.Pow10(params.Decimals))
+
+	// load current balances and account creation heights
+	type XAcc struct {
+		RowId            model.AccountID `pack:"I,pk"`
+		FirstSeen        int64           `pack:"0"`
+		SpendableBalance int64           `pack:"s"`
+		FrozenBond       int64           `pack:"L"`
+	}
+	var (
+		a     = &XAcc{}
+		first = make([]int64, 0)
+		bal   = make(map[model.AccountID]int64)
+	)
+	m.bfmu.Lock()
+	rollbacks := m.rollbacks
+	m.bfmu.Unlock()
+	err = pack.NewQuery("build_distribution_accounts", accounts).
+		WithoutCache().
+		WithFields("I", "0", "s", "L").
+		AndLte("first_seen", heights[0]).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(a); err != nil {
+				return err
+			}
+			for int(a.RowId) >= len(first) {
+				first = append(first, math.MaxInt64)
+			}
+			first[a.RowId] = a.FirstSeen
+			if b := a.SpendableBalance + a.FrozenBond; b > 0 {
+				bal[a.RowId] = b
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// blocks connected during the scan are complete once the lock is held
+	m.bfmu.Lock()
+	top := m.liveHeight()
+	m.bfmu.Unlock()
+
+	snapshot := func(height int64) *model.Distribution {
+		holders := make([]model.HolderBalance, 0, len(bal))
+		for id, b := range bal {
+			if int(id) < len(first) && first[id] <= height {
+				holders = append(holders, model.HolderBalance{AccountId: id, Balance: b})
+			}
+		}
+		d := model.NewDistribution(holders, unit)
+		d.Height = height
+		d.Cycle = params.CycleFromHeight(height)
+		d.Timestamp = m.LookupBlockTime(ctx, height)
+		return d
+	}
+
+	// walk balance history backwards, rows are inserted in block order
+	type XBal struct {
+		AccountId  model.AccountID `pack:"A"`
+		Balance    int64           `pack:"B"`
+		ValidUntil int64           `pack:"<"`
+	}
+	var (
+		b    = &XBal{}
+		i    int
+		list = make([]*model.Distribution, 0, len(heights))
+	)
+	err = pack.NewQuery("build_distribution_balances", balances).
+		WithoutCache().
+		WithDesc().
+		WithFields("A", "B", "<").
+		AndGt("valid_until", heights[len(heights)-1]).
+		AndLte("valid_until", top).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(b); err != nil {
+				return err
+			}
+			for i < len(heights) && b.ValidUntil <= heights[i] {
+				list = append(list, snapshot(heights[i]))
+				i++
+			}
+			if i == len(heights) {
+				return io.EOF
+			}
+			if b.Balance > 0 {
+				bal[b.AccountId] = b.Balance
+			} else {
+				delete(bal, b.AccountId)
+			}
+			return nil
+		})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for ; i < len(heights); i++ {
+		list = append(list, snapshot(heights[i]))
+	}
+
+	m.bfmu.Lock()
+	reorg := m.rollbacks != rollbacks
+	m.bfmu.Unlock()
+	if reorg {
+		return nil, fmt.Errorf("chain reorganized while building distributions")
+	}
+	return list, nil
+}
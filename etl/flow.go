@@ -1090,3 +1090,135 @@ func (b *Builder) NewRollupTransactionFlows(
 	b.block.Flows = append(b.block.Flows, flows...)
 	return flows
 }
+
+// NewSmartRollupFlows handles fees, storage burn, bond freeze/unfreeze and
+// refutation game outcomes for smart rollup operations. Unlike tx rollups,
+// slashed and rewarded stakers are identified from balance updates directly
+// since refute and timeout results may concern any staker.
+func (b *Builder) NewSmartRollupFlows(src *model.Account, fees, bal rpc.BalanceUpdates, id model.OpRef) []*model.Flow {
+	// apply fees
+	flows, feespaid := b.NewFeeFlows(src, fees, id)
+	typ := model.MapFlowType(id.Kind)
+
+	// track net spendable balance changes for delegation updates
+	var (
+		accs  = []*model.Account{src}
+		delta = map[model.AccountID]int64{src.RowId: -feespaid}
+	)
+	lookup := func(u rpc.BalanceUpdate) (*model.Account, bool) {
+		acc, ok := b.AccountByAddress(u.Address())
+		if !ok {
+			return nil, false
+		}
+		if _, ok := delta[acc.RowId]; !ok {
+			accs = append(accs, acc)
+			delta[acc.RowId] = 0
+		}
+		return acc, true
+	}
+
+	for i, u := range bal {
+		if u.Change == 0 {
+			continue
+		}
+		switch u.Kind {
+		case "contract":
+			acc, ok := lookup(u)
+			if !ok {
+				continue
+			}
+			switch {
+			case u.Change < 0 && len(bal) > i+1 && bal[i+1].Category == "storage fees":
+				// origination burn
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBalance
+				f.Operation = typ
+				f.AmountOut = -u.Change
+				f.IsBurned = true
+				delta[acc.RowId] += u.Change
+				flows = append(flows, f)
+			case u.Change < 0:
+				// deposit from balance to bond (bond in-flow handled below)
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBalance
+				f.Operation = typ
+				f.AmountOut = -u.Change
+				flows = append(flows, f)
+			case i > 0 && bal[i-1].Category == "smart_rollup_refutation_rewards":
+				// refutation game winner reward
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBalance
+				f.Operation = model.FlowTypeRollupReward
+				f.AmountIn = u.Change
+				delta[acc.RowId] += u.Change
+				flows = append(flows, f)
+			default:
+				// bond unlock to balance (bond out-flow handled below)
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBalance
+				f.Operation = typ
+				f.AmountIn = u.Change
+				flows = append(flows, f)
+			}
+		case "freezer":
+			if !u.IsSmartRollupBond() {
+				continue
+			}
+			acc, ok := lookup(u)
+			if !ok {
+				continue
+			}
+			switch {
+			case u.Change > 0:
+				// deposit to bond
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBond
+				f.Operation = typ
+				f.AmountIn = u.Change
+				f.IsFrozen = true
+				flows = append(flows, f)
+			case len(bal) > i+1 && bal[i+1].Category == "smart_rollup_refutation_punishments":
+				// slash refutation game loser
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBond
+				f.Operation = model.FlowTypeRollupPenalty
+				f.AmountOut = -u.Change
+				f.IsBurned = true
+				delta[acc.RowId] += u.Change
+				flows = append(flows, f)
+			default:
+				// unlock (move bond back to balance)
+				f := model.NewFlow(b.block, acc, nil, id)
+				f.Category = model.FlowCategoryBond
+				f.Operation = typ
+				f.AmountOut = -u.Change
+				f.IsUnfrozen = true
+				flows = append(flows, f)
+			}
+		}
+	}
+
+	// update delegations unless the account is a baker
+	for _, acc := range accs {
+		amount := delta[acc.RowId]
+		if amount == 0 || acc.BakerId == 0 || acc.IsBaker {
+			continue
+		}
+		bkr, ok := b.BakerById(acc.BakerId)
+		if !ok {
+			continue
+		}
+		f := model.NewFlow(b.block, bkr.Account, acc, id)
+		f.Category = model.FlowCategoryDelegation
+		f.Operation = typ
+		if amount < 0 {
+			f.AmountOut = -amount
+		} else {
+			f.AmountIn = amount
+		}
+		flows = append(flows, f)
+	}
+
+	b.block.Flows = append(b.block.Flows, flows...)
+	return flows
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// LookupAccountAt reconstructs account state at the end of block height.
+// Balances and totals are rolled back from flows, the total balance is
+// cross-checked against balance history and delegate, counter and reveal
+// status are taken from operations. Operation counters are not rolled back.
+func (m *Indexer) LookupAccountAt(ctx context.Context, acc *model.Account, height int64) (*model.Account, error) {
+	if height >= acc.LastSeen {
+		return acc, nil
+	}
+	if height < acc.FirstSeen {
+		return nil, index.ErrNoAccountEntry
+	}
+	a := *acc
+	err := m.rollbackFlows(ctx, a.RowId, height, a.RollbackBalance)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.resetAccountState(ctx, &a, acc, height); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// LookupBakerAt reconstructs baker state at the end of block height including
+// frozen and delegated balances. Flows are always replayed because delegation
+// flows change the delegated balance without updating the baker's last seen
+// height. Delegation counts are not rolled back.
+func (m *Indexer) LookupBakerAt(ctx context.Context, bkr *model.Baker, height int64) (*model.Baker, error) {
+	if height < bkr.BakerSince {
+		return nil, index.ErrNoBakerEntry
+	}
+	b := *bkr
+	acc := *bkr.Account
+	b.Account = &acc
+	err := m.rollbackFlows(ctx, b.AccountId, height, b.RollbackBalance)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.resetAccountState(ctx, &acc, bkr.Account, height); err != nil {
+		return nil, err
+	}
+	if !b.IsActive && b.BakerUntil > height {
+		b.IsActive = true
+		b.BakerUntil = 0
+	}
+	b.DepositsLimit, err = m.findDepositsLimit(ctx, b.AccountId, height)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// rollbackFlows reverts all flows of account id after height in reverse order.
+func (m *Indexer) rollbackFlows(ctx context.Context, id model.AccountID, height int64, fn func(*model.Flow) error) error {
+	table, err := m.Table(index.FlowTableKey)
+	if err != nil {
+		return err
+	}
+	f := &model.Flow{}
+	return pack.NewQuery("rollback_flows", table).
+		WithoutCache().
+		WithDesc().
+		AndEqual("account_id", id).
+		AndGt("height", height).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(f); err != nil {
+				return err
+			}
+			return fn(f)
+		})
+}
+
+// resetAccountState updates balance, activity, delegation, counter and reveal
+// status of rolled back account a to their values at height. cur is the
+// current account state.
+func (m *Indexer) resetAccountState(ctx context.Context, a, cur *model.Account, height int64) error {
+	// balance history is authoritative when available
+	if bal, ok, err := m.findBalance(ctx, a.RowId, height); err != nil {
+		return err
+	} else if ok {
+		a.SpendableBalance = bal - a.FrozenBond
+	}
+	a.IsFunded = a.Balance() > 0
+	a.IsDirty = false
+	a.IsNew = false
+
+	// activity
+	if err := m.resetActivity(ctx, a, height); err != nil {
+		return err
+	}
+
+	// delegation
+	if err := m.resetDelegation(ctx, a, cur, height); err != nil {
+		return err
+	}
+
+	// counter
+	table, err := m.Table(index.OpTableKey)
+	if err != nil {
+		return err
+	}
+	o := &model.Op{}
+	err = pack.NewQuery("find_last_counter", table).
+		WithoutCache().
+		WithDesc().
+		WithLimit(1).
+		WithFields("counter").
+		AndEqual("sender_id", a.RowId).
+		AndEqual("is_internal", false).
+		AndGt("counter", 0).
+		AndLte("height", height).
+		Execute(ctx, o)
+	if err != nil {
+		return err
+	}
+	a.Counter = o.Counter
+
+	// reveal status, accounts without reveal op were revealed at genesis
+	if a.IsRevealed {
+		o = &model.Op{}
+		err = pack.NewQuery("find_reveal", table).
+			WithoutCache().
+			WithLimit(1).
+			WithFields("height").
+			AndEqual("type", model.OpTypeReveal).
+			AndEqual("sender_id", a.RowId).
+			AndEqual("is_success", true).
+			Execute(ctx, o)
+		if err != nil {
+			return err
+		}
+		if o.Height > height {
+			a.IsRevealed = false
+			a.Pubkey = nil
+		}
+	}
+	return nil
+}
+
+// findBalance returns the total balance of account id at height from balance
+// history. Each entry is valid until the block where the balance changed.
+func (m *Indexer) findBalance(ctx context.Context, id model.AccountID, height int64) (int64, bool, error) {
+	table, err := m.Table(index.BalanceTableKey)
+	if err != nil {
+		// balance index is optional
+		return 0, false, nil
+	}
+	b := &model.Balance{}
+	err = pack.NewQuery("find_balance", table).
+		WithoutCache().
+		WithLimit(1).
+		AndEqual("account_id", id).
+		AndGt("valid_until", height).
+		Execute(ctx, b)
+	if err != nil {
+		return 0, false, err
+	}
+	return b.Balance, b.RowId > 0, nil
+}
+
+// resetActivity clamps in/out and last seen heights to height.
+func (m *Indexer) resetActivity(ctx context.Context, a *model.Account, height int64) error {
+	if a.FirstIn > height {
+		a.FirstIn = 0
+	}
+	if a.FirstOut > height {
+		a.FirstOut = 0
+	}
+	if a.LastIn > height || a.LastOut > height {
+		a.LastIn = 0
+		a.LastOut = 0
+		table, err := m.Table(index.FlowTableKey)
+		if err != nil {
+			return err
+		}
+		f := &model.Flow{}
+		err = pack.NewQuery("find_last_activity", table).
+			WithoutCache().
+			WithDesc().
+			WithFields("height", "amount_in", "amount_out").
+			AndEqual("account_id", a.RowId).
+			AndEqual("category", model.FlowCategoryBalance).
+			AndLte("height", height).
+			Stream(ctx, func(r pack.Row) error {
+				if err := r.Decode(f); err != nil {
+					return err
+				}
+				if f.AmountIn > 0 && a.LastIn == 0 {
+					a.LastIn = f.Height
+				}
+				if f.AmountOut > 0 && a.LastOut == 0 {
+					a.LastOut = f.Height
+				}
+				if (a.FirstIn == 0 || a.LastIn > 0) && (a.FirstOut == 0 || a.LastOut > 0) {
+					return io.EOF
+				}
+				return nil
+			})
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if a.LastSeen > height {
+		a.LastSeen = height
+	}
+	return nil
+}
+
+// resetDelegation sets delegate and delegation height at height from the
+// most recent delegation ops. Accounts without delegation op keep the
+// delegate assigned at origination or genesis.
+func (m *Indexer) resetDelegation(ctx context.Context, a, cur *model.Account, height int64) error {
+	table, err := m.Table(index.OpTableKey)
+	if err != nil {
+		return err
+	}
+	var (
+		found bool
+		since int64
+		o     = &model.Op{}
+	)
+	a.BakerId, a.DelegatedSince = 0, 0
+	err = pack.NewQuery("find_delegations", table).
+		WithoutCache().
+		WithDesc().
+		WithFields("height", "baker_id").
+		AndEqual("type", model.OpTypeDelegation).
+		AndEqual("sender_id", a.RowId).
+		AndEqual("is_success", true).
+		AndLte("height", height).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(o); err != nil {
+				return err
+			}
+			if !found {
+				found = true
+				a.BakerId = o.BakerId
+			}
+			// a re-delegation to the same baker keeps the original height
+			if o.BakerId != a.BakerId {
+				return io.EOF
+			}
+			since = o.Height
+			return nil
+		})
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case found:
+		if a.BakerId > 0 && a.BakerId != a.RowId {
+			a.DelegatedSince = since
+		}
+	case cur.DelegatedSince > 0 && cur.DelegatedSince <= height:
+		// delegate has not changed since
+		a.BakerId, a.DelegatedSince = cur.BakerId, cur.DelegatedSince
+	case cur.BakerId == a.RowId && cur.FirstSeen <= 1:
+		// bootstrap baker
+		a.BakerId = cur.BakerId
+	case a.IsContract:
+		// delegate set at origination
+		op, err := m.FindOrigination(ctx, a.RowId, a.FirstSeen)
+		switch err {
+		case nil:
+			if op.BakerId > 0 {
+				a.BakerId, a.DelegatedSince = op.BakerId, op.Height
+			}
+		case index.ErrNoOpEntry:
+		default:
+			return err
+		}
+	}
+	a.IsDelegated = a.BakerId > 0 && a.BakerId != a.RowId
+	a.IsBaker = cur.IsBaker && a.BakerId == a.RowId
+	return nil
+}
+
+// findDepositsLimit returns the deposits limit set by baker id at height
+// or -1 when unset.
+func (m *Indexer) findDepositsLimit(ctx context.Context, id model.AccountID, height int64) (int64, error) {
+	table, err := m.Table(index.OpTableKey)
+	if err != nil {
+		return -1, err
+	}
+	o := &model.Op{}
+	err = pack.NewQuery("find_deposits_limit", table).
+		WithoutCache().
+		WithDesc().
+		WithLimit(1).
+		WithFields("data").
+		AndEqual("type", model.OpTypeDepositsLimit).
+		AndEqual("sender_id", id).
+		AndEqual("is_success", true).
+		AndLte("height", height).
+		Execute(ctx, o)
+	if err != nil || o.Data == "" {
+		return -1, err
+	}
+	return strconv.ParseInt(o.Data, 10, 64)
+}
@@ -11,6 +11,7 @@ import (
 
     "blockwatch.cc/packdb/pack"
     "blockwatch.cc/packdb/util"
+    "blockwatch.cc/tzgo/tezos"
     "blockwatch.cc/tzindex/etl/model"
 )
 
@@ -21,16 +22,23 @@ const (
     BalanceFillLevel       = 100
     BalanceIndexKey        = "balance"
     BalanceTableKey        = "balance"
+
+    DistributionPackSizeLog2    = 10
+    DistributionJournalSizeLog2 = 10
+    DistributionTableKey        = "distribution"
 )
 
 var (
-    ErrNoBalanceEntry = errors.New("balance not indexed")
+    ErrNoBalanceEntry      = errors.New("balance not indexed")
+    ErrNoDistributionEntry = errors.New("distribution not indexed")
 )
 
 type BalanceIndex struct {
-    db    *pack.DB
-    opts  pack.Options
-    table *pack.Table
+    db     *pack.DB
+    opts   pack.Options
+    table  *pack.Table
+    dist   *pack.Table
+    params *tezos.Params
 }
 
 var _ model.BlockIndexer = (*BalanceIndex)(nil)
@@ -46,6 +54,7 @@ func (idx *BalanceIndex) DB() *pack.DB {
 func (idx *BalanceIndex) Tables() []*pack.Table {
     return []*pack.Table{
         idx.table,
+        idx.dist,
     }
 }
 
@@ -77,6 +86,26 @@ func (idx *BalanceIndex) Create(path, label string, opts interface{}) error {
             CacheSize:       util.NonZero(idx.opts.CacheSize, BalanceCacheSize),
             FillLevel:       util.NonZero(idx.opts.FillLevel, BalanceFillLevel),
         })
+    if err != nil {
+        return err
+    }
+    return idx.createDistributionTable(db)
+}
+
+func (idx *BalanceIndex) createDistributionTable(db *pack.DB) error {
+    fields, err := pack.Fields(model.Distribution{})
+    if err != nil {
+        return err
+    }
+    _, err = db.CreateTableIfNotExists(
+        DistributionTableKey,
+        fields,
+        pack.Options{
+            PackSizeLog2:    DistributionPackSizeLog2,
+            JournalSizeLog2: DistributionJournalSizeLog2,
+            CacheSize:       2,
+            FillLevel:       100,
+        })
     return err
 }
 
@@ -96,6 +125,16 @@ func (idx *BalanceIndex) Init(path, label string, opts interface{}) error {
         idx.Close()
         return err
     }
+    // upgrade databases created before distribution snapshots existed
+    if err := idx.createDistributionTable(idx.db); err != nil {
+        idx.Close()
+        return err
+    }
+    idx.dist, err = idx.db.Table(DistributionTableKey)
+    if err != nil {
+        idx.Close()
+        return err
+    }
     return nil
 }
 
@@ -112,6 +151,7 @@ func (idx *BalanceIndex) Close() error {
         }
     }
     idx.table = nil
+    idx.dist = nil
     if idx.db != nil {
         if err := idx.db.Close(); err != nil {
             return err
@@ -122,6 +162,7 @@ func (idx *BalanceIndex) Close() error {
 }
 
 func (idx *BalanceIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+    idx.params = block.Params
     ins := make([]pack.Item, 0)
 
     // handle bakers
@@ -131,6 +172,10 @@ func (idx *BalanceIndex) ConnectBlock(ctx context.Context, block *model.Block, b
             // log.Infof("Skip %s from=%d to=%d bal=%d", acc, acc.PrevSeen, block.Height, acc.PrevBalance)
             continue
         }
+        // skip unselected accounts in filtered mode
+        if !builder.IsSelected(acc.RowId) {
+            continue
+        }
         // log.Infof("Balance for %s from=%d to=%d bal=%d", acc, acc.PrevSeen, block.Height, acc.PrevBalance)
         bal := &model.Balance{
             AccountId:  acc.RowId,
@@ -146,6 +191,9 @@ func (idx *BalanceIndex) ConnectBlock(ctx context.Context, block *model.Block, b
         if !acc.IsDirty || acc.PrevBalance == acc.Balance() || acc.PrevSeen == block.Height {
             continue
         }
+        if !builder.IsSelected(acc.RowId) {
+            continue
+        }
         bal := &model.Balance{
             AccountId:  acc.RowId,
             Balance:    acc.PrevBalance,
@@ -168,11 +216,26 @@ func (idx *BalanceIndex) DeleteBlock(ctx context.Context, height int64) error {
     _, err := pack.NewQuery("etl.balance.delete", idx.table).
         AndEqual("valid_until", height).
         Delete(ctx)
+    if err != nil {
+        return err
+    }
+    // drop distribution snapshots that include the removed block
+    _, err = pack.NewQuery("etl.distribution.delete", idx.dist).
+        AndGte("height", height).
+        Delete(ctx)
     return err
 }
 
+// DeleteCycle removes balance history that ended in cycle. Balances that are
+// still valid live in the account table and are not affected.
 func (idx *BalanceIndex) DeleteCycle(ctx context.Context, cycle int64) error {
-    return nil
+    if idx.params == nil {
+        return ErrNoParams
+    }
+    _, err := pack.NewQuery("etl.balance.delete_cycle", idx.table).
+        AndRange("valid_until", idx.params.CycleStartHeight(cycle), idx.params.CycleEndHeight(cycle)).
+        Delete(ctx)
+    return err
 }
 
 func (idx *BalanceIndex) Flush(ctx context.Context) error {
@@ -0,0 +1,312 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/packdb/vec"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	BigmapFieldPackSizeLog2    = 15 // 32k packs
+	BigmapFieldJournalSizeLog2 = 16 // 64k
+	BigmapFieldCacheSize       = 4
+	BigmapFieldFillLevel       = 100
+	BigmapFieldIndexKey        = "bigmap_field"
+	BigmapFieldTableKey        = "bigmap_field"
+
+	// max number of leaves extracted from a single bigmap entry
+	BigmapFieldMaxValues = 64
+)
+
+// BigmapFieldIndex stores scalar leaves of decoded keys and values of live
+// entries in selected bigmaps so that bigmap filters can avoid full scans.
+// Rows are rebuilt from the live bigmap table whenever an entry changes, for
+// this reason the index must run after the bigmap index.
+type BigmapFieldIndex struct {
+	db      *pack.DB
+	opts    pack.Options
+	table   *pack.Table
+	bigmaps map[int64]struct{}
+}
+
+var _ model.BlockIndexer = (*BigmapFieldIndex)(nil)
+
+func NewBigmapFieldIndex(ids []int64, opts pack.Options) *BigmapFieldIndex {
+	idx := &BigmapFieldIndex{
+		opts:    opts,
+		bigmaps: make(map[int64]struct{}, len(ids)),
+	}
+	for _, v := range ids {
+		idx.bigmaps[v] = struct{}{}
+	}
+	return idx
+}
+
+// IsIndexed reports whether bigmap id is configured for field search.
+func (idx *BigmapFieldIndex) IsIndexed(id int64) bool {
+	_, ok := idx.bigmaps[id]
+	return ok
+}
+
+func (idx *BigmapFieldIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *BigmapFieldIndex) Tables() []*pack.Table {
+	return []*pack.Table{idx.table}
+}
+
+func (idx *BigmapFieldIndex) Key() string {
+	return BigmapFieldIndexKey
+}
+
+func (idx *BigmapFieldIndex) Name() string {
+	return BigmapFieldIndexKey + " index"
+}
+
+func (idx *BigmapFieldIndex) Create(path, label string, opts interface{}) error {
+	fields, err := pack.Fields(model.BigmapField{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.CreateTableIfNotExists(
+		BigmapFieldTableKey,
+		fields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, BigmapFieldPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, BigmapFieldJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, BigmapFieldCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, BigmapFieldFillLevel),
+		})
+	return err
+}
+
+func (idx *BigmapFieldIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		BigmapFieldTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, BigmapFieldJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, BigmapFieldCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *BigmapFieldIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *BigmapFieldIndex) Close() error {
+	if idx.table != nil {
+		if err := idx.table.Close(); err != nil {
+			log.Errorf("Closing %s table: %s", idx.table.Name(), err)
+		}
+		idx.table = nil
+	}
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// assumes live bigmap entries are up to date (must run after BigmapIndex)
+func (idx *BigmapFieldIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	return idx.updateBlock(ctx, block, builder)
+}
+
+// updateBlock rebuilds rows for all entries of indexed bigmaps that were
+// touched by block. Since rows always reflect the live bigmap table the same
+// procedure works for connecting and disconnecting blocks.
+func (idx *BigmapFieldIndex) updateBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	var (
+		keys = make(map[int64][]uint64)
+		full = make(map[int64]bool)
+	)
+	for _, op := range block.Ops {
+		if !op.IsSuccess || len(op.BigmapEvents) == 0 {
+			continue
+		}
+		for _, ev := range op.BigmapEvents {
+			switch ev.Action {
+			case micheline.DiffActionUpdate, micheline.DiffActionRemove:
+				if !idx.IsIndexed(ev.Id) {
+					continue
+				}
+				if ev.KeyHash.IsValid() {
+					keys[ev.Id] = append(keys[ev.Id], model.GetKeyId(ev.Id, ev.KeyHash))
+				} else {
+					full[ev.Id] = true
+				}
+			case micheline.DiffActionAlloc:
+				if idx.IsIndexed(ev.Id) {
+					full[ev.Id] = true
+				}
+			case micheline.DiffActionCopy:
+				if idx.IsIndexed(ev.DestId) {
+					full[ev.DestId] = true
+				}
+			}
+		}
+	}
+	for id := range full {
+		if err := idx.rebuild(ctx, builder, id, nil); err != nil {
+			return fmt.Errorf("%s: bigmap %d: %w", idx.Key(), id, err)
+		}
+	}
+	for id, ids := range keys {
+		if full[id] {
+			continue
+		}
+		if err := idx.rebuild(ctx, builder, id, vec.UniqueUint64Slice(ids)); err != nil {
+			return fmt.Errorf("%s: bigmap %d: %w", idx.Key(), id, err)
+		}
+	}
+	return nil
+}
+
+// rebuild replaces rows for the given key ids of bigmap id (or all keys when
+// ids is nil) with leaves decoded from the live bigmap table.
+func (idx *BigmapFieldIndex) rebuild(ctx context.Context, builder model.BlockBuilder, id int64, ids []uint64) error {
+	q := pack.NewQuery("etl.bigmap_field.delete", idx.table).AndEqual("bigmap_id", id)
+	if ids != nil {
+		q = q.AndIn("key_id", ids)
+	}
+	if _, err := q.Delete(ctx); err != nil {
+		return err
+	}
+
+	// the bigmap may no longer exist after rollback
+	allocs, err := builder.Table(BigmapAllocTableKey)
+	if err != nil {
+		return err
+	}
+	alloc := &model.BigmapAlloc{}
+	err = pack.NewQuery("etl.bigmap_field.alloc", allocs).
+		AndEqual("bigmap_id", id).
+		Execute(ctx, alloc)
+	if err != nil {
+		return err
+	}
+	if alloc.RowId == 0 {
+		return nil
+	}
+	keyType, valueType := alloc.GetKeyType(), alloc.GetValueType()
+
+	values, err := builder.Table(BigmapValueTableKey)
+	if err != nil {
+		return err
+	}
+	q = pack.NewQuery("etl.bigmap_field.scan", values).AndEqual("bigmap_id", id)
+	if ids != nil {
+		q = q.AndIn("key_id", ids)
+	}
+	ins := make([]pack.Item, 0)
+	err = q.Stream(ctx, func(r pack.Row) error {
+		kv := &model.BigmapKV{}
+		if err := r.Decode(kv); err != nil {
+			return err
+		}
+		val, err := model.DecodeBigmapEntry(kv, keyType, valueType)
+		if err != nil {
+			log.Warnf("%s: decoding bigmap %d key %s: %v", idx.Key(), id, kv.GetKeyHash(), err)
+			return nil
+		}
+		for _, v := range newBigmapFields(kv, val) {
+			ins = append(ins, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// insert, will generate unique row ids
+	if len(ins) > 0 {
+		if err := idx.table.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("insert: %w", err)
+		}
+	}
+	return nil
+}
+
+// newBigmapFields flattens a decoded bigmap entry into rows. Entries with
+// more than BigmapFieldMaxValues leaves are stored as a single overflow row.
+func newBigmapFields(kv *model.BigmapKV, val interface{}) []*model.BigmapField {
+	fields := make([]*model.BigmapField, 0)
+	ok := flattenParams(val, "", func(path string, v interface{}) bool {
+		if len(fields) == BigmapFieldMaxValues {
+			return false
+		}
+		f := &model.BigmapField{
+			BigmapId: kv.BigmapId,
+			KeyId:    kv.KeyId,
+			Height:   kv.Height,
+			Path:     path,
+		}
+		var n *big.Int
+		f.Value, n = model.BigmapFieldValue(v)
+		if n != nil {
+			f.Int = clampInt64(n)
+			f.IsNum = true
+		}
+		fields = append(fields, f)
+		return true
+	})
+	if !ok {
+		return []*model.BigmapField{{
+			BigmapId: kv.BigmapId,
+			KeyId:    kv.KeyId,
+			Height:   kv.Height,
+		}}
+	}
+	return fields
+}
+
+// BackfillBlock replays stored bigmap updates against the live bigmap table.
+func (idx *BigmapFieldIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	return idx.updateBlock(ctx, block, builder)
+}
+
+// DisconnectBlock rebuilds touched entries from the live bigmap table which
+// the bigmap index has already rolled back.
+func (idx *BigmapFieldIndex) DisconnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	return idx.updateBlock(ctx, block, builder)
+}
+
+func (idx *BigmapFieldIndex) DeleteBlock(ctx context.Context, height int64) error {
+	return nil
+}
+
+func (idx *BigmapFieldIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	return nil
+}
+
+func (idx *BigmapFieldIndex) Flush(ctx context.Context) error {
+	return idx.table.Flush(ctx)
+}
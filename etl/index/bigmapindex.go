@@ -14,6 +14,7 @@ import (
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/util"
 	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
 	"blockwatch.cc/tzindex/etl/model"
 )
 
@@ -40,6 +41,7 @@ type BigmapIndex struct {
 	updateTable *pack.Table
 	valueTable  *pack.Table
 	allocCache  cache.Cache // cache bigmap allocs (for fast type access)
+	params      *tezos.Params
 }
 
 var _ model.BlockIndexer = (*BigmapIndex)(nil)
@@ -222,8 +224,18 @@ func (idx *BigmapIndex) loadAlloc(ctx context.Context, id int64) (*model.BigmapA
 	return alloc, nil
 }
 
+func hasTemporaryBigmap(events micheline.BigmapEvents) bool {
+	for _, v := range events {
+		if v.Id < 0 || (v.Action == micheline.DiffActionCopy && (v.SourceId < 0 || v.DestId < 0)) {
+			return true
+		}
+	}
+	return false
+}
+
 // assumes op ids are already set (must run after OpIndex)
 func (idx *BigmapIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	idx.params = block.Params
 	tmp := make(map[int64]InMemoryBigmap)
 	for _, op := range block.Ops {
 		// skip non-bigmap ops
@@ -231,6 +243,12 @@ func (idx *BigmapIndex) ConnectBlock(ctx context.Context, block *model.Block, bu
 			continue
 		}
 
+		// skip unselected contracts in filtered mode, but keep processing
+		// temporary bigmaps which may be copied into selected contracts later
+		if !builder.IsSelected(op.ReceiverId) && !hasTemporaryBigmap(op.BigmapEvents) {
+			continue
+		}
+
 		// reset temp bigmap after a batch of internal ops has been processed
 		if !op.IsInternal && len(tmp) > 0 {
 			for k := range tmp {
@@ -300,6 +318,11 @@ func (idx *BigmapIndex) ConnectBlock(ctx context.Context, block *model.Block, bu
 					if err != nil {
 						return fmt.Errorf("etl.bigmap.copy: %v", err)
 					}
+					if srcAlloc.RowId == 0 && builder.IsFiltered() {
+						// source belongs to an unselected contract
+						log.Warnf("etl.bigmap.copy: skipping unindexed source bigmap %d", diff.SourceId)
+						continue
+					}
 					alloc = model.CopyBigmapAlloc(srcAlloc, op, diff.DestId)
 
 					// add a copy update
@@ -791,8 +814,16 @@ func (idx *BigmapIndex) DeleteBlock(ctx context.Context, height int64) error {
 	return nil
 }
 
+// DeleteCycle removes bigmap update history for cycle. Allocs and live
+// values are kept since they represent current contract state.
 func (idx *BigmapIndex) DeleteCycle(ctx context.Context, cycle int64) error {
-	return nil
+	if idx.params == nil {
+		return ErrNoParams
+	}
+	_, err := pack.NewQuery("etl.bigmap.delete_cycle", idx.updateTable).
+		AndRange("height", idx.params.CycleStartHeight(cycle), idx.params.CycleEndHeight(cycle)).
+		Delete(ctx)
+	return err
 }
 
 func (idx *BigmapIndex) Flush(ctx context.Context) error {
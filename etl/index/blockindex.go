@@ -21,6 +21,9 @@ const (
 	BlockFillLevel       = 100
 	BlockIndexKey        = "block"
 	BlockTableKey        = "block"
+	ReorgPackSizeLog2    = 10 // 1k packs
+	ReorgJournalSizeLog2 = 10 // 1k
+	ReorgTableKey        = "reorg"
 )
 
 var (
@@ -33,12 +36,21 @@ var (
 
 	// ErrInvalidBlockHash
 	ErrInvalidBlockHash = errors.New("invalid block hash")
+
+	// ErrNoOrphanEntry is an error that indicates a requested block
+	// was not orphaned by any recorded reorg.
+	ErrNoOrphanEntry = errors.New("orphan block not found")
+
+	// ErrNoParams is an error that indicates an index cannot map cycles to
+	// heights because it has not seen a block since startup.
+	ErrNoParams = errors.New("params not initialized")
 )
 
 type BlockIndex struct {
-	db    *pack.DB
-	opts  pack.Options
-	table *pack.Table
+	db     *pack.DB
+	opts   pack.Options
+	table  *pack.Table
+	reorgs *pack.Table
 }
 
 var _ model.BlockIndexer = (*BlockIndex)(nil)
@@ -52,7 +64,7 @@ func (idx *BlockIndex) DB() *pack.DB {
 }
 
 func (idx *BlockIndex) Tables() []*pack.Table {
-	return []*pack.Table{idx.table}
+	return []*pack.Table{idx.table, idx.reorgs}
 }
 
 func (idx *BlockIndex) Key() string {
@@ -86,7 +98,24 @@ func (idx *BlockIndex) Create(path, label string, opts interface{}) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return idx.createReorgTable(db)
+}
+
+func (idx *BlockIndex) createReorgTable(db *pack.DB) error {
+	fields, err := pack.Fields(model.Reorg{})
+	if err != nil {
+		return err
+	}
+	_, err = db.CreateTableIfNotExists(
+		ReorgTableKey,
+		fields,
+		pack.Options{
+			PackSizeLog2:    ReorgPackSizeLog2,
+			JournalSizeLog2: ReorgJournalSizeLog2,
+			CacheSize:       2,
+			FillLevel:       100,
+		})
+	return err
 }
 
 func (idx *BlockIndex) Init(path, label string, opts interface{}) error {
@@ -106,6 +135,17 @@ func (idx *BlockIndex) Init(path, label string, opts interface{}) error {
 		idx.Close()
 		return err
 	}
+
+	// databases created by earlier versions have no reorg table
+	if err := idx.createReorgTable(idx.db); err != nil {
+		idx.Close()
+		return err
+	}
+	idx.reorgs, err = idx.db.Table(ReorgTableKey)
+	if err != nil {
+		idx.Close()
+		return err
+	}
 	return nil
 }
 
@@ -120,6 +160,12 @@ func (idx *BlockIndex) Close() error {
 		}
 		idx.table = nil
 	}
+	if idx.reorgs != nil {
+		if err := idx.reorgs.Close(); err != nil {
+			log.Errorf("Closing %s: %s", idx.Name(), err)
+		}
+		idx.reorgs = nil
+	}
 	if idx.db != nil {
 		if err := idx.db.Close(); err != nil {
 			return err
@@ -147,6 +147,11 @@ func (idx *ContractIndex) ConnectBlock(ctx context.Context, block *model.Block,
 			continue
 		}
 
+		// smart rollups are no contracts and are handled by the rollup index
+		if op.IsRollup && op.ReceiverId == 0 {
+			continue
+		}
+
 		switch op.Type {
 		case model.OpTypeTransaction,
 			model.OpTypeSubsidy,
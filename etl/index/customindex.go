@@ -0,0 +1,542 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	CustomPackSizeLog2    = 15 // 32k packs
+	CustomJournalSizeLog2 = 16 // 64k
+	CustomCacheSize       = 4
+	CustomFillLevel       = 100
+
+	CustomIndexPrefix = "custom_"
+)
+
+// CustomColumn maps a Micheline value path to a typed table column. Paths
+// for bigmap indexes start at `key` or `value`, paths for entrypoint indexes
+// are relative to the call parameters. An empty path selects the root.
+type CustomColumn struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // int, string, bytes, bool
+
+	slot int // typed slot assigned on validation
+}
+
+// Slot returns the typed slot that holds values for this column.
+func (c CustomColumn) Slot() int {
+	return c.slot
+}
+
+// Field returns the storage column name that holds values for this column.
+func (c CustomColumn) Field() string {
+	return model.CustomSlotName(c.Type, c.slot)
+}
+
+// reserved column names used by the fixed part of custom tables
+var customReservedNames = []string{
+	"row_id", "height", "time", "op_id", "op", "account_id", "contract",
+	"sender_id", "sender", "bigmap_id", "action", "key_hash", "cursor",
+	"columns", "limit", "order", "verbose", "filename",
+}
+
+// CustomIndexConfig declares a user-defined contract index. Contracts are
+// selected by address, code hash or interface hash. Rows are generated either
+// from updates to a named bigmap or from calls to an entrypoint.
+type CustomIndexConfig struct {
+	Name       string         `json:"name"`
+	Contract   tezos.Address  `json:"contract"`
+	CodeHash   uint64         `json:"code_hash"`
+	IfaceHash  uint64         `json:"iface_hash"`
+	Bigmap     string         `json:"bigmap"`
+	BigmapId   int64          `json:"bigmap_id"`
+	Entrypoint string         `json:"entrypoint"`
+	Columns    []CustomColumn `json:"columns"`
+}
+
+func (c *CustomIndexConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("custom index: missing name")
+	}
+	for _, r := range c.Name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("custom index %s: name must be lowercase alphanumeric", c.Name)
+		}
+	}
+	if !c.Contract.IsValid() && c.CodeHash == 0 && c.IfaceHash == 0 {
+		return fmt.Errorf("custom index %s: missing contract, code_hash or iface_hash", c.Name)
+	}
+	isBigmap := c.Bigmap != "" || c.BigmapId > 0
+	if isBigmap == (c.Entrypoint != "") {
+		return fmt.Errorf("custom index %s: exactly one of bigmap or entrypoint required", c.Name)
+	}
+	if len(c.Columns) == 0 {
+		return fmt.Errorf("custom index %s: missing columns", c.Name)
+	}
+	var (
+		slots = make(map[string]int)
+		names = make(map[string]struct{})
+		max   = map[string]int{
+			"int":    model.CustomIntSlots,
+			"string": model.CustomStringSlots,
+			"bytes":  model.CustomBytesSlots,
+			"bool":   model.CustomBoolSlots,
+		}
+	)
+	for i, v := range c.Columns {
+		if v.Name == "" {
+			return fmt.Errorf("custom index %s: missing name for column %d", c.Name, i)
+		}
+		if _, ok := names[v.Name]; ok {
+			return fmt.Errorf("custom index %s: duplicate column %s", c.Name, v.Name)
+		}
+		for _, n := range customReservedNames {
+			if v.Name == n {
+				return fmt.Errorf("custom index %s: reserved column name %s", c.Name, v.Name)
+			}
+		}
+		names[v.Name] = struct{}{}
+		n, ok := max[v.Type]
+		if !ok {
+			return fmt.Errorf("custom index %s: unsupported type %q for column %s", c.Name, v.Type, v.Name)
+		}
+		if slots[v.Type] >= n {
+			return fmt.Errorf("custom index %s: too many %s columns (max %d)", c.Name, v.Type, n)
+		}
+		c.Columns[i].slot = slots[v.Type]
+		slots[v.Type]++
+	}
+	return nil
+}
+
+type CustomIndex struct {
+	db      *pack.DB
+	opts    pack.Options
+	cfg     CustomIndexConfig
+	table   *pack.Table
+	bigmaps map[int64]*model.BigmapAlloc // matching bigmaps (nil value when not matching)
+}
+
+var _ model.BlockIndexer = (*CustomIndex)(nil)
+
+func NewCustomIndex(cfg CustomIndexConfig, opts pack.Options) (*CustomIndex, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	idx := &CustomIndex{cfg: cfg, opts: opts}
+	idx.resetBigmaps()
+	return idx, nil
+}
+
+func (idx *CustomIndex) Config() CustomIndexConfig {
+	return idx.cfg
+}
+
+func (idx *CustomIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *CustomIndex) Tables() []*pack.Table {
+	return []*pack.Table{idx.table}
+}
+
+func (idx *CustomIndex) Key() string {
+	return CustomIndexPrefix + idx.cfg.Name
+}
+
+func (idx *CustomIndex) Name() string {
+	return idx.Key() + " index"
+}
+
+func (idx *CustomIndex) Create(path, label string, opts interface{}) error {
+	fields, err := pack.Fields(model.CustomRow{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.CreateTableIfNotExists(
+		idx.Key(),
+		fields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, CustomPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, CustomJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, CustomCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, CustomFillLevel),
+		})
+	return err
+}
+
+func (idx *CustomIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		idx.Key(),
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, CustomJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, CustomCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *CustomIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *CustomIndex) Close() error {
+	if idx.table != nil {
+		if err := idx.table.Close(); err != nil {
+			log.Errorf("Closing %s table: %s", idx.table.Name(), err)
+		}
+		idx.table = nil
+	}
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+func (idx *CustomIndex) resetBigmaps() {
+	idx.bigmaps = make(map[int64]*model.BigmapAlloc)
+}
+
+func (idx *CustomIndex) matchContract(c *model.Contract) bool {
+	switch {
+	case idx.cfg.Contract.IsValid():
+		return c.Address.Equal(idx.cfg.Contract)
+	case idx.cfg.CodeHash != 0:
+		return c.CodeHash == idx.cfg.CodeHash
+	default:
+		return c.InterfaceHash == idx.cfg.IfaceHash
+	}
+}
+
+// lookupBigmap returns the alloc for bigmap id when it matches the configured
+// bigmap. Allocs are resolved by matching bigmap types against the contract's
+// storage type annotations, results are cached.
+func (idx *CustomIndex) lookupBigmap(ctx context.Context, id int64, c *model.Contract, builder model.BlockBuilder) (*model.BigmapAlloc, error) {
+	if alloc, ok := idx.bigmaps[id]; ok {
+		return alloc, nil
+	}
+	table, err := builder.Table(BigmapAllocTableKey)
+	if err != nil {
+		return nil, err
+	}
+	allocs := make([]*model.BigmapAlloc, 0)
+	err = pack.NewQuery("etl.custom.find_bigmaps", table).
+		AndEqual("account_id", c.AccountId).
+		Execute(ctx, &allocs)
+	if err != nil {
+		return nil, err
+	}
+	var match int64 = -1
+	if idx.cfg.BigmapId > 0 {
+		match = idx.cfg.BigmapId
+	} else if named := c.NamedBigmaps(allocs); named != nil {
+		if v, ok := named[idx.cfg.Bigmap]; ok {
+			match = v
+		}
+	}
+	for _, v := range allocs {
+		if v.BigmapId == match {
+			idx.bigmaps[v.BigmapId] = v
+		} else {
+			idx.bigmaps[v.BigmapId] = nil
+		}
+	}
+	if _, ok := idx.bigmaps[id]; !ok {
+		// not (yet) allocated, don't cache
+		return nil, nil
+	}
+	return idx.bigmaps[id], nil
+}
+
+// assumes op ids are already set (must run after OpIndex and BigmapIndex)
+func (idx *CustomIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	ins := make([]pack.Item, 0)
+	for _, op := range block.Ops {
+		// don't process failed or unrelated ops
+		if !op.IsSuccess || op.ReceiverId == 0 {
+			continue
+		}
+		if idx.cfg.Entrypoint != "" && (op.Type != model.OpTypeTransaction || len(op.Parameters) == 0) {
+			continue
+		}
+		if idx.cfg.Entrypoint == "" && len(op.BigmapEvents) == 0 {
+			continue
+		}
+		contract, ok := builder.ContractById(op.ReceiverId)
+		if !ok || !idx.matchContract(contract) {
+			continue
+		}
+
+		if idx.cfg.Entrypoint != "" {
+			row, err := idx.decodeCall(op, contract)
+			if err != nil {
+				log.Warnf("%s: decoding call %s: %v", idx.Key(), op.Hash, err)
+				continue
+			}
+			if row != nil {
+				ins = append(ins, row)
+			}
+			continue
+		}
+
+		for _, ev := range op.BigmapEvents {
+			// skip temporary bigmaps and whole-bigmap events
+			if ev.Id < 0 || !ev.KeyHash.IsValid() {
+				continue
+			}
+			if ev.Action != micheline.DiffActionUpdate && ev.Action != micheline.DiffActionRemove {
+				continue
+			}
+			alloc, err := idx.lookupBigmap(ctx, ev.Id, contract, builder)
+			if err != nil {
+				return fmt.Errorf("%s: loading bigmap %d: %w", idx.Key(), ev.Id, err)
+			}
+			if alloc == nil {
+				continue
+			}
+			row := idx.newRow(op, "update")
+			row.BigmapId = ev.Id
+			row.KeyHash = ev.KeyHash
+			val := map[string]interface{}{
+				"key": mapValue(alloc.GetKeyType(), ev.Key),
+			}
+			if ev.Action == micheline.DiffActionRemove || !ev.Value.IsValid() {
+				row.Action = "remove"
+			} else {
+				val["value"] = mapValue(alloc.GetValueType(), ev.Value)
+			}
+			idx.fillRow(row, val)
+			ins = append(ins, row)
+		}
+	}
+
+	// insert, will generate unique row ids
+	if len(ins) > 0 {
+		if err := idx.table.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("%s: insert: %w", idx.Key(), err)
+		}
+	}
+	return nil
+}
+
+func (idx *CustomIndex) decodeCall(op *model.Op, c *model.Contract) (*model.CustomRow, error) {
+	pTyp, _, err := c.LoadType()
+	if err != nil {
+		return nil, err
+	}
+	params := &micheline.Parameters{}
+	if err := params.UnmarshalBinary(op.Parameters); err != nil {
+		return nil, err
+	}
+	ep, prim, err := params.MapEntrypoint(pTyp)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Name != idx.cfg.Entrypoint {
+		return nil, nil
+	}
+	typ := ep.Type()
+	typ.Prim.Anno = nil
+	row := idx.newRow(op, "call")
+	idx.fillRow(row, mapValue(typ, prim))
+	return row, nil
+}
+
+func (idx *CustomIndex) newRow(op *model.Op, action string) *model.CustomRow {
+	return &model.CustomRow{
+		Height:    op.Height,
+		Time:      op.Timestamp,
+		OpId:      op.RowId,
+		AccountId: op.ReceiverId,
+		SenderId:  op.SenderId,
+		Action:    action,
+	}
+}
+
+// fillRow converts values found at configured column paths into the row's
+// typed slots. Missing paths and unconvertible values leave a slot empty.
+func (idx *CustomIndex) fillRow(row *model.CustomRow, val interface{}) {
+	for _, col := range idx.cfg.Columns {
+		v, ok := lookupPath(val, col.Path)
+		if !ok || v == nil {
+			continue
+		}
+		switch slot := row.Slot(col.Type, col.slot).(type) {
+		case *int64:
+			*slot, _ = toInt64(v)
+		case *string:
+			*slot = toString(v)
+		case *[]byte:
+			*slot, _ = toBytes(v)
+		case *bool:
+			*slot, _ = toBool(v)
+		}
+	}
+}
+
+func mapValue(typ micheline.Type, prim micheline.Prim) interface{} {
+	val := micheline.NewValue(typ, prim)
+	m, err := val.Map()
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+func lookupPath(val interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return val, true
+	}
+	for _, frag := range strings.Split(path, ".") {
+		switch t := val.(type) {
+		case map[string]interface{}:
+			v, ok := t[frag]
+			if !ok {
+				return nil, false
+			}
+			val = v
+		case []interface{}:
+			i, err := strconv.Atoi(frag)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			val = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// toInt64 converts numbers, timestamps and numeric strings. Big numbers
+// outside the int64 range are clamped like param values.
+func toInt64(v interface{}) (int64, bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), true
+	case int64:
+		return t, true
+	case *big.Int:
+		if t == nil {
+			return 0, false
+		}
+		return clampInt64(t), true
+	case tezos.Z:
+		return clampInt64(t.Big()), true
+	case *tezos.Z:
+		if t == nil {
+			return 0, false
+		}
+		return clampInt64(t.Big()), true
+	case time.Time:
+		return t.Unix(), true
+	case string:
+		if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return i, true
+		}
+		if z, ok := new(big.Int).SetString(t, 10); ok {
+			return clampInt64(z), true
+		}
+		if tm, err := time.Parse(time.RFC3339, t); err == nil {
+			return tm.Unix(), true
+		}
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case fmt.Stringer:
+		return t.String()
+	case time.Time:
+		return t.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toBytes(v interface{}) ([]byte, bool) {
+	switch t := v.(type) {
+	case []byte:
+		return t, true
+	case string:
+		if buf, err := hex.DecodeString(t); err == nil {
+			return buf, true
+		}
+		return []byte(t), true
+	}
+	return nil, false
+}
+
+func toBool(v interface{}) (bool, bool) {
+	switch t := v.(type) {
+	case bool:
+		return t, true
+	case string:
+		if b, err := strconv.ParseBool(t); err == nil {
+			return b, true
+		}
+	}
+	return false, false
+}
+
+// BackfillBlock replays stored contract calls and bigmap updates.
+func (idx *CustomIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
+func (idx *CustomIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	// bigmap ids may be reallocated on the new branch
+	idx.resetBigmaps()
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+func (idx *CustomIndex) DeleteBlock(ctx context.Context, height int64) error {
+	_, err := pack.NewQuery("etl.custom.delete", idx.table).
+		AndEqual("height", height).
+		Delete(ctx)
+	return err
+}
+
+func (idx *CustomIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	return nil
+}
+
+func (idx *CustomIndex) Flush(ctx context.Context) error {
+	return idx.table.Flush(ctx)
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+func TestToInt64(t *testing.T) {
+	// 2^64 + 1 is above the int64 range
+	huge, _ := new(big.Int).SetString("18446744073709551617", 10)
+	var z tezos.Z
+	z.Set(huge)
+	var negz tezos.Z
+	negz.Set(new(big.Int).Neg(huge))
+	tests := []struct {
+		name string
+		val  interface{}
+		want int64
+		ok   bool
+	}{
+		{"int", 42, 42, true},
+		{"big", big.NewInt(-7), -7, true},
+		{"big above int64", huge, math.MaxInt64, true},
+		{"big below int64", new(big.Int).Neg(huge), math.MinInt64, true},
+		{"zarith above int64", z, math.MaxInt64, true},
+		{"zarith pointer above int64", &z, math.MaxInt64, true},
+		{"zarith below int64", negz, math.MinInt64, true},
+		{"nil big", (*big.Int)(nil), 0, false},
+		{"string", "123", 123, true},
+		{"string above int64", "18446744073709551617", math.MaxInt64, true},
+		{"timestamp", "2022-01-01T00:00:00Z", 1640995200, true},
+		{"not a number", "abc", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := toInt64(tc.val)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("got %d %t, want %d %t", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
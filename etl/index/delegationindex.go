@@ -0,0 +1,334 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
+
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	DelegationPackSizeLog2    = 15 // =32k packs
+	DelegationJournalSizeLog2 = 16 // =64k entries
+	DelegationCacheSize       = 2
+	DelegationFillLevel       = 100
+	DelegationIndexKey        = "delegation"
+	DelegationTableKey        = "delegation_period"
+)
+
+var (
+	ErrNoDelegationEntry = errors.New("delegation period not indexed")
+)
+
+type DelegationIndex struct {
+	db     *pack.DB
+	opts   pack.Options
+	table  *pack.Table
+	params *tezos.Params
+	seeded bool // true after periods before the first replayed block were seeded
+}
+
+var _ model.BlockIndexer = (*DelegationIndex)(nil)
+
+func NewDelegationIndex(opts pack.Options) *DelegationIndex {
+	return &DelegationIndex{opts: opts}
+}
+
+func (idx *DelegationIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *DelegationIndex) Tables() []*pack.Table {
+	return []*pack.Table{idx.table}
+}
+
+func (idx *DelegationIndex) Key() string {
+	return DelegationIndexKey
+}
+
+func (idx *DelegationIndex) Name() string {
+	return DelegationIndexKey + " index"
+}
+
+func (idx *DelegationIndex) Create(path, label string, opts interface{}) error {
+	fields, err := pack.Fields(model.DelegationPeriod{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating %s database: %w", idx.Key(), err)
+	}
+	defer db.Close()
+
+	_, err = db.CreateTableIfNotExists(
+		DelegationTableKey,
+		fields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, DelegationPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, DelegationJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, DelegationCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, DelegationFillLevel),
+		})
+	return err
+}
+
+func (idx *DelegationIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		DelegationTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, DelegationJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, DelegationCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *DelegationIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *DelegationIndex) Close() error {
+	if idx.table != nil {
+		if err := idx.table.Close(); err != nil {
+			log.Errorf("Closing %s: %s", idx.Name(), err)
+		}
+		idx.table = nil
+	}
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// ConnectBlock closes the open delegation period of each account that changed
+// its delegate in block and opens a new period unless the account withdrew.
+func (idx *DelegationIndex) ConnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	idx.params = block.Params
+	if len(block.Delegations) == 0 {
+		return nil
+	}
+	ins := make([]pack.Item, 0, len(block.Delegations))
+	upd := make([]pack.Item, 0, len(block.Delegations))
+	closed := make(map[uint64]bool)
+	for _, v := range block.Delegations {
+		// an account may change delegate more than once per block
+		var done bool
+		for _, p := range ins {
+			p := p.(*model.DelegationPeriod)
+			if p.AccountId == v.AccountId && p.EndHeight == 0 {
+				p.EndHeight = v.StartHeight
+				p.EndBalance = v.StartBalance
+				done = true
+			}
+		}
+		if !done {
+			open := &model.DelegationPeriod{}
+			err := pack.NewQuery("etl.delegation.open", idx.table).
+				WithLimit(1).
+				AndEqual("account_id", v.AccountId).
+				AndEqual("end_height", 0).
+				Execute(ctx, open)
+			if err != nil {
+				return err
+			}
+			// stored periods are only closed by the first change in block
+			if open.RowId > 0 && !closed[open.RowId] {
+				closed[open.RowId] = true
+				open.EndHeight = v.StartHeight
+				open.EndBalance = v.StartBalance
+				upd = append(upd, open)
+			}
+		}
+		if v.BakerId > 0 {
+			p := *v
+			ins = append(ins, &p)
+		}
+	}
+	if len(upd) > 0 {
+		if err := idx.table.Update(ctx, upd); err != nil {
+			return err
+		}
+	}
+	if len(ins) == 0 {
+		return nil
+	}
+	return idx.table.Insert(ctx, ins)
+}
+
+// BackfillBlock reconstructs delegation changes from stored originations and
+// delegations. Delegation ops store the previous baker as receiver, which is
+// enough to detect withdrawals and baker switches. Bootstrap delegations are
+// stored as implicit delegation events in the genesis block. When the first
+// replayed block is above genesis, periods that started earlier are seeded
+// from accounts. Delegations performed by protocol migrations are not stored
+// as operations and remain missing.
+func (idx *DelegationIndex) BackfillBlock(ctx context.Context, block *model.Block, b model.BackfillBuilder) error {
+	if !idx.seeded {
+		if err := idx.seed(ctx, block.Height, b); err != nil {
+			return err
+		}
+		idx.seeded = true
+	}
+	block.Delegations = block.Delegations[:0]
+	for _, op := range block.Ops {
+		if !op.IsSuccess {
+			continue
+		}
+		var (
+			acc model.AccountID
+			bkr model.AccountID
+		)
+		switch op.Type {
+		case model.OpTypeOrigination:
+			if op.BakerId == 0 || op.ReceiverId == 0 {
+				continue
+			}
+			acc, bkr = op.ReceiverId, op.BakerId
+		case model.OpTypeDelegation:
+			if op.IsInternal {
+				continue
+			}
+			switch {
+			case op.BakerId == 0 || op.BakerId == op.SenderId:
+				// withdraw or baker registration, ends a delegation
+				if op.ReceiverId == 0 || op.ReceiverId == op.SenderId {
+					continue
+				}
+				acc = op.SenderId
+			case op.BakerId != op.ReceiverId:
+				acc, bkr = op.SenderId, op.BakerId
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+		block.Delegations = append(block.Delegations, &model.DelegationPeriod{
+			AccountId:    acc,
+			BakerId:      bkr,
+			StartHeight:  block.Height,
+			StartBalance: op.Volume,
+		})
+	}
+	return idx.ConnectBlock(ctx, block, nil)
+}
+
+// seed opens periods for accounts that were delegated before height when
+// the index is still empty. Accounts are only seeded when their current
+// delegation started before height, earlier periods of accounts that changed
+// their delegate later remain missing. Seeded start balances are unknown.
+func (idx *DelegationIndex) seed(ctx context.Context, height int64, b model.BackfillBuilder) error {
+	if height <= 1 {
+		return nil
+	}
+	first := &model.DelegationPeriod{}
+	err := pack.NewQuery("etl.delegation.first", idx.table).
+		WithLimit(1).
+		Execute(ctx, first)
+	if err != nil || first.RowId > 0 {
+		return err
+	}
+	accounts, err := b.Table(AccountTableKey)
+	if err != nil {
+		return err
+	}
+	ins := make([]pack.Item, 0)
+	acc := &model.Account{}
+	err = pack.NewQuery("etl.delegation.seed", accounts).
+		WithFields("row_id", "baker_id", "delegated_since").
+		AndGt("baker_id", 0).
+		AndLt("delegated_since", height).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(acc); err != nil {
+				return err
+			}
+			// skip self-delegated bakers
+			if acc.BakerId == acc.RowId {
+				return nil
+			}
+			ins = append(ins, &model.DelegationPeriod{
+				AccountId:   acc.RowId,
+				BakerId:     acc.BakerId,
+				StartHeight: acc.DelegatedSince,
+			})
+			return nil
+		})
+	if err != nil || len(ins) == 0 {
+		return err
+	}
+	log.Infof("Seeded %d delegation periods started before height %d.", len(ins), height)
+	return idx.table.Insert(ctx, ins)
+}
+
+func (idx *DelegationIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+// DeleteBlock removes periods started at height and reopens periods that
+// ended at height.
+func (idx *DelegationIndex) DeleteBlock(ctx context.Context, height int64) error {
+	_, err := pack.NewQuery("etl.delegation.delete", idx.table).
+		AndEqual("start_height", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	upd := make([]pack.Item, 0)
+	err = pack.NewQuery("etl.delegation.reopen", idx.table).
+		AndEqual("end_height", height).
+		Stream(ctx, func(r pack.Row) error {
+			p := &model.DelegationPeriod{}
+			if err := r.Decode(p); err != nil {
+				return err
+			}
+			p.EndHeight = 0
+			p.EndBalance = 0
+			upd = append(upd, p)
+			return nil
+		})
+	if err != nil || len(upd) == 0 {
+		return err
+	}
+	return idx.table.Update(ctx, upd)
+}
+
+// DeleteCycle removes delegation periods that ended in cycle. Open periods
+// are not affected.
+func (idx *DelegationIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	if idx.params == nil {
+		return ErrNoParams
+	}
+	_, err := pack.NewQuery("etl.delegation.delete_cycle", idx.table).
+		AndRange("end_height", idx.params.CycleStartHeight(cycle), idx.params.CycleEndHeight(cycle)).
+		Delete(ctx)
+	return err
+}
+
+func (idx *DelegationIndex) Flush(ctx context.Context) error {
+	for _, v := range idx.Tables() {
+		if err := v.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
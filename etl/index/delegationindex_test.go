@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// tableBuilder is a backfill builder that only provides tables.
+type tableBuilder struct {
+	model.BackfillBuilder
+	tables map[string]*pack.Table
+}
+
+func (b *tableBuilder) Table(key string) (*pack.Table, error) {
+	if t, ok := b.tables[key]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("missing table %s", key)
+}
+
+func newTestDelegationIndex(t *testing.T) *DelegationIndex {
+	t.Helper()
+	idx := NewDelegationIndex(pack.Options{})
+	dir := t.TempDir()
+	if err := idx.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func listDelegationPeriods(t *testing.T, idx *DelegationIndex) []*model.DelegationPeriod {
+	t.Helper()
+	list := make([]*model.DelegationPeriod, 0)
+	if err := pack.NewQuery("test", idx.table).Execute(context.Background(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestDelegationConnectBlock(t *testing.T) {
+	type change struct {
+		acc, bkr model.AccountID
+		balance  int64
+	}
+	tests := []struct {
+		name   string
+		blocks [][]change
+		want   []model.DelegationPeriod
+	}{
+		{
+			name:   "delegate and switch",
+			blocks: [][]change{{{1, 10, 100}}, {{1, 11, 150}}},
+			want: []model.DelegationPeriod{
+				{AccountId: 1, BakerId: 10, StartHeight: 1, EndHeight: 2, StartBalance: 100, EndBalance: 150},
+				{AccountId: 1, BakerId: 11, StartHeight: 2, StartBalance: 150},
+			},
+		},
+		{
+			name:   "withdraw and redelegate in one block",
+			blocks: [][]change{{{1, 10, 100}}, {{1, 0, 150}, {1, 11, 120}}},
+			want: []model.DelegationPeriod{
+				{AccountId: 1, BakerId: 10, StartHeight: 1, EndHeight: 2, StartBalance: 100, EndBalance: 150},
+				{AccountId: 1, BakerId: 11, StartHeight: 2, StartBalance: 120},
+			},
+		},
+		{
+			name:   "switch twice in one block",
+			blocks: [][]change{{{1, 10, 100}}, {{1, 11, 150}, {1, 12, 140}}},
+			want: []model.DelegationPeriod{
+				{AccountId: 1, BakerId: 10, StartHeight: 1, EndHeight: 2, StartBalance: 100, EndBalance: 150},
+				{AccountId: 1, BakerId: 11, StartHeight: 2, EndHeight: 2, StartBalance: 150, EndBalance: 140},
+				{AccountId: 1, BakerId: 12, StartHeight: 2, StartBalance: 140},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			idx := newTestDelegationIndex(t)
+			for i, changes := range tc.blocks {
+				block := &model.Block{Height: int64(i + 1)}
+				for _, c := range changes {
+					block.Delegations = append(block.Delegations, &model.DelegationPeriod{
+						AccountId:    c.acc,
+						BakerId:      c.bkr,
+						StartHeight:  block.Height,
+						StartBalance: c.balance,
+					})
+				}
+				if err := idx.ConnectBlock(ctx, block, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := listDelegationPeriods(t, idx)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d periods, want %d", len(got), len(tc.want))
+			}
+			for i, p := range got {
+				p.RowId = 0
+				if *p != tc.want[i] {
+					t.Errorf("period %d: got %+v, want %+v", i, *p, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestDelegationBackfillBootstrap checks that bootstrap delegations are
+// replayed from genesis events and seeded from accounts when the backfill
+// starts above genesis.
+func TestDelegationBackfillBootstrap(t *testing.T) {
+	ctx := context.Background()
+	accounts := NewAccountIndex(pack.Options{}, pack.Options{})
+	dir := t.TempDir()
+	if err := accounts.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accounts.Close() })
+	table := accounts.Tables()[0]
+	// accounts 1-5: self-delegated baker, bootstrap delegator, delegated
+	// after start, not delegated, delegated before start
+	ins := make([]pack.Item, 0)
+	for i, v := range []struct {
+		bkr   model.AccountID
+		since int64
+	}{{1, 0}, {1, 1}, {1, 50}, {0, 0}, {1, 5}} {
+		acc := model.NewAccount(tezos.NewAddress(tezos.AddressTypeEd25519, []byte{19: byte(i)}))
+		acc.BakerId = v.bkr
+		acc.DelegatedSince = v.since
+		ins = append(ins, acc)
+	}
+	err := table.Insert(ctx, ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &tableBuilder{tables: map[string]*pack.Table{AccountTableKey: table}}
+
+	// genesis stores bootstrap delegations as implicit events
+	idx := newTestDelegationIndex(t)
+	genesis := &model.Block{
+		Height: 1,
+		Ops: []*model.Op{
+			{Type: model.OpTypeDelegation, IsSuccess: true, IsEvent: true, SenderId: 1, BakerId: 1},
+			{Type: model.OpTypeDelegation, IsSuccess: true, IsEvent: true, SenderId: 2, BakerId: 1, Volume: 100},
+		},
+	}
+	if err := idx.BackfillBlock(ctx, genesis, b); err != nil {
+		t.Fatal(err)
+	}
+	got := listDelegationPeriods(t, idx)
+	if len(got) != 1 || got[0].AccountId != 2 || got[0].BakerId != 1 || got[0].StartHeight != 1 || got[0].StartBalance != 100 {
+		t.Errorf("genesis: got %+v, want open period of account 2", got)
+	}
+
+	// backfill above genesis seeds delegations that started earlier
+	idx = newTestDelegationIndex(t)
+	if err := idx.BackfillBlock(ctx, &model.Block{Height: 10}, b); err != nil {
+		t.Fatal(err)
+	}
+	got = listDelegationPeriods(t, idx)
+	if len(got) != 2 || got[0].AccountId != 2 || got[1].AccountId != 5 || got[1].StartHeight != 5 || got[1].EndHeight != 0 {
+		t.Errorf("seed: got %+v, want open periods of accounts 2 and 5", got)
+	}
+
+	// seeding happens once
+	if err := idx.BackfillBlock(ctx, &model.Block{Height: 11}, b); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listDelegationPeriods(t, idx)); n != 2 {
+		t.Errorf("got %d periods after second block, want 2", n)
+	}
+}
@@ -115,9 +115,13 @@ func (idx *FlowIndex) Close() error {
 	return nil
 }
 
-func (idx *FlowIndex) ConnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+func (idx *FlowIndex) ConnectBlock(ctx context.Context, block *model.Block, b model.BlockBuilder) error {
 	flows := make([]pack.Item, 0, len(block.Flows))
 	for _, f := range block.Flows {
+		// skip flows unrelated to selected accounts in filtered mode
+		if b.IsFiltered() && !b.IsSelected(f.AccountId) && !b.IsSelected(f.CounterPartyId) {
+			continue
+		}
 		flows = append(flows, f)
 	}
 	return idx.table.Insert(ctx, flows)
@@ -0,0 +1,255 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/packdb/vec"
+
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	GasStatsPackSizeLog2    = 12 // 4k packs
+	GasStatsJournalSizeLog2 = 14 // 16k
+	GasStatsCacheSize       = 2
+	GasStatsFillLevel       = 100
+	GasStatsIndexKey        = "gas_stats"
+	GasStatsTableKey        = "gas_stats"
+
+	// GasStatsWindow is the number of completed cycles kept in addition to
+	// the current cycle. Older statistics are removed at cycle start.
+	GasStatsWindow = 8
+)
+
+// GasStatsIndex keeps per-cycle gas, storage and fee statistics of external
+// contract calls for each called entrypoint.
+type GasStatsIndex struct {
+	db    *pack.DB
+	opts  pack.Options
+	table *pack.Table
+}
+
+var (
+	_ model.BlockIndexer    = (*GasStatsIndex)(nil)
+	_ model.BlockBackfiller = (*GasStatsIndex)(nil)
+)
+
+func NewGasStatsIndex(opts pack.Options) *GasStatsIndex {
+	return &GasStatsIndex{opts: opts}
+}
+
+func (idx *GasStatsIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *GasStatsIndex) Tables() []*pack.Table {
+	return []*pack.Table{idx.table}
+}
+
+func (idx *GasStatsIndex) Key() string {
+	return GasStatsIndexKey
+}
+
+func (idx *GasStatsIndex) Name() string {
+	return GasStatsIndexKey + " index"
+}
+
+func (idx *GasStatsIndex) Create(path, label string, opts interface{}) error {
+	fields, err := pack.Fields(model.GasStats{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating %s database: %w", idx.Key(), err)
+	}
+	defer db.Close()
+
+	_, err = db.CreateTableIfNotExists(
+		GasStatsTableKey,
+		fields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, GasStatsPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, GasStatsJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, GasStatsCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, GasStatsFillLevel),
+		})
+	return err
+}
+
+func (idx *GasStatsIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		GasStatsTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, GasStatsJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, GasStatsCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *GasStatsIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *GasStatsIndex) Close() error {
+	if idx.table != nil {
+		if err := idx.table.Close(); err != nil {
+			log.Errorf("Closing %s: %s", idx.Name(), err)
+		}
+		idx.table = nil
+	}
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// ConnectBlock adds external contract calls in block to the statistics of
+// the current cycle and removes statistics that fell out of the window.
+func (idx *GasStatsIndex) ConnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	if block.Params.IsCycleStart(block.Height) && block.Cycle > GasStatsWindow {
+		_, err := pack.NewQuery("etl.gas_stats.expire", idx.table).
+			AndLt("cycle", block.Cycle-GasStatsWindow).
+			Delete(ctx)
+		if err != nil {
+			return fmt.Errorf("gas stats: expire: %w", err)
+		}
+	}
+	return idx.update(ctx, block, false)
+}
+
+// BackfillBlock replays stored contract calls. Stored ops keep all fields
+// used by statistics, so replay is identical to connecting a block.
+func (idx *GasStatsIndex) BackfillBlock(ctx context.Context, block *model.Block, _ model.BackfillBuilder) error {
+	return idx.ConnectBlock(ctx, block, nil)
+}
+
+// DisconnectBlock reverts calls in block. Statistics removed at the start of
+// a cycle are not restored because they are outside the window.
+func (idx *GasStatsIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	return idx.update(ctx, block, true)
+}
+
+func (idx *GasStatsIndex) DeleteBlock(ctx context.Context, height int64) error {
+	return nil
+}
+
+func (idx *GasStatsIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	_, err := pack.NewQuery("etl.gas_stats.delete_cycle", idx.table).
+		AndEqual("cycle", cycle).
+		Delete(ctx)
+	return err
+}
+
+func (idx *GasStatsIndex) Flush(ctx context.Context) error {
+	for _, v := range idx.Tables() {
+		if err := v.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update adds (or on rollback removes) external contract calls in block to
+// per-entrypoint statistics of the block's cycle. Failed calls are included
+// so that failure rates can be reported.
+func (idx *GasStatsIndex) update(ctx context.Context, block *model.Block, rollback bool) error {
+	type statsKey struct {
+		acc model.AccountID
+		ep  int
+	}
+	calls := make(map[statsKey][]*model.Op)
+	accs := make([]uint64, 0)
+	for _, op := range block.Ops {
+		if op.Type != model.OpTypeTransaction || !op.IsContract || op.IsInternal || op.IsRollup {
+			continue
+		}
+		key := statsKey{op.ReceiverId, op.Entrypoint}
+		if _, ok := calls[key]; !ok {
+			accs = append(accs, op.ReceiverId.Value())
+		}
+		calls[key] = append(calls[key], op)
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	// load statistics of the current cycle for all called contracts
+	stats := make(map[statsKey]*model.GasStats)
+	err := pack.NewQuery("etl.gas_stats.load", idx.table).
+		AndEqual("cycle", block.Cycle).
+		AndIn("account_id", vec.UniqueUint64Slice(accs)).
+		Stream(ctx, func(r pack.Row) error {
+			s := &model.GasStats{}
+			if err := r.Decode(s); err != nil {
+				return err
+			}
+			key := statsKey{s.AccountId, s.Entrypoint}
+			if _, ok := calls[key]; !ok {
+				return nil
+			}
+			if err := s.Decode(); err != nil {
+				return fmt.Errorf("decoding stats for %d/%d: %w", s.AccountId, s.Entrypoint, err)
+			}
+			stats[key] = s
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("gas stats: load: %w", err)
+	}
+
+	ins := make([]pack.Item, 0)
+	upd := make([]pack.Item, 0, len(stats))
+	for key, ops := range calls {
+		s, ok := stats[key]
+		if !ok {
+			if rollback {
+				continue
+			}
+			s = model.NewGasStats(key.acc, key.ep, block.Cycle)
+		}
+		for _, op := range ops {
+			if rollback {
+				s.Remove(op)
+			} else {
+				s.Add(op)
+			}
+		}
+		if !rollback {
+			s.Height = block.Height
+		}
+		if err := s.Encode(); err != nil {
+			return fmt.Errorf("gas stats: encode: %w", err)
+		}
+		if s.RowId == 0 {
+			ins = append(ins, s)
+		} else {
+			upd = append(upd, s)
+		}
+	}
+	if err := idx.table.Insert(ctx, ins); err != nil {
+		return fmt.Errorf("gas stats: insert: %w", err)
+	}
+	if err := idx.table.Update(ctx, upd); err != nil {
+		return fmt.Errorf("gas stats: update: %w", err)
+	}
+	return nil
+}
@@ -153,6 +153,11 @@ func (idx *MetadataIndex) ConnectBlock(ctx context.Context, block *model.Block,
 	return nil
 }
 
+// BackfillBlock is a noop because metadata is not derived from blocks.
+func (idx *MetadataIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	return nil
+}
+
 func (idx *MetadataIndex) DisconnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
 	// noop
 	return nil
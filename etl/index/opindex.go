@@ -185,6 +185,10 @@ func (idx *OpIndex) ConnectBlock(ctx context.Context, block *model.Block, b mode
 				continue
 			}
 		}
+		// skip ops unrelated to selected accounts in filtered mode
+		if b.IsFiltered() && !IsSelectedOp(b, op) {
+			continue
+		}
 		switch op.Type {
 		case model.OpTypeEndorsement, model.OpTypePreendorsement:
 			endorse = append(endorse, op.ToEndorsement())
@@ -211,6 +215,15 @@ func (idx *OpIndex) ConnectBlock(ctx context.Context, block *model.Block, b mode
 	return idx.table.Insert(ctx, ops)
 }
 
+// IsSelectedOp returns true when any account involved in op is selected
+// by the index filter.
+func IsSelectedOp(b model.BlockBuilder, op *model.Op) bool {
+	return b.IsSelected(op.SenderId) ||
+		b.IsSelected(op.ReceiverId) ||
+		b.IsSelected(op.CreatorId) ||
+		b.IsSelected(op.BakerId)
+}
+
 func (idx *OpIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
 	return idx.DeleteBlock(ctx, block.Height)
 }
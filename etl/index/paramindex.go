@@ -0,0 +1,284 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	ParamPackSizeLog2    = 15 // 32k packs
+	ParamJournalSizeLog2 = 16 // 64k
+	ParamCacheSize       = 4
+	ParamFillLevel       = 100
+	ParamIndexKey        = "param"
+	ParamTableKey        = "param"
+
+	// max number of values extracted from a single call
+	ParamMaxValues = 256
+)
+
+var (
+	minInt64 = big.NewInt(math.MinInt64)
+	maxInt64 = big.NewInt(math.MaxInt64)
+)
+
+// ParamIndex decodes call parameters of selected contracts and stores their
+// scalar leaves in a search table.
+type ParamIndex struct {
+	db     *pack.DB
+	opts   pack.Options
+	filter *model.IndexFilter
+	params *tezos.Params
+	table  *pack.Table
+}
+
+var _ model.BlockIndexer = (*ParamIndex)(nil)
+
+func NewParamIndex(filter *model.IndexFilter, opts pack.Options) *ParamIndex {
+	return &ParamIndex{filter: filter, opts: opts}
+}
+
+func (idx *ParamIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *ParamIndex) Tables() []*pack.Table {
+	return []*pack.Table{idx.table}
+}
+
+func (idx *ParamIndex) Key() string {
+	return ParamIndexKey
+}
+
+func (idx *ParamIndex) Name() string {
+	return ParamIndexKey + " index"
+}
+
+func (idx *ParamIndex) Create(path, label string, opts interface{}) error {
+	fields, err := pack.Fields(model.ParamValue{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.CreateTableIfNotExists(
+		ParamTableKey,
+		fields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, ParamPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, ParamJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, ParamCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, ParamFillLevel),
+		})
+	return err
+}
+
+func (idx *ParamIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		ParamTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, ParamJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, ParamCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *ParamIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *ParamIndex) Close() error {
+	if idx.table != nil {
+		if err := idx.table.Close(); err != nil {
+			log.Errorf("Closing %s table: %s", idx.table.Name(), err)
+		}
+		idx.table = nil
+	}
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// assumes op ids are already set (must run after OpIndex)
+func (idx *ParamIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	idx.params = block.Params
+	ins := make([]pack.Item, 0)
+	for _, op := range block.Ops {
+		// don't process failed or unrelated ops
+		if !op.IsSuccess || !op.IsContract || op.Type != model.OpTypeTransaction || len(op.Parameters) == 0 {
+			continue
+		}
+		contract, ok := builder.ContractById(op.ReceiverId)
+		if !ok || !idx.filter.MatchContract(contract) {
+			continue
+		}
+		vals, err := decodeParams(op, contract)
+		if err != nil {
+			log.Warnf("%s: decoding call %s: %v", idx.Key(), op.Hash, err)
+			continue
+		}
+		for _, v := range vals {
+			ins = append(ins, v)
+		}
+	}
+
+	// insert, will generate unique row ids
+	if len(ins) > 0 {
+		if err := idx.table.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("%s: insert: %w", idx.Key(), err)
+		}
+	}
+	return nil
+}
+
+// decodeParams extracts scalar leaves from call parameters relative to the
+// called entrypoint.
+func decodeParams(op *model.Op, c *model.Contract) ([]*model.ParamValue, error) {
+	pTyp, _, err := c.LoadType()
+	if err != nil {
+		return nil, err
+	}
+	params := &micheline.Parameters{}
+	if err := params.UnmarshalBinary(op.Parameters); err != nil {
+		return nil, err
+	}
+	ep, prim, err := params.MapEntrypoint(pTyp)
+	if err != nil {
+		return nil, err
+	}
+	typ := ep.Type()
+	typ.Prim.Anno = nil
+	vals := make([]*model.ParamValue, 0)
+	flattenParams(mapValue(typ, prim), "", func(path string, v interface{}) bool {
+		p := &model.ParamValue{
+			OpId:      op.RowId,
+			AccountId: op.ReceiverId,
+			Height:    op.Height,
+			Path:      path,
+		}
+		switch t := v.(type) {
+		case tezos.Z:
+			p.Value = t.String()
+			p.Int = clampInt64(t.Big())
+			p.IsNum = true
+		case tezos.Address:
+			p.Value = t.String()
+		case string:
+			p.Value = t
+		default:
+			// skip keys, signatures, timestamps, booleans, etc
+			return true
+		}
+		vals = append(vals, p)
+		return len(vals) < ParamMaxValues
+	})
+	return vals, nil
+}
+
+// flattenParams calls fn for each leaf below val. Map keys extend the path
+// while list positions are skipped. Walking stops when fn returns false.
+func flattenParams(val interface{}, path string, fn func(string, interface{}) bool) bool {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !flattenParams(t[k], joinParamPath(path, k), fn) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		for _, v := range t {
+			if !flattenParams(v, path, fn) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return true
+	default:
+		return fn(path, val)
+	}
+}
+
+func joinParamPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func clampInt64(z *big.Int) int64 {
+	switch {
+	case z.Cmp(minInt64) < 0:
+		return math.MinInt64
+	case z.Cmp(maxInt64) > 0:
+		return math.MaxInt64
+	default:
+		return z.Int64()
+	}
+}
+
+// BackfillBlock replays stored contract calls.
+func (idx *ParamIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
+func (idx *ParamIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+func (idx *ParamIndex) DeleteBlock(ctx context.Context, height int64) error {
+	_, err := pack.NewQuery("etl.param.delete", idx.table).
+		AndEqual("height", height).
+		Delete(ctx)
+	return err
+}
+
+// DeleteCycle removes parameter values of calls included in cycle.
+func (idx *ParamIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	if idx.params == nil {
+		return ErrNoParams
+	}
+	_, err := pack.NewQuery("etl.param.delete_cycle", idx.table).
+		AndRange("height", idx.params.CycleStartHeight(cycle), idx.params.CycleEndHeight(cycle)).
+		Delete(ctx)
+	return err
+}
+
+func (idx *ParamIndex) Flush(ctx context.Context) error {
+	return idx.table.Flush(ctx)
+}
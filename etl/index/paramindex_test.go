@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestFlattenParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		val   interface{}
+		limit int
+		want  []string
+	}{
+		{
+			name: "scalar",
+			val:  "tz1",
+			want: []string{"=tz1"},
+		},
+		{
+			name: "sorted struct fields",
+			val:  map[string]interface{}{"to": "b", "from": "a", "amount": "1"},
+			want: []string{"amount=1", "from=a", "to=b"},
+		},
+		{
+			name: "list positions are skipped",
+			val: map[string]interface{}{
+				"txs": []interface{}{
+					map[string]interface{}{"to_": "a", "amount": "1"},
+					map[string]interface{}{"to_": "b", "amount": "2"},
+				},
+			},
+			want: []string{"txs.amount=1", "txs.to_=a", "txs.amount=2", "txs.to_=b"},
+		},
+		{
+			name: "nested maps and nil",
+			val:  map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": nil}},
+			want: []string{"a.b=c"},
+		},
+		{
+			name:  "stops early",
+			val:   []interface{}{"1", "2", "3", "4"},
+			limit: 2,
+			want:  []string{"=1", "=2"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := make([]string, 0)
+			flattenParams(tc.val, "", func(path string, v interface{}) bool {
+				got = append(got, path+"="+v.(string))
+				return tc.limit == 0 || len(got) < tc.limit
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClampInt64(t *testing.T) {
+	huge, _ := new(big.Int).SetString("100000000000000000000000", 10)
+	tests := []struct {
+		val  *big.Int
+		want int64
+	}{
+		{big.NewInt(0), 0},
+		{big.NewInt(-42), -42},
+		{big.NewInt(math.MaxInt64), math.MaxInt64},
+		{huge, math.MaxInt64},
+		{new(big.Int).Neg(huge), math.MinInt64},
+	}
+	for _, tc := range tests {
+		if got := clampInt64(tc.val); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.val, got, tc.want)
+		}
+	}
+}
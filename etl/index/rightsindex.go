@@ -361,6 +361,32 @@ func (idx *RightsIndex) ConnectBlock(ctx context.Context, block *model.Block, bu
 	return nil
 }
 
+// BackfillBlock fetches rights from RPC at cycle start like the crawler does
+// and replays baking and endorsing activity from stored blocks. Seed nonce
+// revelations are not stored, so seeded flags remain unset.
+func (idx *RightsIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	p := block.Params
+	if block.Height == 1 {
+		for cycle := int64(0); cycle < p.PreservedCycles+1; cycle++ {
+			if err := builder.RPC().FetchRightsByCycle(ctx, block.Height, cycle, block.TZ); err != nil {
+				return fmt.Errorf("rights: fetching rights for cycle %d: %w", cycle, err)
+			}
+			block.TZ.PrevEndorsing = nil
+		}
+	} else if block.Cycle > 0 && p.IsCycleStart(block.Height) {
+		cycle := block.Cycle + p.PreservedCycles
+		if err := builder.RPC().FetchRightsByCycle(ctx, block.Height, cycle, block.TZ); err != nil {
+			return fmt.Errorf("rights: fetching rights for cycle %d: %w", cycle, err)
+		}
+	}
+
+	// empty rights for snapshot bakers require the snapshot index
+	if _, err := builder.Table(SnapshotIndexKey); err != nil {
+		block.TZ.Snapshot = nil
+	}
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
 // Does not work on rollback of the first cycle block!
 func (idx *RightsIndex) DisconnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
 	// reverse right updates by clearing all flags across all bakers for this block
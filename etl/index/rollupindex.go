@@ -0,0 +1,569 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+const (
+	RollupPackSizeLog2         = 12 // 4k packs
+	RollupJournalSizeLog2      = 13 // 8k
+	RollupCacheSize            = 2  // minimum
+	RollupFillLevel            = 100
+	RollupIndexPackSizeLog2    = 15 // 16k packs (32k split size) ~256k
+	RollupIndexJournalSizeLog2 = 16 // 64k
+	RollupIndexCacheSize       = 2  // minimum
+	RollupIndexFillLevel       = 90
+	RollupEventPackSizeLog2    = 15 // 32k packs
+	RollupEventJournalSizeLog2 = 16 // 64k
+	RollupEventCacheSize       = 8
+	RollupEventFillLevel       = 100
+
+	RollupIndexKey           = "rollup"
+	RollupTableKey           = "rollup"
+	RollupCommitmentTableKey = "rollup_commitment"
+	RollupMessageTableKey    = "rollup_message"
+)
+
+var (
+	ErrNoRollupEntry = errors.New("rollup not indexed")
+)
+
+// RollupIndex tracks smart rollups, their state commitments and
+// inbox/outbox messages.
+type RollupIndex struct {
+	db          *pack.DB
+	opts        pack.Options
+	iopts       pack.Options
+	table       *pack.Table
+	commitTable *pack.Table
+	msgTable    *pack.Table
+}
+
+var _ model.BlockIndexer = (*RollupIndex)(nil)
+
+func NewRollupIndex(opts, iopts pack.Options) *RollupIndex {
+	return &RollupIndex{opts: opts, iopts: iopts}
+}
+
+func (idx *RollupIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *RollupIndex) Tables() []*pack.Table {
+	return []*pack.Table{
+		idx.table,
+		idx.commitTable,
+		idx.msgTable,
+	}
+}
+
+func (idx *RollupIndex) Key() string {
+	return RollupIndexKey
+}
+
+func (idx *RollupIndex) Name() string {
+	return RollupIndexKey + " index"
+}
+
+func (idx *RollupIndex) Create(path, label string, opts interface{}) error {
+	rollupFields, err := pack.Fields(model.SmartRollup{})
+	if err != nil {
+		return err
+	}
+	commitFields, err := pack.Fields(model.RollupCommitment{})
+	if err != nil {
+		return err
+	}
+	msgFields, err := pack.Fields(model.RollupMessage{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTableIfNotExists(
+		RollupTableKey,
+		rollupFields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, RollupPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, RollupFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = table.CreateIndexIfNotExists(
+		"hash",
+		rollupFields.Find("H"), // rollup address field (20 byte hashes)
+		pack.IndexTypeHash,     // hash table, index stores hash(field) -> pk value
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.iopts.PackSizeLog2, RollupIndexPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.iopts.JournalSizeLog2, RollupIndexJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.iopts.CacheSize, RollupIndexCacheSize),
+			FillLevel:       util.NonZero(idx.iopts.FillLevel, RollupIndexFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.CreateTableIfNotExists(
+		RollupCommitmentTableKey,
+		commitFields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, RollupEventPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupEventJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupEventCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, RollupEventFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.CreateTableIfNotExists(
+		RollupMessageTableKey,
+		msgFields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, RollupEventPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupEventJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupEventCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, RollupEventFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (idx *RollupIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.table, err = idx.db.Table(
+		RollupTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupCacheSize),
+		},
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.iopts.JournalSizeLog2, RollupIndexJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.iopts.CacheSize, RollupIndexCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	idx.commitTable, err = idx.db.Table(
+		RollupCommitmentTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupEventJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupEventCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	idx.msgTable, err = idx.db.Table(
+		RollupMessageTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, RollupEventJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, RollupEventCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *RollupIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *RollupIndex) Close() error {
+	for _, v := range idx.Tables() {
+		if v != nil {
+			if err := v.Close(); err != nil {
+				log.Errorf("Closing %s table: %s", v.Name(), err)
+			}
+		}
+	}
+	idx.table = nil
+	idx.commitTable = nil
+	idx.msgTable = nil
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// assumes op ids are already set (must run after OpIndex)
+func (idx *RollupIndex) ConnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	return idx.apply(ctx, block, false)
+}
+
+// BackfillBlock replays smart rollup ops from a stored block. Rollup ops are
+// stored without arguments and receipts, so the block is fetched from RPC
+// when it contains any successful rollup op.
+func (idx *RollupIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	for _, op := range block.Ops {
+		if op.IsSuccess && op.IsRollup {
+			if err := builder.LoadReceipts(ctx, block); err != nil {
+				return fmt.Errorf("rollup: loading receipts: %w", err)
+			}
+			break
+		}
+	}
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
+func (idx *RollupIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	if err := idx.apply(ctx, block, true); err != nil {
+		return err
+	}
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+func (idx *RollupIndex) DeleteBlock(ctx context.Context, height int64) error {
+	// log.Debugf("Rollback deleting rollups at height %d", height)
+	_, err := pack.NewQuery("etl.rollup.delete", idx.table).
+		AndEqual("first_seen", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pack.NewQuery("etl.rollup_commitment.delete", idx.commitTable).
+		AndEqual("height", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pack.NewQuery("etl.rollup_message.delete", idx.msgTable).
+		AndEqual("height", height).
+		Delete(ctx)
+	return err
+}
+
+func (idx *RollupIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	return nil
+}
+
+func (idx *RollupIndex) Flush(ctx context.Context) error {
+	for _, v := range idx.Tables() {
+		if err := v.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *RollupIndex) loadRollup(ctx context.Context, addr rpc.SmartRollupHash) (*model.SmartRollup, error) {
+	r := &model.SmartRollup{}
+	err := pack.NewQuery("etl.rollup.find", idx.table).
+		AndEqual("address", addr.Bytes()).
+		Execute(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if r.RowId == 0 {
+		return nil, ErrNoRollupEntry
+	}
+	return r, nil
+}
+
+// apply processes smart rollup ops in a block. On rollback it reverses
+// statistics and cementation while row deletion happens in DeleteBlock.
+func (idx *RollupIndex) apply(ctx context.Context, block *model.Block, rollback bool) error {
+	var (
+		rollups = make(map[string]*model.SmartRollup)
+		commits = make([]pack.Item, 0)
+		msgs    = make([]pack.Item, 0)
+		nInbox  int
+		step    = 1
+	)
+	if rollback {
+		step = -1
+	}
+
+	for _, op := range block.Ops {
+		// don't process failed or unrelated ops
+		if !op.IsSuccess || !op.IsRollup {
+			continue
+		}
+		rop, ok := op.Raw.(*rpc.SmartRollup)
+		if !ok {
+			continue
+		}
+		res := rop.Result()
+
+		// inbox messages are not related to a single rollup
+		if rop.Kind() == rpc.OpTypeSmartRollupAddMessages {
+			if rollback {
+				continue
+			}
+			for _, v := range rop.Messages {
+				msgs = append(msgs, &model.RollupMessage{
+					SenderId: op.SenderId,
+					OpId:     op.RowId,
+					Height:   op.Height,
+					Index:    nInbox,
+					Data:     []byte(v),
+				})
+				nInbox++
+			}
+			continue
+		}
+
+		// originate rollup
+		if rop.Kind() == rpc.OpTypeSmartRollupOriginate {
+			if rollback {
+				// rollup row will be deleted
+				continue
+			}
+			r := model.NewSmartRollup(rop, op)
+			if err := idx.table.Insert(ctx, r); err != nil {
+				return fmt.Errorf("rollup: insert: %w", err)
+			}
+			rollups[r.GetAddress().String()] = r
+			commits = append(commits, model.NewGenesisCommitment(r, op))
+			continue
+		}
+
+		// load rollup
+		addr := rop.Target()
+		r, ok := rollups[addr.String()]
+		if !ok {
+			var err error
+			r, err = idx.loadRollup(ctx, addr)
+			if err != nil {
+				return fmt.Errorf("rollup: %s op [%d:%d]: loading rollup %s: %w",
+					rpc.OpTypeString(rop.Kind()), op.Type.ListId(), op.OpP, addr, err)
+			}
+			rollups[addr.String()] = r
+		}
+		r.IsDirty = true
+		if !rollback {
+			r.LastSeen = block.Height
+		}
+
+		// bonds are identified from balance updates
+		var frozen, unfrozen, slashed int64
+		bal := res.Balances()
+		for i, u := range bal {
+			if u.Kind != "freezer" || !u.IsSmartRollupBond() {
+				continue
+			}
+			switch {
+			case u.Change > 0:
+				frozen += u.Change
+			case len(bal) > i+1 && bal[i+1].Category == "smart_rollup_refutation_punishments":
+				slashed -= u.Change
+			default:
+				unfrozen -= u.Change
+			}
+		}
+		if frozen > 0 {
+			r.NStakers += step
+		}
+		if unfrozen > 0 {
+			r.NStakers -= step
+		}
+		if slashed > 0 {
+			r.NStakers -= step
+			r.TotalLostBond += int64(step) * slashed
+		}
+		r.TotalBond += int64(step) * (frozen - unfrozen - slashed)
+
+		switch rop.Kind() {
+		case rpc.OpTypeSmartRollupPublish:
+			r.NCommitments += step
+			if !rollback {
+				commits = append(commits, model.NewRollupCommitment(rop, op, r, frozen))
+			}
+
+		case rpc.OpTypeSmartRollupCement:
+			r.NCements += step
+			if err := idx.cement(ctx, r, res, block.Height, rollback); err != nil {
+				return fmt.Errorf("rollup: %s op [%d:%d]: %w",
+					rpc.OpTypeString(rop.Kind()), op.Type.ListId(), op.OpP, err)
+			}
+
+		case rpc.OpTypeSmartRollupRefute:
+			r.NRefutations += step
+
+		case rpc.OpTypeSmartRollupTimeout:
+			r.NTimeouts += step
+
+		case rpc.OpTypeSmartRollupExecuteOutboxMessage:
+			r.NOutboxMessages += step
+			if !rollback {
+				msgs = append(msgs, &model.RollupMessage{
+					RollupId:   r.RowId,
+					IsOutbox:   true,
+					SenderId:   op.SenderId,
+					OpId:       op.RowId,
+					Height:     op.Height,
+					Commitment: rop.Execute.CementedCommitment.Clone().Bytes(),
+					Data:       []byte(rop.Execute.OutputProof),
+				})
+			}
+		}
+	}
+
+	// restore last activity from remaining commitments and messages
+	if rollback {
+		for _, r := range rollups {
+			last, err := idx.lastSeenHeight(ctx, r.RowId, block.Height)
+			if err != nil {
+				return err
+			}
+			r.LastSeen = util.Max64(last, r.FirstSeen)
+		}
+	}
+
+	// update rollups
+	upd := make([]pack.Item, 0, len(rollups))
+	for _, v := range rollups {
+		if v.IsDirty {
+			upd = append(upd, v)
+			v.IsDirty = false
+		}
+	}
+	if len(upd) > 0 {
+		if err := idx.table.Update(ctx, upd); err != nil {
+			return fmt.Errorf("rollup: update: %w", err)
+		}
+	}
+
+	// insert, will generate unique row ids
+	if len(commits) > 0 {
+		if err := idx.commitTable.Insert(ctx, commits); err != nil {
+			return fmt.Errorf("rollup: insert commitments: %w", err)
+		}
+	}
+	if len(msgs) > 0 {
+		if err := idx.msgTable.Insert(ctx, msgs); err != nil {
+			return fmt.Errorf("rollup: insert messages: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// cement marks all stakers' copies of a commitment as cemented or reverts
+// this on rollback and updates the rollup's last cemented commitment.
+func (idx *RollupIndex) cement(ctx context.Context, r *model.SmartRollup, res rpc.OperationResult, height int64, rollback bool) error {
+	q := pack.NewQuery("etl.rollup_commitment.cement", idx.commitTable).
+		AndEqual("rollup_id", r.RowId)
+	if rollback {
+		q = q.AndEqual("cement_height", height)
+	} else {
+		q = q.AndEqual("hash", res.CommitmentHash.Bytes())
+	}
+	list := make([]*model.RollupCommitment, 0)
+	if err := q.Execute(ctx, &list); err != nil {
+		return err
+	}
+	upd := make([]pack.Item, 0, len(list))
+	for _, v := range list {
+		v.IsCemented = !rollback
+		v.CementHeight = 0
+		if !rollback {
+			v.CementHeight = height
+		}
+		upd = append(upd, v)
+	}
+	if len(upd) > 0 {
+		if err := idx.commitTable.Update(ctx, upd); err != nil {
+			return err
+		}
+	}
+
+	if !rollback {
+		r.LastCommitment = res.CommitmentHash.Clone().Bytes()
+		r.LastCementedLevel = res.InboxLevel
+		return nil
+	}
+
+	// find the previous cemented commitment
+	last := &model.RollupCommitment{}
+	err := pack.NewQuery("etl.rollup_commitment.last_cemented", idx.commitTable).
+		WithDesc().
+		WithLimit(1).
+		AndEqual("rollup_id", r.RowId).
+		AndEqual("is_cemented", true).
+		AndLt("cement_height", height).
+		Execute(ctx, last)
+	if err != nil {
+		return err
+	}
+	if last.RowId > 0 {
+		r.LastCommitment = last.Hash
+		r.LastCementedLevel = last.InboxLevel
+	} else {
+		r.LastCommitment = r.GenesisCommitment
+		r.LastCementedLevel = r.FirstSeen
+	}
+	return nil
+}
+
+// lastSeenHeight returns the height of the most recent commitment, cement or
+// outbox message of a rollup below height or zero when none exists. Other
+// rollup ops leave no rows, so their activity cannot be restored.
+func (idx *RollupIndex) lastSeenHeight(ctx context.Context, id model.RollupID, height int64) (int64, error) {
+	var last int64
+	c := &model.RollupCommitment{}
+	err := pack.NewQuery("etl.rollup_commitment.last", idx.commitTable).
+		WithDesc().
+		WithLimit(1).
+		AndEqual("rollup_id", id).
+		AndLt("height", height).
+		Execute(ctx, c)
+	if err != nil {
+		return 0, fmt.Errorf("rollup: loading last commitment: %w", err)
+	}
+	last = c.Height
+	c = &model.RollupCommitment{}
+	err = pack.NewQuery("etl.rollup_commitment.last_cement", idx.commitTable).
+		WithDesc().
+		WithLimit(1).
+		AndEqual("rollup_id", id).
+		AndEqual("is_cemented", true).
+		AndLt("cement_height", height).
+		Execute(ctx, c)
+	if err != nil {
+		return 0, fmt.Errorf("rollup: loading last cement: %w", err)
+	}
+	last = util.Max64(last, c.CementHeight)
+	m := &model.RollupMessage{}
+	err = pack.NewQuery("etl.rollup_message.last", idx.msgTable).
+		WithDesc().
+		WithLimit(1).
+		AndEqual("rollup_id", id).
+		AndLt("height", height).
+		Execute(ctx, m)
+	if err != nil {
+		return 0, fmt.Errorf("rollup: loading last message: %w", err)
+	}
+	return util.Max64(last, m.Height), nil
+}
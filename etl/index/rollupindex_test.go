@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+func testRollupHash(typ rpc.SmartRollupHashType, n byte) rpc.SmartRollupHash {
+	buf := make([]byte, typ.Len())
+	buf[0] = n
+	return rpc.NewSmartRollupHash(typ, buf)
+}
+
+func testRollupBlock(height int64, rop *rpc.SmartRollup) *model.Block {
+	return &model.Block{
+		Height: height,
+		Ops: []*model.Op{{
+			RowId:     model.OpID(height),
+			Height:    height,
+			SenderId:  1,
+			IsSuccess: true,
+			IsRollup:  true,
+			Raw:       rop,
+		}},
+	}
+}
+
+// TestRollupRollback checks that disconnecting blocks restores the rollup
+// row to the state a fresh sync up to the parent block would produce.
+func TestRollupRollback(t *testing.T) {
+	ctx := context.Background()
+	idx := NewRollupIndex(pack.Options{}, pack.Options{})
+	dir := t.TempDir()
+	if err := idx.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+
+	addr := testRollupHash(rpc.SmartRollupHashTypeAddress, 1)
+	genesis := testRollupHash(rpc.SmartRollupHashTypeCommitment, 1)
+	commit := testRollupHash(rpc.SmartRollupHashTypeCommitment, 2)
+	newOp := func(res rpc.OperationResult) *rpc.SmartRollup {
+		return &rpc.SmartRollup{
+			Manager: rpc.Manager{Generic: rpc.Generic{Metadata: &rpc.OperationMetadata{Result: res}}},
+			Rollup:  addr,
+		}
+	}
+	originate := newOp(rpc.OperationResult{SmartRollupAddress: addr, GenesisCommitmentHash: genesis})
+	originate.OpKind = rpc.OpTypeSmartRollupOriginate
+	publish := newOp(rpc.OperationResult{StakedHash: commit})
+	publish.OpKind = rpc.OpTypeSmartRollupPublish
+	publish.Publish.InboxLevel = 15
+	cement := newOp(rpc.OperationResult{CommitmentHash: commit, InboxLevel: 15})
+	cement.OpKind = rpc.OpTypeSmartRollupCement
+	blocks := []*model.Block{
+		testRollupBlock(10, originate),
+		testRollupBlock(20, publish),
+		testRollupBlock(30, cement),
+	}
+
+	// connect all blocks and remember the rollup after each
+	states := make([]model.SmartRollup, 0, len(blocks))
+	for _, b := range blocks {
+		if err := idx.ConnectBlock(ctx, b, nil); err != nil {
+			t.Fatal(err)
+		}
+		r, err := idx.loadRollup(ctx, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, *r)
+	}
+	if r := states[2]; r.LastSeen != 30 || !bytes.Equal(r.LastCommitment, commit.Bytes()) || r.LastCementedLevel != 15 {
+		t.Fatalf("after cement got last_seen=%d level=%d, want 30 15", r.LastSeen, r.LastCementedLevel)
+	}
+
+	// disconnect in reverse order, each must match the state after its parent
+	for i := len(blocks) - 1; i > 0; i-- {
+		if err := idx.DisconnectBlock(ctx, blocks[i], nil); err != nil {
+			t.Fatal(err)
+		}
+		r, err := idx.loadRollup(ctx, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := states[i-1]
+		if r.LastSeen != want.LastSeen ||
+			!bytes.Equal(r.LastCommitment, want.LastCommitment) ||
+			r.LastCementedLevel != want.LastCementedLevel ||
+			r.NCommitments != want.NCommitments ||
+			r.NCements != want.NCements {
+			t.Errorf("disconnect %d: got last_seen=%d level=%d commits=%d cements=%d, want %d %d %d %d",
+				blocks[i].Height, r.LastSeen, r.LastCementedLevel, r.NCommitments, r.NCements,
+				want.LastSeen, want.LastCementedLevel, want.NCommitments, want.NCements)
+		}
+	}
+}
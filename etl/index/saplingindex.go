@@ -0,0 +1,396 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const (
+	SaplingPackSizeLog2    = 15 // 32k packs
+	SaplingJournalSizeLog2 = 16 // 64k
+	SaplingCacheSize       = 4
+	SaplingFillLevel       = 100
+
+	SaplingIndexKey     = "sapling"
+	SaplingPoolTableKey = "sapling_pool"
+	SaplingTxTableKey   = "sapling_tx"
+)
+
+var (
+	ErrNoSaplingEntry = errors.New("sapling pool not indexed")
+)
+
+type SaplingIndex struct {
+	db        *pack.DB
+	opts      pack.Options
+	poolTable *pack.Table
+	txTable   *pack.Table
+}
+
+var _ model.BlockIndexer = (*SaplingIndex)(nil)
+
+func NewSaplingIndex(opts pack.Options) *SaplingIndex {
+	return &SaplingIndex{opts: opts}
+}
+
+func (idx *SaplingIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *SaplingIndex) Tables() []*pack.Table {
+	return []*pack.Table{
+		idx.poolTable,
+		idx.txTable,
+	}
+}
+
+func (idx *SaplingIndex) Key() string {
+	return SaplingIndexKey
+}
+
+func (idx *SaplingIndex) Name() string {
+	return SaplingIndexKey + " index"
+}
+
+func (idx *SaplingIndex) Create(path, label string, opts interface{}) error {
+	poolFields, err := pack.Fields(model.SaplingPool{})
+	if err != nil {
+		return err
+	}
+	txFields, err := pack.Fields(model.SaplingTx{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	for key, fields := range map[string]pack.FieldList{
+		SaplingPoolTableKey: poolFields,
+		SaplingTxTableKey:   txFields,
+	} {
+		_, err = db.CreateTableIfNotExists(
+			key,
+			fields,
+			pack.Options{
+				PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, SaplingPackSizeLog2),
+				JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, SaplingJournalSizeLog2),
+				CacheSize:       util.NonZero(idx.opts.CacheSize, SaplingCacheSize),
+				FillLevel:       util.NonZero(idx.opts.FillLevel, SaplingFillLevel),
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *SaplingIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.poolTable, err = idx.db.Table(
+		SaplingPoolTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, SaplingJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, SaplingCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	idx.txTable, err = idx.db.Table(
+		SaplingTxTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, SaplingJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, SaplingCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *SaplingIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *SaplingIndex) Close() error {
+	for _, v := range idx.Tables() {
+		if v != nil {
+			if err := v.Close(); err != nil {
+				log.Errorf("Closing %s table: %s", v.Name(), err)
+			}
+		}
+	}
+	idx.poolTable = nil
+	idx.txTable = nil
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+func (idx *SaplingIndex) loadPool(ctx context.Context, pools map[int64]*model.SaplingPool, id int64) (*model.SaplingPool, error) {
+	if p, ok := pools[id]; ok {
+		return p, nil
+	}
+	p := &model.SaplingPool{}
+	err := pack.NewQuery("etl.sapling_pool.find", idx.poolTable).
+		AndEqual("pool_id", id).
+		Execute(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.RowId == 0 {
+		return nil, ErrNoSaplingEntry
+	}
+	pools[id] = p
+	return p, nil
+}
+
+// saplingAmounts returns tez shielded by calling the pool contract in op and
+// tez unshielded by internal transfers the contract emits in response.
+func saplingAmounts(ops []*model.Op, pos int) (shielded, unshielded int64) {
+	op := ops[pos]
+	if op.Type == model.OpTypeTransaction {
+		shielded = op.Volume
+	}
+	for _, v := range ops[pos+1:] {
+		if !v.IsInternal || v.Type.ListId() != op.Type.ListId() || v.OpP != op.OpP || v.OpC != op.OpC {
+			break
+		}
+		// stop at the next call into the same contract
+		if v.ReceiverId == op.ReceiverId {
+			break
+		}
+		if v.IsSuccess && v.Type == model.OpTypeTransaction && v.SenderId == op.ReceiverId {
+			unshielded += v.Volume
+		}
+	}
+	return
+}
+
+// assumes op ids are already set (must run after OpIndex)
+func (idx *SaplingIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	pools := make(map[int64]*model.SaplingPool)
+	ins := make([]pack.Item, 0)
+	for i, op := range block.Ops {
+		// don't process failed or unrelated ops
+		if !op.IsSuccess || len(op.SaplingEvents) == 0 {
+			continue
+		}
+		shielded, unshielded := saplingAmounts(block.Ops, i)
+		for _, ev := range op.SaplingEvents {
+			// skip temporary sapling states
+			if ev.PoolId < 0 {
+				continue
+			}
+			var (
+				pool *model.SaplingPool
+				err  error
+			)
+			switch ev.Diff.Action {
+			case micheline.DiffActionAlloc:
+				pool = model.NewSaplingPool(ev.PoolId, op, ev.Diff.MemoSize)
+			case micheline.DiffActionCopy:
+				pool = model.NewSaplingPool(ev.PoolId, op, ev.Diff.MemoSize)
+				if ev.Diff.SourceId >= 0 {
+					src, err := idx.loadPool(ctx, pools, ev.Diff.SourceId)
+					if err != nil {
+						return fmt.Errorf("sapling: loading copy source %d: %w", ev.Diff.SourceId, err)
+					}
+					pool.MemoSize = src.MemoSize
+					pool.NCommitments = src.NCommitments
+					pool.NNullifiers = src.NNullifiers
+				}
+			case micheline.DiffActionUpdate:
+				pool, err = idx.loadPool(ctx, pools, ev.PoolId)
+				if err != nil {
+					return fmt.Errorf("sapling: loading pool %d: %w", ev.PoolId, err)
+				}
+			default:
+				continue
+			}
+			if pool.RowId == 0 {
+				// insert immediately to make pool visible to later updates
+				if err := idx.poolTable.Insert(ctx, pool); err != nil {
+					return fmt.Errorf("sapling: insert pool: %w", err)
+				}
+				pools[pool.PoolId] = pool
+			}
+
+			nc, nn := len(ev.Diff.Updates.Commitments), len(ev.Diff.Updates.Nullifiers)
+			pool.NCommitments += int64(nc)
+			pool.NNullifiers += int64(nn)
+			pool.NTxs++
+			pool.Value += shielded - unshielded
+			pool.TotalShielded += shielded
+			pool.TotalUnshielded += unshielded
+			pool.LastBlock = block.Height
+			pool.IsDirty = true
+
+			ins = append(ins, &model.SaplingTx{
+				PoolId:          pool.PoolId,
+				AccountId:       pool.AccountId,
+				OpId:            op.RowId,
+				Height:          block.Height,
+				Time:            block.Timestamp,
+				MemoSize:        pool.MemoSize,
+				NCommitments:    nc,
+				NNullifiers:     nn,
+				Shielded:        shielded,
+				Unshielded:      unshielded,
+				PoolCommitments: pool.NCommitments,
+				PoolNullifiers:  pool.NNullifiers,
+				PoolValue:       pool.Value,
+			})
+
+			// attribute amounts to the first updated pool only
+			shielded, unshielded = 0, 0
+		}
+	}
+
+	if err := idx.flushPools(ctx, pools); err != nil {
+		return err
+	}
+
+	// insert, will generate unique row ids
+	if len(ins) > 0 {
+		if err := idx.txTable.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("sapling: insert txs: %w", err)
+		}
+	}
+	return nil
+}
+
+// BackfillBlock replays sapling state changes of a stored block. Sapling
+// diffs are only part of RPC receipts, so the block is fetched when it
+// contains a successful call or origination of a sapling contract.
+func (idx *SaplingIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	for _, op := range block.Ops {
+		if !op.IsSuccess || op.ReceiverId == 0 {
+			continue
+		}
+		con, ok := builder.ContractById(op.ReceiverId)
+		if !ok || !con.Features.Contains(micheline.FeatureSapling) {
+			continue
+		}
+		if err := builder.LoadReceipts(ctx, block); err != nil {
+			return fmt.Errorf("sapling: loading receipts: %w", err)
+		}
+		break
+	}
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
+func (idx *SaplingIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	// reverse pool statistics from stored txs
+	txs := make([]*model.SaplingTx, 0)
+	err := pack.NewQuery("etl.sapling_tx.disconnect", idx.txTable).
+		AndEqual("height", block.Height).
+		Execute(ctx, &txs)
+	if err != nil {
+		return err
+	}
+
+	pools := make(map[int64]*model.SaplingPool)
+	for _, v := range txs {
+		pool, err := idx.loadPool(ctx, pools, v.PoolId)
+		if err != nil {
+			return fmt.Errorf("sapling: loading pool %d: %w", v.PoolId, err)
+		}
+		pool.NCommitments -= int64(v.NCommitments)
+		pool.NNullifiers -= int64(v.NNullifiers)
+		pool.NTxs--
+		pool.Value -= v.Shielded - v.Unshielded
+		pool.TotalShielded -= v.Shielded
+		pool.TotalUnshielded -= v.Unshielded
+		pool.IsDirty = true
+	}
+
+	// reset last block to the most recent remaining pool update
+	for _, pool := range pools {
+		if pool.LastBlock < block.Height {
+			continue
+		}
+		last := &model.SaplingTx{}
+		err := pack.NewQuery("etl.sapling_tx.find_last", idx.txTable).
+			WithFields("height").
+			WithDesc().
+			WithLimit(1).
+			AndEqual("pool_id", pool.PoolId).
+			AndLt("height", block.Height).
+			Execute(ctx, last)
+		if err != nil {
+			return err
+		}
+		pool.LastBlock = util.Max64(last.Height, pool.FirstBlock)
+	}
+
+	if err := idx.flushPools(ctx, pools); err != nil {
+		return err
+	}
+
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+func (idx *SaplingIndex) flushPools(ctx context.Context, pools map[int64]*model.SaplingPool) error {
+	upd := make([]pack.Item, 0)
+	for _, v := range pools {
+		if v.IsDirty {
+			upd = append(upd, v)
+			v.IsDirty = false
+		}
+	}
+	if len(upd) > 0 {
+		if err := idx.poolTable.Update(ctx, upd); err != nil {
+			return fmt.Errorf("sapling: update pools: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *SaplingIndex) DeleteBlock(ctx context.Context, height int64) error {
+	// log.Debugf("Rollback deleting sapling txs at height %d", height)
+	_, err := pack.NewQuery("etl.sapling_tx.delete", idx.txTable).
+		AndEqual("height", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pack.NewQuery("etl.sapling_pool.delete", idx.poolTable).
+		AndEqual("first_block", height).
+		Delete(ctx)
+	return err
+}
+
+func (idx *SaplingIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	return nil
+}
+
+func (idx *SaplingIndex) Flush(ctx context.Context) error {
+	for _, v := range idx.Tables() {
+		if err := v.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
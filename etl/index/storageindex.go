@@ -10,8 +10,10 @@ import (
 
     "blockwatch.cc/packdb/pack"
     "blockwatch.cc/packdb/util"
+    "blockwatch.cc/tzgo/tezos"
 
     "blockwatch.cc/tzindex/etl/model"
+    "blockwatch.cc/tzindex/rpc"
 )
 
 const (
@@ -31,6 +33,7 @@ type StorageIndex struct {
     db       *pack.DB
     opts     pack.Options
     storages *pack.Table
+    params   *tezos.Params
 }
 
 var _ model.BlockIndexer = (*StorageIndex)(nil)
@@ -120,6 +123,7 @@ func (idx *StorageIndex) Close() error {
 }
 
 func (idx *StorageIndex) ConnectBlock(ctx context.Context, block *model.Block, b model.BlockBuilder) error {
+    idx.params = block.Params
     ins := make([]pack.Item, 0)
     for _, op := range block.Ops {
         // don't process failed or unrelated ops
@@ -127,6 +131,11 @@ func (idx *StorageIndex) ConnectBlock(ctx context.Context, block *model.Block, b
             continue
         }
 
+        // skip unselected contracts in filtered mode
+        if !b.IsSelected(op.Contract.AccountId) {
+            continue
+        }
+
         store := &model.Storage{
             AccountId: op.Contract.AccountId,
             Hash:      op.StorageHash,
@@ -140,6 +149,64 @@ func (idx *StorageIndex) ConnectBlock(ctx context.Context, block *model.Block, b
     return idx.storages.Insert(ctx, ins)
 }
 
+// BackfillBlock stores contract storage for a past block. Stored operations
+// only keep storage hashes, so storage is fetched from RPC once per updated
+// contract at the end of the block. Intermediate states of contracts called
+// multiple times in the same block are not restored.
+func (idx *StorageIndex) BackfillBlock(ctx context.Context, block *model.Block, b model.BackfillBuilder) error {
+    idx.params = block.Params
+
+    // find the last storage hash per contract
+    last := make(map[model.AccountID]uint64)
+    order := make([]model.AccountID, 0)
+    for _, op := range block.Ops {
+        if !op.IsSuccess || !op.IsContract || op.StorageHash == 0 || op.ReceiverId == 0 {
+            continue
+        }
+        if !b.IsSelected(op.ReceiverId) {
+            continue
+        }
+        if _, ok := last[op.ReceiverId]; !ok {
+            order = append(order, op.ReceiverId)
+        }
+        last[op.ReceiverId] = op.StorageHash
+    }
+
+    ins := make([]pack.Item, 0, len(order))
+    for _, id := range order {
+        // skip when storage did not change
+        prev := &model.Storage{}
+        err := pack.NewQuery("etl.storage.backfill", idx.storages).
+            WithFields("row_id", "hash").
+            WithDesc().
+            WithLimit(1).
+            AndEqual("account_id", id).
+            Execute(ctx, prev)
+        if err != nil {
+            return err
+        }
+        if prev.RowId > 0 && prev.Hash == last[id] {
+            continue
+        }
+        con, ok := b.ContractById(id)
+        if !ok {
+            continue
+        }
+        prim, err := b.RPC().GetContractStorage(ctx, con.Address, rpc.BlockLevel(block.Height))
+        if err != nil {
+            return fmt.Errorf("storage: fetching %s at block %d: %w", con.Address, block.Height, err)
+        }
+        buf, _ := prim.MarshalBinary()
+        ins = append(ins, &model.Storage{
+            AccountId: id,
+            Hash:      prim.Hash64(),
+            Height:    block.Height,
+            Storage:   buf,
+        })
+    }
+    return idx.storages.Insert(ctx, ins)
+}
+
 func (idx *StorageIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
     return idx.DeleteBlock(ctx, block.Height)
 }
@@ -151,8 +218,42 @@ func (idx *StorageIndex) DeleteBlock(ctx context.Context, height int64) error {
     return err
 }
 
+// DeleteCycle removes storage updates up to the end of cycle. The most recent
+// update of each contract is kept because it represents current storage.
 func (idx *StorageIndex) DeleteCycle(ctx context.Context, cycle int64) error {
-    return nil
+    if idx.params == nil {
+        return ErrNoParams
+    }
+    type XStorage struct {
+        RowId     uint64          `pack:"row_id"`
+        AccountId model.AccountID `pack:"account_id"`
+    }
+    var (
+        xs     XStorage
+        latest = make(map[model.AccountID]uint64)
+        ids    = make([]uint64, 0)
+    )
+    err := pack.NewQuery("etl.storage.scan", idx.storages).
+        WithFields("row_id", "account_id").
+        AndLte("height", idx.params.CycleEndHeight(cycle)).
+        Stream(ctx, func(r pack.Row) error {
+            if err := r.Decode(&xs); err != nil {
+                return err
+            }
+            // rows are sorted by row id, so later rows replace earlier ones
+            if prev, ok := latest[xs.AccountId]; ok {
+                ids = append(ids, prev)
+            }
+            latest[xs.AccountId] = xs.RowId
+            return nil
+        })
+    if err != nil {
+        return err
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    return idx.storages.DeleteIds(ctx, ids)
 }
 
 func (idx *StorageIndex) Flush(ctx context.Context) error {
@@ -0,0 +1,593 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+const (
+	TicketPackSizeLog2         = 15 // 32k packs
+	TicketJournalSizeLog2      = 16 // 64k
+	TicketCacheSize            = 8
+	TicketFillLevel            = 100
+	TicketIndexPackSizeLog2    = 15 // 16k packs (32k split size) ~256k
+	TicketIndexJournalSizeLog2 = 16 // 64k
+	TicketIndexCacheSize       = 2  // minimum
+	TicketIndexFillLevel       = 90
+
+	TicketIndexKey        = "ticket"
+	TicketTypeTableKey    = "ticket_type"
+	TicketBalanceTableKey = "ticket_balance"
+	TicketUpdateTableKey  = "ticket_update"
+)
+
+var (
+	ErrNoTicketEntry = errors.New("ticket not indexed")
+)
+
+type TicketIndex struct {
+	db           *pack.DB
+	opts         pack.Options
+	iopts        pack.Options
+	typeTable    *pack.Table
+	balanceTable *pack.Table
+	updateTable  *pack.Table
+}
+
+var _ model.BlockIndexer = (*TicketIndex)(nil)
+
+func NewTicketIndex(opts, iopts pack.Options) *TicketIndex {
+	return &TicketIndex{opts: opts, iopts: iopts}
+}
+
+func (idx *TicketIndex) DB() *pack.DB {
+	return idx.db
+}
+
+func (idx *TicketIndex) Tables() []*pack.Table {
+	return []*pack.Table{
+		idx.typeTable,
+		idx.balanceTable,
+		idx.updateTable,
+	}
+}
+
+func (idx *TicketIndex) Key() string {
+	return TicketIndexKey
+}
+
+func (idx *TicketIndex) Name() string {
+	return TicketIndexKey + " index"
+}
+
+func (idx *TicketIndex) Create(path, label string, opts interface{}) error {
+	typeFields, err := pack.Fields(model.TicketType{})
+	if err != nil {
+		return err
+	}
+	balanceFields, err := pack.Fields(model.TicketBalance{})
+	if err != nil {
+		return err
+	}
+	updateFields, err := pack.Fields(model.TicketUpdate{})
+	if err != nil {
+		return err
+	}
+	db, err := pack.CreateDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTableIfNotExists(
+		TicketTypeTableKey,
+		typeFields,
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, TicketPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, TicketJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, TicketCacheSize),
+			FillLevel:       util.NonZero(idx.opts.FillLevel, TicketFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = table.CreateIndexIfNotExists(
+		"hash",
+		typeFields.Find("H"), // ticket type hash field (32 byte expr hashes)
+		pack.IndexTypeHash,   // hash table, index stores hash(field) -> pk value
+		pack.Options{
+			PackSizeLog2:    util.NonZero(idx.iopts.PackSizeLog2, TicketIndexPackSizeLog2),
+			JournalSizeLog2: util.NonZero(idx.iopts.JournalSizeLog2, TicketIndexJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.iopts.CacheSize, TicketIndexCacheSize),
+			FillLevel:       util.NonZero(idx.iopts.FillLevel, TicketIndexFillLevel),
+		})
+	if err != nil {
+		return err
+	}
+
+	for key, fields := range map[string]pack.FieldList{
+		TicketBalanceTableKey: balanceFields,
+		TicketUpdateTableKey:  updateFields,
+	} {
+		_, err = db.CreateTableIfNotExists(
+			key,
+			fields,
+			pack.Options{
+				PackSizeLog2:    util.NonZero(idx.opts.PackSizeLog2, TicketPackSizeLog2),
+				JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, TicketJournalSizeLog2),
+				CacheSize:       util.NonZero(idx.opts.CacheSize, TicketCacheSize),
+				FillLevel:       util.NonZero(idx.opts.FillLevel, TicketFillLevel),
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (idx *TicketIndex) Init(path, label string, opts interface{}) error {
+	var err error
+	idx.db, err = pack.OpenDatabase(path, idx.Key(), label, opts)
+	if err != nil {
+		return err
+	}
+	idx.typeTable, err = idx.db.Table(
+		TicketTypeTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, TicketJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, TicketCacheSize),
+		},
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.iopts.JournalSizeLog2, TicketIndexJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.iopts.CacheSize, TicketIndexCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	idx.balanceTable, err = idx.db.Table(
+		TicketBalanceTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, TicketJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, TicketCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	idx.updateTable, err = idx.db.Table(
+		TicketUpdateTableKey,
+		pack.Options{
+			JournalSizeLog2: util.NonZero(idx.opts.JournalSizeLog2, TicketJournalSizeLog2),
+			CacheSize:       util.NonZero(idx.opts.CacheSize, TicketCacheSize),
+		})
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+func (idx *TicketIndex) FinalizeSync(_ context.Context) error {
+	return nil
+}
+
+func (idx *TicketIndex) Close() error {
+	for _, v := range idx.Tables() {
+		if v != nil {
+			if err := v.Close(); err != nil {
+				log.Errorf("Closing %s table: %s", v.Name(), err)
+			}
+		}
+	}
+	idx.typeTable = nil
+	idx.balanceTable = nil
+	idx.updateTable = nil
+	if idx.db != nil {
+		if err := idx.db.Close(); err != nil {
+			return err
+		}
+		idx.db = nil
+	}
+	return nil
+}
+
+// ticketState caches ticket types and balances touched in a single block
+type ticketState struct {
+	types    map[string]*model.TicketType
+	typeIds  map[model.TicketTypeID]*model.TicketType
+	balances map[[2]uint64]*model.TicketBalance
+}
+
+func newTicketState() *ticketState {
+	return &ticketState{
+		types:    make(map[string]*model.TicketType),
+		typeIds:  make(map[model.TicketTypeID]*model.TicketType),
+		balances: make(map[[2]uint64]*model.TicketBalance),
+	}
+}
+
+func (idx *TicketIndex) loadType(ctx context.Context, st *ticketState, t rpc.Ticket) (*model.TicketType, error) {
+	hash := t.Hash()
+	if tt, ok := st.types[hash.String()]; ok {
+		return tt, nil
+	}
+	tt := &model.TicketType{}
+	err := pack.NewQuery("etl.ticket_type.find", idx.typeTable).
+		AndEqual("hash", hash.Bytes()).
+		Execute(ctx, tt)
+	if err != nil {
+		return nil, err
+	}
+	if tt.RowId == 0 {
+		return nil, ErrNoTicketEntry
+	}
+	st.types[hash.String()] = tt
+	st.typeIds[tt.RowId] = tt
+	return tt, nil
+}
+
+func (idx *TicketIndex) loadTypeId(ctx context.Context, st *ticketState, id model.TicketTypeID) (*model.TicketType, error) {
+	if tt, ok := st.typeIds[id]; ok {
+		return tt, nil
+	}
+	tt := &model.TicketType{}
+	err := pack.NewQuery("etl.ticket_type.find_id", idx.typeTable).
+		AndEqual("I", id).
+		Execute(ctx, tt)
+	if err != nil {
+		return nil, err
+	}
+	if tt.RowId == 0 {
+		return nil, ErrNoTicketEntry
+	}
+	st.types[tt.GetHash().String()] = tt
+	st.typeIds[tt.RowId] = tt
+	return tt, nil
+}
+
+func (idx *TicketIndex) loadBalance(ctx context.Context, st *ticketState, tid model.TicketTypeID, aid model.AccountID) (*model.TicketBalance, error) {
+	key := [2]uint64{tid.Value(), aid.Value()}
+	if bal, ok := st.balances[key]; ok {
+		return bal, nil
+	}
+	bal := &model.TicketBalance{}
+	err := pack.NewQuery("etl.ticket_balance.find", idx.balanceTable).
+		AndEqual("ticket_id", tid).
+		AndEqual("account_id", aid).
+		Execute(ctx, bal)
+	if err != nil {
+		return nil, err
+	}
+	if bal.RowId == 0 {
+		bal.TicketId = tid
+		bal.AccountId = aid
+	}
+	st.balances[key] = bal
+	return bal, nil
+}
+
+// updateBalance applies a signed amount and tracks the number of holders.
+func updateBalance(tt *model.TicketType, bal *model.TicketBalance, amount int64) {
+	wasZero := bal.Balance == 0
+	bal.Balance += amount
+	switch {
+	case wasZero && bal.Balance != 0:
+		tt.NHolders++
+	case !wasZero && bal.Balance == 0:
+		tt.NHolders--
+	}
+	bal.IsDirty = true
+	tt.IsDirty = true
+}
+
+// bookTransfer applies the net amount an operation moved for a ticket type.
+// A positive net change mints, a negative net change burns tickets. Use step
+// -1 to reverse a transfer on rollback.
+func bookTransfer(tt *model.TicketType, net int64, step int64) {
+	if net > 0 {
+		tt.TotalMint += step * net
+	} else {
+		tt.TotalBurn -= step * net
+	}
+	tt.TotalSupply += step * net
+	tt.NTransfers += int(step)
+	tt.IsDirty = true
+}
+
+// ticketAmounts converts the balance changes of a ticket update to int64 and
+// returns false when any amount does not fit.
+func ticketAmounts(tu rpc.TicketUpdate) ([]int64, bool) {
+	amounts := make([]int64, len(tu.Updates))
+	for i, u := range tu.Updates {
+		b := u.Amount.Big()
+		if !b.IsInt64() {
+			return nil, false
+		}
+		amounts[i] = b.Int64()
+	}
+	return amounts, true
+}
+
+// assumes op ids are already set (must run after OpIndex)
+//
+// Holders that are not accounts (i.e. smart rollups) have no balance row,
+// but their updates are stored with the holder address so that deposits
+// and withdrawals are transfers rather than burns and mints.
+func (idx *TicketIndex) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	st := newTicketState()
+	ins := make([]pack.Item, 0)
+	for _, op := range block.Ops {
+		// don't process failed or unrelated ops
+		if !op.IsSuccess || len(op.TicketUpdates) == 0 {
+			continue
+		}
+
+		// net change per ticket type, an op may update the same ticket twice
+		nets := make(map[model.TicketTypeID]int64)
+		order := make([]*model.TicketType, 0, len(op.TicketUpdates))
+		for _, tu := range op.TicketUpdates {
+			amounts, ok := ticketAmounts(tu)
+			if !ok {
+				log.Warnf("ticket: skipping %s update with amount out of range in %s op [%d:%d]",
+					tu.Ticket.Hash(), op.Type, op.Type.ListId(), op.OpP)
+				continue
+			}
+			tt, err := idx.loadType(ctx, st, tu.Ticket)
+			switch err {
+			case nil:
+			case ErrNoTicketEntry:
+				ticketer, ok := builder.AccountByAddress(tu.Ticket.Ticketer)
+				if !ok {
+					return fmt.Errorf("ticket: missing ticketer %s in %s op [%d:%d]",
+						tu.Ticket.Ticketer, op.Type, op.Type.ListId(), op.OpP)
+				}
+				// insert immediately to allocate an id
+				tt = model.NewTicketType(tu.Ticket, ticketer.RowId, block.Height)
+				if err := idx.typeTable.Insert(ctx, tt); err != nil {
+					return fmt.Errorf("ticket: insert type: %w", err)
+				}
+				st.types[tt.GetHash().String()] = tt
+				st.typeIds[tt.RowId] = tt
+			default:
+				return fmt.Errorf("ticket: loading type: %w", err)
+			}
+			if _, ok := nets[tt.RowId]; !ok {
+				order = append(order, tt)
+			}
+
+			for i, u := range tu.Updates {
+				amount := amounts[i]
+				nets[tt.RowId] += amount
+				upd := &model.TicketUpdate{
+					TicketId: tt.RowId,
+					Amount:   amount,
+					Height:   block.Height,
+					Time:     block.Timestamp,
+					OpId:     op.RowId,
+				}
+				ins = append(ins, upd)
+
+				acc, ok := builder.AccountByAddress(u.Address())
+				if !ok {
+					upd.Holder = u.Account
+					continue
+				}
+				upd.AccountId = acc.RowId
+				bal, err := idx.loadBalance(ctx, st, tt.RowId, acc.RowId)
+				if err != nil {
+					return fmt.Errorf("ticket: loading balance: %w", err)
+				}
+				updateBalance(tt, bal, amount)
+				if bal.FirstBlock == 0 {
+					bal.FirstBlock = block.Height
+				}
+				bal.LastBlock = block.Height
+				bal.NTransfers++
+			}
+		}
+		for _, tt := range order {
+			bookTransfer(tt, nets[tt.RowId], 1)
+			tt.LastBlock = block.Height
+		}
+	}
+
+	if err := idx.flushState(ctx, st); err != nil {
+		return err
+	}
+
+	// insert, will generate unique row ids
+	if len(ins) > 0 {
+		if err := idx.updateTable.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("ticket: insert updates: %w", err)
+		}
+	}
+	return nil
+}
+
+// BackfillBlock replays ticket updates of a stored block. Ticket updates are
+// only part of RPC receipts, so the block is fetched when it contains a
+// successful rollup op or touches a contract that uses tickets.
+func (idx *TicketIndex) BackfillBlock(ctx context.Context, block *model.Block, builder model.BackfillBuilder) error {
+	for _, op := range block.Ops {
+		if !op.IsSuccess || !(op.IsRollup || usesTickets(builder, op.SenderId) || usesTickets(builder, op.ReceiverId)) {
+			continue
+		}
+		if err := builder.LoadReceipts(ctx, block); err != nil {
+			return fmt.Errorf("ticket: loading receipts: %w", err)
+		}
+		break
+	}
+	return idx.ConnectBlock(ctx, block, builder)
+}
+
+func usesTickets(builder model.BlockBuilder, id model.AccountID) bool {
+	if id == 0 {
+		return false
+	}
+	con, ok := builder.ContractById(id)
+	return ok && con.Features.Contains(micheline.FeatureTicket)
+}
+
+func (idx *TicketIndex) DisconnectBlock(ctx context.Context, block *model.Block, _ model.BlockBuilder) error {
+	// reverse balances and statistics from stored updates
+	updates := make([]*model.TicketUpdate, 0)
+	err := pack.NewQuery("etl.ticket_update.disconnect", idx.updateTable).
+		WithDesc().
+		AndEqual("height", block.Height).
+		Execute(ctx, &updates)
+	if err != nil {
+		return err
+	}
+
+	st := newTicketState()
+	type opTicket struct {
+		op model.OpID
+		id model.TicketTypeID
+	}
+	nets := make(map[opTicket]int64)
+	for _, v := range updates {
+		tt, err := idx.loadTypeId(ctx, st, v.TicketId)
+		if err != nil {
+			return fmt.Errorf("ticket: loading type %d: %w", v.TicketId, err)
+		}
+		nets[opTicket{v.OpId, v.TicketId}] += v.Amount
+		if v.AccountId == 0 {
+			continue
+		}
+		bal, err := idx.loadBalance(ctx, st, v.TicketId, v.AccountId)
+		if err != nil {
+			return fmt.Errorf("ticket: loading balance: %w", err)
+		}
+		updateBalance(tt, bal, -v.Amount)
+		bal.NTransfers--
+	}
+	for k, net := range nets {
+		bookTransfer(st.typeIds[k.id], net, -1)
+	}
+
+	// restore last activity from remaining updates
+	for _, tt := range st.typeIds {
+		last, err := idx.lastUpdateHeight(ctx, tt.RowId, 0, block.Height)
+		if err != nil {
+			return err
+		}
+		tt.LastBlock = util.Max64(last, tt.FirstBlock)
+	}
+	for _, bal := range st.balances {
+		last, err := idx.lastUpdateHeight(ctx, bal.TicketId, bal.AccountId, block.Height)
+		if err != nil {
+			return err
+		}
+		bal.LastBlock = util.Max64(last, bal.FirstBlock)
+	}
+
+	if err := idx.flushState(ctx, st); err != nil {
+		return err
+	}
+
+	return idx.DeleteBlock(ctx, block.Height)
+}
+
+// lastUpdateHeight returns the height of the most recent update of a ticket
+// type or holder balance below height or zero when none exists.
+func (idx *TicketIndex) lastUpdateHeight(ctx context.Context, tid model.TicketTypeID, aid model.AccountID, height int64) (int64, error) {
+	q := pack.NewQuery("etl.ticket_update.last", idx.updateTable).
+		WithDesc().
+		WithLimit(1).
+		AndEqual("ticket_id", tid).
+		AndLt("height", height)
+	if aid > 0 {
+		q = q.AndEqual("account_id", aid)
+	}
+	upd := &model.TicketUpdate{}
+	if err := q.Execute(ctx, upd); err != nil {
+		return 0, fmt.Errorf("ticket: loading last update: %w", err)
+	}
+	return upd.Height, nil
+}
+
+func (idx *TicketIndex) flushState(ctx context.Context, st *ticketState) error {
+	upd := make([]pack.Item, 0)
+	for _, v := range st.typeIds {
+		if v.IsDirty {
+			upd = append(upd, v)
+			v.IsDirty = false
+		}
+	}
+	if len(upd) > 0 {
+		if err := idx.typeTable.Update(ctx, upd); err != nil {
+			return fmt.Errorf("ticket: update types: %w", err)
+		}
+	}
+
+	ins := make([]pack.Item, 0)
+	upd = upd[:0]
+	for _, v := range st.balances {
+		if !v.IsDirty {
+			continue
+		}
+		v.IsDirty = false
+		if v.RowId == 0 {
+			ins = append(ins, v)
+		} else {
+			upd = append(upd, v)
+		}
+	}
+	if len(ins) > 0 {
+		if err := idx.balanceTable.Insert(ctx, ins); err != nil {
+			return fmt.Errorf("ticket: insert balances: %w", err)
+		}
+	}
+	if len(upd) > 0 {
+		if err := idx.balanceTable.Update(ctx, upd); err != nil {
+			return fmt.Errorf("ticket: update balances: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *TicketIndex) DeleteBlock(ctx context.Context, height int64) error {
+	// log.Debugf("Rollback deleting tickets at height %d", height)
+	_, err := pack.NewQuery("etl.ticket_update.delete", idx.updateTable).
+		AndEqual("height", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pack.NewQuery("etl.ticket_balance.delete", idx.balanceTable).
+		AndEqual("first_block", height).
+		Delete(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pack.NewQuery("etl.ticket_type.delete", idx.typeTable).
+		AndEqual("first_block", height).
+		Delete(ctx)
+	return err
+}
+
+func (idx *TicketIndex) DeleteCycle(ctx context.Context, cycle int64) error {
+	return nil
+}
+
+func (idx *TicketIndex) Flush(ctx context.Context) error {
+	for _, v := range idx.Tables() {
+		if err := v.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"math/big"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+func TestBookTransfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		nets   []int64
+		supply int64
+		mint   int64
+		burn   int64
+	}{
+		{"mint", []int64{10}, 10, 10, 0},
+		{"transfer", []int64{10, 0}, 10, 10, 0},
+		{"burn", []int64{10, -4}, 6, 10, 4},
+		{"rollup deposit is a transfer", []int64{10, 0, 0}, 10, 10, 0},
+		{"burn all", []int64{5, 7, -12}, 0, 12, 12},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := &model.TicketType{}
+			for _, net := range tc.nets {
+				bookTransfer(tt, net, 1)
+			}
+			if tt.TotalSupply != tc.supply || tt.TotalMint != tc.mint || tt.TotalBurn != tc.burn {
+				t.Errorf("got supply=%d mint=%d burn=%d, want %d %d %d",
+					tt.TotalSupply, tt.TotalMint, tt.TotalBurn, tc.supply, tc.mint, tc.burn)
+			}
+			if tt.NTransfers != len(tc.nets) {
+				t.Errorf("got %d transfers, want %d", tt.NTransfers, len(tc.nets))
+			}
+
+			// rollback in reverse order restores the initial state
+			for i := len(tc.nets) - 1; i >= 0; i-- {
+				bookTransfer(tt, tc.nets[i], -1)
+			}
+			if tt.TotalSupply != 0 || tt.TotalMint != 0 || tt.TotalBurn != 0 || tt.NTransfers != 0 {
+				t.Errorf("rollback left supply=%d mint=%d burn=%d transfers=%d",
+					tt.TotalSupply, tt.TotalMint, tt.TotalBurn, tt.NTransfers)
+			}
+		})
+	}
+}
+
+func TestUpdateBalanceHolders(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []int64
+		holders int
+		balance int64
+	}{
+		{"receive", []int64{5}, 1, 5},
+		{"spend all", []int64{5, -5}, 0, 0},
+		{"spend some", []int64{5, -2}, 1, 3},
+		{"receive again", []int64{5, -5, 1}, 1, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := &model.TicketType{}
+			bal := &model.TicketBalance{}
+			for _, v := range tc.amounts {
+				updateBalance(tt, bal, v)
+			}
+			if tt.NHolders != tc.holders || bal.Balance != tc.balance {
+				t.Errorf("got holders=%d balance=%d, want %d %d", tt.NHolders, bal.Balance, tc.holders, tc.balance)
+			}
+			for i := len(tc.amounts) - 1; i >= 0; i-- {
+				updateBalance(tt, bal, -tc.amounts[i])
+			}
+			if tt.NHolders != 0 || bal.Balance != 0 {
+				t.Errorf("rollback left holders=%d balance=%d", tt.NHolders, bal.Balance)
+			}
+		})
+	}
+}
+
+func TestTicketAmounts(t *testing.T) {
+	huge := new(big.Int).Lsh(big.NewInt(1), 70)
+	tests := []struct {
+		name    string
+		amounts []*big.Int
+		ok      bool
+	}{
+		{"small", []*big.Int{big.NewInt(1), big.NewInt(-1)}, true},
+		{"max int64", []*big.Int{big.NewInt(1<<63 - 1)}, true},
+		{"overflow", []*big.Int{big.NewInt(1), huge}, false},
+		{"underflow", []*big.Int{new(big.Int).Neg(huge)}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tu := rpc.TicketUpdate{}
+			for _, v := range tc.amounts {
+				var z tezos.Z
+				z.Set(v)
+				tu.Updates = append(tu.Updates, rpc.TicketBalanceUpdate{Amount: z})
+			}
+			res, ok := ticketAmounts(tu)
+			if ok != tc.ok {
+				t.Fatalf("got ok=%t, want %t", ok, tc.ok)
+			}
+			for i := range res {
+				if res[i] != tc.amounts[i].Int64() {
+					t.Errorf("amount %d: got %d, want %s", i, res[i], tc.amounts[i])
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ package etl
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -13,6 +15,7 @@ import (
 	"blockwatch.cc/packdb/store"
 	"blockwatch.cc/tzgo/tezos"
 	"blockwatch.cc/tzindex/etl/cache"
+	"blockwatch.cc/tzindex/etl/cdc"
 	"blockwatch.cc/tzindex/etl/model"
 	"blockwatch.cc/tzindex/rpc"
 )
@@ -23,6 +26,10 @@ type IndexerConfig struct {
 	StateDB   store.DB
 	Indexes   []model.BlockIndexer
 	LightMode bool
+	Filter    *model.IndexFilter
+	Retain    int64    // number of cycles to keep, 0 keeps full history
+	Changes   *cdc.Log // optional change log
+	Persist   bool     // keep cache snapshots across restarts
 }
 
 // Indexer defines an index manager that manages and stores multiple indexes.
@@ -44,6 +51,20 @@ type Indexer struct {
 	tips           map[string]*IndexTip
 	tables         map[string]*pack.Table
 	lightMode      bool
+	filter         *model.IndexFilter
+	retain         int64
+	horizon        PruneHorizon
+	pruning        bool           // guarded by mu
+	pruned         int64          // first cycle with undeleted history, guarded by mu
+	distributing   bool           // guarded by mu
+	wg             sync.WaitGroup // background pruning and distributions
+	dmu            sync.Mutex     // guards distribution cache
+	dists          map[int64]*distEntry
+	distOrder      []int64
+	bfmu           sync.Mutex // guards tips of indexes that are backfilled
+	rollbacks      uint64     // number of rolled back blocks, guarded by bfmu
+	changes        *cdc.Log
+	persistCaches  bool
 }
 
 func NewIndexer(cfg IndexerConfig) *Indexer {
@@ -59,6 +80,10 @@ func NewIndexer(cfg IndexerConfig) *Indexer {
 		tips:           make(map[string]*IndexTip),
 		tables:         make(map[string]*pack.Table),
 		lightMode:      cfg.LightMode,
+		filter:         cfg.Filter,
+		retain:         cfg.Retain,
+		changes:        cfg.Changes,
+		persistCaches:  cfg.Persist,
 	}
 }
 
@@ -78,6 +103,10 @@ func (m *Indexer) IsLightMode() bool {
 	return m.lightMode
 }
 
+func (m *Indexer) IsFiltered() bool {
+	return !m.filter.IsEmpty()
+}
+
 func (m *Indexer) Table(key string) (*pack.Table, error) {
 	t, ok := m.tables[key]
 	if !ok {
@@ -95,6 +124,11 @@ func (m *Indexer) Index(key string) (model.BlockIndexer, error) {
 	return nil, ErrNoIndex
 }
 
+// Indexes returns all enabled indexes.
+func (m *Indexer) Indexes() []model.BlockIndexer {
+	return m.indexes
+}
+
 func (m *Indexer) TableStats() []pack.TableStats {
 	stats := make([]pack.TableStats, 0)
 	for _, idx := range m.indexes {
@@ -133,7 +167,11 @@ func (m *Indexer) Init(ctx context.Context, tip *model.ChainTip, mode Mode) erro
 		for _, v := range deps {
 			m.reg.Register(v)
 		}
-		return nil
+
+		// load pruned history horizon
+		m.horizon, err = dbLoadHorizon(dbTx)
+		m.pruned = m.horizon.Cycle
+		return err
 	})
 	if err != nil && !needCreate {
 		return err
@@ -141,7 +179,7 @@ func (m *Indexer) Init(ctx context.Context, tip *model.ChainTip, mode Mode) erro
 
 	// Create the initial state for the indexes as needed.
 	nError := 0
-	nMissing := 0
+	missing := make([]string, 0)
 	if needCreate {
 		err := m.statedb.Update(func(dbTx store.Tx) error {
 			// create buckets for index tips in the respecive databases
@@ -161,29 +199,62 @@ func (m *Indexer) Init(ctx context.Context, tip *model.ChainTip, mode Mode) erro
 			if err != nil {
 				return err
 			}
+			// record index filter on fresh databases
+			if tip.BestHeight <= 0 {
+				return dbStoreIndexFilter(dbTx, m.filter)
+			}
 			return nil
 		})
 		if err != nil {
 			return err
 		}
-	} else {
-		// check all indexes are at same height as chain tip
-		for n, v := range m.tips {
-			if tip.BestHeight > 0 && v.Height != tip.BestHeight {
-				log.Errorf("%s index with unexpected height %d/%d", n, v.Height, tip.BestHeight)
-				nError++
-				if v.Height == 0 {
-					nMissing++
-				}
+	}
+
+	// check all indexes are at same height as chain tip, lagging indexes
+	// are backfilled when supported
+	for n, v := range m.tips {
+		if tip.BestHeight <= 0 || v.Height == tip.BestHeight {
+			continue
+		}
+		if v.Height < tip.BestHeight && m.canBackfill(n) {
+			if !v.Backfill {
+				log.Infof("Backfilling %s index from height %d.", n, v.Height)
+				v.Backfill = true
+			}
+			continue
+		}
+		log.Errorf("%s index with unexpected height %d/%d", n, v.Height, tip.BestHeight)
+		nError++
+		if v.Height == 0 {
+			missing = append(missing, n)
+		}
+	}
+
+	// make sure the database is reopened with the same index filter
+	if tip.BestHeight > 0 {
+		var stored string
+		_ = m.statedb.View(func(dbTx store.Tx) error {
+			stored = dbLoadIndexFilter(dbTx)
+			return nil
+		})
+		if have, want := stored, m.filter.String(); have != want {
+			if have == "" {
+				have = "none"
+			}
+			if want == "" {
+				want = "none"
 			}
+			return fmt.Errorf("Index filter mismatch! Database was built with filter %s, but configured filter is %s.", have, want)
 		}
 	}
 
 	switch true {
-	case nMissing > 0 && !m.lightMode:
-		return fmt.Errorf("Missing database files! Looks like you used --light mode before or you deleted a database file.")
-	case nMissing > 0 && m.lightMode:
-		return fmt.Errorf("Missing database files! Looks like you deleted a database file.")
+	case len(missing) > 0 && !m.lightMode:
+		sort.Strings(missing)
+		return fmt.Errorf("Missing database files for %s! These indexes cannot be backfilled. Switching from --light to --full mode is not supported on an existing database, please resync or keep using --light mode.", strings.Join(missing, ", "))
+	case len(missing) > 0 && m.lightMode:
+		sort.Strings(missing)
+		return fmt.Errorf("Missing database files for %s! Looks like you deleted a database file.", strings.Join(missing, ", "))
 	case nError > 0 && mode != MODE_ROLLBACK:
 		return fmt.Errorf("Corrupted database! Looks like you need to rebuild your database.")
 	}
@@ -202,6 +273,9 @@ func (m *Indexer) Init(ctx context.Context, tip *model.ChainTip, mode Mode) erro
 			m.tables[t.Name()] = t
 		}
 	}
+
+	// warm caches from snapshots
+	m.loadCaches(tip)
 	return nil
 }
 
@@ -274,6 +348,7 @@ func (m *Indexer) GC(ctx context.Context, ratio float64) error {
 }
 
 func (m *Indexer) Close() error {
+	m.wg.Wait()
 	m.tables = nil
 	for _, idx := range m.indexes {
 		log.Infof("Closing %s.", idx.Name())
@@ -301,11 +376,18 @@ func (m *Indexer) ConnectProtocol(ctx context.Context, params *tezos.Params) err
 	if err != nil {
 		return err
 	}
-	return m.reg.Register(params)
+	if err := m.reg.Register(params); err != nil {
+		return err
+	}
+	return m.captureProtocol(params)
 }
 
 func (m *Indexer) ConnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder) error {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+
 	// insert block into all indexes
+	var connected bool
 	for _, t := range m.indexes {
 		key := t.Key()
 		tip, ok := m.tips[string(key)]
@@ -314,6 +396,11 @@ func (m *Indexer) ConnectBlock(ctx context.Context, block *model.Block, builder
 			continue
 		}
 
+		// skip indexes that are still catching up
+		if tip.Backfill {
+			continue
+		}
+
 		// skip when the block is already known
 		if tip.Hash != nil && tip.Hash.Equal(block.Hash) {
 			continue
@@ -322,6 +409,7 @@ func (m *Indexer) ConnectBlock(ctx context.Context, block *model.Block, builder
 		if err := t.ConnectBlock(ctx, block, builder); err != nil {
 			return err
 		}
+		connected = true
 
 		// Update the current tip.
 		cloned := block.Hash.Clone()
@@ -329,6 +417,13 @@ func (m *Indexer) ConnectBlock(ctx context.Context, block *model.Block, builder
 		tip.Height = block.Height
 	}
 
+	// record row changes
+	if connected {
+		if err := m.captureConnect(ctx, block, builder); err != nil {
+			return err
+		}
+	}
+
 	// check context
 	if interruptRequested(ctx) {
 		return nil
@@ -348,6 +443,18 @@ func (m *Indexer) ConnectBlock(ctx context.Context, block *model.Block, builder
 }
 
 func (m *Indexer) DisconnectBlock(ctx context.Context, block *model.Block, builder model.BlockBuilder, ignoreErrors bool) error {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+
+	m.rollbacks++
+
+	// collect rows that indexes restore on rollback
+	touched, err := m.captureTouched(ctx, block)
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
+	var disconnected bool
 	for _, t := range m.indexes {
 		key := t.Key()
 		tip, ok := m.tips[string(key)]
@@ -355,6 +462,10 @@ func (m *Indexer) DisconnectBlock(ctx context.Context, block *model.Block, build
 			log.Errorf("missing tip for table %s", string(key))
 			continue
 		}
+		// indexes that are catching up rewind themselves during backfill
+		if tip.Backfill {
+			continue
+		}
 		if block.Height > 0 && !tip.Hash.Equal(block.Hash) {
 			continue
 		}
@@ -362,6 +473,7 @@ func (m *Indexer) DisconnectBlock(ctx context.Context, block *model.Block, build
 		if err := t.DisconnectBlock(ctx, block, builder); err != nil && !ignoreErrors {
 			return err
 		}
+		disconnected = true
 
 		// Update the current tip.
 		cloned := block.TZ.ParentHash().Clone()
@@ -369,6 +481,14 @@ func (m *Indexer) DisconnectBlock(ctx context.Context, block *model.Block, build
 		tip.Height = block.Height - 1
 	}
 
+	// record row changes
+	if disconnected {
+		if err := m.captureDisconnect(ctx, block, builder, touched); err != nil && !ignoreErrors {
+			return err
+		}
+	}
+	m.dropDistributions(block.Height)
+
 	// we don't roll-back caches here because cached data will be overwritten by
 	// roll-forward
 
@@ -376,6 +496,9 @@ func (m *Indexer) DisconnectBlock(ctx context.Context, block *model.Block, build
 }
 
 func (m *Indexer) DeleteBlock(ctx context.Context, tz *rpc.Bundle) error {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+	m.rollbacks++
 	for _, t := range m.indexes {
 		key := t.Key()
 		tip, ok := m.tips[string(key)]
@@ -383,7 +506,7 @@ func (m *Indexer) DeleteBlock(ctx context.Context, tz *rpc.Bundle) error {
 			log.Errorf("missing tip for table %s", string(key))
 			continue
 		}
-		if tz.Height() != tip.Height {
+		if tip.Backfill || tz.Height() != tip.Height {
 			continue
 		}
 		if err := t.DeleteBlock(ctx, tz.Height()); err != nil {
@@ -427,12 +550,16 @@ func (m *Indexer) maybeCreateIndex(ctx context.Context, dbTx store.Tx, idx model
 
 // Store idx tip
 func (m *Indexer) storeTip(key string) error {
+	m.bfmu.Lock()
 	tip, ok := m.tips[key]
 	if !ok {
+		m.bfmu.Unlock()
 		return nil
 	}
+	cp := *tip
+	m.bfmu.Unlock()
 	// log.Debugf("Storing %s idx tip.", key)
 	return m.statedb.Update(func(dbTx store.Tx) error {
-		return dbStoreIndexTip(dbTx, key, tip)
+		return dbStoreIndexTip(dbTx, key, &cp)
 	})
 }
@@ -185,6 +185,7 @@ func (b *Builder) BuildGenesisBlock(ctx context.Context) (*model.Block, error) {
 		acc.IsDelegated = true
 		acc.BakerId = bkr.AccountId
 		acc.DelegatedSince = b.block.Height
+		b.appendDelegationPeriod(acc, bkr.AccountId, acc.Balance())
 		bkr.TotalDelegations++
 		bkr.ActiveDelegations++
 		bkr.DelegatedBalance += acc.Balance()
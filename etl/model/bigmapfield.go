@@ -0,0 +1,236 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// BigmapField is a single scalar leaf of a live bigmap entry's decoded key or
+// value. Paths start with `key` or `value` and omit list and set positions.
+// Numbers are stored as decimal string in Value and, clamped to the int64
+// range, in Int for range comparisons. Entries with too many leaves are
+// represented by a single row with an empty path that matches any search.
+type BigmapField struct {
+	RowId    uint64 `pack:"I,pk,snappy"      json:"row_id"`
+	BigmapId int64  `pack:"B,bloom"          json:"bigmap_id"`
+	KeyId    uint64 `pack:"K,bloom=3,snappy" json:"key_id"` // xxhash(BigmapId, KeyHash)
+	Height   int64  `pack:"h,snappy"         json:"height"` // entry update height
+	Path     string `pack:"p,snappy"         json:"path"`
+	Value    string `pack:"v,snappy"         json:"value"`
+	Int      int64  `pack:"i,snappy"         json:"int"`
+	IsNum    bool   `pack:"n,snappy"         json:"is_num"`
+}
+
+// Ensure BigmapField implements the pack.Item interface.
+var _ pack.Item = (*BigmapField)(nil)
+
+func (f *BigmapField) ID() uint64 {
+	return f.RowId
+}
+
+func (f *BigmapField) SetID(id uint64) {
+	f.RowId = id
+}
+
+// DecodeBigmapEntry renders a live bigmap entry as a tree of the form
+// `{"key": .., "value": ..}` which is used for field paths.
+func DecodeBigmapEntry(kv *BigmapKV, keyType, valueType micheline.Type) (map[string]interface{}, error) {
+	key, val := micheline.Prim{}, micheline.Prim{}
+	if err := key.UnmarshalBinary(kv.Key); err != nil {
+		return nil, err
+	}
+	if err := val.UnmarshalBinary(kv.Value); err != nil {
+		return nil, err
+	}
+	k, err := micheline.NewValuePtr(keyType, key).Map()
+	if err != nil {
+		return nil, err
+	}
+	v, err := micheline.NewValuePtr(valueType, val).Map()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"key": k, "value": v}, nil
+}
+
+// BigmapFieldValue converts a rendered leaf into its string form and returns
+// the numeric value for numbers.
+func BigmapFieldValue(v interface{}) (string, *big.Int) {
+	switch t := v.(type) {
+	case tezos.Z:
+		return t.String(), t.Big()
+	case *big.Int:
+		return t.String(), t
+	case int64:
+		return strconv.FormatInt(t, 10), big.NewInt(t)
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case time.Time:
+		return t.UTC().Format(time.RFC3339), nil
+	case fmt.Stringer:
+		return t.String(), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// BigmapFieldsAt returns all leaves found at path below val. Lists and sets
+// are expanded at any level so that paths never contain element positions.
+func BigmapFieldsAt(val interface{}, path string) []interface{} {
+	var frags []string
+	if path != "" {
+		frags = strings.Split(path, ".")
+	}
+	return appendBigmapFields(nil, val, frags)
+}
+
+func appendBigmapFields(res []interface{}, val interface{}, frags []string) []interface{} {
+	switch t := val.(type) {
+	case nil:
+		return res
+	case []interface{}:
+		for _, v := range t {
+			res = appendBigmapFields(res, v, frags)
+		}
+		return res
+	case map[string]interface{}:
+		if len(frags) == 0 {
+			return res
+		}
+		if v, ok := t[frags[0]]; ok {
+			res = appendBigmapFields(res, v, frags[1:])
+		}
+		return res
+	default:
+		if len(frags) > 0 {
+			return res
+		}
+		return append(res, val)
+	}
+}
+
+// BigmapFilter selects live bigmap entries by a decoded key or value field.
+// Comparison modes compare numbers numerically when the filter value is a
+// number and strings lexically otherwise. Regexp mode matches string
+// prefixes. A filter matches when any leaf at Path matches.
+type BigmapFilter struct {
+	Path   string          // starts with `key` or `value`
+	Mode   pack.FilterMode // eq, ne, gt, gte, lt, lte, in, nin, regexp (prefix)
+	Value  string
+	Values []string // in, nin
+	Num    *big.Int // numeric filter value, nil for strings
+}
+
+func NewBigmapFilter(path string, mode pack.FilterMode, value string) BigmapFilter {
+	f := BigmapFilter{
+		Path:  path,
+		Mode:  mode,
+		Value: value,
+	}
+	switch mode {
+	case pack.FilterModeIn, pack.FilterModeNotIn:
+		f.Values = strings.Split(value, ",")
+	case pack.FilterModeGt, pack.FilterModeGte, pack.FilterModeLt, pack.FilterModeLte:
+		if n, ok := new(big.Int).SetString(value, 10); ok {
+			f.Num = n
+		}
+	}
+	return f
+}
+
+// Prefix returns the anchored regular expression used for prefix matches.
+func (f BigmapFilter) Prefix() string {
+	return "^" + regexp.QuoteMeta(f.Value)
+}
+
+// Match reports whether the decoded entry val contains a matching leaf.
+func (f BigmapFilter) Match(val interface{}) bool {
+	for _, v := range BigmapFieldsAt(val, f.Path) {
+		if f.matchField(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f BigmapFilter) matchField(v interface{}) bool {
+	s, n := BigmapFieldValue(v)
+	switch f.Mode {
+	case pack.FilterModeEqual:
+		return s == f.Value
+	case pack.FilterModeNotEqual:
+		return s != f.Value
+	case pack.FilterModeIn, pack.FilterModeNotIn:
+		var found bool
+		for _, x := range f.Values {
+			if s == x {
+				found = true
+				break
+			}
+		}
+		return found == (f.Mode == pack.FilterModeIn)
+	case pack.FilterModeRegexp:
+		return strings.HasPrefix(s, f.Value)
+	}
+
+	// compare numbers to numbers and strings to strings only
+	var c int
+	switch {
+	case f.Num != nil && n != nil:
+		c = n.Cmp(f.Num)
+	case f.Num == nil && n == nil:
+		c = strings.Compare(s, f.Value)
+	default:
+		return false
+	}
+	switch f.Mode {
+	case pack.FilterModeGt:
+		return c > 0
+	case pack.FilterModeGte:
+		return c >= 0
+	case pack.FilterModeLt:
+		return c < 0
+	case pack.FilterModeLte:
+		return c <= 0
+	default:
+		return false
+	}
+}
+
+// CompareBigmapFields orders two leaves returned from BigmapFieldsAt. Numbers
+// sort before strings, missing leaves (nil) sort after everything else.
+func CompareBigmapFields(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	as, an := BigmapFieldValue(a)
+	bs, bn := BigmapFieldValue(b)
+	switch {
+	case an != nil && bn != nil:
+		return an.Cmp(bn)
+	case an != nil:
+		return -1
+	case bn != nil:
+		return 1
+	default:
+		return strings.Compare(as, bs)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"math/big"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+)
+
+func TestBigmapFilterMatch(t *testing.T) {
+	val := map[string]interface{}{
+		"key": map[string]interface{}{
+			"owner":    "tz1abc",
+			"token_id": big.NewInt(7),
+		},
+		"value": map[string]interface{}{
+			"amount": big.NewInt(150),
+			"tags":   []interface{}{"red", "blue"},
+			"name":   "bob",
+		},
+	}
+	tests := []struct {
+		path  string
+		mode  pack.FilterMode
+		value string
+		want  bool
+	}{
+		{"key.owner", pack.FilterModeEqual, "tz1abc", true},
+		{"key.owner", pack.FilterModeNotEqual, "tz1abc", false},
+		{"key.owner", pack.FilterModeRegexp, "tz1", true},
+		{"key.owner", pack.FilterModeRegexp, "KT1", false},
+		{"key.token_id", pack.FilterModeIn, "1,7", true},
+		{"key.token_id", pack.FilterModeNotIn, "1,7", false},
+		{"value.amount", pack.FilterModeGte, "150", true},
+		{"value.amount", pack.FilterModeGt, "150", false},
+		{"value.amount", pack.FilterModeLt, "1000", true},
+		{"value.amount", pack.FilterModeLt, "abc", false}, // number vs string
+		{"value.name", pack.FilterModeGt, "alice", true},
+		{"value.tags", pack.FilterModeEqual, "blue", true}, // any list element
+		{"value.missing", pack.FilterModeNotEqual, "x", false},
+		{"value", pack.FilterModeEqual, "bob", false}, // not a leaf
+	}
+	for _, tc := range tests {
+		f := NewBigmapFilter(tc.path, tc.mode, tc.value)
+		if got := f.Match(val); got != tc.want {
+			t.Errorf("%s.%s=%s: got %t, want %t", tc.path, tc.mode, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestCompareBigmapFields(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		want int
+	}{
+		{"numbers", big.NewInt(2), big.NewInt(10), -1},
+		{"equal numbers", int64(5), big.NewInt(5), 0},
+		{"strings", "b", "a", 1},
+		{"number before string", big.NewInt(100), "1", -1},
+		{"nil last", nil, "a", 1},
+		{"both nil", nil, nil, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CompareBigmapFields(tc.a, tc.b); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
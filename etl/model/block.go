@@ -70,20 +70,21 @@ type Block struct {
 	LbEscapeEma      int64                  `pack:"M"                json:"lb_esc_ema"`
 
 	// other tz or extracted/translated data for processing
-	TZ              *rpc.Bundle   `pack:"-" json:"-"`
-	Params          *tezos.Params `pack:"-" json:"-"`
-	Chain           *Chain        `pack:"-" json:"-"`
-	Supply          *Supply       `pack:"-" json:"-"`
-	Ops             []*Op         `pack:"-" json:"-"`
-	Flows           []*Flow       `pack:"-" json:"-"`
-	Baker           *Baker        `pack:"-" json:"-"`
-	Proposer        *Baker        `pack:"-" json:"-"`
-	Parent          *Block        `pack:"-" json:"-"`
-	HasProposals    bool          `pack:"-" json:"-"`
-	HasBallots      bool          `pack:"-" json:"-"`
-	HasSeeds        bool          `pack:"-" json:"-"`
-	AbsentBaker     AccountID     `pack:"-" json:"-"`
-	AbsentEndorsers []AccountID   `pack:"-" json:"-"`
+	TZ              *rpc.Bundle         `pack:"-" json:"-"`
+	Params          *tezos.Params       `pack:"-" json:"-"`
+	Chain           *Chain              `pack:"-" json:"-"`
+	Supply          *Supply             `pack:"-" json:"-"`
+	Ops             []*Op               `pack:"-" json:"-"`
+	Flows           []*Flow             `pack:"-" json:"-"`
+	Delegations     []*DelegationPeriod `pack:"-" json:"-"`
+	Baker           *Baker              `pack:"-" json:"-"`
+	Proposer        *Baker              `pack:"-" json:"-"`
+	Parent          *Block              `pack:"-" json:"-"`
+	HasProposals    bool                `pack:"-" json:"-"`
+	HasBallots      bool                `pack:"-" json:"-"`
+	HasSeeds        bool                `pack:"-" json:"-"`
+	AbsentBaker     AccountID           `pack:"-" json:"-"`
+	AbsentEndorsers []AccountID         `pack:"-" json:"-"`
 }
 
 // Ensure Block implements the pack.Item interface.
@@ -177,6 +178,7 @@ func (b Block) Clone() *Block {
 	clone.Supply = nil
 	clone.Ops = nil
 	clone.Flows = nil
+	clone.Delegations = nil
 	clone.Baker = nil
 	clone.Proposer = nil
 	clone.Parent = nil
@@ -291,6 +293,7 @@ func (b *Block) Clean() {
 		}
 		b.Flows = b.Flows[:0]
 	}
+	b.Delegations = b.Delegations[:0]
 	if b.TZ != nil {
 		b.TZ.Baking = b.TZ.Baking[:0]
 		b.TZ.Endorsing = b.TZ.Endorsing[:0]
@@ -357,6 +360,7 @@ func (b *Block) Reset() {
 		}
 		b.Flows = b.Flows[:0]
 	}
+	b.Delegations = b.Delegations[:0]
 	b.HasProposals = false
 	b.HasBallots = false
 	b.HasSeeds = false
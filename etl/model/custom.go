@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Number of typed value slots available to custom index column mappings.
+const (
+	CustomIntSlots    = 8
+	CustomStringSlots = 8
+	CustomBytesSlots  = 4
+	CustomBoolSlots   = 4
+)
+
+type CustomRowID uint64
+
+func (id CustomRowID) Value() uint64 {
+	return uint64(id)
+}
+
+// CustomRow is a single row in a user-defined contract index. Rows are
+// produced from bigmap updates or contract calls. Decoded Micheline values
+// are stored in generic typed slots whose public column names are defined
+// by the index configuration.
+type CustomRow struct {
+	RowId     CustomRowID    `pack:"I,pk,snappy"   json:"row_id"`
+	Height    int64          `pack:"h,snappy"      json:"height"`
+	Time      time.Time      `pack:"T,snappy"      json:"time"`
+	OpId      OpID           `pack:"o,snappy"      json:"op_id"`
+	AccountId AccountID      `pack:"A,snappy"      json:"account_id"`
+	SenderId  AccountID      `pack:"S,snappy"      json:"sender_id"`
+	BigmapId  int64          `pack:"B,snappy"      json:"bigmap_id"`
+	Action    string         `pack:"a,snappy"      json:"action"`
+	KeyHash   tezos.ExprHash `pack:"H,snappy"      json:"key_hash"`
+	Int0      int64          `pack:"i0,snappy"     json:"int_0"`
+	Int1      int64          `pack:"i1,snappy"     json:"int_1"`
+	Int2      int64          `pack:"i2,snappy"     json:"int_2"`
+	Int3      int64          `pack:"i3,snappy"     json:"int_3"`
+	Int4      int64          `pack:"i4,snappy"     json:"int_4"`
+	Int5      int64          `pack:"i5,snappy"     json:"int_5"`
+	Int6      int64          `pack:"i6,snappy"     json:"int_6"`
+	Int7      int64          `pack:"i7,snappy"     json:"int_7"`
+	String0   string         `pack:"s0,snappy"     json:"string_0"`
+	String1   string         `pack:"s1,snappy"     json:"string_1"`
+	String2   string         `pack:"s2,snappy"     json:"string_2"`
+	String3   string         `pack:"s3,snappy"     json:"string_3"`
+	String4   string         `pack:"s4,snappy"     json:"string_4"`
+	String5   string         `pack:"s5,snappy"     json:"string_5"`
+	String6   string         `pack:"s6,snappy"     json:"string_6"`
+	String7   string         `pack:"s7,snappy"     json:"string_7"`
+	Bytes0    []byte         `pack:"b0,snappy"     json:"bytes_0"`
+	Bytes1    []byte         `pack:"b1,snappy"     json:"bytes_1"`
+	Bytes2    []byte         `pack:"b2,snappy"     json:"bytes_2"`
+	Bytes3    []byte         `pack:"b3,snappy"     json:"bytes_3"`
+	Bool0     bool           `pack:"f0,snappy"     json:"bool_0"`
+	Bool1     bool           `pack:"f1,snappy"     json:"bool_1"`
+	Bool2     bool           `pack:"f2,snappy"     json:"bool_2"`
+	Bool3     bool           `pack:"f3,snappy"     json:"bool_3"`
+}
+
+// Ensure CustomRow implements the pack.Item interface.
+var _ pack.Item = (*CustomRow)(nil)
+
+func (r *CustomRow) ID() uint64 {
+	return uint64(r.RowId)
+}
+
+func (r *CustomRow) SetID(id uint64) {
+	r.RowId = CustomRowID(id)
+}
+
+// Slot returns a pointer to the typed slot n of kind typ (one of int, string,
+// bytes, bool) or nil when the slot does not exist.
+func (r *CustomRow) Slot(typ string, n int) interface{} {
+	switch typ {
+	case "int":
+		if s := []*int64{&r.Int0, &r.Int1, &r.Int2, &r.Int3, &r.Int4, &r.Int5, &r.Int6, &r.Int7}; n < len(s) {
+			return s[n]
+		}
+	case "string":
+		if s := []*string{&r.String0, &r.String1, &r.String2, &r.String3, &r.String4, &r.String5, &r.String6, &r.String7}; n < len(s) {
+			return s[n]
+		}
+	case "bytes":
+		if s := []*[]byte{&r.Bytes0, &r.Bytes1, &r.Bytes2, &r.Bytes3}; n < len(s) {
+			return s[n]
+		}
+	case "bool":
+		if s := []*bool{&r.Bool0, &r.Bool1, &r.Bool2, &r.Bool3}; n < len(s) {
+			return s[n]
+		}
+	}
+	return nil
+}
+
+// CustomSlotName returns the storage column name of typed slot n.
+func CustomSlotName(typ string, n int) string {
+	switch typ {
+	case "int":
+		return "int_" + string(rune('0'+n))
+	case "string":
+		return "string_" + string(rune('0'+n))
+	case "bytes":
+		return "bytes_" + string(rune('0'+n))
+	case "bool":
+		return "bool_" + string(rune('0'+n))
+	}
+	return ""
+}
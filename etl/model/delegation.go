@@ -0,0 +1,36 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"blockwatch.cc/packdb/pack"
+)
+
+// DelegationPeriod is a continuous time range during which an account was
+// delegated to the same baker. Open periods have a zero end height.
+type DelegationPeriod struct {
+	RowId        uint64    `pack:"I,pk"     json:"row_id"`
+	AccountId    AccountID `pack:"A,bloom"  json:"account_id"`    // delegator
+	BakerId      AccountID `pack:"B,bloom"  json:"baker_id"`      // delegate
+	StartHeight  int64     `pack:"s"        json:"start_height"`  // first block of delegation
+	EndHeight    int64     `pack:"e"        json:"end_height"`    // block where delegation ended or 0
+	StartBalance int64     `pack:"b"        json:"start_balance"` // delegator balance at start
+	EndBalance   int64     `pack:"E"        json:"end_balance"`   // delegator balance at end
+}
+
+// Ensure DelegationPeriod implements the pack.Item interface.
+var _ pack.Item = (*DelegationPeriod)(nil)
+
+func (d *DelegationPeriod) ID() uint64 {
+	return d.RowId
+}
+
+func (d *DelegationPeriod) SetID(id uint64) {
+	d.RowId = id
+}
+
+// IsActive returns true when the delegation was active at the end of block height.
+func (d DelegationPeriod) IsActive(height int64) bool {
+	return d.StartHeight <= height && (d.EndHeight == 0 || d.EndHeight > height)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+)
+
+// DistributionTopN is the number of top holders kept per snapshot.
+const DistributionTopN = 1000
+
+// Distribution is a snapshot of holder balances at the end of a block,
+// usually the last block of a cycle. Balances are total account balances
+// (spendable plus frozen bond) as recorded in balance history.
+type Distribution struct {
+	RowId     uint64    `pack:"I,pk"     json:"row_id"`
+	Height    int64     `pack:"h"        json:"height"`
+	Cycle     int64     `pack:"c"        json:"cycle"`
+	Timestamp time.Time `pack:"T"        json:"time"`
+	NHolders  int64     `pack:"n"        json:"n_holders"` // funded accounts
+	Total     int64     `pack:"t"        json:"total"`     // sum of holder balances
+	Gini      float64   `pack:"g"        json:"gini"`      // Gini coefficient of holder balances
+	NDust     int64     `pack:"0"        json:"n_dust"`    // holders below 1 tez
+	N1k       int64     `pack:"1"        json:"n_1k"`      // holders below 1k tez
+	N10k      int64     `pack:"2"        json:"n_10k"`     // holders below 10k tez
+	N100k     int64     `pack:"3"        json:"n_100k"`    // holders below 100k tez
+	N1M       int64     `pack:"4"        json:"n_1m"`      // holders below 1M tez
+	NWhale    int64     `pack:"5"        json:"n_whale"`   // holders with 1M tez or more
+	Data      []byte    `pack:"D,snappy" json:"-"`         // JSON encoded top holders
+
+	// decoded data
+	Top []HolderBalance `pack:"-" json:"top,omitempty"`
+}
+
+// HolderBalance is a single entry in a distribution top list.
+type HolderBalance struct {
+	AccountId AccountID `json:"id"`
+	Balance   int64     `json:"balance"`
+}
+
+// Ensure Distribution implements the pack.Item interface.
+var _ pack.Item = (*Distribution)(nil)
+
+func (d *Distribution) ID() uint64 {
+	return d.RowId
+}
+
+func (d *Distribution) SetID(id uint64) {
+	d.RowId = id
+}
+
+func (d Distribution) Time() time.Time {
+	return d.Timestamp
+}
+
+func (d *Distribution) Encode() (err error) {
+	d.Data, err = json.Marshal(d.Top)
+	return
+}
+
+func (d *Distribution) Decode() error {
+	d.Top = d.Top[:0]
+	if len(d.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(d.Data, &d.Top)
+}
+
+// NewDistribution computes holder statistics from a list of positive balances
+// in atomic units. unit is the number of atomic units per tez. The list is
+// sorted in place.
+func NewDistribution(holders []HolderBalance, unit int64) *Distribution {
+	d := &Distribution{
+		NHolders: int64(len(holders)),
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Balance == holders[j].Balance {
+			return holders[i].AccountId < holders[j].AccountId
+		}
+		return holders[i].Balance > holders[j].Balance
+	})
+
+	// Gini from descending order, rank n-i is the ascending position
+	var weighted float64
+	n := len(holders)
+	for i, v := range holders {
+		d.Total += v.Balance
+		weighted += float64(n-i) * float64(v.Balance)
+		switch {
+		case v.Balance < unit:
+			d.NDust++
+		case v.Balance < 1_000*unit:
+			d.N1k++
+		case v.Balance < 10_000*unit:
+			d.N10k++
+		case v.Balance < 100_000*unit:
+			d.N100k++
+		case v.Balance < 1_000_000*unit:
+			d.N1M++
+		default:
+			d.NWhale++
+		}
+	}
+	if n > 0 && d.Total > 0 {
+		g := 2*weighted/(float64(n)*float64(d.Total)) - float64(n+1)/float64(n)
+		d.Gini = math.Round(g*1_000_000) / 1_000_000
+	}
+	if n > DistributionTopN {
+		n = DistributionTopN
+	}
+	d.Top = make([]HolderBalance, n)
+	copy(d.Top, holders)
+	return d
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// IndexFilter is an allow-list of accounts for selective indexing. An account
+// is selected when its address is listed or when it is a smart contract with
+// a listed code or interface hash.
+type IndexFilter struct {
+	Addresses   []tezos.Address `json:"addresses,omitempty"`
+	CodeHashes  []uint64        `json:"code_hashes,omitempty"`
+	IfaceHashes []uint64        `json:"iface_hashes,omitempty"`
+
+	addrs  map[string]struct{}
+	codes  map[uint64]struct{}
+	ifaces map[uint64]struct{}
+}
+
+func NewIndexFilter(addrs []tezos.Address, codes, ifaces []uint64) *IndexFilter {
+	f := &IndexFilter{
+		Addresses:   addrs,
+		CodeHashes:  codes,
+		IfaceHashes: ifaces,
+		addrs:       make(map[string]struct{}),
+		codes:       make(map[uint64]struct{}),
+		ifaces:      make(map[uint64]struct{}),
+	}
+	sort.Slice(f.Addresses, func(i, j int) bool { return f.Addresses[i].String() < f.Addresses[j].String() })
+	sort.Slice(f.CodeHashes, func(i, j int) bool { return f.CodeHashes[i] < f.CodeHashes[j] })
+	sort.Slice(f.IfaceHashes, func(i, j int) bool { return f.IfaceHashes[i] < f.IfaceHashes[j] })
+	for _, v := range addrs {
+		f.addrs[v.String()] = struct{}{}
+	}
+	for _, v := range codes {
+		f.codes[v] = struct{}{}
+	}
+	for _, v := range ifaces {
+		f.ifaces[v] = struct{}{}
+	}
+	return f
+}
+
+// IsEmpty returns true when the filter selects nothing, i.e. filtering is off.
+func (f *IndexFilter) IsEmpty() bool {
+	return f == nil || len(f.Addresses)+len(f.CodeHashes)+len(f.IfaceHashes) == 0
+}
+
+// Match returns true when acc or its contract c (may be nil) is selected.
+func (f *IndexFilter) Match(acc *Account, c *Contract) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	if acc != nil {
+		if _, ok := f.addrs[acc.Address.String()]; ok {
+			return true
+		}
+	}
+	if c != nil {
+		if _, ok := f.codes[c.CodeHash]; ok {
+			return true
+		}
+		if _, ok := f.ifaces[c.InterfaceHash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchContract returns true when contract c is selected by address, code
+// hash or interface hash.
+func (f *IndexFilter) MatchContract(c *Contract) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	if _, ok := f.addrs[c.Address.String()]; ok {
+		return true
+	}
+	return f.Match(nil, c)
+}
+
+// String returns a canonical representation used to compare filters across
+// database restarts.
+func (f *IndexFilter) String() string {
+	if f.IsEmpty() {
+		return ""
+	}
+	type hexFilter struct {
+		Addresses   []tezos.Address `json:"addresses,omitempty"`
+		CodeHashes  []string        `json:"code_hashes,omitempty"`
+		IfaceHashes []string        `json:"iface_hashes,omitempty"`
+	}
+	hf := hexFilter{Addresses: f.Addresses}
+	var tmp [8]byte
+	for _, v := range f.CodeHashes {
+		binary.BigEndian.PutUint64(tmp[:], v)
+		hf.CodeHashes = append(hf.CodeHashes, hex.EncodeToString(tmp[:]))
+	}
+	for _, v := range f.IfaceHashes {
+		binary.BigEndian.PutUint64(tmp[:], v)
+		hf.IfaceHashes = append(hf.IfaceHashes, hex.EncodeToString(tmp[:]))
+	}
+	buf, _ := json.Marshal(hf)
+	return string(buf)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"blockwatch.cc/packdb/pack"
+)
+
+// GasStats aggregates gas, fee and storage usage of external calls to a
+// single contract entrypoint during one cycle. Sums and sketches only cover
+// successful calls while fees are counted for all calls. Rolling statistics
+// are obtained by merging the rows of recent cycles.
+type GasStats struct {
+	RowId         uint64    `pack:"I,pk"     json:"row_id"`
+	AccountId     AccountID `pack:"A,bloom"  json:"account_id"`    // contract
+	Entrypoint    int       `pack:"E"        json:"entrypoint_id"` // entrypoint id
+	Cycle         int64     `pack:"c"        json:"cycle"`         // cycle of calls
+	Height        int64     `pack:"h"        json:"height"`        // last update height
+	NCalls        int64     `pack:"n"        json:"n_calls"`       // all calls
+	NFailed       int64     `pack:"f"        json:"n_failed"`      // failed calls
+	GasUsed       int64     `pack:"g"        json:"gas_used"`      // sum of gas used
+	StoragePaid   int64     `pack:"s"        json:"storage_paid"`  // sum of storage paid
+	Fee           int64     `pack:"F"        json:"fee"`           // sum of fees
+	GasData       []byte    `pack:"1,snappy" json:"-"`             // encoded gas sketch
+	StorageData   []byte    `pack:"2,snappy" json:"-"`             // encoded storage sketch
+	FeeData       []byte    `pack:"3,snappy" json:"-"`             // encoded fee sketch
+	GasSketch     *Sketch   `pack:"-"        json:"-"`
+	StorageSketch *Sketch   `pack:"-"        json:"-"`
+	FeeSketch     *Sketch   `pack:"-"        json:"-"`
+}
+
+// Ensure GasStats implements the pack.Item interface.
+var _ pack.Item = (*GasStats)(nil)
+
+func NewGasStats(acc AccountID, entrypoint int, cycle int64) *GasStats {
+	return &GasStats{
+		AccountId:     acc,
+		Entrypoint:    entrypoint,
+		Cycle:         cycle,
+		GasSketch:     NewSketch(),
+		StorageSketch: NewSketch(),
+		FeeSketch:     NewSketch(),
+	}
+}
+
+func (s *GasStats) ID() uint64 {
+	return s.RowId
+}
+
+func (s *GasStats) SetID(id uint64) {
+	s.RowId = id
+}
+
+// Add accounts for a call to this entrypoint.
+func (s *GasStats) Add(op *Op) {
+	s.NCalls++
+	s.Fee += op.Fee
+	s.FeeSketch.Add(op.Fee)
+	if !op.IsSuccess {
+		s.NFailed++
+		return
+	}
+	s.GasUsed += op.GasUsed
+	s.StoragePaid += op.StoragePaid
+	s.GasSketch.Add(op.GasUsed)
+	s.StorageSketch.Add(op.StoragePaid)
+}
+
+// Remove reverts Add on rollback.
+func (s *GasStats) Remove(op *Op) {
+	s.NCalls--
+	s.Fee -= op.Fee
+	s.FeeSketch.Remove(op.Fee)
+	if !op.IsSuccess {
+		s.NFailed--
+		return
+	}
+	s.GasUsed -= op.GasUsed
+	s.StoragePaid -= op.StoragePaid
+	s.GasSketch.Remove(op.GasUsed)
+	s.StorageSketch.Remove(op.StoragePaid)
+}
+
+// Merge adds statistics of o, usually from another cycle, to s. Both
+// sketches must be decoded.
+func (s *GasStats) Merge(o *GasStats) {
+	s.NCalls += o.NCalls
+	s.NFailed += o.NFailed
+	s.GasUsed += o.GasUsed
+	s.StoragePaid += o.StoragePaid
+	s.Fee += o.Fee
+	s.GasSketch.Merge(o.GasSketch)
+	s.StorageSketch.Merge(o.StorageSketch)
+	s.FeeSketch.Merge(o.FeeSketch)
+	if o.Height > s.Height {
+		s.Height = o.Height
+	}
+	if o.Cycle < s.Cycle {
+		s.Cycle = o.Cycle
+	}
+}
+
+func (s *GasStats) NSuccess() int64 {
+	return s.NCalls - s.NFailed
+}
+
+func (s *GasStats) Encode() (err error) {
+	if s.GasData, err = s.GasSketch.MarshalBinary(); err != nil {
+		return
+	}
+	if s.StorageData, err = s.StorageSketch.MarshalBinary(); err != nil {
+		return
+	}
+	s.FeeData, err = s.FeeSketch.MarshalBinary()
+	return
+}
+
+func (s *GasStats) Decode() error {
+	s.GasSketch, s.StorageSketch, s.FeeSketch = NewSketch(), NewSketch(), NewSketch()
+	if err := s.GasSketch.UnmarshalBinary(s.GasData); err != nil {
+		return err
+	}
+	if err := s.StorageSketch.UnmarshalBinary(s.StorageData); err != nil {
+		return err
+	}
+	return s.FeeSketch.UnmarshalBinary(s.FeeData)
+}
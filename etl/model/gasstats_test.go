@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"testing"
+)
+
+// TestGasStatsMerge checks that merging per-cycle statistics equals adding
+// all calls to a single row.
+func TestGasStatsMerge(t *testing.T) {
+	calls := [][]*Op{
+		{
+			{IsSuccess: true, GasUsed: 1000, StoragePaid: 0, Fee: 500},
+			{IsSuccess: false, Fee: 400},
+		},
+		{
+			{IsSuccess: true, GasUsed: 3000, StoragePaid: 67, Fee: 900},
+		},
+		{},
+		{
+			{IsSuccess: true, GasUsed: 2000, StoragePaid: 10, Fee: 700},
+			{IsSuccess: true, GasUsed: 50000, StoragePaid: 250, Fee: 6000},
+		},
+	}
+	all := NewGasStats(1, 2, 0)
+	var merged *GasStats
+	for i, ops := range calls {
+		s := NewGasStats(1, 2, int64(10+i))
+		for _, op := range ops {
+			s.Add(op)
+			all.Add(op)
+		}
+		s.Height = int64(100 * (i + 1))
+		if merged == nil {
+			merged = s
+		} else {
+			merged.Merge(s)
+		}
+	}
+
+	if merged.NCalls != 5 || merged.NFailed != 1 || merged.GasUsed != all.GasUsed ||
+		merged.StoragePaid != all.StoragePaid || merged.Fee != all.Fee {
+		t.Errorf("got %+v, want %+v", merged, all)
+	}
+	if merged.Cycle != 10 || merged.Height != 400 {
+		t.Errorf("got cycle=%d height=%d, want 10 400", merged.Cycle, merged.Height)
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		for _, v := range []struct {
+			name string
+			a, b *Sketch
+		}{
+			{"gas", merged.GasSketch, all.GasSketch},
+			{"storage", merged.StorageSketch, all.StorageSketch},
+			{"fee", merged.FeeSketch, all.FeeSketch},
+		} {
+			if v.a.Count() != v.b.Count() || v.a.Quantile(q) != v.b.Quantile(q) {
+				t.Errorf("%s q%v: got %d/%d, want %d/%d", v.name, q,
+					v.a.Quantile(q), v.a.Count(), v.b.Quantile(q), v.b.Count())
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/rpc"
 )
 
 // BlockCrawler provides an interface to access information about the current
@@ -81,6 +82,13 @@ type BlockBuilder interface {
 
 	// returns true if indexer is run in light mode
 	IsLightMode() bool
+
+	// returns true if indexer only stores data for selected accounts
+	IsFiltered() bool
+
+	// returns true if data related to this account should be stored,
+	// always true when indexing is not filtered
+	IsSelected(AccountID) bool
 }
 
 // BlockIndexer provides a generic interface for an indexer that is managed by an
@@ -133,3 +141,26 @@ type BlockIndexer interface {
 	// returns the list of database tables used by the indexer
 	Tables() []*pack.Table
 }
+
+// BackfillBuilder provides access to stored state and the RPC node while an
+// index is backfilled from past blocks. Block-level state such as touched
+// accounts, bakers and contracts is not available.
+type BackfillBuilder interface {
+	BlockBuilder
+
+	// returns the RPC client to fetch data that is not stored in the database
+	RPC() *rpc.Client
+
+	// fetches the block from RPC and attaches raw operations and receipt data
+	// such as ticket updates and sapling events to stored operations
+	LoadReceipts(ctx context.Context, block *Block) error
+}
+
+// BlockBackfiller is an optional interface for indexes that can be enabled
+// on an existing database. Past blocks are replayed from stored block and
+// operation data which lacks raw RPC receipts.
+type BlockBackfiller interface {
+	// BackfillBlock is invoked for every past block while the index catches
+	// up with the other indexes.
+	BackfillBlock(ctx context.Context, block *Block, builder BackfillBuilder) error
+}
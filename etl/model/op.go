@@ -93,6 +93,8 @@ type Op struct {
 	IsStorageUpdate bool                   `pack:"-"  json:"-"` // true when contract storage changed
 	Contract        *Contract              `pack:"-"  json:"-"` // cached contract
 	BigmapUpdates   []BigmapUpdate         `pack:"-"  json:"-"` // cached query result
+	TicketUpdates   []rpc.TicketUpdate     `pack:"-"  json:"-"` // cache here for ticket index
+	SaplingEvents   []rpc.SaplingEvent     `pack:"-"  json:"-"` // cache here for sapling index
 }
 
 // Ensure Op implements the pack.Item interface.
@@ -195,6 +197,8 @@ func (o *Op) Reset() {
 	o.OpI = 0
 	o.Raw = nil
 	o.BigmapEvents = nil
+	o.TicketUpdates = nil
+	o.SaplingEvents = nil
 	o.Storage = nil
 	o.StorageHash = 0
 	o.IsStorageUpdate = false
@@ -5,6 +5,7 @@ package model
 
 import (
     "blockwatch.cc/tzgo/tezos"
+    "blockwatch.cc/tzindex/rpc"
     "fmt"
 )
 
@@ -172,7 +173,8 @@ func MapOpType(typ tezos.OpType) OpType {
         return OpTypeRegisterConstant
     case tezos.OpTypeSetDepositsLimit:
         return OpTypeDepositsLimit
-    case tezos.OpTypeToruOrigination:
+    case tezos.OpTypeToruOrigination,
+        rpc.OpTypeSmartRollupOriginate:
         return OpTypeRollupOrigination
     case tezos.OpTypeTransferTicket,
         tezos.OpTypeToruSubmitBatch,
@@ -181,7 +183,14 @@ func MapOpType(typ tezos.OpType) OpType {
         tezos.OpTypeToruFinalizeCommitment,
         tezos.OpTypeToruRemoveCommitment,
         tezos.OpTypeToruRejection,
-        tezos.OpTypeToruDispatchTickets:
+        tezos.OpTypeToruDispatchTickets,
+        rpc.OpTypeSmartRollupAddMessages,
+        rpc.OpTypeSmartRollupCement,
+        rpc.OpTypeSmartRollupPublish,
+        rpc.OpTypeSmartRollupRefute,
+        rpc.OpTypeSmartRollupTimeout,
+        rpc.OpTypeSmartRollupExecuteOutboxMessage,
+        rpc.OpTypeSmartRollupRecoverBond:
         return OpTypeRollupTransaction
     default:
         return OpTypeInvalid
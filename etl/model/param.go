@@ -0,0 +1,35 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"blockwatch.cc/packdb/pack"
+)
+
+// ParamValue is a single scalar leaf (address, number, string or bytes) of
+// decoded contract call parameters. Paths are relative to the called
+// entrypoint and omit list positions, so that `txs.to_` matches receivers at
+// any position in a list. Numbers are stored as decimal string in Value and,
+// clamped to the int64 range, in Int for range comparisons.
+type ParamValue struct {
+	RowId     uint64    `pack:"I,pk,snappy"  json:"row_id"`
+	OpId      OpID      `pack:"O,snappy"     json:"op_id"`
+	AccountId AccountID `pack:"A,bloom"      json:"account_id"`
+	Height    int64     `pack:"h,snappy"     json:"height"`
+	Path      string    `pack:"p,snappy"     json:"path"`
+	Value     string    `pack:"v,snappy"     json:"value"`
+	Int       int64     `pack:"i,snappy"     json:"int"`
+	IsNum     bool      `pack:"n,snappy"     json:"is_num"`
+}
+
+// Ensure ParamValue implements the pack.Item interface.
+var _ pack.Item = (*ParamValue)(nil)
+
+func (p *ParamValue) ID() uint64 {
+	return p.RowId
+}
+
+func (p *ParamValue) SetID(id uint64) {
+	p.RowId = id
+}
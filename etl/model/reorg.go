@@ -0,0 +1,87 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Reorg is a persistent record of a chain reorganization or manual rollback.
+type Reorg struct {
+	RowId      uint64          `pack:"I,pk"     json:"row_id"`
+	Timestamp  time.Time       `pack:"T"        json:"time"`        // time the reorg was processed
+	ForkHeight int64           `pack:"h"        json:"fork_height"` // last common block height
+	ForkHash   tezos.BlockHash `pack:"f"        json:"fork_block"`  // last common block hash
+	Depth      int             `pack:"d"        json:"depth"`       // number of orphaned blocks
+	NAttached  int             `pack:"a"        json:"n_attached"`  // number of new main chain blocks
+	OldTip     tezos.BlockHash `pack:"o"        json:"old_tip"`     // best block before reorg
+	NewTip     tezos.BlockHash `pack:"n"        json:"new_tip"`     // best block after reorg
+	IsRollback bool            `pack:"r"        json:"is_rollback"` // manual rollback without new chain
+	NOps       int             `pack:"O"        json:"n_ops"`       // ops reverted across all orphans
+	Data       []byte          `pack:"D,snappy" json:"-"`           // JSON encoded orphan list
+
+	// decoded data
+	Orphans []*ReorgBlock `pack:"-" json:"orphans,omitempty"`
+}
+
+// ReorgBlock describes a single block orphaned by a reorg and the
+// number of rows reverted from each table.
+type ReorgBlock struct {
+	RowId    uint64           `json:"row_id"`
+	Hash     tezos.BlockHash  `json:"hash"`
+	Height   int64            `json:"height"`
+	Time     time.Time        `json:"time"`
+	NOps     int              `json:"n_ops"`
+	Ops      []tezos.OpHash   `json:"ops"`
+	Reverted map[string]int64 `json:"reverted"`
+}
+
+// Ensure Reorg implements the pack.Item interface.
+var _ pack.Item = (*Reorg)(nil)
+
+func (r *Reorg) ID() uint64 {
+	return r.RowId
+}
+
+func (r *Reorg) SetID(id uint64) {
+	r.RowId = id
+}
+
+// Reverted returns the total number of rows reverted per table.
+func (r *Reorg) Reverted() map[string]int64 {
+	m := make(map[string]int64)
+	for _, b := range r.Orphans {
+		for k, v := range b.Reverted {
+			m[k] += v
+		}
+	}
+	return m
+}
+
+// Orphan returns the orphaned block with hash h or nil.
+func (r *Reorg) Orphan(h tezos.BlockHash) *ReorgBlock {
+	for _, b := range r.Orphans {
+		if b.Hash.Equal(h) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (r *Reorg) Encode() (err error) {
+	r.Data, err = json.Marshal(r.Orphans)
+	return
+}
+
+func (r *Reorg) Decode() error {
+	r.Orphans = r.Orphans[:0]
+	if len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, &r.Orphans)
+}
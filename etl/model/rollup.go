@@ -0,0 +1,203 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+type RollupID uint64
+
+func (id RollupID) Value() uint64 {
+	return uint64(id)
+}
+
+// SmartRollup holds static info and running statistics for smart rollups.
+// Smart rollup addresses (sr1) are not accounts, so they live in their own table.
+type SmartRollup struct {
+	RowId             RollupID  `pack:"I,pk,snappy"   json:"row_id"`
+	Address           []byte    `pack:"H,snappy"      json:"address"`
+	CreatorId         AccountID `pack:"C,snappy"      json:"creator_id"`
+	PvmKind           string    `pack:"k,snappy"      json:"pvm_kind"`
+	ParametersType    []byte    `pack:"p,snappy"      json:"parameters_type"`
+	Kernel            []byte    `pack:"K,snappy"      json:"kernel"`
+	GenesisCommitment []byte    `pack:"g,snappy"      json:"genesis_commitment"`
+	FirstSeen         int64     `pack:"f,snappy"      json:"first_seen"`
+	LastSeen          int64     `pack:"l,snappy"      json:"last_seen"`
+	LastCommitment    []byte    `pack:"c,snappy"      json:"last_cemented_commitment"`
+	LastCementedLevel int64     `pack:"L,snappy"      json:"last_cemented_level"`
+	NCommitments      int       `pack:"1,snappy"      json:"n_commitments"`
+	NCements          int       `pack:"2,snappy"      json:"n_cements"`
+	NRefutations      int       `pack:"3,snappy"      json:"n_refutations"`
+	NTimeouts         int       `pack:"4,snappy"      json:"n_timeouts"`
+	NOutboxMessages   int       `pack:"5,snappy"      json:"n_outbox_messages"`
+	NStakers          int       `pack:"6,snappy"      json:"n_stakers"`
+	TotalBond         int64     `pack:"b,snappy"      json:"total_bond"`
+	TotalLostBond     int64     `pack:"B,snappy"      json:"total_lost_bond"`
+
+	IsDirty bool `pack:"-" json:"-"`
+}
+
+// Ensure SmartRollup implements the pack.Item interface.
+var _ pack.Item = (*SmartRollup)(nil)
+
+// assuming the op was successful!
+func NewSmartRollup(rop *rpc.SmartRollup, op *Op) *SmartRollup {
+	res := rop.Result()
+	r := &SmartRollup{
+		Address:           res.SmartRollupAddress.Clone().Bytes(),
+		CreatorId:         op.SenderId,
+		PvmKind:           rop.Originate.PvmKind,
+		Kernel:            rop.Originate.Kernel,
+		GenesisCommitment: res.GenesisCommitmentHash.Clone().Bytes(),
+		FirstSeen:         op.Height,
+		LastSeen:          op.Height,
+		LastCommitment:    res.GenesisCommitmentHash.Clone().Bytes(),
+		LastCementedLevel: op.Height,
+	}
+	if rop.Originate.ParametersType.IsValid() {
+		r.ParametersType, _ = rop.Originate.ParametersType.MarshalBinary()
+	}
+	return r
+}
+
+func (r *SmartRollup) ID() uint64 {
+	return uint64(r.RowId)
+}
+
+func (r *SmartRollup) SetID(id uint64) {
+	r.RowId = RollupID(id)
+}
+
+func (r SmartRollup) String() string {
+	return r.GetAddress().String()
+}
+
+func (r SmartRollup) GetAddress() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeAddress, r.Address)
+}
+
+func (r SmartRollup) GetLastCommitment() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeCommitment, r.LastCommitment)
+}
+
+func (r SmartRollup) GetGenesisCommitment() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeCommitment, r.GenesisCommitment)
+}
+
+type RollupCommitmentID uint64
+
+func (id RollupCommitmentID) Value() uint64 {
+	return uint64(id)
+}
+
+// RollupCommitment is a smart rollup state commitment published by a staker.
+// The same commitment may be published by multiple stakers in which case
+// there is a row for each of them.
+type RollupCommitment struct {
+	RowId        RollupCommitmentID `pack:"I,pk,snappy"   json:"row_id"`
+	RollupId     RollupID           `pack:"R,snappy"      json:"rollup_id"`
+	Hash         []byte             `pack:"H,snappy"      json:"hash"`
+	Predecessor  []byte             `pack:"P,snappy"      json:"predecessor"`
+	StateHash    []byte             `pack:"s,snappy"      json:"state_hash"`
+	InboxLevel   int64              `pack:"l,snappy"      json:"inbox_level"`
+	NumTicks     int64              `pack:"t,snappy"      json:"num_ticks"`
+	StakerId     AccountID          `pack:"S,snappy"      json:"staker_id"`
+	Bond         int64              `pack:"b,snappy"      json:"bond"`
+	OpId         OpID               `pack:"o,snappy"      json:"op_id"`
+	Height       int64              `pack:"h,snappy"      json:"height"`
+	IsCemented   bool               `pack:"c,snappy"      json:"is_cemented"`
+	CementHeight int64              `pack:"C,snappy"      json:"cement_height"`
+}
+
+// Ensure RollupCommitment implements the pack.Item interface.
+var _ pack.Item = (*RollupCommitment)(nil)
+
+// assuming the op was successful!
+func NewRollupCommitment(rop *rpc.SmartRollup, op *Op, rollup *SmartRollup, bond int64) *RollupCommitment {
+	res := rop.Result()
+	return &RollupCommitment{
+		RollupId:    rollup.RowId,
+		Hash:        res.StakedHash.Clone().Bytes(),
+		Predecessor: rop.Publish.Predecessor.Clone().Bytes(),
+		StateHash:   rop.Publish.CompressedState.Clone().Bytes(),
+		InboxLevel:  rop.Publish.InboxLevel,
+		NumTicks:    rop.Publish.NumberOfTicks.Int64(),
+		StakerId:    op.SenderId,
+		Bond:        bond,
+		OpId:        op.RowId,
+		Height:      op.Height,
+	}
+}
+
+// NewGenesisCommitment creates the initial commitment that is implicitly
+// cemented on rollup origination.
+func NewGenesisCommitment(rollup *SmartRollup, op *Op) *RollupCommitment {
+	return &RollupCommitment{
+		RollupId:     rollup.RowId,
+		Hash:         rollup.GenesisCommitment,
+		InboxLevel:   op.Height,
+		StakerId:     op.SenderId,
+		OpId:         op.RowId,
+		Height:       op.Height,
+		IsCemented:   true,
+		CementHeight: op.Height,
+	}
+}
+
+func (c *RollupCommitment) ID() uint64 {
+	return uint64(c.RowId)
+}
+
+func (c *RollupCommitment) SetID(id uint64) {
+	c.RowId = RollupCommitmentID(id)
+}
+
+func (c RollupCommitment) GetHash() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeCommitment, c.Hash)
+}
+
+func (c RollupCommitment) GetPredecessor() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeCommitment, c.Predecessor)
+}
+
+func (c RollupCommitment) GetStateHash() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeState, c.StateHash)
+}
+
+type RollupMessageID uint64
+
+func (id RollupMessageID) Value() uint64 {
+	return uint64(id)
+}
+
+// RollupMessage is either an external message added to the shared smart rollup
+// inbox or an outbox message executed on L1 for a specific rollup.
+type RollupMessage struct {
+	RowId      RollupMessageID `pack:"I,pk,snappy"   json:"row_id"`
+	RollupId   RollupID        `pack:"R,snappy"      json:"rollup_id"` // 0 for inbox
+	IsOutbox   bool            `pack:"x,snappy"      json:"is_outbox"`
+	SenderId   AccountID       `pack:"S,snappy"      json:"sender_id"`
+	OpId       OpID            `pack:"o,snappy"      json:"op_id"`
+	Height     int64           `pack:"h,snappy"      json:"height"`
+	Index      int             `pack:"i,snappy"      json:"index"`
+	Commitment []byte          `pack:"c,snappy"      json:"commitment"` // outbox only
+	Data       []byte          `pack:"d,snappy"      json:"data"`       // inbox payload or outbox proof
+}
+
+// Ensure RollupMessage implements the pack.Item interface.
+var _ pack.Item = (*RollupMessage)(nil)
+
+func (m *RollupMessage) ID() uint64 {
+	return uint64(m.RowId)
+}
+
+func (m *RollupMessage) SetID(id uint64) {
+	m.RowId = RollupMessageID(id)
+}
+
+func (m RollupMessage) GetCommitment() rpc.SmartRollupHash {
+	return rpc.NewSmartRollupHash(rpc.SmartRollupHashTypeCommitment, m.Commitment)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+)
+
+type SaplingPoolID uint64
+
+func (id SaplingPoolID) Value() uint64 {
+	return uint64(id)
+}
+
+// SaplingPool tracks size and shielded value of a sapling state. Pools are
+// identified by their lazy storage id. Note contents are never decrypted.
+type SaplingPool struct {
+	RowId           SaplingPoolID `pack:"I,pk,snappy"   json:"row_id"`
+	PoolId          int64         `pack:"p,snappy"      json:"pool_id"`
+	AccountId       AccountID     `pack:"A,snappy"      json:"account_id"`
+	MemoSize        int           `pack:"m,snappy"      json:"memo_size"`
+	NCommitments    int64         `pack:"c,snappy"      json:"n_commitments"`
+	NNullifiers     int64         `pack:"n,snappy"      json:"n_nullifiers"`
+	NTxs            int64         `pack:"x,snappy"      json:"n_txs"`
+	Value           int64         `pack:"V,snappy"      json:"value"`
+	TotalShielded   int64         `pack:"s,snappy"      json:"total_shielded"`
+	TotalUnshielded int64         `pack:"u,snappy"      json:"total_unshielded"`
+	FirstBlock      int64         `pack:"<,snappy"      json:"first_block"`
+	LastBlock       int64         `pack:">,snappy"      json:"last_block"`
+
+	IsDirty bool `pack:"-" json:"-"`
+}
+
+// Ensure SaplingPool implements the pack.Item interface.
+var _ pack.Item = (*SaplingPool)(nil)
+
+func NewSaplingPool(id int64, op *Op, memoSize int) *SaplingPool {
+	return &SaplingPool{
+		PoolId:     id,
+		AccountId:  op.ReceiverId,
+		MemoSize:   memoSize,
+		FirstBlock: op.Height,
+		LastBlock:  op.Height,
+	}
+}
+
+func (p *SaplingPool) ID() uint64 {
+	return uint64(p.RowId)
+}
+
+func (p *SaplingPool) SetID(id uint64) {
+	p.RowId = SaplingPoolID(id)
+}
+
+type SaplingTxID uint64
+
+func (id SaplingTxID) Value() uint64 {
+	return uint64(id)
+}
+
+// SaplingTx is a single sapling state update caused by an operation. Pool
+// counters are stored post-update so a time series of pool size can be read
+// without aggregation.
+type SaplingTx struct {
+	RowId           SaplingTxID `pack:"I,pk,snappy"   json:"row_id"`
+	PoolId          int64       `pack:"p,snappy"      json:"pool_id"`
+	AccountId       AccountID   `pack:"A,snappy"      json:"account_id"`
+	OpId            OpID        `pack:"o,snappy"      json:"op_id"`
+	Height          int64       `pack:"h,snappy"      json:"height"`
+	Time            time.Time   `pack:"t,snappy"      json:"time"`
+	MemoSize        int         `pack:"m,snappy"      json:"memo_size"`
+	NCommitments    int         `pack:"c,snappy"      json:"n_commitments"`
+	NNullifiers     int         `pack:"n,snappy"      json:"n_nullifiers"`
+	Shielded        int64       `pack:"s,snappy"      json:"shielded"`
+	Unshielded      int64       `pack:"u,snappy"      json:"unshielded"`
+	PoolCommitments int64       `pack:"C,snappy"      json:"pool_commitments"`
+	PoolNullifiers  int64       `pack:"N,snappy"      json:"pool_nullifiers"`
+	PoolValue       int64       `pack:"V,snappy"      json:"pool_value"`
+}
+
+// Ensure SaplingTx implements the pack.Item interface.
+var _ pack.Item = (*SaplingTx)(nil)
+
+func (t *SaplingTx) ID() uint64 {
+	return uint64(t.RowId)
+}
+
+func (t *SaplingTx) SetID(id uint64) {
+	t.RowId = SaplingTxID(id)
+}
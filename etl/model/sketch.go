@@ -0,0 +1,157 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// SketchAccuracy is the relative accuracy of quantiles returned by a Sketch.
+const SketchAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + SketchAccuracy) / (1 - SketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+
+	errShortSketch = errors.New("sketch: short buffer")
+)
+
+// Sketch is a quantile sketch over non-negative integer values with log-sized
+// buckets. Quantiles are accurate to SketchAccuracy relative error. Values can
+// be removed again which makes sketches safe to roll back.
+type Sketch struct {
+	zero    int64           // count of values <= 0
+	buckets map[int32]int64 // bucket index -> count
+	count   int64
+}
+
+func NewSketch() *Sketch {
+	return &Sketch{buckets: make(map[int32]int64)}
+}
+
+func sketchIndex(v int64) int32 {
+	return int32(math.Ceil(math.Log(float64(v)) / sketchLogGamma))
+}
+
+func (s *Sketch) Count() int64 {
+	return s.count
+}
+
+func (s *Sketch) Add(v int64) {
+	s.count++
+	if v <= 0 {
+		s.zero++
+		return
+	}
+	s.buckets[sketchIndex(v)]++
+}
+
+func (s *Sketch) Remove(v int64) {
+	if s.count == 0 {
+		return
+	}
+	s.count--
+	if v <= 0 {
+		if s.zero > 0 {
+			s.zero--
+		}
+		return
+	}
+	i := sketchIndex(v)
+	if n := s.buckets[i]; n > 1 {
+		s.buckets[i] = n - 1
+	} else {
+		delete(s.buckets, i)
+	}
+}
+
+// Merge adds all values of o to s.
+func (s *Sketch) Merge(o *Sketch) {
+	s.count += o.count
+	s.zero += o.zero
+	for k, n := range o.buckets {
+		s.buckets[k] += n
+	}
+}
+
+// Quantile returns the approximate value at quantile q in [0,1].
+func (s *Sketch) Quantile(q float64) int64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.count-1))
+	if rank < s.zero {
+		return 0
+	}
+	rank -= s.zero
+	keys := make([]int32, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if rank < s.buckets[k] {
+			return int64(math.Round(2 * math.Pow(sketchGamma, float64(k)) / (1 + sketchGamma)))
+		}
+		rank -= s.buckets[k]
+	}
+	return 0
+}
+
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	keys := make([]int32, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	var (
+		tmp [binary.MaxVarintLen64]byte
+		buf = make([]byte, 0, 2*binary.MaxVarintLen64+len(keys)*4)
+	)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(s.zero))]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(keys)))]...)
+	for _, k := range keys {
+		buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(k))]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(s.buckets[k]))]...)
+	}
+	return buf, nil
+}
+
+func (s *Sketch) UnmarshalBinary(buf []byte) error {
+	s.buckets = make(map[int32]int64)
+	s.zero, s.count = 0, 0
+	if len(buf) == 0 {
+		return nil
+	}
+	zero, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return errShortSketch
+	}
+	buf = buf[n:]
+	l, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return errShortSketch
+	}
+	buf = buf[n:]
+	s.zero = int64(zero)
+	s.count = s.zero
+	for i := uint64(0); i < l; i++ {
+		k, n := binary.Varint(buf)
+		if n <= 0 {
+			return errShortSketch
+		}
+		buf = buf[n:]
+		c, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errShortSketch
+		}
+		buf = buf[n:]
+		s.buckets[int32(k)] = int64(c)
+		s.count += int64(c)
+	}
+	return nil
+}
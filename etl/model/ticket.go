@@ -0,0 +1,138 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package model
+
+import (
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/rpc"
+)
+
+type TicketTypeID uint64
+
+func (id TicketTypeID) Value() uint64 {
+	return uint64(id)
+}
+
+// TicketType is a unique combination of ticketer, content type and content.
+// Ticket amounts are unbounded naturals on-chain, we store them as int64.
+type TicketType struct {
+	RowId       TicketTypeID `pack:"I,pk,snappy"   json:"row_id"`
+	Ticketer    AccountID    `pack:"A,snappy"      json:"ticketer_id"`
+	Type        []byte       `pack:"t,snappy"      json:"type"`
+	Content     []byte       `pack:"c,snappy"      json:"content"`
+	Hash        []byte       `pack:"H,snappy"      json:"hash"`
+	FirstBlock  int64        `pack:"<,snappy"      json:"first_block"`
+	LastBlock   int64        `pack:">,snappy"      json:"last_block"`
+	TotalSupply int64        `pack:"S,snappy"      json:"total_supply"`
+	TotalMint   int64        `pack:"m,snappy"      json:"total_mint"`
+	TotalBurn   int64        `pack:"b,snappy"      json:"total_burn"`
+	NHolders    int          `pack:"h,snappy"      json:"n_holders"`
+	NTransfers  int          `pack:"x,snappy"      json:"n_transfers"`
+
+	IsDirty bool `pack:"-" json:"-"`
+}
+
+// Ensure TicketType implements the pack.Item interface.
+var _ pack.Item = (*TicketType)(nil)
+
+func NewTicketType(t rpc.Ticket, ticketer AccountID, height int64) *TicketType {
+	tt := &TicketType{
+		Ticketer:   ticketer,
+		Hash:       t.Hash().Bytes(),
+		FirstBlock: height,
+		LastBlock:  height,
+	}
+	tt.Type, _ = t.Type.MarshalBinary()
+	tt.Content, _ = t.Content.MarshalBinary()
+	return tt
+}
+
+func (t *TicketType) ID() uint64 {
+	return uint64(t.RowId)
+}
+
+func (t *TicketType) SetID(id uint64) {
+	t.RowId = TicketTypeID(id)
+}
+
+func (t TicketType) GetHash() tezos.ExprHash {
+	return tezos.NewExprHash(t.Hash)
+}
+
+func (t TicketType) GetType() micheline.Prim {
+	var p micheline.Prim
+	_ = p.UnmarshalBinary(t.Type)
+	return p
+}
+
+func (t TicketType) GetContent() micheline.Prim {
+	var p micheline.Prim
+	_ = p.UnmarshalBinary(t.Content)
+	return p
+}
+
+type TicketBalanceID uint64
+
+func (id TicketBalanceID) Value() uint64 {
+	return uint64(id)
+}
+
+// TicketBalance is the current balance of a ticket holder.
+type TicketBalance struct {
+	RowId      TicketBalanceID `pack:"I,pk,snappy"   json:"row_id"`
+	TicketId   TicketTypeID    `pack:"T,snappy"      json:"ticket_id"`
+	AccountId  AccountID       `pack:"A,snappy,bloom" json:"account_id"`
+	Balance    int64           `pack:"B,snappy"      json:"balance"`
+	FirstBlock int64           `pack:"<,snappy"      json:"first_block"`
+	LastBlock  int64           `pack:">,snappy"      json:"last_block"`
+	NTransfers int             `pack:"x,snappy"      json:"n_transfers"`
+
+	IsDirty bool `pack:"-" json:"-"`
+}
+
+// Ensure TicketBalance implements the pack.Item interface.
+var _ pack.Item = (*TicketBalance)(nil)
+
+func (b *TicketBalance) ID() uint64 {
+	return uint64(b.RowId)
+}
+
+func (b *TicketBalance) SetID(id uint64) {
+	b.RowId = TicketBalanceID(id)
+}
+
+type TicketUpdateID uint64
+
+func (id TicketUpdateID) Value() uint64 {
+	return uint64(id)
+}
+
+// TicketUpdate is a single signed ticket balance change caused by an operation.
+// Updates of holders that are not accounts have a zero account id and keep
+// the holder address instead.
+type TicketUpdate struct {
+	RowId     TicketUpdateID `pack:"I,pk,snappy"   json:"row_id"`
+	TicketId  TicketTypeID   `pack:"T,snappy"      json:"ticket_id"`
+	AccountId AccountID      `pack:"A,snappy,bloom" json:"account_id"`
+	Amount    int64          `pack:"a,snappy"      json:"amount"`
+	Height    int64          `pack:"h,snappy"      json:"height"`
+	Time      time.Time      `pack:"t,snappy"      json:"time"`
+	OpId      OpID           `pack:"o,snappy"      json:"op_id"`
+	Holder    string         `pack:"r,snappy"      json:"holder"` // non-account holder (i.e. smart rollup)
+}
+
+// Ensure TicketUpdate implements the pack.Item interface.
+var _ pack.Item = (*TicketUpdate)(nil)
+
+func (u *TicketUpdate) ID() uint64 {
+	return uint64(u.RowId)
+}
+
+func (u *TicketUpdate) SetID(id uint64) {
+	u.RowId = TicketUpdateID(id)
+}
@@ -335,9 +335,17 @@ func (b *Builder) AppendRegularBlockOps(ctx context.Context, rollback bool) erro
 				case model.OpTypeDepositsLimit:
 					err = b.AppendDepositsLimitOp(ctx, oh, id, rollback)
 				case model.OpTypeRollupOrigination:
-					err = b.AppendRollupOriginationOp(ctx, oh, id, rollback)
+					if rpc.IsSmartRollupOpType(o.Kind()) {
+						err = b.AppendSmartRollupOriginationOp(ctx, oh, id, rollback)
+					} else {
+						err = b.AppendRollupOriginationOp(ctx, oh, id, rollback)
+					}
 				case model.OpTypeRollupTransaction:
-					err = b.AppendRollupTransactionOp(ctx, oh, id, rollback)
+					if rpc.IsSmartRollupOpType(o.Kind()) {
+						err = b.AppendSmartRollupTransactionOp(ctx, oh, id, rollback)
+					} else {
+						err = b.AppendRollupTransactionOp(ctx, oh, id, rollback)
+					}
 				}
 				if err != nil {
 					return err
@@ -1175,6 +1183,8 @@ func (b *Builder) AppendTransactionOp(ctx context.Context, oh *rpc.Operation, id
 			return Errorf("patch bigmap: %v", err)
 		}
 	}
+	op.TicketUpdates = res.TicketUpdates()
+	op.SaplingEvents = res.SaplingEvents()
 
 	var flows []*model.Flow
 
@@ -1427,6 +1437,8 @@ func (b *Builder) AppendInternalTransactionOp(
 			return Errorf("patch bigmap: %v", err)
 		}
 	}
+	op.TicketUpdates = res.TicketUpdates()
+	op.SaplingEvents = res.SaplingEvents()
 
 	var flows []*model.Flow
 
@@ -1625,6 +1637,8 @@ func (b *Builder) AppendOriginationOp(ctx context.Context, oh *rpc.Operation, id
 				return Errorf("patch bigmap: %v", err)
 			}
 		}
+		op.TicketUpdates = res.TicketUpdates()
+		op.SaplingEvents = res.SaplingEvents()
 
 	} else {
 		// handle errors
@@ -1674,6 +1688,7 @@ func (b *Builder) AppendOriginationOp(ctx context.Context, oh *rpc.Operation, id
 				dst.IsDelegated = true
 				dst.BakerId = newbkr.AccountId
 				dst.DelegatedSince = b.block.Height
+				b.appendDelegationPeriod(dst, newbkr.AccountId, op.Volume)
 
 				newbkr.TotalDelegations++
 				newbkr.ActiveDelegations++
@@ -1820,6 +1835,8 @@ func (b *Builder) AppendInternalOriginationOp(
 				return Errorf("patch bigmap: %v", err)
 			}
 		}
+		op.TicketUpdates = res.TicketUpdates()
+		op.SaplingEvents = res.SaplingEvents()
 
 	} else {
 		// handle errors
@@ -1860,6 +1877,7 @@ func (b *Builder) AppendInternalOriginationOp(
 				dst.IsDelegated = true
 				dst.BakerId = newbkr.AccountId
 				dst.DelegatedSince = b.block.Height
+				b.appendDelegationPeriod(dst, newbkr.AccountId, op.Volume)
 
 				newbkr.TotalDelegations++
 				newbkr.ActiveDelegations++
@@ -1901,6 +1919,18 @@ func (b *Builder) AppendInternalOriginationOp(
 	return nil
 }
 
+// appendDelegationPeriod records a delegation change of acc to baker id bkr
+// (0 on withdraw). The delegation index closes any open period of acc and
+// opens a new period when bkr is set.
+func (b *Builder) appendDelegationPeriod(acc *model.Account, bkr model.AccountID, balance int64) {
+	b.block.Delegations = append(b.block.Delegations, &model.DelegationPeriod{
+		AccountId:    acc.RowId,
+		BakerId:      bkr,
+		StartHeight:  b.block.Height,
+		StartBalance: balance,
+	})
+}
+
 // Notes
 // - manager operation, extends grace period
 // - delegations may or may not pay a fee, so BalanceUpdates may be empty
@@ -2056,11 +2086,15 @@ func (b *Builder) AppendDelegationOp(ctx context.Context, oh *rpc.Operation, id
 					// if src.Balance() > 0 {
 					obkr.ActiveDelegations--
 					// }
+					b.appendDelegationPeriod(src, 0, op.Volume)
 				}
 
 			} else {
 				// handle delegator withdraw
 				if nbkr == nil {
+					if src.BakerId != 0 {
+						b.appendDelegationPeriod(src, 0, op.Volume)
+					}
 					src.IsDelegated = false
 					src.BakerId = 0
 					src.DelegatedSince = 0
@@ -2068,6 +2102,7 @@ func (b *Builder) AppendDelegationOp(ctx context.Context, oh *rpc.Operation, id
 					// handle switch to new baker
 					// only update when this delegation changes baker
 					if src.BakerId != nbkr.AccountId {
+						b.appendDelegationPeriod(src, nbkr.AccountId, op.Volume)
 						src.IsDelegated = true
 						src.BakerId = nbkr.AccountId
 						src.DelegatedSince = b.block.Height
@@ -2262,6 +2297,9 @@ func (b *Builder) AppendInternalDelegationOp(
 
 			// handle delegator withdraw
 			if nbkr == nil {
+				if src.BakerId != 0 {
+					b.appendDelegationPeriod(src, 0, op.Volume)
+				}
 				src.IsDelegated = false
 				src.BakerId = 0
 				src.DelegatedSince = 0
@@ -2271,6 +2309,7 @@ func (b *Builder) AppendInternalDelegationOp(
 			if nbkr != nil {
 				// only update when this delegation changes baker
 				if src.BakerId != nbkr.AccountId {
+					b.appendDelegationPeriod(src, nbkr.AccountId, op.Volume)
 					src.IsDelegated = true
 					src.BakerId = nbkr.AccountId
 					src.DelegatedSince = b.block.Height
@@ -2761,6 +2800,7 @@ func (b *Builder) AppendRollupTransactionOp(ctx context.Context, oh *rpc.Operati
 	if err != nil {
 		log.Error(Errorf("marshal parameters errors: %s", err))
 	}
+	op.TicketUpdates = res.TicketUpdates()
 
 	if op.IsSuccess {
 		flows := b.NewRollupTransactionFlows(
@@ -2850,3 +2890,122 @@ func (b *Builder) AppendRollupTransactionOp(ctx context.Context, oh *rpc.Operati
 
 	return nil
 }
+
+func (b *Builder) AppendSmartRollupOriginationOp(ctx context.Context, oh *rpc.Operation, id model.OpRef, rollback bool) error {
+	return b.appendSmartRollupOp(ctx, oh, id, rollback, true)
+}
+
+func (b *Builder) AppendSmartRollupTransactionOp(ctx context.Context, oh *rpc.Operation, id model.OpRef, rollback bool) error {
+	return b.appendSmartRollupOp(ctx, oh, id, rollback, false)
+}
+
+// Smart rollups are not accounts, so ops have no receiver. The target rollup
+// is stored along with op arguments in parameters and tracked by the rollup index.
+func (b *Builder) appendSmartRollupOp(ctx context.Context, oh *rpc.Operation, id model.OpRef, rollback, isOrigination bool) error {
+	o := id.Get(oh)
+
+	Errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf(
+			"%s op [%d:%d:%d]: "+format,
+			append([]interface{}{rpc.OpTypeString(o.Kind()), id.L, id.P, id.C}, args...)...,
+		)
+	}
+
+	rollup, ok := o.(*rpc.SmartRollup)
+	if !ok {
+		return Errorf("unexpected type %T", o)
+	}
+
+	src, ok := b.AccountByAddress(rollup.Source)
+	if !ok {
+		return Errorf("missing source account %s", rollup.Source)
+	}
+
+	res := rollup.Result()
+
+	// build op
+	op := model.NewOp(b.block, id)
+	op.SenderId = src.RowId
+	op.Counter = rollup.Counter
+	op.Fee = rollup.Fee
+	op.GasLimit = rollup.GasLimit
+	op.StorageLimit = rollup.StorageLimit
+	op.IsRollup = true
+	op.Status = res.Status
+	op.IsSuccess = res.Status.IsSuccess()
+	op.GasUsed = res.Gas()
+	op.StoragePaid = res.PaidStorageSizeDiff
+	op.Data = rpc.OpTypeString(rollup.Kind())
+	op.Entrypoint = int(rollup.Kind()) - int(rpc.OpTypeSmartRollupOriginate)
+
+	// add rollup address and args as parameters
+	var err error
+	op.Parameters, err = rollup.EncodeParameters().MarshalBinary()
+	if err != nil {
+		log.Error(Errorf("marshal parameters errors: %s", err))
+	}
+	op.TicketUpdates = res.TicketUpdates()
+
+	if op.IsSuccess {
+		flows := b.NewSmartRollupFlows(src, rollup.Fees(), res.Balances(), id)
+
+		for _, f := range flows {
+			switch {
+			case f.Category == model.FlowCategoryDelegation:
+				// skip
+			case f.IsBurned:
+				// storage or penalty
+				op.Burned += f.AmountOut
+			case f.Operation == model.FlowTypeRollupReward:
+				op.Reward += f.AmountIn
+			case f.Category == model.FlowCategoryBond && f.IsFrozen:
+				op.Deposit += f.AmountIn
+			}
+		}
+	} else {
+		// handle errors
+		if len(res.Errors) > 0 {
+			if buf, err := json.Marshal(res.Errors); err == nil {
+				op.Errors = buf
+			} else {
+				// non-fatal, but error data will be missing from index
+				log.Error(Errorf("marshal op errors: %s", err))
+			}
+		}
+
+		// fees only
+		_ = b.NewSmartRollupFlows(src, rollup.Fees(), nil, id)
+	}
+
+	// update sender account
+	if !rollback {
+		src.Counter = op.Counter
+		src.LastSeen = b.block.Height
+		src.NOps++
+		if isOrigination {
+			src.NOrigination++
+		} else {
+			src.NTx++
+		}
+		src.IsDirty = true
+		if !op.IsSuccess {
+			src.NOpsFailed++
+		}
+	} else {
+		src.Counter = op.Counter - 1
+		src.NOps--
+		if isOrigination {
+			src.NOrigination--
+		} else {
+			src.NTx--
+		}
+		src.IsDirty = true
+		if !op.IsSuccess {
+			src.NOpsFailed--
+		}
+	}
+
+	b.block.Ops = append(b.block.Ops, op)
+
+	return nil
+}
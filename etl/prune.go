@@ -0,0 +1,174 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/packdb/store"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+var ErrPruned = errors.New("history pruned")
+
+// PruneHorizon is the oldest cycle and height that is still fully indexed
+// after history pruning. A zero horizon means nothing was pruned.
+type PruneHorizon struct {
+	Cycle  int64 `json:"cycle"`
+	Height int64 `json:"height"`
+}
+
+// prunableIndexes lists indexes with historic data that can be deleted by
+// cycle without affecting current state.
+var prunableIndexes = []string{
+	index.OpIndexKey,
+	index.FlowIndexKey,
+	index.BalanceIndexKey,
+	index.StorageIndexKey,
+	index.BigmapIndexKey,
+	index.RightsIndexKey,
+	index.IncomeIndexKey,
+	index.ParamIndexKey,
+}
+
+func (m *Indexer) IsPruned() bool {
+	return m.Horizon().Height > 0
+}
+
+// Horizon returns the first cycle and height with complete history.
+func (m *Indexer) Horizon() PruneHorizon {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.horizon
+}
+
+// CheckPruned returns ErrPruned when history at height has been deleted.
+func (m *Indexer) CheckPruned(height int64) error {
+	if h := m.Horizon(); h.Height > 0 && height < h.Height {
+		return ErrPruned
+	}
+	return nil
+}
+
+// PruneBatchSize is the max number of cycles deleted per call to Prune.
+const PruneBatchSize = 4
+
+// Prune deletes history older than the configured retention window. Data of
+// unfrozen cycles is always kept because the builder still needs it to
+// process deposit and reward unfreezing. Deletion runs in the background and
+// removes at most PruneBatchSize cycles per call so that block indexing is
+// not stalled, later calls continue where the previous one stopped. Each
+// deletion step holds the lock block processing uses, so it never
+// interleaves with inserts into the same tables.
+func (m *Indexer) Prune(ctx context.Context, block *model.Block) error {
+	if m.retain <= 0 || block.Params == nil {
+		return nil
+	}
+	p := block.Params
+	keep := util.Max64(m.retain, p.PreservedCycles+2)
+	last := block.Cycle - keep - 1
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pruning || last < m.pruned {
+		return nil
+	}
+	first := m.pruned
+	last = util.Min64(last, first+PruneBatchSize-1)
+
+	idxs := make([]model.BlockIndexer, 0, len(prunableIndexes))
+	for _, key := range prunableIndexes {
+		if idx, err := m.Index(key); err == nil {
+			idxs = append(idxs, idx)
+		}
+	}
+	if len(idxs) == 0 {
+		return nil
+	}
+
+	// move the horizon first so that requests for deleted history fail
+	// instead of returning partial results, it is only persisted once all
+	// data is gone so that an interrupted run is repeated after restart
+	next := PruneHorizon{
+		Cycle:  last + 1,
+		Height: p.CycleStartHeight(last + 1),
+	}
+	prev := m.horizon
+	m.horizon = next
+	m.pruning = true
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := m.prune(ctx, idxs, first, last, next)
+		m.mu.Lock()
+		m.pruning = false
+		if err == nil {
+			m.pruned = next.Cycle
+		} else {
+			m.horizon = prev
+		}
+		m.mu.Unlock()
+		switch err {
+		case nil:
+			log.Infof("History pruned up to cycle %d (height %d).", last, next.Height-1)
+		case errInterruptRequested:
+		default:
+			log.Errorf("Pruning history up to cycle %d: %s", last, err)
+		}
+	}()
+	return nil
+}
+
+func (m *Indexer) prune(ctx context.Context, idxs []model.BlockIndexer, first, last int64, next PruneHorizon) error {
+	for cycle := first; cycle <= last; cycle++ {
+		log.Infof("Pruning history for cycle %d.", cycle)
+		for _, idx := range idxs {
+			m.bfmu.Lock()
+			err := idx.DeleteCycle(ctx, cycle)
+			m.bfmu.Unlock()
+			if err != nil {
+				return fmt.Errorf("%s: %w", idx.Name(), err)
+			}
+		}
+		if interruptRequested(ctx) {
+			return errInterruptRequested
+		}
+	}
+
+	// flush and compact pruned tables
+	for _, idx := range idxs {
+		if err := m.compact(ctx, idx); err != nil {
+			return err
+		}
+		if interruptRequested(ctx) {
+			return errInterruptRequested
+		}
+	}
+
+	// store the new horizon
+	return m.statedb.Update(func(dbTx store.Tx) error {
+		return dbStoreHorizon(dbTx, next)
+	})
+}
+
+// compact flushes and compacts the tables of idx while block processing is
+// blocked.
+func (m *Indexer) compact(ctx context.Context, idx model.BlockIndexer) error {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+	if err := idx.Flush(ctx); err != nil {
+		return err
+	}
+	for _, t := range idx.Tables() {
+		log.Debugf("Compacting %s.", t.Name())
+		if err := t.Compact(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"context"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// unprunable is an index that cannot delete cycles, like an index that has
+// not seen a block since startup.
+type unprunable struct {
+	model.BlockIndexer
+	cycles []int64
+}
+
+func (u *unprunable) Key() string  { return index.ParamIndexKey }
+func (u *unprunable) Name() string { return index.ParamIndexKey + " index" }
+
+func (u *unprunable) DeleteCycle(_ context.Context, cycle int64) error {
+	u.cycles = append(u.cycles, cycle)
+	return index.ErrNoParams
+}
+
+// TestPruneFailureKeepsHorizon checks that a failed prune run restores the
+// previous horizon so that requests for history that still exists succeed.
+func TestPruneFailureKeepsHorizon(t *testing.T) {
+	idx := &unprunable{}
+	m := &Indexer{indexes: []model.BlockIndexer{idx}, retain: 2}
+	p := tezos.NewParams()
+	p.BlocksPerCycle = 100
+	p.PreservedCycles = 1
+	block := &model.Block{Height: 1001, Cycle: 10, Params: p}
+
+	if err := m.Prune(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+
+	if len(idx.cycles) != 1 || idx.cycles[0] != 0 {
+		t.Errorf("got deleted cycles %v, want [0]", idx.cycles)
+	}
+	if h := m.Horizon(); h.Height != 0 || h.Cycle != 0 {
+		t.Errorf("got horizon %+v after failure, want none", h)
+	}
+	if m.pruned != 0 || m.pruning {
+		t.Errorf("got pruned=%d pruning=%t, want 0 false", m.pruned, m.pruning)
+	}
+	if err := m.CheckPruned(1); err != nil {
+		t.Errorf("history reported pruned after failure: %v", err)
+	}
+}
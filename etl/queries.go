@@ -4,6 +4,8 @@
 package etl
 
 import (
+	"bytes"
+	"container/heap"
 	"context"
 	"fmt"
 	"io"
@@ -14,10 +16,12 @@ import (
 
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/packdb/vec"
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
 	"blockwatch.cc/tzindex/etl/index"
 	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
 )
 
 type ListRequest struct {
@@ -37,7 +41,36 @@ type ListRequest struct {
 	BigmapId    int64
 	BigmapKey   tezos.ExprHash
 	OpId        model.OpID
+	RollupId    model.RollupID
+	TicketId    model.TicketTypeID
+	Params      []ParamFilter
 	WithStorage bool
+
+	// decoded bigmap key and value conditions and sort field
+	BigmapFilters []model.BigmapFilter
+	BigmapOrderBy string
+
+	// optional cost hook called once per decoded entry, scans stop
+	// with the returned error
+	Charge func(n int64) error
+}
+
+// ParamFilter selects contract calls by a decoded parameter value at Path.
+// Comparison modes (gt, gte, lt, lte) match numbers, all other modes match
+// string values.
+type ParamFilter struct {
+	Path  string
+	Mode  pack.FilterMode
+	Value interface{} // string, []string for in/nin, int64 for comparisons
+}
+
+func (f ParamFilter) IsNumeric() bool {
+	switch f.Mode {
+	case pack.FilterModeGt, pack.FilterModeGte, pack.FilterModeLt, pack.FilterModeLte:
+		return true
+	default:
+		return false
+	}
 }
 
 func (r ListRequest) WithDelegation() bool {
@@ -1486,6 +1519,18 @@ func (m *Indexer) ListContractCalls(ctx context.Context, r ListRequest) ([]*mode
 		q = q.AndEqual("sender_id", r.SenderId)
 	}
 
+	// resolve parameter filters to op ids
+	if len(r.Params) > 0 {
+		ids, err := m.findParamOps(ctx, r.Account.RowId, r.Params, r.Charge)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return []*model.Op{}, nil
+		}
+		q = q.AndIn("I", ids)
+	}
+
 	// add entrypoint filter
 	switch len(r.Entrypoints) {
 	case 0:
@@ -2027,6 +2072,402 @@ func (m *Indexer) ListBigmapKeys(ctx context.Context, r ListRequest) ([]*model.B
 	return items, nil
 }
 
+// FilterBigmapKeys lists live entries of bigmap alloc that match all decoded
+// key and value filters in r, optionally sorted by a decoded field. Candidates
+// are preselected from the bigmap field index when the bigmap is indexed,
+// otherwise all live entries are scanned. Sorted results keep at most
+// offset+limit entries in memory. Cursors are only supported without sort
+// field.
+func (m *Indexer) FilterBigmapKeys(ctx context.Context, alloc *model.BigmapAlloc, r ListRequest) ([]*model.BigmapKV, error) {
+	table, err := m.Table(index.BigmapValueTableKey)
+	if err != nil {
+		return nil, err
+	}
+	q := pack.NewQuery("api.filter_bigmap", table).
+		WithOrder(r.Order).
+		AndEqual("bigmap_id", alloc.BigmapId)
+
+	// use the field index to preselect key ids unless it is still catching up
+	if idx, err := m.Index(index.BigmapFieldIndexKey); err == nil && len(r.BigmapFilters) > 0 && !m.isBackfilling(idx.Key()) {
+		if fi, ok := idx.(*index.BigmapFieldIndex); ok && fi.IsIndexed(alloc.BigmapId) {
+			ids, err := m.findBigmapFieldKeys(ctx, alloc.BigmapId, r.BigmapFilters)
+			if err != nil {
+				return nil, err
+			}
+			if len(ids) == 0 {
+				return []*model.BigmapKV{}, nil
+			}
+			q = q.AndIn("key_id", ids)
+		}
+	}
+
+	sorted := r.BigmapOrderBy != ""
+	if r.Cursor > 0 && !sorted {
+		r.Offset = 0
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+
+	// sorted results keep the best offset+limit entries in a bounded heap
+	var (
+		keyType, valueType = alloc.GetKeyType(), alloc.GetValueType()
+		items              = make([]*model.BigmapKV, 0)
+		top                = &bigmapFieldHeap{desc: r.Order == pack.OrderDesc}
+		size               = int(r.Offset + r.Limit)
+	)
+	err = q.Stream(ctx, func(row pack.Row) error {
+		if r.Charge != nil {
+			if err := r.Charge(1); err != nil {
+				return err
+			}
+		}
+		b := &model.BigmapKV{}
+		if err := row.Decode(b); err != nil {
+			return err
+		}
+		val, err := model.DecodeBigmapEntry(b, keyType, valueType)
+		if err != nil {
+			log.Warnf("filter bigmap %d: decoding key %s: %v", alloc.BigmapId, b.GetKeyHash(), err)
+			return nil
+		}
+		for _, f := range r.BigmapFilters {
+			if !f.Match(val) {
+				return nil
+			}
+		}
+		if sorted {
+			// keep the first leaf at the sort path
+			var field interface{}
+			if v := model.BigmapFieldsAt(val, r.BigmapOrderBy); len(v) > 0 {
+				field = v[0]
+			}
+			heap.Push(top, bigmapFieldEntry{b, field})
+			if r.Limit > 0 && top.Len() > size {
+				heap.Pop(top)
+			}
+			return nil
+		}
+		if r.Offset > 0 {
+			r.Offset--
+			return nil
+		}
+		items = append(items, b)
+		if len(items) == int(r.Limit) {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !sorted {
+		return items, nil
+	}
+
+	// heap pops the last entry first
+	n := top.Len() - int(r.Offset)
+	if n <= 0 {
+		return []*model.BigmapKV{}, nil
+	}
+	items = make([]*model.BigmapKV, top.Len())
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(top).(bigmapFieldEntry).kv
+	}
+	items = items[r.Offset:]
+	if r.Limit > 0 && len(items) > int(r.Limit) {
+		items = items[:r.Limit]
+	}
+	return items, nil
+}
+
+type bigmapFieldEntry struct {
+	kv    *model.BigmapKV
+	field interface{}
+}
+
+// bigmapFieldHeap orders bigmap entries by sort field with the entry that
+// sorts last on top. Entries without field go last in both directions and
+// equal fields keep scan order.
+type bigmapFieldHeap struct {
+	list []bigmapFieldEntry
+	desc bool
+}
+
+// before returns true when a sorts before b.
+func (h *bigmapFieldHeap) before(a, b bigmapFieldEntry) bool {
+	var c int
+	if a.field == nil || b.field == nil || !h.desc {
+		c = model.CompareBigmapFields(a.field, b.field)
+	} else {
+		c = model.CompareBigmapFields(b.field, a.field)
+	}
+	if c != 0 {
+		return c < 0
+	}
+	if h.desc {
+		return a.kv.RowId > b.kv.RowId
+	}
+	return a.kv.RowId < b.kv.RowId
+}
+
+func (h *bigmapFieldHeap) Len() int           { return len(h.list) }
+func (h *bigmapFieldHeap) Less(i, j int) bool { return h.before(h.list[j], h.list[i]) }
+func (h *bigmapFieldHeap) Swap(i, j int)      { h.list[i], h.list[j] = h.list[j], h.list[i] }
+func (h *bigmapFieldHeap) Push(x interface{}) { h.list = append(h.list, x.(bigmapFieldEntry)) }
+func (h *bigmapFieldHeap) Pop() interface{} {
+	n := len(h.list) - 1
+	x := h.list[n]
+	h.list = h.list[:n]
+	return x
+}
+
+// BigmapKeyDiff is the net change of a single bigmap key between two heights.
+type BigmapKeyDiff struct {
+	Old *model.BigmapUpdate // live entry at the start height, nil for added keys
+	New *model.BigmapUpdate // last update until the end height, remove for removed keys
+}
+
+// DiffBigmap returns keys of bigmap id that were added, removed or changed
+// after height r.Since up to and including height r.Until. Repeated updates
+// to a key are collapsed and keys that end up with their original value are
+// skipped. When the bigmap is removed in range all keys live before are
+// reported as removed. Results are ordered by their last update. Each scanned
+// update row is charged to r.Charge. Returns ErrPruned when the bigmap has
+// keys written before the pruning horizon whose original value is unknown.
+func (m *Indexer) DiffBigmap(ctx context.Context, r ListRequest) ([]BigmapKeyDiff, error) {
+	table, err := m.Table(index.BigmapUpdateTableKey)
+	if err != nil {
+		return nil, err
+	}
+	charge := func() error {
+		if r.Charge != nil {
+			return r.Charge(1)
+		}
+		return nil
+	}
+
+	// find the last update per key in range
+	var (
+		last    = make(map[string]*model.BigmapUpdate)
+		ids     = make([]uint64, 0)
+		cleared *model.BigmapUpdate
+	)
+	err = pack.NewQuery("api.diff_bigmap", table).
+		AndEqual("bigmap_id", r.BigmapId).
+		AndGt("height", r.Since).
+		AndLte("height", r.Until).
+		Stream(ctx, func(row pack.Row) error {
+			if err := charge(); err != nil {
+				return err
+			}
+			upd := &model.BigmapUpdate{}
+			if err := row.Decode(upd); err != nil {
+				return err
+			}
+			switch upd.Action {
+			case micheline.DiffActionUpdate, micheline.DiffActionRemove:
+				// key is empty when the entire bigmap is removed
+				if len(upd.Key) == 0 {
+					if upd.Action == micheline.DiffActionRemove {
+						cleared = upd
+					}
+					return nil
+				}
+				last[upd.GetKeyHash().String()] = upd
+				ids = append(ids, upd.KeyId)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if len(last) == 0 && cleared == nil {
+		return []BigmapKeyDiff{}, nil
+	}
+
+	// find live entries at the start height, all of them when the bigmap
+	// was removed, otherwise only touched keys
+	prev := make(map[string]*model.BigmapUpdate)
+	q := pack.NewQuery("api.diff_bigmap_prev", table).
+		AndEqual("bigmap_id", r.BigmapId).
+		AndLte("height", r.Since)
+	if cleared == nil {
+		q = q.AndIn("key_id", vec.UniqueUint64Slice(ids))
+	}
+	err = q.Stream(ctx, func(row pack.Row) error {
+		if err := charge(); err != nil {
+			return err
+		}
+		upd := &model.BigmapUpdate{}
+		if err := row.Decode(upd); err != nil {
+			return err
+		}
+		if len(upd.Key) == 0 {
+			return nil
+		}
+		// skip hash collisions on key_id
+		hash := upd.GetKeyHash().String()
+		if _, ok := last[hash]; !ok && cleared == nil {
+			return nil
+		}
+		switch upd.Action {
+		case micheline.DiffActionUpdate:
+			prev[hash] = upd
+		case micheline.DiffActionRemove:
+			delete(prev, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// bigmap removal ends all keys that were live before
+	if cleared != nil {
+		for hash, old := range prev {
+			if _, ok := last[hash]; !ok {
+				last[hash] = bigmapKeyRemove(cleared, old)
+			}
+		}
+		for hash, upd := range last {
+			if upd.Action == micheline.DiffActionUpdate && upd.RowId < cleared.RowId {
+				last[hash] = bigmapKeyRemove(cleared, upd)
+			}
+		}
+	}
+
+	// original values of keys written before the pruning horizon are gone
+	var pruned bool
+	if h := m.Horizon(); h.Height > 0 {
+		alloc, err := m.LookupBigmapAlloc(ctx, r.BigmapId)
+		if err != nil {
+			return nil, err
+		}
+		pruned = alloc.Height < h.Height
+	}
+
+	items := make([]BigmapKeyDiff, 0, len(last))
+	for hash, upd := range last {
+		old := prev[hash]
+		switch {
+		case old == nil && pruned:
+			return nil, ErrPruned
+		case old == nil && upd.Action == micheline.DiffActionRemove:
+			// added and removed again
+			continue
+		case old != nil && upd.Action == micheline.DiffActionUpdate && bytes.Equal(old.Value, upd.Value):
+			// changed back to the original value
+			continue
+		}
+		items = append(items, BigmapKeyDiff{Old: old, New: upd})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i].New, items[j].New
+		if a.RowId == b.RowId {
+			// keys removed together with the bigmap
+			return bytes.Compare(a.Key, b.Key) < 0
+		}
+		if r.Order == pack.OrderDesc {
+			return a.RowId > b.RowId
+		}
+		return a.RowId < b.RowId
+	})
+	if int(r.Offset) >= len(items) {
+		return []BigmapKeyDiff{}, nil
+	}
+	items = items[r.Offset:]
+	if r.Limit > 0 && len(items) > int(r.Limit) {
+		items = items[:r.Limit]
+	}
+	return items, nil
+}
+
+// bigmapKeyRemove returns a removal of the key in upd caused by the bigmap
+// removal cleared.
+func bigmapKeyRemove(cleared, upd *model.BigmapUpdate) *model.BigmapUpdate {
+	return &model.BigmapUpdate{
+		RowId:     cleared.RowId,
+		BigmapId:  cleared.BigmapId,
+		KeyId:     upd.KeyId,
+		Action:    micheline.DiffActionRemove,
+		OpId:      cleared.OpId,
+		Height:    cleared.Height,
+		Timestamp: cleared.Timestamp,
+		Key:       upd.Key,
+	}
+}
+
+// findBigmapFieldKeys returns a sorted list of key ids that are candidates for
+// matching all filters. Entries that overflowed the field index are always
+// included. Results are a superset which must be checked by decoding entries.
+func (m *Indexer) findBigmapFieldKeys(ctx context.Context, id int64, filters []model.BigmapFilter) ([]uint64, error) {
+	table, err := m.Table(index.BigmapFieldTableKey)
+	if err != nil {
+		return nil, err
+	}
+	stream := func(q pack.Query) ([]uint64, error) {
+		match := make([]uint64, 0)
+		err := q.Stream(ctx, func(r pack.Row) error {
+			f := &model.BigmapField{}
+			if err := r.Decode(f); err != nil {
+				return err
+			}
+			match = append(match, f.KeyId)
+			return nil
+		})
+		return match, err
+	}
+	overflow, err := stream(pack.NewQuery("api.find_bigmap_overflow", table).
+		WithFields("key_id").
+		AndEqual("bigmap_id", id).
+		AndEqual("path", ""))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for i, f := range filters {
+		q := pack.NewQuery("api.find_bigmap_fields", table).
+			WithFields("key_id").
+			AndEqual("bigmap_id", id).
+			AndEqual("path", f.Path)
+		switch f.Mode {
+		case pack.FilterModeIn, pack.FilterModeNotIn:
+			q = q.AndCondition("value", f.Mode, f.Values)
+		case pack.FilterModeRegexp:
+			q = q.AndRegexp("value", f.Prefix())
+		case pack.FilterModeGt, pack.FilterModeGte, pack.FilterModeLt, pack.FilterModeLte:
+			switch {
+			case f.Num == nil:
+				q = q.AndEqual("is_num", false).AndCondition("value", f.Mode, f.Value)
+			case f.Num.IsInt64():
+				q = q.AndEqual("is_num", true).AndCondition("int", f.Mode, f.Num.Int64())
+			default:
+				// out of range for the clamped index column
+				q = q.AndEqual("is_num", true)
+			}
+		default:
+			q = q.AndCondition("value", f.Mode, f.Value)
+		}
+		match, err := stream(q)
+		if err != nil {
+			return nil, err
+		}
+		match = vec.UniqueUint64Slice(append(match, overflow...))
+		if i == 0 {
+			ids = match
+		} else {
+			ids = vec.IntersectSortedUint64(ids, match, nil)
+		}
+		if len(ids) == 0 {
+			break
+		}
+	}
+	return ids, nil
+}
+
 func (m *Indexer) ListBigmapUpdates(ctx context.Context, r ListRequest) ([]model.BigmapUpdate, error) {
 	table, err := m.Table(index.BigmapUpdateTableKey)
 	if err != nil {
@@ -2295,6 +2736,35 @@ func (m *Indexer) FindPreviousStorage(ctx context.Context, id model.AccountID, s
 	return store, nil
 }
 
+// LookupPreviousStorage returns contract storage as it was before op was
+// applied. When the contract was called earlier in the same block the storage
+// after that call is returned, otherwise the last storage update before the
+// block.
+func (m *Indexer) LookupPreviousStorage(ctx context.Context, op *model.Op) (*model.Storage, error) {
+	table, err := m.Table(index.OpTableKey)
+	if err != nil {
+		return nil, err
+	}
+	prev := &model.Op{}
+	err = pack.NewQuery("api.storage.prev_call", table).
+		WithFields("I", "storage_hash").
+		WithDesc().
+		WithLimit(1).
+		AndEqual("height", op.Height).
+		AndEqual("receiver_id", op.ReceiverId).
+		AndEqual("is_success", true).
+		AndLt("I", op.RowId).
+		AndGt("storage_hash", 0).
+		Execute(ctx, prev)
+	if err != nil {
+		return nil, err
+	}
+	if prev.RowId > 0 {
+		return m.LookupStorage(ctx, op.ReceiverId, prev.StorageHash, op.Height, op.Height)
+	}
+	return m.FindPreviousStorage(ctx, op.ReceiverId, 0, op.Height-1)
+}
+
 func (m *Indexer) LookupStorage(ctx context.Context, id model.AccountID, h uint64, since, until int64) (*model.Storage, error) {
 	table, err := m.Table(index.StorageTableKey)
 	if err != nil {
@@ -2318,3 +2788,643 @@ func (m *Indexer) LookupStorage(ctx context.Context, id model.AccountID, h uint6
 	}
 	return store, nil
 }
+
+func (m *Indexer) LookupSmartRollup(ctx context.Context, addr rpc.SmartRollupHash) (*model.SmartRollup, error) {
+	if !addr.IsValid() || addr.Type != rpc.SmartRollupHashTypeAddress {
+		return nil, ErrInvalidHash
+	}
+	table, err := m.Table(index.RollupTableKey)
+	if err != nil {
+		return nil, err
+	}
+	r := &model.SmartRollup{}
+	err = pack.NewQuery("api.rollup.lookup", table).
+		AndEqual("address", addr.Bytes()).
+		Execute(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if r.RowId == 0 {
+		return nil, index.ErrNoRollupEntry
+	}
+	return r, nil
+}
+
+func (m *Indexer) ListRollupCommitments(ctx context.Context, r ListRequest) ([]*model.RollupCommitment, error) {
+	table, err := m.Table(index.RollupCommitmentTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.rollup.list_commitments", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset)).
+		AndEqual("rollup_id", r.RollupId)
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	if r.Since > 0 {
+		q = q.AndGte("height", r.Since)
+	}
+	if r.Until > 0 {
+		q = q.AndLte("height", r.Until)
+	}
+	if r.SenderId > 0 {
+		q = q.AndEqual("staker_id", r.SenderId)
+	}
+	items := make([]*model.RollupCommitment, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListRollupMessages lists outbox messages executed for a rollup or when
+// r.RollupId is zero messages from the shared inbox.
+func (m *Indexer) ListRollupMessages(ctx context.Context, r ListRequest) ([]*model.RollupMessage, error) {
+	table, err := m.Table(index.RollupMessageTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.rollup.list_messages", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset)).
+		AndEqual("rollup_id", r.RollupId).
+		AndEqual("is_outbox", r.RollupId > 0)
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	if r.Since > 0 {
+		q = q.AndGte("height", r.Since)
+	}
+	if r.Until > 0 {
+		q = q.AndLte("height", r.Until)
+	}
+	if r.SenderId > 0 {
+		q = q.AndEqual("sender_id", r.SenderId)
+	}
+	items := make([]*model.RollupMessage, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (m *Indexer) LookupTicketType(ctx context.Context, id model.TicketTypeID) (*model.TicketType, error) {
+	table, err := m.Table(index.TicketTypeTableKey)
+	if err != nil {
+		return nil, err
+	}
+	t := &model.TicketType{}
+	err = pack.NewQuery("api.ticket.lookup", table).
+		AndEqual("I", id).
+		Execute(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if t.RowId == 0 {
+		return nil, index.ErrNoTicketEntry
+	}
+	return t, nil
+}
+
+func (m *Indexer) LookupTicketTypeIds(ctx context.Context, ids []uint64) ([]*model.TicketType, error) {
+	table, err := m.Table(index.TicketTypeTableKey)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]*model.TicketType, 0, len(ids))
+	err = pack.NewQuery("api.ticket.lookup_ids", table).
+		AndIn("I", ids).
+		Execute(ctx, &types)
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// ListTicketBalances lists non-zero ticket balances of a holder (r.Account)
+// or all holders of a ticket type (r.TicketId).
+func (m *Indexer) ListTicketBalances(ctx context.Context, r ListRequest) ([]*model.TicketBalance, error) {
+	table, err := m.Table(index.TicketBalanceTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.ticket.list_balances", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset)).
+		AndGt("balance", 0)
+	if r.Account != nil {
+		q = q.AndEqual("account_id", r.Account.RowId)
+	}
+	if r.TicketId > 0 {
+		q = q.AndEqual("ticket_id", r.TicketId)
+	}
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	items := make([]*model.TicketBalance, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListTicketUpdates lists ticket transfers of a ticket type and/or account.
+func (m *Indexer) ListTicketUpdates(ctx context.Context, r ListRequest) ([]*model.TicketUpdate, error) {
+	table, err := m.Table(index.TicketUpdateTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.ticket.list_updates", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset))
+	if r.Account != nil {
+		q = q.AndEqual("account_id", r.Account.RowId)
+	}
+	if r.TicketId > 0 {
+		q = q.AndEqual("ticket_id", r.TicketId)
+	}
+	if r.OpId > 0 {
+		q = q.AndEqual("op_id", r.OpId)
+	}
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	if r.Since > 0 {
+		q = q.AndGte("height", r.Since)
+	}
+	if r.Until > 0 {
+		q = q.AndLte("height", r.Until)
+	}
+	items := make([]*model.TicketUpdate, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (m *Indexer) LookupSaplingPool(ctx context.Context, id int64) (*model.SaplingPool, error) {
+	table, err := m.Table(index.SaplingPoolTableKey)
+	if err != nil {
+		return nil, err
+	}
+	p := &model.SaplingPool{}
+	err = pack.NewQuery("api.sapling.lookup", table).
+		AndEqual("pool_id", id).
+		Execute(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.RowId == 0 {
+		return nil, index.ErrNoSaplingEntry
+	}
+	return p, nil
+}
+
+// ListSaplingTxs lists state updates of sapling pool r.BigmapId (sapling states
+// share the lazy storage id space with bigmaps).
+func (m *Indexer) ListSaplingTxs(ctx context.Context, r ListRequest) ([]*model.SaplingTx, error) {
+	table, err := m.Table(index.SaplingTxTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.sapling.list_txs", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset)).
+		AndEqual("pool_id", r.BigmapId)
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	if r.Since > 0 {
+		q = q.AndGte("height", r.Since)
+	}
+	if r.Until > 0 {
+		q = q.AndLte("height", r.Until)
+	}
+	items := make([]*model.SaplingTx, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListReorgs lists recorded chain reorganizations. Since and Until filter
+// by fork height.
+func (m *Indexer) ListReorgs(ctx context.Context, r ListRequest) ([]*model.Reorg, error) {
+	table, err := m.Table(index.ReorgTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.list_reorgs", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset))
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	if r.Since > 0 {
+		q = q.AndGte("fork_height", r.Since)
+	}
+	if r.Until > 0 {
+		q = q.AndLte("fork_height", r.Until)
+	}
+	items := make([]*model.Reorg, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	for _, v := range items {
+		if err := v.Decode(); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// LookupOrphan finds the most recent reorg that orphaned block h.
+func (m *Indexer) LookupOrphan(ctx context.Context, h tezos.BlockHash) (*model.Reorg, *model.ReorgBlock, error) {
+	if !h.IsValid() {
+		return nil, nil, fmt.Errorf("invalid block hash %s", h)
+	}
+	table, err := m.Table(index.ReorgTableKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		reorg  *model.Reorg
+		orphan *model.ReorgBlock
+	)
+	err = pack.NewQuery("api.lookup_orphan", table).
+		WithDesc().
+		Stream(ctx, func(row pack.Row) error {
+			r := &model.Reorg{}
+			if err := row.Decode(r); err != nil {
+				return err
+			}
+			if err := r.Decode(); err != nil {
+				return err
+			}
+			if b := r.Orphan(h); b != nil {
+				reorg, orphan = r, b
+				return io.EOF
+			}
+			return nil
+		})
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if orphan == nil {
+		return nil, nil, index.ErrNoOrphanEntry
+	}
+	return reorg, orphan, nil
+}
+
+// ListDelegationPeriods lists delegation periods of a delegator (r.Account).
+func (m *Indexer) ListDelegationPeriods(ctx context.Context, r ListRequest) ([]*model.DelegationPeriod, error) {
+	table, err := m.Table(index.DelegationTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.list_delegation_periods", table).
+		WithOrder(r.Order).
+		WithLimit(int(r.Limit)).
+		WithOffset(int(r.Offset)).
+		AndEqual("account_id", r.Account.RowId)
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	items := make([]*model.DelegationPeriod, 0)
+	if err := q.Execute(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListDelegators lists delegation periods of baker r.Account that were active
+// at the end of block r.Until.
+func (m *Indexer) ListDelegators(ctx context.Context, r ListRequest) ([]*model.DelegationPeriod, error) {
+	table, err := m.Table(index.DelegationTableKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cursor > 0 {
+		r.Offset = 0
+	}
+	q := pack.NewQuery("api.list_delegators", table).
+		WithOrder(r.Order).
+		AndEqual("baker_id", r.Account.RowId).
+		AndLte("start_height", r.Until)
+	if r.Cursor > 0 {
+		if r.Order == pack.OrderDesc {
+			q = q.AndLt("I", r.Cursor)
+		} else {
+			q = q.AndGt("I", r.Cursor)
+		}
+	}
+	items := make([]*model.DelegationPeriod, 0)
+	err = q.Stream(ctx, func(row pack.Row) error {
+		p := &model.DelegationPeriod{}
+		if err := row.Decode(p); err != nil {
+			return err
+		}
+		if !p.IsActive(r.Until) {
+			return nil
+		}
+		if r.Offset > 0 {
+			r.Offset--
+			return nil
+		}
+		items = append(items, p)
+		if r.Limit > 0 && len(items) == int(r.Limit) {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return items, nil
+}
+
+// LookupAccounts resolves a list of addresses with a single query. Unknown
+// addresses are not contained in the result.
+func (m *Indexer) LookupAccounts(ctx context.Context, addrs []tezos.Address) ([]*model.Account, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	table, err := m.Table(index.AccountTableKey)
+	if err != nil {
+		return nil, err
+	}
+	lookup := make([][]byte, 0, len(addrs))
+	for _, v := range addrs {
+		lookup = append(lookup, v.Bytes22())
+	}
+	accs := make([]*model.Account, 0, len(addrs))
+	err = pack.NewQuery("api.account.lookup_addrs", table).
+		AndIn("address", lookup).
+		Execute(ctx, &accs)
+	if err != nil {
+		return nil, err
+	}
+	return accs, nil
+}
+
+// LookupContracts resolves a list of contract addresses with a single query.
+// Unknown addresses are not contained in the result.
+func (m *Indexer) LookupContracts(ctx context.Context, addrs []tezos.Address) ([]*model.Contract, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	table, err := m.Table(index.ContractTableKey)
+	if err != nil {
+		return nil, err
+	}
+	lookup := make([][]byte, 0, len(addrs))
+	for _, v := range addrs {
+		lookup = append(lookup, v.Bytes22())
+	}
+	ccs := make([]*model.Contract, 0, len(addrs))
+	err = pack.NewQuery("api.contract.lookup_addrs", table).
+		AndIn("address", lookup).
+		Execute(ctx, &ccs)
+	if err != nil {
+		return nil, err
+	}
+	return ccs, nil
+}
+
+// LookupOpHashes loads all operations matching any of the given hashes with
+// a single query. Storage is not merged.
+func (m *Indexer) LookupOpHashes(ctx context.Context, hashes []tezos.OpHash) ([]*model.Op, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	table, err := m.Table(index.OpTableKey)
+	if err != nil {
+		return nil, err
+	}
+	lookup := make([][]byte, 0, len(hashes))
+	for _, v := range hashes {
+		lookup = append(lookup, v.Hash.Hash[:])
+	}
+	ops := make([]*model.Op, 0, len(hashes))
+	err = pack.NewQuery("api.op.lookup_hashes", table).
+		AndIn("hash", lookup).
+		Execute(ctx, &ops)
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// LookupEndorsementHashes loads all endorsements matching any of the given
+// hashes with a single query.
+func (m *Indexer) LookupEndorsementHashes(ctx context.Context, hashes []tezos.OpHash) ([]*model.Op, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	table, err := m.Table(index.EndorseOpTableKey)
+	if err != nil {
+		return nil, err
+	}
+	lookup := make([][]byte, 0, len(hashes))
+	for _, v := range hashes {
+		lookup = append(lookup, v.Hash.Hash[:])
+	}
+	ops := make([]*model.Op, 0, len(hashes))
+	q := pack.NewQuery("api.endorsement.lookup_hashes", table).
+		AndIn("hash", lookup)
+	err = table.Stream(ctx, q, func(r pack.Row) error {
+		ed := &model.Endorsement{}
+		if err := r.Decode(ed); err != nil {
+			return err
+		}
+		ops = append(ops, ed.ToOp())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// LookupBigmapKeys loads current values for a list of key hashes in bigmap id
+// with a single query. Keys without value are not contained in the result.
+func (m *Indexer) LookupBigmapKeys(ctx context.Context, id int64, hashes []tezos.ExprHash) ([]*model.BigmapKV, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	table, err := m.Table(index.BigmapValueTableKey)
+	if err != nil {
+		return nil, err
+	}
+	lookup := make([]uint64, 0, len(hashes))
+	want := make(map[string]struct{}, len(hashes))
+	for _, v := range hashes {
+		lookup = append(lookup, model.GetKeyId(id, v))
+		want[v.String()] = struct{}{}
+	}
+	items := make([]*model.BigmapKV, 0, len(hashes))
+	err = pack.NewQuery("api.bigmap.lookup_keys", table).
+		AndEqual("bigmap_id", id).
+		AndIn("key_id", vec.UniqueUint64Slice(lookup)).
+		Stream(ctx, func(r pack.Row) error {
+			b := &model.BigmapKV{}
+			if err := r.Decode(b); err != nil {
+				return err
+			}
+			// skip hash collisions on key_id
+			if _, ok := want[b.GetKeyHash().String()]; !ok {
+				return nil
+			}
+			items = append(items, b)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListGasStats returns per-entrypoint gas statistics for contract id merged
+// over all cycles since cycle, with decoded sketches.
+func (m *Indexer) ListGasStats(ctx context.Context, id model.AccountID, cycle int64) ([]*model.GasStats, error) {
+	table, err := m.Table(index.GasStatsTableKey)
+	if err != nil {
+		return nil, err
+	}
+	byEntrypoint := make(map[int]*model.GasStats)
+	err = pack.NewQuery("api.list_gas_stats", table).
+		AndEqual("account_id", id).
+		AndGte("cycle", cycle).
+		Stream(ctx, func(row pack.Row) error {
+			s := &model.GasStats{}
+			if err := row.Decode(s); err != nil {
+				return err
+			}
+			if s.NCalls == 0 {
+				return nil
+			}
+			if err := s.Decode(); err != nil {
+				return err
+			}
+			if prev, ok := byEntrypoint[s.Entrypoint]; ok {
+				prev.Merge(s)
+			} else {
+				byEntrypoint[s.Entrypoint] = s
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*model.GasStats, 0, len(byEntrypoint))
+	for _, v := range byEntrypoint {
+		items = append(items, v)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Entrypoint < items[j].Entrypoint })
+	return items, nil
+}
+
+// findParamOps returns sorted ids of calls to contract id whose decoded
+// parameters match all filters. Each matching param row is charged to the
+// optional charge hook and scans stop with its error.
+func (m *Indexer) findParamOps(ctx context.Context, id model.AccountID, filters []ParamFilter, charge func(n int64) error) ([]uint64, error) {
+	table, err := m.Table(index.ParamTableKey)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for i, f := range filters {
+		q := pack.NewQuery("api.find_param_ops", table).
+			WithFields("op_id").
+			AndEqual("account_id", id).
+			AndEqual("path", f.Path)
+		if f.IsNumeric() {
+			q = q.AndEqual("is_num", true).AndCondition("int", f.Mode, f.Value)
+		} else {
+			q = q.AndCondition("value", f.Mode, f.Value)
+		}
+		match := make([]uint64, 0)
+		err := q.Stream(ctx, func(r pack.Row) error {
+			if charge != nil {
+				if err := charge(1); err != nil {
+					return err
+				}
+			}
+			v := &model.ParamValue{}
+			if err := r.Decode(v); err != nil {
+				return err
+			}
+			match = append(match, v.OpId.Value())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		match = vec.UniqueUint64Slice(match)
+		if i == 0 {
+			ids = match
+		} else {
+			ids = vec.IntersectSortedUint64(ids, match, nil)
+		}
+		if len(ids) == 0 {
+			break
+		}
+	}
+	return ids, nil
+}
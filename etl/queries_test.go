@@ -0,0 +1,202 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"blockwatch.cc/packdb/pack"
+	_ "blockwatch.cc/packdb/store/bolt"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+// TestBigmapFieldHeap checks that the bounded heap used for sorted bigmap
+// filters returns the same page as a full sort.
+func TestBigmapFieldHeap(t *testing.T) {
+	// row id -> sort field, nil has no field, equal fields keep scan order
+	// which is descending by row id for descending requests
+	fields := []interface{}{big.NewInt(5), nil, big.NewInt(1), big.NewInt(9), big.NewInt(5), nil, big.NewInt(3)}
+	tests := []struct {
+		name  string
+		desc  bool
+		size  int
+		order []uint64
+	}{
+		{"asc", false, 7, []uint64{3, 7, 1, 5, 4, 2, 6}},
+		{"asc top 3", false, 3, []uint64{3, 7, 1}},
+		{"desc", true, 7, []uint64{4, 5, 1, 7, 3, 6, 2}},
+		{"desc top 4", true, 4, []uint64{4, 5, 1, 7}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &bigmapFieldHeap{desc: tc.desc}
+			for i, f := range fields {
+				heap.Push(h, bigmapFieldEntry{&model.BigmapKV{RowId: uint64(i + 1)}, f})
+				if h.Len() > tc.size {
+					heap.Pop(h)
+				}
+			}
+			got := make([]uint64, h.Len())
+			for i := len(got) - 1; i >= 0; i-- {
+				got[i] = heap.Pop(h).(bigmapFieldEntry).kv.RowId
+			}
+			if len(got) != len(tc.order) {
+				t.Fatalf("got %v, want %v", got, tc.order)
+			}
+			for i := range got {
+				if got[i] != tc.order[i] {
+					t.Fatalf("got %v, want %v", got, tc.order)
+				}
+			}
+		})
+	}
+}
+
+// TestFindParamOps checks that param filters select calls by path and value
+// and that multiple filters must all match.
+func TestFindParamOps(t *testing.T) {
+	ctx := context.Background()
+	idx := index.NewParamIndex(nil, pack.Options{})
+	dir := t.TempDir()
+	if err := idx.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	m := &Indexer{tables: make(map[string]*pack.Table)}
+	for _, v := range idx.Tables() {
+		m.tables[v.Name()] = v
+	}
+
+	// calls 1-3 to contract 1, call 4 to contract 2
+	vals := []*model.ParamValue{
+		{OpId: 1, AccountId: 1, Path: "to", Value: "alice"},
+		{OpId: 1, AccountId: 1, Path: "amount", Value: "100", Int: 100, IsNum: true},
+		{OpId: 2, AccountId: 1, Path: "to", Value: "bob"},
+		{OpId: 2, AccountId: 1, Path: "amount", Value: "5", Int: 5, IsNum: true},
+		{OpId: 3, AccountId: 1, Path: "txs.to", Value: "alice"},
+		{OpId: 3, AccountId: 1, Path: "txs.to", Value: "carol"},
+		{OpId: 3, AccountId: 1, Path: "amount", Value: "abc"},
+		{OpId: 4, AccountId: 2, Path: "to", Value: "alice"},
+	}
+	ins := make([]pack.Item, 0, len(vals))
+	for _, v := range vals {
+		ins = append(ins, v)
+	}
+	if err := m.tables[index.ParamTableKey].Insert(ctx, ins); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		filters []ParamFilter
+		want    []uint64
+	}{
+		{"equal", []ParamFilter{{"to", pack.FilterModeEqual, "alice"}}, []uint64{1}},
+		{"in", []ParamFilter{{"to", pack.FilterModeIn, []string{"alice", "bob"}}}, []uint64{1, 2}},
+		{"list leaves", []ParamFilter{{"txs.to", pack.FilterModeEqual, "carol"}}, []uint64{3}},
+		{"numeric", []ParamFilter{{"amount", pack.FilterModeGt, int64(10)}}, []uint64{1}},
+		{"numeric skips strings", []ParamFilter{{"amount", pack.FilterModeLt, int64(1000)}}, []uint64{1, 2}},
+		{"all filters match", []ParamFilter{
+			{"to", pack.FilterModeIn, []string{"alice", "bob"}},
+			{"amount", pack.FilterModeLte, int64(5)},
+		}, []uint64{2}},
+		{"no match", []ParamFilter{
+			{"to", pack.FilterModeEqual, "alice"},
+			{"amount", pack.FilterModeLt, int64(5)},
+		}, nil},
+		{"unknown path", []ParamFilter{{"from", pack.FilterModeEqual, "alice"}}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, err := m.findParamOps(ctx, 1, tc.filters, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != len(tc.want) {
+				t.Fatalf("got %v, want %v", ids, tc.want)
+			}
+			for i := range ids {
+				if ids[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", ids, tc.want)
+				}
+			}
+		})
+	}
+
+	// each scanned param row is charged and the scan stops on error
+	var charged int64
+	errBudget := errors.New("budget exceeded")
+	filters := []ParamFilter{{"to", pack.FilterModeIn, []string{"alice", "bob"}}}
+	if _, err := m.findParamOps(ctx, 1, filters, func(n int64) error {
+		charged += n
+		return nil
+	}); err != nil || charged != 2 {
+		t.Errorf("got charged=%d err=%v, want 2 rows", charged, err)
+	}
+	charged = 0
+	_, err := m.findParamOps(ctx, 1, filters, func(n int64) error {
+		if charged += n; charged > 1 {
+			return errBudget
+		}
+		return nil
+	})
+	if !errors.Is(err, errBudget) || charged != 2 {
+		t.Errorf("got charged=%d err=%v, want budget error after 2 rows", charged, err)
+	}
+}
+
+// TestLookupEndorsementHashes checks that endorsements matching any of the
+// given hashes are returned by a single lookup.
+func TestLookupEndorsementHashes(t *testing.T) {
+	ctx := context.Background()
+	idx := index.NewOpIndex(pack.Options{})
+	dir := t.TempDir()
+	if err := idx.Create(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Init(dir, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	m := &Indexer{tables: make(map[string]*pack.Table)}
+	for _, v := range idx.Tables() {
+		m.tables[v.Name()] = v
+	}
+
+	hashes := make([]tezos.OpHash, 4)
+	for i := range hashes {
+		hashes[i] = tezos.NewOpHash([]byte{31: byte(i + 1)})
+	}
+	ins := make([]pack.Item, 0, 3)
+	for i, h := range hashes[:3] {
+		ins = append(ins, &model.Endorsement{Hash: h, Height: 10, OpN: i, SenderId: model.AccountID(i + 1)})
+	}
+	if err := m.tables[index.EndorseOpTableKey].Insert(ctx, ins); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := m.LookupEndorsementHashes(ctx, []tezos.OpHash{hashes[0], hashes[2], hashes[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, v := range ops {
+		if v.Type != model.OpTypeEndorsement {
+			t.Errorf("got op type %s, want endorsement", v.Type)
+		}
+		got[v.Hash.String()] = true
+	}
+	if len(ops) != 2 || !got[hashes[0].String()] || !got[hashes[2].String()] {
+		t.Errorf("got %d ops %v, want hashes 1 and 3", len(ops), got)
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"container/list"
 	"context"
 	"fmt"
+	"time"
 
+	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/store"
 	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
 
+	"blockwatch.cc/tzindex/etl/index"
 	"blockwatch.cc/tzindex/etl/model"
 )
 
@@ -72,11 +76,29 @@ func (c *Crawler) reorganize(ctx context.Context, formerBest, newBest *model.Blo
 		}
 	}
 
+	// cache snapshots no longer match indexed history
+	c.indexer.DropCaches()
+
 	// start reorg by flushing all tables
 	if err := c.indexer.Flush(ctx); err != nil {
 		return fmt.Errorf("flushing tables: %w", err)
 	}
 
+	// keep a record of orphaned blocks and reverted rows
+	var rec *model.Reorg
+	if detach.Len() > 0 {
+		rec = &model.Reorg{
+			Timestamp:  time.Now().UTC(),
+			ForkHeight: forkBlock.Height,
+			ForkHash:   forkBlock.Hash.Clone(),
+			Depth:      detach.Len(),
+			NAttached:  attach.Len(),
+			OldTip:     formerBest.Hash.Clone(),
+			IsRollback: rollbackOnly,
+			Orphans:    make([]*model.ReorgBlock, 0, detach.Len()),
+		}
+	}
+
 	// Disconnect all of the blocks back to the fork point.
 	tip := c.Tip()
 
@@ -121,6 +143,12 @@ func (c *Crawler) reorganize(ctx context.Context, formerBest, newBest *model.Blo
 			}
 
 			// update indexes to rollback block
+			orphan, err := c.indexer.newReorgBlock(ctx, block)
+			if err != nil {
+				return err
+			}
+			rec.Orphans = append(rec.Orphans, orphan)
+			rec.NOps += orphan.NOps
 
 			// disconnect block from indexes
 			log.Infof("REORGANIZE: dropping indexes for block %d %s", block.Height, block.Hash)
@@ -257,6 +285,14 @@ func (c *Crawler) reorganize(ctx context.Context, formerBest, newBest *model.Blo
 		return fmt.Errorf("flushing tables: %w", err)
 	}
 
+	// audit records are not essential, so don't fail here
+	if rec != nil {
+		rec.NewTip = tip.BestHash.Clone()
+		if err := c.indexer.StoreReorg(ctx, rec); err != nil {
+			log.Errorf("REORGANIZE: storing reorg record: %v", err)
+		}
+	}
+
 	log.Infof("REORGANIZE: completed successfully at %s (height %d).",
 		tip.BestHash, tip.BestHeight)
 
@@ -418,3 +454,71 @@ findfork:
 
 	return ancestor, detachBlocks, attachBlocks, nil
 }
+
+// StoreReorg persists a reorg record.
+func (m *Indexer) StoreReorg(ctx context.Context, r *model.Reorg) error {
+	table, err := m.Table(index.ReorgTableKey)
+	if err != nil {
+		return err
+	}
+	if err := r.Encode(); err != nil {
+		return err
+	}
+	if err := table.Insert(ctx, []pack.Item{r}); err != nil {
+		return err
+	}
+	if err := table.Flush(ctx); err != nil {
+		return err
+	}
+	return m.captureReorg(r)
+}
+
+// newReorgBlock describes block before it is disconnected from indexes.
+func (m *Indexer) newReorgBlock(ctx context.Context, block *model.Block) (*model.ReorgBlock, error) {
+	counts, err := m.countBlockRows(ctx, block.Height)
+	if err != nil {
+		return nil, fmt.Errorf("REORGANIZE: counting rows for block %d: %w", block.Height, err)
+	}
+	b := &model.ReorgBlock{
+		RowId:    block.RowId,
+		Hash:     block.Hash.Clone(),
+		Height:   block.Height,
+		Time:     block.Timestamp,
+		NOps:     len(block.Ops),
+		Ops:      make([]tezos.OpHash, 0),
+		Reverted: counts,
+	}
+	for _, op := range block.Ops {
+		if !op.Hash.IsValid() {
+			continue
+		}
+		if l := len(b.Ops); l > 0 && b.Ops[l-1].Equal(op.Hash) {
+			continue
+		}
+		b.Ops = append(b.Ops, op.Hash.Clone())
+	}
+	return b, nil
+}
+
+// countBlockRows returns the number of rows stored at height for every
+// table that is keyed by block height.
+func (m *Indexer) countBlockRows(ctx context.Context, height int64) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, idx := range m.indexes {
+		for _, t := range idx.Tables() {
+			if !t.Fields().Contains("height") {
+				continue
+			}
+			n, err := pack.NewQuery("etl.reorg.count", t).
+				AndEqual("height", height).
+				Count(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				counts[t.Name()] = n
+			}
+		}
+	}
+	return counts, nil
+}
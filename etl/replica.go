@@ -0,0 +1,588 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package etl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/store"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/cdc"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+)
+
+const replicaRetryDelay = 5 * time.Second
+
+// ReplicaIndexes lists indexes whose tables are fully contained in the change
+// stream, including reorg events and contract gas statistics. Replicas should
+// only enable these indexes and custom indexes.
+var ReplicaIndexes = []string{
+	index.AccountIndexKey,
+	index.ContractIndexKey,
+	index.GasStatsIndexKey,
+	index.StorageIndexKey,
+	index.BlockIndexKey,
+	index.OpIndexKey,
+	index.FlowIndexKey,
+	index.ChainIndexKey,
+	index.SupplyIndexKey,
+	index.BigmapIndexKey,
+	index.RollupIndexKey,
+	index.TicketIndexKey,
+	index.SaplingIndexKey,
+	index.DelegationIndexKey,
+}
+
+// replicaTables maps change record tables to their row types.
+var replicaTables = map[string]func() pack.Item{
+	index.BlockTableKey:            func() pack.Item { return &model.Block{} },
+	index.OpTableKey:               func() pack.Item { return &model.Op{} },
+	index.EndorseOpTableKey:        func() pack.Item { return &model.Endorsement{} },
+	index.FlowTableKey:             func() pack.Item { return &model.Flow{} },
+	index.AccountTableKey:          func() pack.Item { return &model.Account{} },
+	index.BakerTableKey:            func() pack.Item { return &model.Baker{} },
+	index.ContractTableKey:         func() pack.Item { return &model.Contract{} },
+	index.SupplyTableKey:           func() pack.Item { return &model.Supply{} },
+	index.ChainTableKey:            func() pack.Item { return &model.Chain{} },
+	index.StorageTableKey:          func() pack.Item { return &model.Storage{} },
+	index.BigmapUpdateTableKey:     func() pack.Item { return &model.BigmapUpdate{} },
+	index.GasStatsTableKey:         func() pack.Item { return &model.GasStats{} },
+	index.ReorgTableKey:            func() pack.Item { return &model.Reorg{} },
+	index.RollupTableKey:           func() pack.Item { return &model.SmartRollup{} },
+	index.RollupCommitmentTableKey: func() pack.Item { return &model.RollupCommitment{} },
+	index.RollupMessageTableKey:    func() pack.Item { return &model.RollupMessage{} },
+	index.TicketTypeTableKey:       func() pack.Item { return &model.TicketType{} },
+	index.TicketBalanceTableKey:    func() pack.Item { return &model.TicketBalance{} },
+	index.TicketUpdateTableKey:     func() pack.Item { return &model.TicketUpdate{} },
+	index.SaplingPoolTableKey:      func() pack.Item { return &model.SaplingPool{} },
+	index.SaplingTxTableKey:        func() pack.Item { return &model.SaplingTx{} },
+	index.DelegationTableKey:       func() pack.Item { return &model.DelegationPeriod{} },
+}
+
+// replicaUpsertTables lists tables where the primary cannot tell inserts from
+// updates. Updates to unknown rows are inserted.
+var replicaUpsertTables = map[string]bool{
+	index.GasStatsTableKey: true,
+}
+
+// replicaRollbackTables lists append-only tables whose rows are removed by
+// height when a block is rolled back. Custom index tables are removed as well.
+var replicaRollbackTables = []string{
+	index.BlockTableKey,
+	index.OpTableKey,
+	index.EndorseOpTableKey,
+	index.FlowTableKey,
+	index.SupplyTableKey,
+	index.ChainTableKey,
+	index.StorageTableKey,
+	index.BigmapUpdateTableKey,
+	index.RollupMessageTableKey,
+	index.TicketUpdateTableKey,
+	index.SaplingTxTableKey,
+}
+
+// decodeChange decodes record data into a table row. Empty strings are
+// dropped first because zero values of some enum types encode as empty
+// string, but cannot be decoded again.
+func decodeChange(data json.RawMessage, val interface{}) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for n, v := range fields {
+		if string(v) == `""` {
+			delete(fields, n)
+		}
+	}
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, val)
+}
+
+// applyChanges writes a change set received from a primary indexer into
+// local tables and advances index tips. It returns the new tip block or nil
+// for change sets that are not related to a block.
+func (m *Indexer) applyChanges(ctx context.Context, recs []cdc.Record) (*model.Block, error) {
+	m.bfmu.Lock()
+	defer m.bfmu.Unlock()
+
+	var (
+		block    *model.Block
+		rollback bool
+		accounts = make(map[model.AccountID]*model.Account)
+	)
+
+	// bigmaps that are removed and written in the same block keep their row
+	keep := make(map[string]bool)
+	for _, r := range recs {
+		if r.Table == index.BigmapAllocTableKey && (r.Action == cdc.ActionInsert || r.Action == cdc.ActionUpdate) {
+			keep[r.Key] = true
+		}
+	}
+
+	for _, r := range recs {
+		var err error
+		switch {
+		case r.Action == cdc.ActionRollback:
+			rollback = true
+			err = m.applyRollback(ctx, r.Height)
+		case r.Table == index.BigmapAllocTableKey:
+			err = m.applyBigmapAlloc(ctx, r, keep[r.Key])
+		case r.Table == index.BigmapValueTableKey:
+			err = m.applyBigmapValue(ctx, r)
+		default:
+			var item pack.Item
+			item, err = m.applyRow(ctx, r)
+			switch v := item.(type) {
+			case *model.Block:
+				block = v
+			case *model.Account:
+				accounts[v.RowId] = v
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %s: %w", r.Table, r.Action, r.Key, err)
+		}
+	}
+
+	// rollbacks continue at the parent block
+	if rollback {
+		var err error
+		block, err = m.BlockByHeight(ctx, recs[0].Height-1)
+		if err != nil {
+			return nil, fmt.Errorf("loading parent block %d: %w", recs[0].Height-1, err)
+		}
+	}
+	if block == nil {
+		if recs[0].Block.IsValid() {
+			return nil, fmt.Errorf("missing block %d %s in change set", recs[0].Height, recs[0].Block)
+		}
+		return nil, nil
+	}
+
+	for _, tip := range m.tips {
+		cloned := block.Hash.Clone()
+		tip.Hash = &cloned
+		tip.Height = block.Height
+	}
+
+	// update live caches
+	if !rollback {
+		if err := m.updateBlocks(ctx, block); err != nil {
+			return nil, err
+		}
+		if err := m.updateAddrs(ctx, accounts); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+func (m *Indexer) applyRollback(ctx context.Context, height int64) error {
+	for _, key := range append(m.customTables(), replicaRollbackTables...) {
+		table, err := m.Table(key)
+		if err != nil {
+			continue
+		}
+		_, err = pack.NewQuery("etl.replica.rollback", table).
+			AndEqual("height", height).
+			Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Indexer) applyRow(ctx context.Context, r cdc.Record) (pack.Item, error) {
+	fn, ok := replicaTables[r.Table]
+	if !ok && strings.HasPrefix(r.Table, index.CustomIndexPrefix) {
+		fn, ok = func() pack.Item { return &model.CustomRow{} }, true
+	}
+	if !ok {
+		return nil, nil
+	}
+	table, err := m.Table(r.Table)
+	if err != nil {
+		return nil, nil
+	}
+	switch r.Action {
+	case cdc.ActionInsert, cdc.ActionUpdate:
+		item := fn()
+		if err := decodeRow(r.Data, item); err != nil {
+			return nil, err
+		}
+		insert := r.Action == cdc.ActionInsert
+		if !insert && replicaUpsertTables[r.Table] {
+			res, err := table.Lookup(ctx, []uint64{item.ID()})
+			if err != nil {
+				return nil, err
+			}
+			insert = res.Rows() == 0
+			res.Close()
+		}
+		if insert {
+			err = table.Insert(ctx, item)
+		} else {
+			err = table.Update(ctx, item)
+		}
+		return item, err
+	case cdc.ActionDelete:
+		id, err := strconv.ParseUint(r.Key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return nil, table.DeleteIds(ctx, []uint64{id})
+	}
+	return nil, nil
+}
+
+// decodeRow decodes record data into item and restores encoded fields that
+// are exported separately by change capture.
+func decodeRow(data json.RawMessage, item pack.Item) error {
+	switch v := item.(type) {
+	case *model.Contract:
+		row := contractRow{Contract: v}
+		if err := decodeChange(data, &row); err != nil {
+			return err
+		}
+		v.Features = micheline.Features(row.FeatureBits)
+		v.Interfaces = make(micheline.Interfaces, len(row.Interfaces))
+		for i, iface := range row.Interfaces {
+			v.Interfaces[i] = micheline.Interface(iface)
+		}
+	case *model.GasStats:
+		row := gasStatsRow{GasStats: v}
+		if err := decodeChange(data, &row); err != nil {
+			return err
+		}
+		v.GasData, v.StorageData, v.FeeData = row.GasData, row.StorageData, row.FeeData
+	case *model.Reorg:
+		row := reorgRow{Reorg: v}
+		if err := decodeChange(data, &row); err != nil {
+			return err
+		}
+		v.Data = row.Data
+	default:
+		return decodeChange(data, item)
+	}
+	return nil
+}
+
+func (m *Indexer) applyBigmapAlloc(ctx context.Context, r cdc.Record, keep bool) error {
+	allocs, err := m.Table(index.BigmapAllocTableKey)
+	if err != nil {
+		return nil
+	}
+	id, err := strconv.ParseInt(r.Key, 10, 64)
+	if err != nil {
+		return err
+	}
+	switch r.Action {
+	case cdc.ActionInsert, cdc.ActionUpdate:
+		row := bigmapAllocRow{BigmapAlloc: &model.BigmapAlloc{}}
+		if err := decodeChange(r.Data, &row); err != nil {
+			return err
+		}
+		row.BigmapAlloc.Data = row.Data
+		prev := &model.BigmapAlloc{}
+		err := pack.NewQuery("etl.replica.bigmap_alloc", allocs).
+			AndEqual("bigmap_id", id).
+			Execute(ctx, prev)
+		if err != nil {
+			return err
+		}
+		if prev.RowId > 0 {
+			row.BigmapAlloc.RowId = prev.RowId
+			return allocs.Update(ctx, row.BigmapAlloc)
+		}
+		return allocs.Insert(ctx, row.BigmapAlloc)
+
+	case cdc.ActionDelete:
+		if values, err := m.Table(index.BigmapValueTableKey); err == nil {
+			_, err = pack.NewQuery("etl.replica.bigmap_clear", values).
+				AndEqual("bigmap_id", id).
+				Delete(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		if keep {
+			return nil
+		}
+		_, err = pack.NewQuery("etl.replica.bigmap_delete", allocs).
+			AndEqual("bigmap_id", id).
+			Delete(ctx)
+		return err
+	}
+
+	// copied keys and allocations arrive as separate records
+	return nil
+}
+
+func (m *Indexer) applyBigmapValue(ctx context.Context, r cdc.Record) error {
+	values, err := m.Table(index.BigmapValueTableKey)
+	if err != nil {
+		return nil
+	}
+	ids := strings.SplitN(r.Key, ":", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("invalid bigmap key")
+	}
+	id, err := strconv.ParseInt(ids[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	kh, err := tezos.ParseExprHash(ids[1])
+	if err != nil {
+		return err
+	}
+
+	// value row ids are local to each database
+	prev := &model.BigmapKV{}
+	err = pack.NewQuery("etl.replica.bigmap_value", values).
+		AndEqual("key_id", model.GetKeyId(id, kh)).
+		AndEqual("bigmap_id", id).
+		Execute(ctx, prev)
+	if err != nil {
+		return err
+	}
+	switch r.Action {
+	case cdc.ActionInsert, cdc.ActionUpdate:
+		kv := &model.BigmapKV{}
+		if err := decodeChange(r.Data, kv); err != nil {
+			return err
+		}
+		kv.RowId = prev.RowId
+		if kv.RowId > 0 {
+			return values.Update(ctx, kv)
+		}
+		return values.Insert(ctx, kv)
+	case cdc.ActionDelete:
+		if prev.RowId > 0 {
+			return values.DeleteIds(ctx, []uint64{prev.RowId})
+		}
+	}
+	return nil
+}
+
+// runReplica follows the change stream of a primary indexer and applies
+// change sets to local tables.
+func (c *Crawler) runReplica() {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	// derive private context
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	ctx := c.ctx
+	defer c.cancel()
+
+	// abort pending requests on shutdown
+	go func() {
+		select {
+		case <-c.quit:
+			c.cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var seq uint64
+	err := c.db.View(func(dbTx store.Tx) error {
+		seq = dbLoadReplicaSeq(dbTx)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Loading replica position: %s", err)
+		c.setState(STATE_FAILED, MONITOR_DISABLE)
+		return
+	}
+
+	// keep warm caches for next start
+	defer func() {
+		if err := c.indexer.StoreCaches(c.Tip()); err != nil {
+			log.Errorf("Storing caches: %s", err)
+		}
+	}()
+
+	log.Infof("Following primary indexer %s from height %d.", c.primary, c.Height())
+	for {
+		c.setState(STATE_CONNECTING, MONITOR_DISABLE)
+		next, err := c.followPrimary(ctx, seq)
+		if next > seq {
+			seq = next
+		}
+		if interruptRequested(ctx) {
+			log.Infof("Stopping replica at height %d.", c.Height())
+			return
+		}
+		if err == nil {
+			// the primary ends follow streams periodically
+			continue
+		}
+		log.Errorf("Replica: %s", err)
+		select {
+		case <-ctx.Done():
+			log.Infof("Stopping replica at height %d.", c.Height())
+			return
+		case <-time.After(replicaRetryDelay):
+		}
+	}
+}
+
+// followPrimary streams change records after seq and applies complete change
+// sets. It returns the sequence number of the last applied record.
+func (c *Crawler) followPrimary(ctx context.Context, seq uint64) (uint64, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.primary, "/") + "/system/changes")
+	if err != nil {
+		return seq, err
+	}
+	q := u.Query()
+	q.Set("follow", "true")
+	if seq > 0 {
+		q.Set("since", strconv.FormatUint(seq, 10))
+	} else {
+		// initial start from a copy of the primary database
+		q.Set("after_block", c.Tip().BestHash.String())
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return seq, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return seq, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return seq, fmt.Errorf("primary returned %s: %s", resp.Status, strings.TrimSpace(string(buf)))
+	}
+	c.setState(STATE_SYNCHRONIZING, MONITOR_DISABLE)
+
+	var (
+		rd   = bufio.NewReader(resp.Body)
+		recs = make([]cdc.Record, 0, 1024)
+	)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return seq, err
+		}
+		var r cdc.Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return seq, err
+		}
+		recs = append(recs, r)
+		if !r.Commit {
+			continue
+		}
+		if err := c.applyReplicaSet(recs); err != nil {
+			c.setState(STATE_FAILED, MONITOR_DISABLE)
+			return seq, fmt.Errorf("block %d %s: %w", r.Height, r.Block, err)
+		}
+		seq = r.Seq
+		recs = recs[:0]
+
+		// nothing buffered means we have caught up with the primary
+		if rd.Buffered() == 0 {
+			c.setState(STATE_SYNCHRONIZED, MONITOR_DISABLE)
+		}
+	}
+}
+
+// applyReplicaSet applies a complete change set and stores the new chain tip
+// together with the stream position. Change sets are applied without
+// interruption to keep database state consistent.
+func (c *Crawler) applyReplicaSet(recs []cdc.Record) error {
+	ctx := context.Background()
+	start := time.Now()
+	tip := c.Tip()
+	last := recs[len(recs)-1]
+	newTip := &model.ChainTip{
+		Name:        tip.Name,
+		Symbol:      tip.Symbol,
+		ChainId:     tip.ChainId,
+		BestHash:    tip.BestHash,
+		BestId:      tip.BestId,
+		BestHeight:  tip.BestHeight,
+		BestTime:    tip.BestTime,
+		GenesisTime: tip.GenesisTime,
+		NYEveBlocks: tip.NYEveBlocks,
+		Deployments: tip.Deployments,
+	}
+
+	if last.Table == protocolChangeKey {
+		// protocol deployments are sent before the first block
+		params := &tezos.Params{}
+		if err := json.Unmarshal(last.Data, params); err != nil {
+			return err
+		}
+		if p, err := c.indexer.ParamsByProtocol(params.Protocol); err == nil && p.StartHeight == params.StartHeight {
+			return c.storeReplicaTip(newTip, last.Seq)
+		}
+		if err := c.indexer.ConnectProtocol(ctx, params); err != nil {
+			return err
+		}
+		newTip.AddDeployment(params)
+	} else {
+		block, err := c.indexer.applyChanges(ctx, recs)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			if err := c.indexer.FlushJournals(ctx); err != nil {
+				return err
+			}
+			return c.storeReplicaTip(newTip, last.Seq)
+		}
+		newTip.BestHash = block.Hash
+		newTip.BestId = block.RowId
+		newTip.BestHeight = block.Height
+		newTip.BestTime = block.Timestamp
+
+		// update blockchain years
+		if newTip.GenesisTime.AddDate(len(newTip.NYEveBlocks)+1, 0, 0).Before(block.Timestamp) {
+			newTip.NYEveBlocks = append(newTip.NYEveBlocks, block.Height)
+		}
+		if err := c.indexer.FlushJournals(ctx); err != nil {
+			return err
+		}
+		state, _ := c.getState()
+		c.plog.LogBlockHeight(block, 0, state, time.Since(start))
+	}
+	return c.storeReplicaTip(newTip, last.Seq)
+}
+
+func (c *Crawler) storeReplicaTip(tip *model.ChainTip, seq uint64) error {
+	err := c.db.Update(func(dbTx store.Tx) error {
+		if err := dbStoreChainTip(dbTx, tip); err != nil {
+			return err
+		}
+		return dbStoreReplicaSeq(dbTx, seq)
+	})
+	if err != nil {
+		return err
+	}
+	c.updateTip(tip)
+	return nil
+}
@@ -4,6 +4,7 @@
 package etl
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 
@@ -25,6 +26,18 @@ var (
 
 	// deploymentsBucketName is the name of the bucket holding protocol deployment parameters.
 	deploymentsBucketName = []byte("deployments")
+
+	// modeBucketName is the name of the bucket holding indexing mode settings.
+	modeBucketName = []byte("mode")
+
+	// filterKey is the key of the index filter serialized data in the db.
+	filterKey = []byte("filter")
+
+	// horizonKey is the key of the pruned history horizon in the db.
+	horizonKey = []byte("horizon")
+
+	// replicaKey is the key of the last change record applied by a replica.
+	replicaKey = []byte("replica")
 )
 
 func dbLoadChainTip(dbTx store.Tx) (*model.ChainTip, error) {
@@ -55,8 +68,9 @@ func dbStoreChainTip(dbTx store.Tx, tip *model.ChainTip) error {
 }
 
 type IndexTip struct {
-	Hash   *tezos.BlockHash `json:"hash,omitempty"`
-	Height int64            `json:"height"`
+	Hash     *tezos.BlockHash `json:"hash,omitempty"`
+	Height   int64            `json:"height"`
+	Backfill bool             `json:"backfill,omitempty"` // true while catching up
 }
 
 func dbStoreIndexTip(dbTx store.Tx, key string, tip *IndexTip) error {
@@ -112,3 +126,76 @@ func dbStoreDeployment(dbTx store.Tx, p *tezos.Params) error {
 	bucket.FillPercent(1.0)
 	return bucket.Put(p.Protocol.Hash.Hash, buf)
 }
+
+// dbLoadIndexFilter returns the canonical index filter the database was built
+// with. Databases created before filters were recorded return an empty string.
+func dbLoadIndexFilter(dbTx store.Tx) string {
+	bucket := dbTx.Bucket(modeBucketName)
+	if bucket == nil {
+		return ""
+	}
+	return string(bucket.Get(filterKey))
+}
+
+func dbStoreIndexFilter(dbTx store.Tx, f *model.IndexFilter) error {
+	bucket, err := dbTx.Root().CreateBucketIfNotExists(modeBucketName)
+	if err != nil {
+		return err
+	}
+	bucket.FillPercent(1.0)
+	return bucket.Put(filterKey, []byte(f.String()))
+}
+
+// dbLoadHorizon returns the pruned history horizon or a zero horizon when
+// the database has never been pruned.
+func dbLoadHorizon(dbTx store.Tx) (PruneHorizon, error) {
+	var h PruneHorizon
+	bucket := dbTx.Bucket(modeBucketName)
+	if bucket == nil {
+		return h, nil
+	}
+	buf := bucket.Get(horizonKey)
+	if buf == nil {
+		return h, nil
+	}
+	err := json.Unmarshal(buf, &h)
+	return h, err
+}
+
+func dbStoreHorizon(dbTx store.Tx, h PruneHorizon) error {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	bucket, err := dbTx.Root().CreateBucketIfNotExists(modeBucketName)
+	if err != nil {
+		return err
+	}
+	bucket.FillPercent(1.0)
+	return bucket.Put(horizonKey, buf)
+}
+
+// dbLoadReplicaSeq returns the last change record applied by a replica or
+// zero when the replica has not started yet.
+func dbLoadReplicaSeq(dbTx store.Tx) uint64 {
+	bucket := dbTx.Bucket(modeBucketName)
+	if bucket == nil {
+		return 0
+	}
+	buf := bucket.Get(replicaKey)
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+func dbStoreReplicaSeq(dbTx store.Tx, seq uint64) error {
+	bucket, err := dbTx.Root().CreateBucketIfNotExists(modeBucketName)
+	if err != nil {
+		return err
+	}
+	bucket.FillPercent(1.0)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	return bucket.Put(replicaKey, buf[:])
+}
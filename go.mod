@@ -18,10 +18,11 @@ require (
 	github.com/fatih/color v1.13.0
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/schema v1.2.0
+	github.com/lib/pq v1.9.0
 	github.com/qri-io/jsonschema v0.2.1
 	github.com/spf13/cobra v1.1.3
 	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
-	golang.org/x/sys v0.0.0-20220615213510-4f61da869c0c // indirect
+	modernc.org/sqlite v1.20.3
 )
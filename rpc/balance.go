@@ -23,6 +23,9 @@ type BalanceUpdate struct {
 	IsParticipationBurn bool `json:"participation"` // burn only
 	IsRevelationBurn    bool `json:"revelation"`    // burn only
 
+	// smart rollup bonds
+	BondId *BondId `json:"bond_id,omitempty"` // bond freezer only
+
 	// legacy freezer cycle
 	Level_ int64 `json:"level"` // wrongly called level, it's cycle
 	Cycle_ int64 `json:"cycle"` // v4 fix
@@ -55,6 +58,16 @@ type BalanceUpdate struct {
 // - `legacy_deposits`, `legacy_fees`, or `legacy_rewards` represent the accounts of frozen deposits, frozen fees or frozen rewards up to protocol HANGZHOU.
 // - `deposits` represents the account of frozen deposits in subsequent protocols (replacing the legacy container account `legacy_deposits` above).
 
+// BondId identifies the rollup a frozen bond belongs to
+type BondId struct {
+	TxRollup    tezos.Address   `json:"tx_rollup"`
+	SmartRollup SmartRollupHash `json:"smart_rollup"`
+}
+
+func (b BalanceUpdate) IsSmartRollupBond() bool {
+	return b.BondId != nil && b.BondId.SmartRollup.IsValid()
+}
+
 func (b BalanceUpdate) Address() tezos.Address {
 	a := b.Contract + b.Delegate + b.Committer
 	addr, _ := tezos.ParseAddress(a)
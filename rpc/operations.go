@@ -94,6 +94,27 @@ type OperationResult struct {
 	LazyStorageDiff     json.RawMessage  `json:"lazy_storage_diff,omitempty"`    // v008+ tx, orig
 	GlobalAddress       tezos.ExprHash   `json:"global_address"`                 // const
 	OriginatedRollup    tezos.Address    `json:"originated_rollup"`              // v013
+
+	// smart rollups
+	SmartRollupAddress    SmartRollupHash        `json:"address"`                 // originate
+	GenesisCommitmentHash SmartRollupHash        `json:"genesis_commitment_hash"` // originate
+	StakedHash            SmartRollupHash        `json:"staked_hash"`             // publish
+	PublishedAtLevel      int64                  `json:"published_at_level"`      // publish
+	InboxLevel            int64                  `json:"inbox_level"`             // cement
+	CommitmentHash        SmartRollupHash        `json:"commitment_hash"`         // cement
+	GameStatus            *SmartRollupGameStatus `json:"game_status,omitempty"`   // refute, timeout
+
+	// tickets
+	TicketUpdates_ []TicketUpdate `json:"ticket_updates,omitempty"` // v015
+	TicketReceipt  []TicketUpdate `json:"ticket_receipt,omitempty"` // v016+
+}
+
+// TicketUpdates returns ticket balance updates independent of protocol version.
+func (r OperationResult) TicketUpdates() []TicketUpdate {
+	if len(r.TicketReceipt) > 0 {
+		return r.TicketReceipt
+	}
+	return r.TicketUpdates_
 }
 
 func (r OperationResult) BigmapEvents() micheline.BigmapEvents {
@@ -207,7 +228,7 @@ func (e *OperationList) UnmarshalJSON(data []byte) error {
 			start += 1
 		}
 		end := start + bytes.IndexByte(data[start:], '"')
-		kind := tezos.ParseOpType(string(data[start:end]))
+		kind := ParseOpType(string(data[start:end]))
 		var op TypedOperation
 		switch kind {
 		// anonymous operations
@@ -263,6 +284,17 @@ func (e *OperationList) UnmarshalJSON(data []byte) error {
 			tezos.OpTypeScruPublish:
 			op = &Rollup{}
 
+		// smart rollup operations
+		case OpTypeSmartRollupOriginate,
+			OpTypeSmartRollupAddMessages,
+			OpTypeSmartRollupCement,
+			OpTypeSmartRollupPublish,
+			OpTypeSmartRollupRefute,
+			OpTypeSmartRollupTimeout,
+			OpTypeSmartRollupExecuteOutboxMessage,
+			OpTypeSmartRollupRecoverBond:
+			op = &SmartRollup{}
+
 		default:
 			return fmt.Errorf("rpc: unsupported op %q", kind)
 		}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// SaplingEvent is a lazy storage diff for a sapling state. Unlike tzgo's
+// LazySaplingEvent it decodes hex encoded commitments and nullifiers and
+// keeps ciphertexts opaque because we never attempt decryption.
+type SaplingEvent struct {
+	PoolId int64 `json:"id,string"`
+	Diff   struct {
+		Action   micheline.DiffAction `json:"action"`
+		Updates  SaplingUpdates       `json:"updates"`
+		MemoSize int                  `json:"memo_size"`     // alloc
+		SourceId int64                `json:"source,string"` // copy
+	} `json:"diff"`
+}
+
+type SaplingUpdates struct {
+	Commitments []SaplingCommitment `json:"commitments_and_ciphertexts"`
+	Nullifiers  []tezos.HexBytes    `json:"nullifiers"`
+}
+
+// SaplingCommitment is a note commitment followed by its encrypted ciphertext.
+type SaplingCommitment struct {
+	Commitment tezos.HexBytes
+	Ciphertext json.RawMessage
+}
+
+func (c *SaplingCommitment) UnmarshalJSON(data []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(data, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 2 {
+		return fmt.Errorf("rpc: invalid sapling commitment tuple len %d", len(tuple))
+	}
+	if err := json.Unmarshal(tuple[0], &c.Commitment); err != nil {
+		return err
+	}
+	c.Ciphertext = tuple[1]
+	return nil
+}
+
+// SaplingEvents returns all sapling state diffs from a lazy storage diff.
+func (r OperationResult) SaplingEvents() []SaplingEvent {
+	if r.LazyStorageDiff == nil {
+		return nil
+	}
+	diffs := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(r.LazyStorageDiff, &diffs); err != nil {
+		log.Debugf("rpc: lazy decode: %v", err)
+		return nil
+	}
+	var events []SaplingEvent
+	for _, v := range diffs {
+		var kind struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(v, &kind); err != nil {
+			log.Debugf("rpc: lazy decode: %v", err)
+			continue
+		}
+		if micheline.ParseLazyKind(kind.Kind) != micheline.LazyKindSapling {
+			continue
+		}
+		var ev SaplingEvent
+		if err := json.Unmarshal(v, &ev); err != nil {
+			log.Debugf("rpc: sapling decode: %v", err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
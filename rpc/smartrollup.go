@@ -0,0 +1,467 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"blockwatch.cc/tzgo/base58"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Smart rollup operation kinds are not yet known to tzgo. We allocate them
+// here outside the range tzgo uses so they can travel as tezos.OpType.
+const (
+	OpTypeSmartRollupOriginate tezos.OpType = iota + 64
+	OpTypeSmartRollupAddMessages
+	OpTypeSmartRollupCement
+	OpTypeSmartRollupPublish
+	OpTypeSmartRollupRefute
+	OpTypeSmartRollupTimeout
+	OpTypeSmartRollupExecuteOutboxMessage
+	OpTypeSmartRollupRecoverBond
+)
+
+var (
+	smartRollupOpTypeStrings = map[tezos.OpType]string{
+		OpTypeSmartRollupOriginate:            "smart_rollup_originate",
+		OpTypeSmartRollupAddMessages:          "smart_rollup_add_messages",
+		OpTypeSmartRollupCement:               "smart_rollup_cement",
+		OpTypeSmartRollupPublish:              "smart_rollup_publish",
+		OpTypeSmartRollupRefute:               "smart_rollup_refute",
+		OpTypeSmartRollupTimeout:              "smart_rollup_timeout",
+		OpTypeSmartRollupExecuteOutboxMessage: "smart_rollup_execute_outbox_message",
+		OpTypeSmartRollupRecoverBond:          "smart_rollup_recover_bond",
+	}
+	smartRollupOpTypeReverseStrings = make(map[string]tezos.OpType)
+)
+
+func init() {
+	for n, v := range smartRollupOpTypeStrings {
+		smartRollupOpTypeReverseStrings[v] = n
+	}
+}
+
+// ParseOpType extends tezos.ParseOpType with operation kinds that are only
+// known to this package.
+func ParseOpType(s string) tezos.OpType {
+	if t, ok := smartRollupOpTypeReverseStrings[s]; ok {
+		return t
+	}
+	return tezos.ParseOpType(s)
+}
+
+// OpTypeString returns the operation kind name including kinds that are only
+// known to this package.
+func OpTypeString(t tezos.OpType) string {
+	if s, ok := smartRollupOpTypeStrings[t]; ok {
+		return s
+	}
+	return t.String()
+}
+
+// IsSmartRollupOpType returns true for all smart rollup operation kinds.
+func IsSmartRollupOpType(t tezos.OpType) bool {
+	_, ok := smartRollupOpTypeStrings[t]
+	return ok
+}
+
+// SmartRollupHashType defines the kind of a smart rollup hash.
+type SmartRollupHashType byte
+
+const (
+	SmartRollupHashTypeInvalid    SmartRollupHashType = iota
+	SmartRollupHashTypeAddress                        // sr1
+	SmartRollupHashTypeCommitment                     // src1
+	SmartRollupHashTypeState                          // srs1
+)
+
+var (
+	ErrInvalidSmartRollupHash = errors.New("rpc: invalid smart rollup hash")
+
+	// base58 version bytes
+	smartRollupAddressId    = []byte{6, 124, 117}
+	smartRollupCommitmentId = []byte{17, 165, 134, 138}
+	smartRollupStateId      = []byte{17, 165, 235, 240}
+)
+
+func (t SmartRollupHashType) IsValid() bool {
+	return t != SmartRollupHashTypeInvalid
+}
+
+func (t SmartRollupHashType) Prefix() string {
+	switch t {
+	case SmartRollupHashTypeAddress:
+		return "sr1"
+	case SmartRollupHashTypeCommitment:
+		return "src1"
+	case SmartRollupHashTypeState:
+		return "srs1"
+	default:
+		return ""
+	}
+}
+
+func (t SmartRollupHashType) Len() int {
+	switch t {
+	case SmartRollupHashTypeAddress:
+		return 20
+	case SmartRollupHashTypeCommitment, SmartRollupHashTypeState:
+		return 32
+	default:
+		return 0
+	}
+}
+
+func (t SmartRollupHashType) id() []byte {
+	switch t {
+	case SmartRollupHashTypeAddress:
+		return smartRollupAddressId
+	case SmartRollupHashTypeCommitment:
+		return smartRollupCommitmentId
+	case SmartRollupHashTypeState:
+		return smartRollupStateId
+	default:
+		return nil
+	}
+}
+
+// SmartRollupHash represents smart rollup addresses, commitment and state
+// hashes which share the same structure but use different base58 prefixes.
+type SmartRollupHash struct {
+	Type SmartRollupHashType
+	Hash []byte
+}
+
+func NewSmartRollupHash(typ SmartRollupHashType, buf []byte) SmartRollupHash {
+	h := SmartRollupHash{Type: typ}
+	if len(buf) == typ.Len() {
+		h.Hash = make([]byte, len(buf))
+		copy(h.Hash, buf)
+	}
+	return h
+}
+
+func ParseSmartRollupHash(s string) (SmartRollupHash, error) {
+	var typ SmartRollupHashType
+	switch {
+	case strings.HasPrefix(s, "src1"):
+		typ = SmartRollupHashTypeCommitment
+	case strings.HasPrefix(s, "srs1"):
+		typ = SmartRollupHashTypeState
+	case strings.HasPrefix(s, "sr1"):
+		typ = SmartRollupHashTypeAddress
+	default:
+		return SmartRollupHash{}, ErrInvalidSmartRollupHash
+	}
+	buf, ver, err := base58.CheckDecode(s, len(typ.id()), nil)
+	if err != nil {
+		return SmartRollupHash{}, err
+	}
+	if !bytes.Equal(ver, typ.id()) || len(buf) != typ.Len() {
+		return SmartRollupHash{}, ErrInvalidSmartRollupHash
+	}
+	return SmartRollupHash{Type: typ, Hash: buf}, nil
+}
+
+func (h SmartRollupHash) IsValid() bool {
+	return h.Type.IsValid() && len(h.Hash) == h.Type.Len()
+}
+
+func (h SmartRollupHash) Equal(h2 SmartRollupHash) bool {
+	return h.Type == h2.Type && bytes.Equal(h.Hash, h2.Hash)
+}
+
+func (h SmartRollupHash) Clone() SmartRollupHash {
+	return NewSmartRollupHash(h.Type, h.Hash)
+}
+
+func (h SmartRollupHash) Bytes() []byte {
+	return h.Hash
+}
+
+func (h SmartRollupHash) String() string {
+	if !h.IsValid() {
+		return ""
+	}
+	return base58.CheckEncode(h.Hash, h.Type.id())
+}
+
+func (h SmartRollupHash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *SmartRollupHash) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	v, err := ParseSmartRollupHash(string(data))
+	if err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// Ensure SmartRollup implements the TypedOperation interface.
+var _ TypedOperation = (*SmartRollup)(nil)
+
+// SmartRollup represents any kind of smart rollup operation
+type SmartRollup struct {
+	// common
+	Manager
+
+	// most smart rollup ops
+	Rollup SmartRollupHash `json:"rollup"`
+
+	// smart_rollup_originate
+	Originate SmartRollupOriginate `json:"-"`
+
+	// smart_rollup_add_messages
+	Messages []tezos.HexBytes `json:"message,omitempty"`
+
+	// smart_rollup_cement
+	Cement SmartRollupCement `json:"-"`
+
+	// smart_rollup_publish
+	Publish SmartRollupCommitment `json:"-"`
+
+	// smart_rollup_refute
+	Refute SmartRollupRefute `json:"-"`
+
+	// smart_rollup_timeout
+	Stakers SmartRollupStakers `json:"stakers"`
+
+	// smart_rollup_execute_outbox_message
+	Execute SmartRollupExecute `json:"-"`
+
+	// smart_rollup_recover_bond
+	Staker tezos.Address `json:"staker"`
+}
+
+func (r *SmartRollup) UnmarshalJSON(data []byte) error {
+	type alias SmartRollup
+	var a struct {
+		*alias
+		Kind       string          `json:"kind"` // unknown to tzgo
+		Commitment json.RawMessage `json:"commitment"`
+	}
+	a.alias = (*alias)(r)
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	r.OpKind = ParseOpType(a.Kind)
+	switch r.Kind() {
+	case OpTypeSmartRollupOriginate:
+		return json.Unmarshal(data, &r.Originate)
+	case OpTypeSmartRollupCement:
+		// commitment was removed from cement in later protocols
+		if len(a.Commitment) > 0 {
+			return json.Unmarshal(a.Commitment, &r.Cement.Commitment)
+		}
+	case OpTypeSmartRollupPublish:
+		return json.Unmarshal(a.Commitment, &r.Publish)
+	case OpTypeSmartRollupRefute:
+		return json.Unmarshal(data, &r.Refute)
+	case OpTypeSmartRollupExecuteOutboxMessage:
+		return json.Unmarshal(data, &r.Execute)
+	}
+	return nil
+}
+
+// Target returns the rollup address an operation refers to. Origination
+// operations only know their rollup address from the operation receipt and
+// add_messages operations always target the shared inbox.
+func (r *SmartRollup) Target() SmartRollupHash {
+	if r.Kind() == OpTypeSmartRollupOriginate {
+		return r.Result().SmartRollupAddress
+	}
+	return r.Rollup
+}
+
+// Accounts returns all accounts an operation may refer to.
+func (r *SmartRollup) Accounts() []tezos.Address {
+	addrs := []tezos.Address{r.Source}
+	switch r.Kind() {
+	case OpTypeSmartRollupRefute:
+		addrs = append(addrs, r.Refute.Opponent)
+	case OpTypeSmartRollupTimeout:
+		addrs = append(addrs, r.Stakers.Alice, r.Stakers.Bob)
+	case OpTypeSmartRollupRecoverBond:
+		addrs = append(addrs, r.Staker)
+	}
+	for _, v := range r.Result().BalanceUpdates {
+		addrs = append(addrs, v.Address())
+	}
+	for _, v := range r.Result().TicketUpdates() {
+		addrs = append(addrs, v.Accounts()...)
+	}
+	return addrs
+}
+
+// EncodeParameters packs the target rollup address and operation arguments
+// into a parameters structure so they can be stored with the operation.
+func (r *SmartRollup) EncodeParameters() micheline.Parameters {
+	params := micheline.Parameters{
+		Entrypoint: OpTypeString(r.Kind()),
+	}
+	args := micheline.NewCode(micheline.D_UNIT)
+	switch r.Kind() {
+	case OpTypeSmartRollupOriginate:
+		args = r.Originate.Prim()
+	case OpTypeSmartRollupAddMessages:
+		args = micheline.NewSeq()
+		for _, v := range r.Messages {
+			args.Args = append(args.Args, micheline.NewBytes(v))
+		}
+	case OpTypeSmartRollupCement:
+		args = micheline.NewBytes(r.Cement.Commitment.Bytes())
+	case OpTypeSmartRollupPublish:
+		args = r.Publish.Prim()
+	case OpTypeSmartRollupRefute:
+		args = r.Refute.Prim()
+	case OpTypeSmartRollupTimeout:
+		args = micheline.NewPair(
+			micheline.NewBytes(r.Stakers.Alice.Bytes22()),
+			micheline.NewBytes(r.Stakers.Bob.Bytes22()),
+		)
+	case OpTypeSmartRollupExecuteOutboxMessage:
+		args = r.Execute.Prim()
+	case OpTypeSmartRollupRecoverBond:
+		args = micheline.NewBytes(r.Staker.Bytes22())
+	}
+	params.Value = micheline.NewPair(
+		micheline.NewBytes(r.Target().Bytes()),
+		args,
+	)
+	return params
+}
+
+// SmartRollupArgs is the decoded form of stored smart rollup operation parameters.
+type SmartRollupArgs struct {
+	Rollup SmartRollupHash `json:"rollup,omitempty"`
+	Value  micheline.Prim  `json:"value"`
+}
+
+func DecodeSmartRollupArgs(data []byte) (*SmartRollupArgs, error) {
+	var p micheline.Parameters
+	if err := p.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(p.Value.Args) != 2 {
+		return nil, ErrInvalidSmartRollupHash
+	}
+	args := &SmartRollupArgs{
+		Value: p.Value.Args[1],
+	}
+	if buf := p.Value.Args[0].Bytes; len(buf) > 0 {
+		args.Rollup = NewSmartRollupHash(SmartRollupHashTypeAddress, buf)
+	}
+	return args, nil
+}
+
+type SmartRollupOriginate struct {
+	PvmKind          string         `json:"pvm_kind"`
+	Kernel           tezos.HexBytes `json:"kernel"`
+	OriginationProof tezos.HexBytes `json:"origination_proof,omitempty"`
+	ParametersType   micheline.Prim `json:"parameters_ty"`
+}
+
+func (o SmartRollupOriginate) Prim() micheline.Prim {
+	return micheline.NewCombPair(
+		micheline.NewString(o.PvmKind),
+		micheline.NewBytes(o.Kernel),
+		o.ParametersType,
+	)
+}
+
+type SmartRollupCement struct {
+	Commitment SmartRollupHash `json:"commitment,omitempty"`
+}
+
+type SmartRollupCommitment struct {
+	CompressedState SmartRollupHash `json:"compressed_state"`
+	InboxLevel      int64           `json:"inbox_level"`
+	Predecessor     SmartRollupHash `json:"predecessor"`
+	NumberOfTicks   tezos.Z         `json:"number_of_ticks"`
+}
+
+func (c SmartRollupCommitment) Prim() micheline.Prim {
+	return micheline.NewCombPair(
+		micheline.NewBytes(c.CompressedState.Bytes()),
+		micheline.NewInt64(c.InboxLevel),
+		micheline.NewBytes(c.Predecessor.Bytes()),
+		micheline.NewInt64(c.NumberOfTicks.Int64()),
+	)
+}
+
+type SmartRollupRefute struct {
+	Opponent   tezos.Address   `json:"opponent"`
+	Refutation json.RawMessage `json:"refutation"`
+}
+
+func (r SmartRollupRefute) Prim() micheline.Prim {
+	return micheline.NewPair(
+		micheline.NewBytes(r.Opponent.Bytes22()),
+		micheline.NewBytes(r.Refutation),
+	)
+}
+
+type SmartRollupStakers struct {
+	Alice tezos.Address `json:"alice"`
+	Bob   tezos.Address `json:"bob"`
+}
+
+type SmartRollupExecute struct {
+	CementedCommitment SmartRollupHash `json:"cemented_commitment"`
+	OutputProof        tezos.HexBytes  `json:"output_proof"`
+}
+
+func (e SmartRollupExecute) Prim() micheline.Prim {
+	return micheline.NewPair(
+		micheline.NewBytes(e.CementedCommitment.Bytes()),
+		micheline.NewBytes(e.OutputProof),
+	)
+}
+
+// SmartRollupGameStatus is returned by refute and timeout operations. The
+// game is either `ongoing` or ended with a result that names the loser.
+type SmartRollupGameStatus struct {
+	Status string               `json:"-"`
+	Result *SmartRollupGameEnds `json:"result,omitempty"`
+}
+
+type SmartRollupGameEnds struct {
+	Kind   string          `json:"kind"` // loser, draw
+	Reason json.RawMessage `json:"reason,omitempty"`
+	Player tezos.Address   `json:"player"`
+}
+
+func (s *SmartRollupGameStatus) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &s.Status)
+	}
+	type alias SmartRollupGameStatus
+	if err := json.Unmarshal(data, (*alias)(s)); err != nil {
+		return err
+	}
+	s.Status = "ended"
+	return nil
+}
+
+func (s SmartRollupGameStatus) IsEnded() bool {
+	return s.Result != nil
+}
+
+// Loser returns the losing staker address if any.
+func (s SmartRollupGameStatus) Loser() tezos.Address {
+	if s.Result != nil && s.Result.Kind == "loser" {
+		return s.Result.Player
+	}
+	return tezos.InvalidAddress
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package rpc
+
+import (
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Ticket identifies a ticket type by its ticketer, content type and content.
+type Ticket struct {
+	Ticketer tezos.Address  `json:"ticketer"`
+	Type     micheline.Prim `json:"content_type"`
+	Content  micheline.Prim `json:"content"`
+}
+
+// Hash returns a hash that uniquely identifies a ticket type.
+func (t Ticket) Hash() tezos.ExprHash {
+	key := micheline.NewCombPair(
+		micheline.NewBytes(t.Ticketer.Bytes22()),
+		t.Type,
+		t.Content,
+	)
+	buf, _ := key.MarshalBinary()
+	return micheline.KeyHash(buf)
+}
+
+// TicketBalanceUpdate is a signed change to a ticket holder's balance. Holders
+// may be smart rollups which tezos.Address cannot represent, so the address
+// is kept as string.
+type TicketBalanceUpdate struct {
+	Account string  `json:"account"`
+	Amount  tezos.Z `json:"amount"`
+}
+
+func (u TicketBalanceUpdate) Address() tezos.Address {
+	addr, _ := tezos.ParseAddress(u.Account)
+	return addr
+}
+
+type TicketUpdate struct {
+	Ticket  Ticket                `json:"ticket_token"`
+	Updates []TicketBalanceUpdate `json:"updates"`
+}
+
+// Accounts returns the ticketer and all valid ticket holder addresses.
+func (u TicketUpdate) Accounts() []tezos.Address {
+	addrs := make([]tezos.Address, 0, len(u.Updates)+1)
+	addrs = append(addrs, u.Ticket.Ticketer)
+	for _, v := range u.Updates {
+		if a := v.Address(); a.IsValid() {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
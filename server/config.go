@@ -19,6 +19,7 @@ type Config struct {
 	Indexer *etl.Indexer
 	Client  *rpc.Client
 	Http    HttpConfig
+	Health  HealthConfig
 }
 
 func (c Config) ClampList(count uint) uint {
@@ -63,7 +64,7 @@ func (c Config) ClampExplore64(count int64) int64 {
 	if count <= 0 {
 		return def
 	}
-	if count > max {
+	if max > 0 && count > max {
 		return max
 	}
 	return count
@@ -77,6 +78,7 @@ type HttpConfig struct {
 	Host                string        `json:"host"`
 	MaxWorkers          int           `json:"max_workers"`
 	MaxQueue            int           `json:"max_queue"`
+	MaxStreams          int           `json:"max_streams"`
 	ReadTimeout         time.Duration `json:"read_timeout"`
 	HeaderTimeout       time.Duration `json:"header_timeout"`
 	WriteTimeout        time.Duration `json:"write_timeout"`
@@ -86,7 +88,10 @@ type HttpConfig struct {
 	MaxListCount        uint          `json:"max_list_count"`
 	DefaultExploreCount uint          `json:"default_explore_count"`
 	MaxExploreCount     uint          `json:"max_explore_count"`
+	MaxBatchCount       uint          `json:"max_batch_count"`
 	MaxSeriesDuration   time.Duration `json:"max_series_duration"`
+	MaxQueryCost        int64         `json:"max_query_cost"`
+	MaxQueryBudget      int64         `json:"max_query_budget"`
 	CorsEnable          bool          `json:"cors_enable"`
 	CorsOrigin          string        `json:"cors_origin"`
 	CorsAllowHeaders    string        `json:"cors_allow_headers"`
@@ -100,6 +105,16 @@ type HttpConfig struct {
 	CacheMaxExpires     time.Duration `json:"cache_max"`
 }
 
+// Health check thresholds, zero values disable a check
+type HealthConfig struct {
+	MaxBlockLag   int64         `json:"max_block_lag"`   // max blocks behind node head
+	MaxBlockAge   time.Duration `json:"max_block_age"`   // max time since last indexed block
+	RpcTimeout    time.Duration `json:"rpc_timeout"`     // node reachability timeout
+	MaxQueueRatio float64       `json:"max_queue_ratio"` // max request queue fill level
+	MinDiskFree   int64         `json:"min_disk_free"`   // min free bytes on database volume
+	DiskPath      string        `json:"disk_path"`       // database path
+}
+
 func (c HttpConfig) Address() string {
 	return net.JoinHostPort(c.Addr, strconv.Itoa(c.Port))
 }
@@ -112,6 +127,7 @@ func NewHttpConfig() HttpConfig {
 		Scheme:              "http",
 		MaxWorkers:          50,
 		MaxQueue:            200,
+		MaxStreams:          16,
 		HeaderTimeout:       2 * time.Second,  // header timeout
 		ReadTimeout:         5 * time.Second,  // header+body timeout
 		WriteTimeout:        90 * time.Second, // response deadline
@@ -122,6 +138,8 @@ func NewHttpConfig() HttpConfig {
 		DefaultExploreCount: 20,
 		MaxExploreCount:     100,
 		MaxSeriesDuration:   90 * 24 * time.Hour,
+		MaxQueryCost:        10000,  // per request
+		MaxQueryBudget:      100000, // all concurrent requests
 		CacheExpires:        30 * time.Second,
 		CacheMaxExpires:     24 * time.Hour,
 	}
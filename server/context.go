@@ -65,6 +65,7 @@ type Context struct {
 	// Statistics
 	Now         time.Time
 	Performance *PerformanceCounter
+	cost        int64 // cost units reserved from the dispatcher budget
 
 	// input
 	name string
@@ -161,7 +162,40 @@ func (api *Context) serve() {
 	}
 }
 
+// ChargeCost reserves cost units for an expensive request from the shared
+// dispatcher budget until the request completes. Panics when the request
+// exceeds its own cost limit or the shared budget is exhausted.
+func (api *Context) ChargeCost(n int64) {
+	if err := api.TryChargeCost(n); err != nil {
+		panic(err)
+	}
+}
+
+// TryChargeCost is like ChargeCost but returns the API error instead of
+// panicking so it can be used from inside table scans. Negative costs are
+// rejected and zero costs reserve nothing.
+func (api *Context) TryChargeCost(n int64) error {
+	switch {
+	case n < 0:
+		return EInternal(EC_SERVER, fmt.Sprintf("invalid query cost %d", n), nil)
+	case n == 0:
+		return nil
+	}
+	if max := api.Cfg.Http.MaxQueryCost; max > 0 && n > max-api.cost {
+		return EBadRequest(EC_PARAM_INVALID, fmt.Sprintf("query cost exceeds limit %d", max), nil)
+	}
+	if !queryBudget.acquire(n) {
+		return ETooManyRequests(EC_ACCESS_RATE_LIMITED, "query budget exhausted", nil)
+	}
+	api.cost += n
+	return nil
+}
+
 func (api *Context) complete() {
+	// return reserved cost units
+	queryBudget.release(api.cost)
+	api.cost = 0
+
 	// only execute on panic
 	if e := recover(); e != nil {
 		if debugHttp {
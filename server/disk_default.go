@@ -0,0 +1,15 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+)
+
+// diskFree is not supported on this platform.
+func diskFree(path string) (int64, error) {
+	return -1, errors.New("not supported")
+}
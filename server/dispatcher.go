@@ -3,9 +3,53 @@
 
 package server
 
+import (
+	"sync/atomic"
+)
+
 // A buffered channel that we can send work requests on.
 var jobQueue chan *Context
 
+// Shared cost budget for concurrently executing requests.
+var queryBudget costBudget
+
+// Slots for long-running streams which are served outside the worker pool.
+var streamSlots chan struct{}
+
+// costBudget limits the total cost of all requests in flight so that a
+// few expensive queries cannot occupy every worker. A zero limit disables it.
+type costBudget struct {
+	limit int64
+	used  int64
+}
+
+// acquire reserves n > 0 units. Non-positive amounts are rejected so that
+// no request can return units it has not reserved.
+func (b *costBudget) acquire(n int64) bool {
+	if n <= 0 {
+		return false
+	}
+	if b.limit <= 0 {
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if n > b.limit-used {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+func (b *costBudget) release(n int64) {
+	if b.limit <= 0 || n == 0 {
+		return
+	}
+	atomic.AddInt64(&b.used, -n)
+}
+
 type Worker struct {
 	WorkerPool chan chan *Context
 	JobChannel chan *Context
@@ -66,8 +110,10 @@ type Dispatcher struct {
 	maxQueue   int
 }
 
-func NewDispatcher(maxWorkers int, maxQueue int) *Dispatcher {
+func NewDispatcher(maxWorkers int, maxQueue int, maxBudget int64, maxStreams int) *Dispatcher {
 	jobQueue = make(chan *Context, maxQueue)
+	streamSlots = make(chan struct{}, maxStreams)
+	queryBudget = costBudget{limit: maxBudget}
 	pool := make(chan chan *Context, maxWorkers)
 	return &Dispatcher{pool: pool, maxWorkers: maxWorkers, maxQueue: maxQueue}
 }
@@ -0,0 +1,42 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCostBudget(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		used  int64
+		n     int64
+		ok    bool
+	}{
+		{"fits", 100, 10, 90, true},
+		{"exceeds", 100, 10, 91, false},
+		{"negative", 100, 50, -10, false},
+		{"zero", 100, 50, 0, false},
+		{"saturated cost", 100, 10, math.MaxInt64, false},
+		{"unlimited", 0, 0, math.MaxInt64, true},
+		{"unlimited negative", 0, 0, -1, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &costBudget{limit: tc.limit, used: tc.used}
+			if ok := b.acquire(tc.n); ok != tc.ok {
+				t.Fatalf("got %t, want %t", ok, tc.ok)
+			}
+			want := tc.used
+			if tc.ok && tc.limit > 0 {
+				want += tc.n
+			}
+			if b.used != want {
+				t.Errorf("got used %d, want %d", b.used, want)
+			}
+		})
+	}
+}
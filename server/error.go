@@ -70,6 +70,7 @@ const (
 	EC_RESOURCE_UPDATE_FAILED
 	EC_RESOURCE_DELETE_FAILED
 	EC_RESOURCE_STATE_UNEXPECTED
+	EC_RESOURCE_PRUNED
 )
 
 type Error struct {
@@ -242,6 +243,7 @@ var (
 	ENotAcceptable      = NewWrappedError(http.StatusNotAcceptable, "unsupported response type")
 	EBadMimetype        = NewWrappedError(http.StatusUnsupportedMediaType, "unsupported media type")
 	EConflict           = NewWrappedError(http.StatusConflict, "resource state conflict")
+	EGone               = NewWrappedError(http.StatusGone, "history pruned")
 	EInternal           = NewWrappedError(http.StatusInternalServerError, "internal server error")
 	ERequestTooLarge    = NewWrappedError(http.StatusRequestEntityTooLarge, "request size exceeds our limits")
 	ETooManyRequests    = NewWrappedError(http.StatusTooManyRequests, "request limit exceeded")
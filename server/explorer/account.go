@@ -185,7 +185,21 @@ func (a Account) RESTPath(r *mux.Router) string {
 	return path.String()
 }
 
+func (b Account) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, b.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{ident}":             {Summary: "Read account", Args: AccountRequest{}, Response: Account{}},
+		"GET /{ident}/contracts":   {Summary: "List contracts created by account", Args: AccountRequest{}, Response: []Account{}},
+		"GET /{ident}/operations":  {Summary: "List account operations", Args: OpsRequest{}, Response: OpList{}},
+		"GET /{ident}/tickets":     {Summary: "List account ticket balances", Args: ContractRequest{}, Response: []TicketBalance{}},
+		"GET /{ident}/delegations": {Summary: "List account delegation periods", Args: AccountRequest{}, Response: []DelegationPeriod{}},
+		"GET /{ident}/portfolio":   {Summary: "Read account portfolio", Args: AccountRequest{}, Response: Portfolio{}},
+		"GET /{ident}/op":          {Summary: "Read account with operations (legacy)", Args: OpsRequest{}, Response: Account{}},
+		"POST /explorer/accounts":  {Summary: "Read accounts by address", Args: BatchAccountRequest{}, Response: BatchResult{}},
+	})
+}
+
 func (b Account) RegisterDirectRoutes(r *mux.Router) error {
+	r.HandleFunc("/explorer/accounts", server.C(BatchLookupAccounts)).Methods("POST")
 	return nil
 }
 
@@ -194,6 +208,9 @@ func (b Account) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/{ident}/contracts", server.C(ReadManagedAccounts)).Methods("GET")
 	r.HandleFunc("/{ident}/operations", server.C(ListAccountOperations)).Methods("GET")
 	r.HandleFunc("/{ident}/metadata", server.C(ReadMetadata)).Methods("GET")
+	r.HandleFunc("/{ident}/tickets", server.C(ListAccountTickets)).Methods("GET")
+	r.HandleFunc("/{ident}/delegations", server.C(ListAccountDelegationPeriods)).Methods("GET")
+	r.HandleFunc("/{ident}/portfolio", server.C(GetPortfolio)).Methods("GET")
 
 	// LEGACY: keep here for dapp and wallet compatibility
 	r.HandleFunc("/{ident}/op", server.C(ReadAccountOps)).Methods("GET")
@@ -201,17 +218,33 @@ func (b Account) RegisterRoutes(r *mux.Router) error {
 }
 
 type AccountRequest struct {
-	ListRequest      // offset, limit, cursor, order
-	Meta        bool `schema:"meta"` // include account metadata
+	ListRequest        // offset, limit, cursor, order
+	Meta        bool   `schema:"meta"`  // include account metadata
+	Block       string `schema:"block"` // historic state at block height or hash
+
+	// decoded values
+	BlockHeight int64           `schema:"-"`
+	BlockHash   tezos.BlockHash `schema:"-"`
 }
 
-func (r *AccountRequest) WithPrim() bool    { return false }
-func (r *AccountRequest) WithUnpack() bool  { return false }
-func (r *AccountRequest) WithHeight() int64 { return 0 }
-func (r *AccountRequest) WithMeta() bool    { return r != nil && r.Meta }
-func (r *AccountRequest) WithRights() bool  { return false }
-func (r *AccountRequest) WithMerge() bool   { return false }
-func (r *AccountRequest) WithStorage() bool { return false }
+func (r *AccountRequest) WithPrim() bool        { return false }
+func (r *AccountRequest) WithUnpack() bool      { return false }
+func (r *AccountRequest) WithHeight() int64     { return r.BlockHeight }
+func (r *AccountRequest) WithMeta() bool        { return r != nil && r.Meta }
+func (r *AccountRequest) WithRights() bool      { return false }
+func (r *AccountRequest) WithMerge() bool       { return false }
+func (r *AccountRequest) WithStorage() bool     { return false }
+func (r *AccountRequest) WithStorageDiff() bool { return false }
+
+// HasBlock returns true when a historic block was requested, including the
+// genesis block at height 0.
+func (r *AccountRequest) HasBlock() bool { return r != nil && r.Block != "" }
+
+func (r *AccountRequest) Parse(ctx *server.Context) {
+	if len(r.Block) > 0 {
+		r.BlockHash, r.BlockHeight = lookupBlockId(ctx, r.Block)
+	}
+}
 
 func loadAccount(ctx *server.Context) *model.Account {
 	if accIdent, ok := mux.Vars(ctx.Request)["ident"]; !ok || accIdent == "" {
@@ -249,7 +282,20 @@ func loadAccount(ctx *server.Context) *model.Account {
 func ReadAccount(ctx *server.Context) (interface{}, int) {
 	args := &AccountRequest{}
 	ctx.ParseRequestArgs(args)
-	return NewAccount(ctx, loadAccount(ctx), args), http.StatusOK
+	acc := loadAccount(ctx)
+	if args.HasBlock() {
+		var err error
+		acc, err = ctx.Indexer.LookupAccountAt(ctx, acc, args.BlockHeight)
+		if err != nil {
+			switch err {
+			case index.ErrNoAccountEntry:
+				panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "account did not exist at block", err))
+			default:
+				panic(server.EInternal(server.EC_DATABASE, "cannot reconstruct account state", err))
+			}
+		}
+	}
+	return NewAccount(ctx, acc, args), http.StatusOK
 }
 
 func ReadManagedAccounts(ctx *server.Context) (interface{}, int) {
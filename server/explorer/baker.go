@@ -120,11 +120,21 @@ func NewBaker(ctx *server.Context, b *model.Baker, args server.Options) *Baker {
 		TotalDelegations:  b.TotalDelegations,
 		IsActive:          b.IsActive,
 		IsFull:            b.StakingBalance() >= capacity,
-		StakingShare:      math.Ceil(float64(stake)/float64(tip.Supply.ActiveStake)*100_000) / 100_000,
 		expires:           tip.Timestamp.Add(ctx.Params.BlockTime()),
 		lastmod:           ctx.Indexer.LookupBlockTime(ctx.Context, b.Account.LastSeen),
 	}
 
+	// staking share relative to active stake at the requested block
+	activeStake := tip.Supply.ActiveStake
+	if h := args.WithHeight(); h > 0 {
+		if supply, err := ctx.Indexer.SupplyByHeight(ctx, h); err == nil {
+			activeStake = supply.ActiveStake
+		}
+	}
+	if activeStake > 0 {
+		baker.StakingShare = math.Ceil(float64(stake)/float64(activeStake)*100_000) / 100_000
+	}
+
 	if !b.IsActive {
 		baker.BakerUntil = ctx.Indexer.LookupBlockTimePtr(ctx.Context, b.BakerUntil)
 		baker.StakingShare = 0
@@ -182,7 +192,7 @@ func NewBaker(ctx *server.Context, b *model.Baker, args server.Options) *Baker {
 				ev.LastEndorseTime = info.Timestamp
 			}
 
-			if b.IsActive {
+			if b.IsActive && args.WithHeight() == 0 {
 				// from rights cache
 				bh, eh := ctx.Indexer.NextRights(ctx, b.AccountId, tip.Height)
 				if bh > 0 {
@@ -227,6 +237,7 @@ func (b BakerList) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/{ident}/votes", server.C(ListBakerVotes)).Methods("GET")
 	r.HandleFunc("/{ident}/endorsements", server.C(ListBakerEndorsements)).Methods("GET")
 	r.HandleFunc("/{ident}/delegations", server.C(ListBakerDelegations)).Methods("GET")
+	r.HandleFunc("/{ident}/delegators", server.C(ListBakerDelegators)).Methods("GET")
 	r.HandleFunc("/{ident}/income/{cycle}", server.C(GetBakerIncome)).Methods("GET")
 	r.HandleFunc("/{ident}/rights/{cycle}", server.C(GetBakerRights)).Methods("GET")
 	r.HandleFunc("/{ident}/snapshot/{cycle}", server.C(GetBakerSnapshot)).Methods("GET")
@@ -503,7 +514,20 @@ func loadBaker(ctx *server.Context) *model.Baker {
 func ReadBaker(ctx *server.Context) (interface{}, int) {
 	args := &AccountRequest{}
 	ctx.ParseRequestArgs(args)
-	return NewBaker(ctx, loadBaker(ctx), args), http.StatusOK
+	bkr := loadBaker(ctx)
+	if args.HasBlock() {
+		var err error
+		bkr, err = ctx.Indexer.LookupBakerAt(ctx, bkr, args.BlockHeight)
+		if err != nil {
+			switch err {
+			case index.ErrNoBakerEntry:
+				panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "not a baker at block", err))
+			default:
+				panic(server.EInternal(server.EC_DATABASE, "cannot reconstruct baker state", err))
+			}
+		}
+	}
+	return NewBaker(ctx, bkr, args), http.StatusOK
 }
 
 func ListBakerVotes(ctx *server.Context) (interface{}, int) {
@@ -0,0 +1,280 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"fmt"
+	"net/http"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+// BatchResult maps each requested identifier to its result or error.
+type BatchResult map[string]*BatchItem
+
+type BatchItem struct {
+	Data  interface{}   `json:"data,omitempty"`
+	Error *server.Error `json:"error,omitempty"`
+}
+
+func (r BatchResult) set(id string, data interface{}) {
+	r[id] = &BatchItem{Data: data}
+}
+
+func (r BatchResult) fail(id string, err error) {
+	r[id] = &BatchItem{Error: err.(*server.Error)}
+}
+
+// BatchAccountRequest lists identifiers in the JSON body, options may be
+// passed as URL query or JSON fields.
+type BatchAccountRequest struct {
+	AccountRequest
+	Ids []string `schema:"-" json:"ids"`
+}
+
+type BatchContractRequest struct {
+	ContractRequest
+	Ids []string `schema:"-" json:"ids"`
+}
+
+type BatchOpsRequest struct {
+	OpsRequest
+	Ids []string `schema:"-" json:"ids"`
+}
+
+func checkBatchIds(ctx *server.Context, ids []string) {
+	if len(ids) == 0 {
+		panic(server.EBadRequest(server.EC_PARAM_REQUIRED, "missing identifiers", nil))
+	}
+	if max := ctx.Cfg.Http.MaxBatchCount; max > 0 && uint(len(ids)) > max {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("too many identifiers, max %d", max), nil))
+	}
+}
+
+func BatchLookupAccounts(ctx *server.Context) (interface{}, int) {
+	args := &BatchAccountRequest{}
+	ctx.ParseRequestArgs(args)
+	checkBatchIds(ctx, args.Ids)
+
+	resp := make(BatchResult, len(args.Ids))
+	addrs := make([]tezos.Address, 0, len(args.Ids))
+	inputs := make([]string, 0, len(args.Ids))
+	for _, v := range args.Ids {
+		addr, err := tezos.ParseAddress(v)
+		if err != nil {
+			resp.fail(v, server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid address", err))
+			continue
+		}
+		addrs = append(addrs, addr)
+		inputs = append(inputs, v)
+	}
+	accs, err := ctx.Indexer.LookupAccounts(ctx, addrs)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read accounts", err))
+	}
+	byAddr := make(map[string]*model.Account, len(accs))
+	for _, v := range accs {
+		byAddr[v.Address.String()] = v
+	}
+	// historic state is rebuilt per account
+	if args.HasBlock() {
+		ctx.ChargeCost(int64(len(addrs)))
+	}
+	for i, v := range addrs {
+		acc, ok := byAddr[v.String()]
+		if !ok {
+			resp.fail(inputs[i], server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such account", index.ErrNoAccountEntry))
+			continue
+		}
+		if args.HasBlock() {
+			acc, err = ctx.Indexer.LookupAccountAt(ctx, acc, args.BlockHeight)
+			if err != nil {
+				switch err {
+				case index.ErrNoAccountEntry:
+					resp.fail(inputs[i], server.ENotFound(server.EC_RESOURCE_NOTFOUND, "account did not exist at block", err))
+				default:
+					resp.fail(inputs[i], server.EInternal(server.EC_DATABASE, "cannot reconstruct account state", err))
+				}
+				continue
+			}
+		}
+		resp.set(inputs[i], NewAccount(ctx, acc, args))
+	}
+	return resp, http.StatusOK
+}
+
+func BatchLookupContracts(ctx *server.Context) (interface{}, int) {
+	args := &BatchContractRequest{}
+	ctx.ParseRequestArgs(args)
+	checkBatchIds(ctx, args.Ids)
+
+	resp := make(BatchResult, len(args.Ids))
+	addrs := make([]tezos.Address, 0, len(args.Ids))
+	inputs := make([]string, 0, len(args.Ids))
+	for _, v := range args.Ids {
+		addr, err := tezos.ParseAddress(v)
+		if err != nil || addr.Type != tezos.AddressTypeContract {
+			resp.fail(v, server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid contract address", err))
+			continue
+		}
+		addrs = append(addrs, addr)
+		inputs = append(inputs, v)
+	}
+	ccs, err := ctx.Indexer.LookupContracts(ctx, addrs)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read contracts", err))
+	}
+
+	// load contract accounts in a single batch
+	ids := make([]uint64, 0, len(ccs))
+	for _, v := range ccs {
+		ids = append(ids, v.AccountId.Value())
+	}
+	accs, err := ctx.Indexer.LookupAccountIds(ctx, ids)
+	if err != nil && err != index.ErrNoAccountEntry {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read accounts", err))
+	}
+	accMap := make(map[model.AccountID]*model.Account, len(accs))
+	for _, v := range accs {
+		accMap[v.RowId] = v
+	}
+	byAddr := make(map[string]*Contract, len(ccs))
+	for _, v := range ccs {
+		if acc, ok := accMap[v.AccountId]; ok {
+			byAddr[v.Address.String()] = NewContract(ctx, v, acc, args)
+		}
+	}
+	for i, v := range addrs {
+		if cc, ok := byAddr[v.String()]; ok {
+			resp.set(inputs[i], cc)
+		} else {
+			resp.fail(inputs[i], server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such contract", index.ErrNoContractEntry))
+		}
+	}
+	return resp, http.StatusOK
+}
+
+func BatchLookupOps(ctx *server.Context) (interface{}, int) {
+	args := &BatchOpsRequest{}
+	ctx.ParseRequestArgs(args)
+	checkBatchIds(ctx, args.Ids)
+
+	resp := make(BatchResult, len(args.Ids))
+	hashes := make([]tezos.OpHash, 0, len(args.Ids))
+	inputs := make([]string, 0, len(args.Ids))
+	for _, v := range args.Ids {
+		h, err := tezos.ParseOpHash(v)
+		if err != nil {
+			resp.fail(v, server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid operation hash", err))
+			continue
+		}
+		hashes = append(hashes, h)
+		inputs = append(inputs, v)
+	}
+	ops, err := ctx.Indexer.LookupOpHashes(ctx, hashes)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read operations", err))
+	}
+	byHash := make(map[string][]*model.Op, len(hashes))
+	for _, v := range ops {
+		key := v.Hash.String()
+		byHash[key] = append(byHash[key], v)
+	}
+
+	// endorsements are kept in a separate table
+	missing := make([]tezos.OpHash, 0)
+	for _, v := range hashes {
+		if _, ok := byHash[v.String()]; !ok {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		ends, err := ctx.Indexer.LookupEndorsementHashes(ctx, missing)
+		if err != nil && err != etl.ErrNoTable {
+			panic(server.EInternal(server.EC_DATABASE, "cannot read endorsements", err))
+		}
+		for _, v := range ends {
+			key := v.Hash.String()
+			byHash[key] = append(byHash[key], v)
+		}
+		ops = append(ops, ends...)
+	}
+
+	// storage and bigmap updates are loaded per operation
+	if args.WithStorage() || args.WithStorageDiff() {
+		ctx.ChargeCost(int64(len(ops)))
+	}
+
+	cache := make(map[int64]interface{})
+	for i, v := range hashes {
+		list, ok := byHash[v.String()]
+		if !ok {
+			resp.fail(inputs[i], server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such operation", index.ErrNoOpEntry))
+			continue
+		}
+		res := make(OpList, 0, len(list))
+		for _, op := range list {
+			res.Append(NewOp(ctx, op, nil, nil, args, cache), args.WithMerge())
+		}
+		resp.set(inputs[i], res)
+	}
+	return resp, http.StatusOK
+}
+
+// BatchLookupBigmapKeys returns current values for a list of keys or key
+// hashes in a bigmap.
+func BatchLookupBigmapKeys(ctx *server.Context) (interface{}, int) {
+	args := &BatchContractRequest{}
+	ctx.ParseRequestArgs(args)
+	checkBatchIds(ctx, args.Ids)
+	if args.Block != "" {
+		panic(server.EBadRequest(server.EC_PARAM_NOTEXPECTED, "historic batch lookups are not supported", nil))
+	}
+	alloc := loadBigmap(ctx)
+	keyType := alloc.GetKeyType()
+
+	resp := make(BatchResult, len(args.Ids))
+	hashes := make([]tezos.ExprHash, 0, len(args.Ids))
+	inputs := make(map[string][]string, len(args.Ids))
+	for _, v := range args.Ids {
+		expr, err := tezos.ParseExprHash(v)
+		if err != nil {
+			key, err := micheline.ParseKey(keyType.OpCode, v)
+			if err != nil {
+				resp.fail(v, server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid bigmap key", err))
+				continue
+			}
+			expr = key.Hash()
+		}
+		hashes = append(hashes, expr)
+		inputs[expr.String()] = append(inputs[expr.String()], v)
+	}
+	items, err := ctx.Indexer.LookupBigmapKeys(ctx, alloc.BigmapId, hashes)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read bigmap", err))
+	}
+	for _, v := range items {
+		hash := v.GetKeyHash().String()
+		val, err := NewBigmapValue(ctx, alloc, v, args)
+		for _, in := range inputs[hash] {
+			if err != nil {
+				resp.fail(in, server.EInternal(server.EC_SERVER, "cannot decode bigmap key", err))
+			} else {
+				resp.set(in, val)
+			}
+		}
+		delete(inputs, hash)
+	}
+	for _, list := range inputs {
+		for _, in := range list {
+			resp.fail(in, server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such bigmap key", nil))
+		}
+	}
+	return resp, http.StatusOK
+}
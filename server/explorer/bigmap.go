@@ -5,11 +5,14 @@ package explorer
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
 	"blockwatch.cc/tzindex/etl"
@@ -87,6 +90,19 @@ func (b Bigmap) RESTPath(r *mux.Router) string {
 	return path.String()
 }
 
+func (b Bigmap) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, b.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{id}":               {Summary: "Read bigmap", Args: ContractRequest{}, Response: Bigmap{}},
+		"GET /{id}/keys":          {Summary: "List bigmap keys", Args: BigmapRequest{}, Response: []BigmapKey{}},
+		"POST /{id}/keys":         {Summary: "Read bigmap values by key", Args: BatchContractRequest{}, Response: BatchResult{}},
+		"GET /{id}/values":        {Summary: "List bigmap values", Args: BigmapRequest{}, Response: []BigmapValue{}},
+		"GET /{id}/updates":       {Summary: "List bigmap updates", Args: ContractRequest{}, Response: []BigmapUpdate{}},
+		"GET /{id}/diff":          {Summary: "Diff bigmap between two blocks", Args: BigmapDiffRequest{}, Response: []BigmapDiff{}},
+		"GET /{id}/{key}/updates": {Summary: "List bigmap key updates", Args: ContractRequest{}, Response: []BigmapUpdate{}},
+		"GET /{id}/{key}":         {Summary: "Read bigmap value", Args: ContractRequest{}, Response: BigmapValue{}},
+	})
+}
+
 func (b Bigmap) RegisterDirectRoutes(r *mux.Router) error {
 	return nil
 }
@@ -94,8 +110,10 @@ func (b Bigmap) RegisterDirectRoutes(r *mux.Router) error {
 func (b Bigmap) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/{id}", server.C(ReadBigmap)).Methods("GET").Name("bigmap")
 	r.HandleFunc("/{id}/keys", server.C(ListBigmapKeys)).Methods("GET")
+	r.HandleFunc("/{id}/keys", server.C(BatchLookupBigmapKeys)).Methods("POST")
 	r.HandleFunc("/{id}/values", server.C(ListBigmapValues)).Methods("GET")
 	r.HandleFunc("/{id}/updates", server.C(ListBigmapUpdates)).Methods("GET")
+	r.HandleFunc("/{id}/diff", server.C(DiffBigmap)).Methods("GET")
 	r.HandleFunc("/{id}/{key}/updates", server.C(ListBigmapKeyUpdates)).Methods("GET")
 	r.HandleFunc("/{id}/{key}", server.C(ReadBigmapValue)).Methods("GET")
 	return nil
@@ -188,6 +206,108 @@ func (l BigmapUpdateList) Expires() time.Time           { return l.expires }
 
 var _ server.Resource = (*BigmapUpdateList)(nil)
 
+// diffs
+type BigmapDiff struct {
+	Action       string           `json:"action"` // added, removed, changed
+	Key          *micheline.Key   `json:"key"`
+	KeyHash      tezos.ExprHash   `json:"hash"`
+	OldValue     *micheline.Value `json:"old_value,omitempty"` // omit on added keys
+	NewValue     *micheline.Value `json:"new_value,omitempty"` // omit on removed keys
+	Meta         *BigmapMeta      `json:"meta,omitempty"`
+	KeyPrim      *micheline.Prim  `json:"key_prim,omitempty"`
+	OldValuePrim *micheline.Prim  `json:"old_value_prim,omitempty"`
+	NewValuePrim *micheline.Prim  `json:"new_value_prim,omitempty"`
+}
+
+type BigmapDiffList struct {
+	list     []BigmapDiff
+	modified time.Time
+	expires  time.Time
+}
+
+func (l BigmapDiffList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l BigmapDiffList) LastModified() time.Time      { return l.modified }
+func (l BigmapDiffList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*BigmapDiffList)(nil)
+
+// BigmapRequest adds decoded key and value filters and a sort field to
+// bigmap key and value listings.
+type BigmapRequest struct {
+	ContractRequest
+
+	OrderBy string `schema:"order_by"` // decoded bigmap sort field
+
+	// decoded bigmap key and value conditions
+	BigmapFilters []model.BigmapFilter `schema:"-"`
+}
+
+func (r *BigmapRequest) Parse(ctx *server.Context) {
+	r.ContractRequest.Parse(ctx)
+	// filter bigmap entries by decoded key and value, e.g. `key.owner=tz1..`
+	// or `value.amount.gte=100`, a trailing filter mode is optional
+	for key, val := range ctx.Request.URL.Query() {
+		keys := strings.Split(key, ".")
+		if keys[0] != "key" && keys[0] != "value" {
+			continue
+		}
+		mode := pack.FilterModeEqual
+		if n := len(keys); n > 1 {
+			switch m := keys[n-1]; m {
+			case "prefix":
+				mode = pack.FilterModeRegexp
+				keys = keys[:n-1]
+			default:
+				switch pm := pack.ParseFilterMode(m); pm {
+				case pack.FilterModeEqual, pack.FilterModeNotEqual,
+					pack.FilterModeGt, pack.FilterModeGte,
+					pack.FilterModeLt, pack.FilterModeLte,
+					pack.FilterModeIn, pack.FilterModeNotIn:
+					if m != "" {
+						mode = pm
+						keys = keys[:n-1]
+					}
+				}
+			}
+		}
+		r.BigmapFilters = append(r.BigmapFilters, model.NewBigmapFilter(strings.Join(keys, "."), mode, val[0]))
+	}
+	if r.OrderBy != "" {
+		if p := strings.Split(r.OrderBy, ".")[0]; p != "key" && p != "value" {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid order_by field '%s', must start with key or value", r.OrderBy), nil))
+		}
+	}
+}
+
+// BigmapDiffRequest selects the block range of a bigmap diff. The end block
+// defaults to the current chain tip.
+type BigmapDiffRequest struct {
+	ContractRequest
+
+	From string `schema:"from"` // block hash or height
+	To   string `schema:"to"`   // block hash or height
+
+	// decoded values
+	FromHeight int64 `schema:"-"`
+	ToHeight   int64 `schema:"-"`
+}
+
+func (r *BigmapDiffRequest) Parse(ctx *server.Context) {
+	r.ContractRequest.Parse(ctx)
+	if r.From == "" {
+		panic(server.EBadRequest(server.EC_PARAM_REQUIRED, "missing from block", nil))
+	}
+	_, r.FromHeight = lookupBlockId(ctx, r.From)
+	if r.To != "" {
+		_, r.ToHeight = lookupBlockId(ctx, r.To)
+	} else {
+		r.ToHeight = ctx.Tip.BestHeight
+	}
+	if r.FromHeight >= r.ToHeight {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, "from block must be lower than to block", nil))
+	}
+}
+
 func loadBigmap(ctx *server.Context) *model.BigmapAlloc {
 	if id, ok := mux.Vars(ctx.Request)["id"]; !ok || id == "" {
 		panic(server.EBadRequest(server.EC_RESOURCE_ID_MISSING, "missing bigmap id", nil))
@@ -233,31 +353,10 @@ func ReadBigmap(ctx *server.Context) (interface{}, int) {
 }
 
 func ListBigmapKeys(ctx *server.Context) (interface{}, int) {
-	args := &ContractRequest{}
+	args := &BigmapRequest{}
 	ctx.ParseRequestArgs(args)
 	alloc := loadBigmap(ctx)
-
-	r := etl.ListRequest{
-		BigmapId: alloc.BigmapId,
-		Since:    args.BlockHeight,
-		Cursor:   args.Cursor,
-		Offset:   args.Offset,
-		Limit:    ctx.Cfg.ClampExplore(args.Limit),
-		Order:    args.Order,
-	}
-
-	var (
-		items []*model.BigmapKV
-		err   error
-	)
-	if r.Since == 0 {
-		items, err = ctx.Indexer.ListBigmapKeys(ctx.Context, r)
-	} else {
-		items, err = ctx.Indexer.ListHistoricBigmapKeys(ctx.Context, r)
-	}
-	if err != nil {
-		panic(server.EInternal(server.EC_DATABASE, "cannot read bigmap", err))
-	}
+	items := listBigmapEntries(ctx, alloc, args)
 
 	resp := &BigmapKeyList{
 		list:     make([]BigmapKey, 0, len(items)),
@@ -301,31 +400,10 @@ func ListBigmapKeys(ctx *server.Context) (interface{}, int) {
 }
 
 func ListBigmapValues(ctx *server.Context) (interface{}, int) {
-	args := &ContractRequest{}
+	args := &BigmapRequest{}
 	ctx.ParseRequestArgs(args)
 	alloc := loadBigmap(ctx)
-
-	r := etl.ListRequest{
-		BigmapId: alloc.BigmapId,
-		Since:    args.BlockHeight,
-		Cursor:   args.Cursor,
-		Offset:   args.Offset,
-		Limit:    ctx.Cfg.ClampExplore(args.Limit),
-		Order:    args.Order,
-	}
-
-	var (
-		items []*model.BigmapKV
-		err   error
-	)
-	if r.Since == 0 {
-		items, err = ctx.Indexer.ListBigmapKeys(ctx.Context, r)
-	} else {
-		items, err = ctx.Indexer.ListHistoricBigmapKeys(ctx.Context, r)
-	}
-	if err != nil {
-		panic(server.EInternal(server.EC_DATABASE, "cannot read bigmap", err))
-	}
+	items := listBigmapEntries(ctx, alloc, args)
 
 	resp := &BigmapValueList{
 		list:     make([]BigmapValue, 0, len(items)),
@@ -378,14 +456,56 @@ func ListBigmapValues(ctx *server.Context) (interface{}, int) {
 	return resp, http.StatusOK
 }
 
+// listBigmapEntries lists live or historic entries of alloc. Decoded key
+// and value filters and sorting are charged per scanned entry.
+func listBigmapEntries(ctx *server.Context, alloc *model.BigmapAlloc, args *BigmapRequest) []*model.BigmapKV {
+	r := etl.ListRequest{
+		BigmapId: alloc.BigmapId,
+		Since:    args.BlockHeight,
+		Cursor:   args.Cursor,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+	}
+
+	var (
+		items []*model.BigmapKV
+		err   error
+	)
+	switch {
+	case len(args.BigmapFilters) > 0 || args.OrderBy != "":
+		// filters and sorting are evaluated on live entries only
+		if r.Since > 0 {
+			panic(server.EBadRequest(server.EC_PARAM_NOTEXPECTED, "bigmap filters and order_by cannot be used with block", nil))
+		}
+		if args.OrderBy != "" && args.Cursor > 0 {
+			panic(server.EBadRequest(server.EC_PARAM_NOTEXPECTED, "cursor cannot be used with order_by, use offset instead", nil))
+		}
+		r.BigmapFilters = args.BigmapFilters
+		r.BigmapOrderBy = args.OrderBy
+		r.Charge = ctx.TryChargeCost
+		items, err = ctx.Indexer.FilterBigmapKeys(ctx.Context, alloc, r)
+	case r.Since == 0:
+		items, err = ctx.Indexer.ListBigmapKeys(ctx.Context, r)
+	default:
+		items, err = ctx.Indexer.ListHistoricBigmapKeys(ctx.Context, r)
+	}
+	if err != nil {
+		if e, ok := err.(*server.Error); ok {
+			panic(e)
+		}
+		panic(server.EInternal(server.EC_DATABASE, "cannot read bigmap", err))
+	}
+	return items
+}
+
 func ReadBigmapValue(ctx *server.Context) (interface{}, int) {
 	args := &ContractRequest{}
 	ctx.ParseRequestArgs(args)
 
 	// support key and key_hash
 	alloc := loadBigmap(ctx)
-	keyType, valType := alloc.GetKeyType(), alloc.GetValueType()
-	_, expr := parseBigmapKey(ctx, keyType.OpCode)
+	_, expr := parseBigmapKey(ctx, alloc.GetKeyType().OpCode)
 
 	r := etl.ListRequest{
 		BigmapId:  alloc.BigmapId,
@@ -408,11 +528,19 @@ func ReadBigmapValue(ctx *server.Context) (interface{}, int) {
 	if len(items) == 0 {
 		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such bigmap key", err))
 	}
-	v := items[0]
-	key, err := v.GetKey(keyType)
+	resp, err := NewBigmapValue(ctx, alloc, items[0], args)
 	if err != nil {
 		panic(server.EInternal(server.EC_SERVER, "cannot decode bigmap key", err))
 	}
+	return resp, http.StatusOK
+}
+
+func NewBigmapValue(ctx *server.Context, alloc *model.BigmapAlloc, v *model.BigmapKV, args server.Options) (*BigmapValue, error) {
+	keyType, valType := alloc.GetKeyType(), alloc.GetValueType()
+	key, err := v.GetKey(keyType)
+	if err != nil {
+		return nil, err
+	}
 
 	keyHash := v.GetKeyHash()
 	typedValue := v.GetValue(valType)
@@ -448,8 +576,7 @@ func ReadBigmapValue(ctx *server.Context) (interface{}, int) {
 			}
 		}
 	}
-
-	return resp, http.StatusOK
+	return resp, nil
 }
 
 func ListBigmapUpdates(ctx *server.Context) (interface{}, int) {
@@ -589,6 +716,106 @@ func ListBigmapUpdates(ctx *server.Context) (interface{}, int) {
 	return resp, http.StatusOK
 }
 
+// DiffBigmap returns keys that were added, removed or changed between two
+// blocks together with their old and new values.
+func DiffBigmap(ctx *server.Context) (interface{}, int) {
+	args := &BigmapDiffRequest{}
+	ctx.ParseRequestArgs(args)
+	alloc := loadBigmap(ctx)
+	r := etl.ListRequest{
+		BigmapId: alloc.BigmapId,
+		Since:    args.FromHeight,
+		Until:    args.ToHeight,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+		Charge:   ctx.TryChargeCost,
+	}
+
+	items, err := ctx.Indexer.DiffBigmap(ctx.Context, r)
+	if err != nil {
+		if e, ok := err.(*server.Error); ok {
+			panic(e)
+		}
+		if err == etl.ErrPruned {
+			panic(server.EGone(server.EC_RESOURCE_PRUNED, "bigmap history before the pruning horizon is incomplete", err))
+		}
+		panic(server.EInternal(server.EC_DATABASE, "cannot read bigmap", err))
+	}
+
+	resp := &BigmapDiffList{
+		list:     make([]BigmapDiff, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, args.ToHeight),
+	}
+
+	keyType, valType := alloc.GetKeyType(), alloc.GetValueType()
+	contract := ctx.Indexer.LookupAddress(ctx, alloc.AccountId)
+	for _, v := range items {
+		key, err := v.New.GetKey(keyType)
+		if err != nil {
+			log.Errorf("explorer: decode bigmap key: %v", err)
+			continue
+		}
+		diff := BigmapDiff{
+			Action:  "changed",
+			Key:     &key,
+			KeyHash: v.New.GetKeyHash(),
+		}
+		switch {
+		case v.Old == nil:
+			diff.Action = "added"
+		case v.New.Action == micheline.DiffActionRemove:
+			diff.Action = "removed"
+		}
+		if v.Old != nil {
+			val := v.Old.GetValue(valType)
+			diff.OldValue = &val
+		}
+		if v.New.Action == micheline.DiffActionUpdate {
+			val := v.New.GetValue(valType)
+			diff.NewValue = &val
+		}
+		if args.WithMeta() {
+			diff.Meta = &BigmapMeta{
+				Contract:     contract,
+				BigmapId:     alloc.BigmapId,
+				UpdateTime:   &v.New.Timestamp,
+				UpdateHeight: v.New.Height,
+			}
+		}
+		if args.WithPrim() {
+			diff.KeyPrim = key.PrimPtr()
+			if diff.OldValue != nil {
+				diff.OldValuePrim = &diff.OldValue.Value
+			}
+			if diff.NewValue != nil {
+				diff.NewValuePrim = &diff.NewValue.Value
+			}
+		}
+		if args.WithUnpack() {
+			if diff.OldValue != nil && diff.OldValue.IsPackedAny() {
+				if up, err := diff.OldValue.UnpackAll(); err == nil {
+					diff.OldValue = &up
+				}
+			}
+			if diff.NewValue != nil && diff.NewValue.IsPackedAny() {
+				if up, err := diff.NewValue.UnpackAll(); err == nil {
+					diff.NewValue = &up
+				}
+			}
+			if diff.Key.IsPacked() {
+				if up, err := diff.Key.Unpack(); err == nil {
+					diff.Key = &up
+				}
+			}
+		}
+		resp.list = append(resp.list, diff)
+	}
+
+	return resp, http.StatusOK
+}
+
 func ListBigmapKeyUpdates(ctx *server.Context) (interface{}, int) {
 	args := &ContractRequest{}
 	ctx.ParseRequestArgs(args)
@@ -257,6 +257,15 @@ func (b Block) RESTPath(r *mux.Router) string {
 	return path.String()
 }
 
+func (b Block) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, b.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{ident}":             {Summary: "Read block", Args: BlockRequest{}, Response: Block{}},
+		"GET /{ident}/operations":  {Summary: "List block operations", Args: OpsRequest{}, Response: OpList{}},
+		"GET /{ident}/op":          {Summary: "Read block with operations (legacy)", Args: OpsRequest{}, Response: Block{}},
+		"GET /{ident}/orphan_info": {Summary: "Read reorg info for an orphaned block", Response: OrphanInfo{}},
+	})
+}
+
 func (b Block) RegisterDirectRoutes(r *mux.Router) error {
 	return nil
 }
@@ -264,6 +273,7 @@ func (b Block) RegisterDirectRoutes(r *mux.Router) error {
 func (b Block) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/{ident}", server.C(ReadBlock)).Methods("GET").Name("block")
 	r.HandleFunc("/{ident}/operations", server.C(ListBlockOps)).Methods("GET")
+	r.HandleFunc("/{ident}/orphan_info", server.C(ReadOrphanInfo)).Methods("GET")
 
 	// LEGACY
 	r.HandleFunc("/{ident}/op", server.C(ReadBlockOps)).Methods("GET")
@@ -296,13 +306,14 @@ type BlockRequest struct {
 	Rights bool `schema:"rights"` // include rights
 }
 
-func (r *BlockRequest) WithPrim() bool    { return false }
-func (r *BlockRequest) WithUnpack() bool  { return false }
-func (r *BlockRequest) WithHeight() int64 { return 0 }
-func (r *BlockRequest) WithMeta() bool    { return r != nil && r.Meta }
-func (r *BlockRequest) WithRights() bool  { return r != nil && r.Rights }
-func (r *BlockRequest) WithMerge() bool   { return false }
-func (r *BlockRequest) WithStorage() bool { return false }
+func (r *BlockRequest) WithPrim() bool        { return false }
+func (r *BlockRequest) WithUnpack() bool      { return false }
+func (r *BlockRequest) WithHeight() int64     { return 0 }
+func (r *BlockRequest) WithMeta() bool        { return r != nil && r.Meta }
+func (r *BlockRequest) WithRights() bool      { return r != nil && r.Rights }
+func (r *BlockRequest) WithMerge() bool       { return false }
+func (r *BlockRequest) WithStorage() bool     { return false }
+func (r *BlockRequest) WithStorageDiff() bool { return false }
 
 func ReadBlock(ctx *server.Context) (interface{}, int) {
 	args := &BlockRequest{}
@@ -328,6 +339,7 @@ func listBlockOps(ctx *server.Context) OpList {
 	args := &OpsRequest{}
 	ctx.ParseRequestArgs(args)
 	block := loadBlock(ctx)
+	checkPruned(ctx, block.Height)
 
 	// don't use offset/limit because we mix in endorsements
 	r := etl.ListRequest{
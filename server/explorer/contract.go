@@ -144,7 +144,19 @@ func (a Contract) RESTPath(r *mux.Router) string {
 	return path.String()
 }
 
+func (b Contract) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, b.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{ident}":             {Summary: "Read contract", Args: AccountRequest{}, Response: Contract{}},
+		"GET /{ident}/calls":       {Summary: "List contract calls", Args: ContractRequest{}, Response: OpList{}},
+		"GET /{ident}/script":      {Summary: "Read contract script", Args: ContractRequest{}, Response: Script{}},
+		"GET /{ident}/storage":     {Summary: "Read contract storage", Args: ContractRequest{}, Response: StorageValue{}},
+		"GET /{ident}/gas_stats":   {Summary: "Read contract gas statistics", Args: ContractRequest{}, Response: []GasStats{}},
+		"POST /explorer/contracts": {Summary: "Read contracts by address", Args: BatchContractRequest{}, Response: BatchResult{}},
+	})
+}
+
 func (b Contract) RegisterDirectRoutes(r *mux.Router) error {
+	r.HandleFunc("/explorer/contracts", server.C(BatchLookupContracts)).Methods("POST")
 	return nil
 }
 
@@ -153,6 +165,7 @@ func (b Contract) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/{ident}/calls", server.C(ReadContractCalls)).Methods("GET")
 	r.HandleFunc("/{ident}/script", server.C(ReadContractScript)).Methods("GET")
 	r.HandleFunc("/{ident}/storage", server.C(ReadContractStorage)).Methods("GET")
+	r.HandleFunc("/{ident}/gas_stats", server.C(ReadContractGasStats)).Methods("GET")
 	return nil
 
 }
@@ -167,22 +180,30 @@ type ExplorerParameters struct {
 	Arguments  json.RawMessage  `json:"arguments,omitempty"`  // rollup
 }
 
+// maxParamFilters limits param.* filters per call list request, each filter
+// scans the param table of the contract.
+const maxParamFilters = 4
+
 type ContractRequest struct {
 	ListRequest // offset, limit, cursor, order
 
-	Block   string        `schema:"block"`   // height or hash for time-lock
-	Since   string        `schema:"since"`   // block hash or height for updates
-	Unpack  bool          `schema:"unpack"`  // unpack packed key/values
-	Prim    bool          `schema:"prim"`    // for prim/value rendering
-	Meta    bool          `schema:"meta"`    // include account metadata
-	Merge   bool          `schema:"merge"`   // collapse internal calls
-	Storage bool          `schema:"storage"` // embed storage updates
-	Sender  tezos.Address `schema:"sender"`  // sender address
+	Block       string        `schema:"block"`        // height or hash for time-lock
+	Since       string        `schema:"since"`        // block hash or height for updates
+	Unpack      bool          `schema:"unpack"`       // unpack packed key/values
+	Prim        bool          `schema:"prim"`         // for prim/value rendering
+	Meta        bool          `schema:"meta"`         // include account metadata
+	Merge       bool          `schema:"merge"`        // collapse internal calls
+	Storage     bool          `schema:"storage"`      // embed storage updates
+	StorageDiff bool          `schema:"storage_diff"` // embed storage diffs
+	Sender      tezos.Address `schema:"sender"`       // sender address
 
 	// decoded entrypoint condition (list of name, num or branch)
 	EntrypointMode pack.FilterMode `schema:"-"`
 	EntrypointCond string          `schema:"-"`
 
+	// decoded call parameter conditions
+	Params []etl.ParamFilter `schema:"-"`
+
 	// decoded values
 	BlockHeight int64           `schema:"-"`
 	BlockHash   tezos.BlockHash `schema:"-"`
@@ -199,10 +220,11 @@ func (r *ContractRequest) WithHeight() int64 {
 	return 0
 }
 
-func (r *ContractRequest) WithMeta() bool    { return r != nil && r.Meta }
-func (r *ContractRequest) WithRights() bool  { return false }
-func (r *ContractRequest) WithMerge() bool   { return r != nil && r.Merge }
-func (r *ContractRequest) WithStorage() bool { return r != nil && r.Storage }
+func (r *ContractRequest) WithMeta() bool        { return r != nil && r.Meta }
+func (r *ContractRequest) WithRights() bool      { return false }
+func (r *ContractRequest) WithMerge() bool       { return r != nil && r.Merge }
+func (r *ContractRequest) WithStorage() bool     { return r != nil && r.Storage }
+func (r *ContractRequest) WithStorageDiff() bool { return r != nil && r.StorageDiff }
 
 func (r *ContractRequest) Parse(ctx *server.Context) {
 	if len(r.Block) > 0 {
@@ -219,6 +241,7 @@ func (r *ContractRequest) Parse(ctx *server.Context) {
 				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
 			}
 		}
+		checkPruned(ctx, height)
 		r.BlockHeight = height
 		r.BlockHash = hash
 	}
@@ -236,6 +259,7 @@ func (r *ContractRequest) Parse(ctx *server.Context) {
 				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
 			}
 		}
+		checkPruned(ctx, height)
 		r.SinceHeight = height
 		r.SinceHash = hash
 	}
@@ -270,6 +294,43 @@ func (r *ContractRequest) Parse(ctx *server.Context) {
 			}
 		}
 	}
+	// filter by decoded call parameters, e.g. `param.to=tz1..` or
+	// `param.amount.gt=1000`, a trailing filter mode is optional
+	for key, val := range ctx.Request.URL.Query() {
+		keys := strings.Split(key, ".")
+		if keys[0] != "param" {
+			continue
+		}
+		f := etl.ParamFilter{Mode: pack.FilterModeEqual}
+		keys = keys[1:]
+		if n := len(keys); n > 0 && keys[n-1] != "" {
+			switch mode := pack.ParseFilterMode(keys[n-1]); mode {
+			case pack.FilterModeEqual, pack.FilterModeNotEqual,
+				pack.FilterModeGt, pack.FilterModeGte,
+				pack.FilterModeLt, pack.FilterModeLte,
+				pack.FilterModeIn, pack.FilterModeNotIn:
+				f.Mode = mode
+				keys = keys[:n-1]
+			}
+		}
+		f.Path = strings.Join(keys, ".")
+		switch f.Mode {
+		case pack.FilterModeGt, pack.FilterModeGte, pack.FilterModeLt, pack.FilterModeLte:
+			n, err := strconv.ParseInt(val[0], 10, 64)
+			if err != nil {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid number %q for %s", val[0], key), err))
+			}
+			f.Value = n
+		case pack.FilterModeIn, pack.FilterModeNotIn:
+			f.Value = strings.Split(val[0], ",")
+		default:
+			f.Value = val[0]
+		}
+		r.Params = append(r.Params, f)
+		if len(r.Params) > maxParamFilters {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("too many parameter filters, max %d", maxParamFilters), nil))
+		}
+	}
 }
 
 func loadContract(ctx *server.Context) *model.Contract {
@@ -333,7 +394,7 @@ func ReadContractCalls(ctx *server.Context) (interface{}, int) {
 		Limit:       ctx.Cfg.ClampExplore(args.Limit),
 		Cursor:      args.Cursor,
 		Order:       args.Order,
-		WithStorage: args.WithStorage(),
+		WithStorage: args.WithStorage() || args.WithStorageDiff(),
 	}
 
 	if args.Sender.IsValid() {
@@ -344,6 +405,14 @@ func ReadContractCalls(ctx *server.Context) (interface{}, int) {
 		}
 	}
 
+	if len(args.Params) > 0 {
+		if _, err := ctx.Indexer.Table(index.ParamTableKey); err != nil {
+			panic(server.EBadRequest(server.EC_PARAM_NOTEXPECTED, "parameter filters require the param index", err))
+		}
+		r.Params = args.Params
+		r.Charge = ctx.TryChargeCost
+	}
+
 	// parse entrypoint filter
 	// - name (eg. "default")
 	// - branch (eg. "/R/R/L")
@@ -393,6 +462,9 @@ func ReadContractCalls(ctx *server.Context) (interface{}, int) {
 
 	ops, err := ctx.Indexer.ListContractCalls(ctx, r)
 	if err != nil {
+		if e, ok := err.(*server.Error); ok {
+			panic(e)
+		}
 		panic(server.EInternal(server.EC_DATABASE, "cannot read contract calls", err))
 	}
 
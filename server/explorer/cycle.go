@@ -435,6 +435,7 @@ func (c Cycle) RegisterRoutes(r *mux.Router) error {
 	return nil
 }
 
+// parseCycle reads the cycle path argument and fails for pruned cycles.
 func parseCycle(ctx *server.Context) int64 {
 	// from number or string
 	if id, ok := mux.Vars(ctx.Request)["cycle"]; !ok || id == "" {
@@ -449,6 +450,7 @@ func parseCycle(ctx *server.Context) int64 {
 			if err != nil || cycle < 0 {
 				panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid cycle", err))
 			}
+			checkPrunedCycle(ctx, cycle)
 			return cycle
 		}
 	}
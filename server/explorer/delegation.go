@@ -0,0 +1,123 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"net/http"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+type DelegationPeriod struct {
+	RowId        uint64        `json:"row_id"`
+	Account      tezos.Address `json:"account"`
+	Baker        tezos.Address `json:"baker"`
+	StartHeight  int64         `json:"start_height"`
+	StartTime    time.Time     `json:"start_time"`
+	StartBalance float64       `json:"start_balance"`
+	EndHeight    int64         `json:"end_height,omitempty"`
+	EndTime      *time.Time    `json:"end_time,omitempty"`
+	EndBalance   float64       `json:"end_balance,omitempty"`
+	IsActive     bool          `json:"is_active"`
+}
+
+// NewDelegationPeriod renders p with its active status at the end of block
+// height.
+func NewDelegationPeriod(ctx *server.Context, p *model.DelegationPeriod, height int64) DelegationPeriod {
+	d := DelegationPeriod{
+		RowId:        p.RowId,
+		Account:      ctx.Indexer.LookupAddress(ctx, p.AccountId),
+		Baker:        ctx.Indexer.LookupAddress(ctx, p.BakerId),
+		StartHeight:  p.StartHeight,
+		StartTime:    ctx.Indexer.LookupBlockTime(ctx, p.StartHeight),
+		StartBalance: ctx.Params.ConvertValue(p.StartBalance),
+		IsActive:     p.IsActive(height),
+	}
+	if p.EndHeight > 0 {
+		tm := ctx.Indexer.LookupBlockTime(ctx, p.EndHeight)
+		d.EndHeight = p.EndHeight
+		d.EndTime = &tm
+		d.EndBalance = ctx.Params.ConvertValue(p.EndBalance)
+	}
+	return d
+}
+
+type DelegatorsRequest struct {
+	ListRequest
+	Cycle int64 `schema:"cycle"`
+}
+
+func ListAccountDelegationPeriods(ctx *server.Context) (interface{}, int) {
+	args := &AccountRequest{
+		ListRequest: ListRequest{
+			Order: pack.OrderDesc,
+		},
+	}
+	ctx.ParseRequestArgs(args)
+	acc := loadAccount(ctx)
+
+	r := etl.ListRequest{
+		Account: acc,
+		Cursor:  args.Cursor,
+		Offset:  args.Offset,
+		Limit:   ctx.Cfg.ClampExplore(args.Limit),
+		Order:   args.Order,
+	}
+	items, err := ctx.Indexer.ListDelegationPeriods(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read delegation periods", err))
+	}
+	resp := make([]DelegationPeriod, 0, len(items))
+	for _, v := range items {
+		resp = append(resp, NewDelegationPeriod(ctx, v, ctx.Tip.BestHeight))
+	}
+	return resp, http.StatusOK
+}
+
+// ListBakerDelegators returns delegators of a baker at the end of a cycle,
+// or at the current block when cycle is unset or still in progress.
+func ListBakerDelegators(ctx *server.Context) (interface{}, int) {
+	args := &DelegatorsRequest{
+		ListRequest: ListRequest{
+			Order: pack.OrderAsc,
+		},
+		Cycle: -1,
+	}
+	ctx.ParseRequestArgs(args)
+	bkr := loadBaker(ctx)
+
+	height := ctx.Tip.BestHeight
+	if args.Cycle >= 0 {
+		if args.Cycle > ctx.Params.CycleFromHeight(height) {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, "cycle is in the future", nil))
+		}
+		checkPrunedCycle(ctx, args.Cycle)
+		if h := ctx.Params.ForCycle(args.Cycle).CycleEndHeight(args.Cycle); h < height {
+			height = h
+		}
+	}
+
+	r := etl.ListRequest{
+		Account: bkr.Account,
+		Until:   height,
+		Cursor:  args.Cursor,
+		Offset:  args.Offset,
+		Limit:   ctx.Cfg.ClampExplore(args.Limit),
+		Order:   args.Order,
+	}
+	items, err := ctx.Indexer.ListDelegators(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read delegators", err))
+	}
+	resp := make([]DelegationPeriod, 0, len(items))
+	for _, v := range items {
+		resp = append(resp, NewDelegationPeriod(ctx, v, height))
+	}
+	return resp, http.StatusOK
+}
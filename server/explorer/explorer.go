@@ -5,9 +5,11 @@ package explorer
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"math"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -63,6 +65,54 @@ func (b Explorer) RegisterRoutes(r *mux.Router) error {
 	return nil
 }
 
+// describe returns the docs for a method and route template. Docs are keyed
+// by method and path relative to prefix, direct routes outside prefix use
+// their full path.
+func describe(method, path, prefix string, docs map[string]server.ApiDoc) []server.ApiDoc {
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	if doc, ok := docs[method+" "+path]; ok {
+		return []server.ApiDoc{doc}
+	}
+	return nil
+}
+
+// checkPruned fails requests for history that was removed by retention pruning.
+func checkPruned(ctx *server.Context, height int64) {
+	if err := ctx.Indexer.CheckPruned(height); err != nil {
+		h := ctx.Indexer.Horizon()
+		panic(server.EGone(server.EC_RESOURCE_PRUNED, fmt.Sprintf("history before height %d is pruned", h.Height), err))
+	}
+}
+
+// lookupBlockId resolves a block height or hash argument and fails for
+// unknown or pruned blocks.
+func lookupBlockId(ctx *server.Context, ident string) (tezos.BlockHash, int64) {
+	hash, height, err := ctx.Indexer.LookupBlockId(ctx.Context, ident)
+	if err != nil {
+		switch err {
+		case index.ErrNoBlockEntry:
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such block", err))
+		case index.ErrInvalidBlockHeight:
+			panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid block height", err))
+		case index.ErrInvalidBlockHash:
+			panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid block hash", err))
+		default:
+			panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
+		}
+	}
+	checkPruned(ctx, height)
+	return hash, height
+}
+
+// checkPrunedCycle is like checkPruned for cycle-based requests.
+func checkPrunedCycle(ctx *server.Context, cycle int64) {
+	if h := ctx.Indexer.Horizon(); h.Height > 0 && cycle < h.Cycle {
+		panic(server.EGone(server.EC_RESOURCE_PRUNED, fmt.Sprintf("history before cycle %d is pruned", h.Cycle), etl.ErrPruned))
+	}
+}
+
 func GetStatus(ctx *server.Context) (interface{}, int) {
 	return ctx.Crawler.Status(), http.StatusOK
 }
@@ -0,0 +1,105 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"math"
+	"net/http"
+
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+type GasStats struct {
+	Entrypoint   string             `json:"entrypoint"`
+	EntrypointId int                `json:"entrypoint_id"`
+	NCalls       int64              `json:"n_calls"`
+	NFailed      int64              `json:"n_failed"`
+	FailureRate  float64            `json:"failure_rate"`
+	GasUsed      GasStatsPercentile `json:"gas_used"`
+	StoragePaid  GasStatsPercentile `json:"storage_paid"`
+	Fee          GasStatsFee        `json:"fee"`
+	FirstCycle   int64              `json:"first_cycle"`
+	LastHeight   int64              `json:"last_height"`
+}
+
+type GasStatsPercentile struct {
+	Mean int64 `json:"mean"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+}
+
+type GasStatsFee struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+}
+
+func newGasStatsPercentile(sum int64, s *model.Sketch) GasStatsPercentile {
+	if s.Count() == 0 {
+		return GasStatsPercentile{}
+	}
+	return GasStatsPercentile{
+		Mean: int64(math.Round(float64(sum) / float64(s.Count()))),
+		P50:  s.Quantile(0.5),
+		P90:  s.Quantile(0.9),
+		P99:  s.Quantile(0.99),
+	}
+}
+
+func NewGasStats(ctx *server.Context, s *model.GasStats, name string) GasStats {
+	p := newGasStatsPercentile(s.Fee, s.FeeSketch)
+	return GasStats{
+		Entrypoint:   name,
+		EntrypointId: s.Entrypoint,
+		NCalls:       s.NCalls,
+		NFailed:      s.NFailed,
+		FailureRate:  math.Round(float64(s.NFailed)/float64(s.NCalls)*10000) / 10000,
+		GasUsed:      newGasStatsPercentile(s.GasUsed, s.GasSketch),
+		StoragePaid:  newGasStatsPercentile(s.StoragePaid, s.StorageSketch),
+		Fee: GasStatsFee{
+			Mean: ctx.Params.ConvertValue(p.Mean),
+			P50:  ctx.Params.ConvertValue(p.P50),
+			P90:  ctx.Params.ConvertValue(p.P90),
+			P99:  ctx.Params.ConvertValue(p.P99),
+		},
+		FirstCycle: s.Cycle,
+		LastHeight: s.Height,
+	}
+}
+
+// ReadContractGasStats returns gas, storage and fee statistics of external
+// calls per entrypoint over the current and up to index.GasStatsWindow past
+// cycles. Gas and storage only consider successful calls, fees are paid by
+// all calls.
+func ReadContractGasStats(ctx *server.Context) (interface{}, int) {
+	args := &ContractRequest{}
+	ctx.ParseRequestArgs(args)
+	cc := loadContract(ctx)
+
+	since := ctx.Params.CycleFromHeight(ctx.Tip.BestHeight) - index.GasStatsWindow
+	items, err := ctx.Indexer.ListGasStats(ctx, cc.AccountId, since)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read gas stats", err))
+	}
+
+	// map entrypoint ids to names
+	names := make(map[int]string)
+	if pTyp, _, err := cc.LoadType(); err == nil {
+		if eps, err := pTyp.Entrypoints(false); err == nil {
+			for _, v := range eps {
+				names[v.Id] = v.Name
+			}
+		}
+	}
+
+	resp := make([]GasStats, 0, len(items))
+	for _, v := range items {
+		resp = append(resp, NewGasStats(ctx, v, names[v.Entrypoint]))
+	}
+	return resp, http.StatusOK
+}
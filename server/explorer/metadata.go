@@ -290,7 +290,9 @@ type Metadata struct {
 	Status         string
 	Symbol         string
 	Standard       string
+	Decimals       int
 	MinDelegation  float64
+	Fee            float64
 	NonDelegatable bool
 	IsPayout       bool
 }
@@ -380,7 +382,9 @@ func NewMetadata(m *model.Metadata) *Metadata {
 			md.Status = c.GetString("baker.status")
 			md.Symbol = c.GetString("asset.symbol")
 			md.Standard = c.GetString("asset.standard")
+			md.Decimals = c.GetInt("asset.decimals")
 			md.MinDelegation = c.GetFloat64("baker.min_delegation")
+			md.Fee = c.GetFloat64("baker.fee")
 			md.NonDelegatable = c.GetBool("baker.non_delegatable")
 			md.IsPayout = len(c.GetStringSlice("payout.from")) > 0
 		}
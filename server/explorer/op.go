@@ -170,6 +170,7 @@ type Op struct {
 	Parameters    *ExplorerParameters       `json:"parameters,omitempty"`
 	Value         *micheline.Prim           `json:"value,omitempty"`
 	Storage       *StorageValue             `json:"storage,omitempty"`
+	StorageDiff   []StorageDiff             `json:"storage_diff,omitempty"`
 	BigmapDiff    *BigmapUpdateList         `json:"big_map_diff,omitempty"`
 	Sender        *tezos.Address            `json:"sender,omitempty"`
 	Receiver      *tezos.Address            `json:"receiver,omitempty"`
@@ -191,6 +192,31 @@ type Op struct {
 	expires time.Time `json:"-"`
 }
 
+// decodeOpStorage unmarshals stored contract storage. Storage type is patched
+// post-Babylon, but pre-Babylon storage is unpatched, so we always output
+// post-Babylon storage.
+func decodeOpStorage(ctx *server.Context, cc *model.Contract, buf []byte, height int64, args server.Options) micheline.Prim {
+	prim := micheline.Prim{}
+	if err := prim.UnmarshalBinary(buf); err != nil {
+		log.Errorf("explorer op: unmarshal storage: %v", err)
+	}
+
+	if cc != nil {
+		if etl.NeedsBabylonUpgradeContract(cc, ctx.Params) && ctx.Params.IsPreBabylonHeight(height) {
+			if acc, err := ctx.Indexer.LookupAccountId(ctx, cc.CreatorId); err == nil {
+				prim = prim.MigrateToBabylonStorage(acc.Address.Bytes())
+			}
+		}
+	}
+
+	if args.WithUnpack() && prim.IsPackedAny() {
+		if up, err := prim.UnpackAll(); err == nil {
+			prim = up
+		}
+	}
+	return prim
+}
+
 func WrapAsBatchOp(op *Op) *Op {
 	return &Op{
 		Id:            op.Id,
@@ -403,7 +429,8 @@ func NewOp(ctx *server.Context, op *model.Op, block *model.Block, cc *model.Cont
 	o.BlockHash = blockHash
 
 	// special treatment for rollup ops (params behave differently)
-	if op.IsRollup && op.Type != model.OpTypeRollupOrigination {
+	// smart rollup originations have no receiver and carry their args in params
+	if op.IsRollup && (op.Type != model.OpTypeRollupOrigination || op.ReceiverId == 0) {
 		o.Parameters = &ExplorerParameters{
 			Method: op.Data,
 		}
@@ -453,8 +480,17 @@ func NewOp(ctx *server.Context, op *model.Op, block *model.Block, cc *model.Cont
 			if dispatch, err := rpc.DecodeRollupDispatch(op.Parameters); err == nil {
 				o.Parameters.Arguments, _ = dispatch.MarshalJSON()
 			}
-		case "sc_rollup_originate", "sc_rollup_add_messages", "sc_rollup_cement", "sc_rollup_publish":
-			// unsupported right niw
+		case "smart_rollup_originate",
+			"smart_rollup_add_messages",
+			"smart_rollup_cement",
+			"smart_rollup_publish",
+			"smart_rollup_refute",
+			"smart_rollup_timeout",
+			"smart_rollup_execute_outbox_message",
+			"smart_rollup_recover_bond":
+			if args, err := rpc.DecodeSmartRollupArgs(op.Parameters); err == nil {
+				o.Parameters.Arguments, _ = json.Marshal(args)
+			}
 		}
 
 		o.Data = nil
@@ -512,26 +548,7 @@ func NewOp(ctx *server.Context, op *model.Op, block *model.Block, cc *model.Cont
 
 		// handle storage
 		if args.WithStorage() && len(op.Storage) > 0 && sTyp.IsValid() {
-			prim := micheline.Prim{}
-			if err := prim.UnmarshalBinary(op.Storage); err != nil {
-				log.Errorf("explorer op: unmarshal %s storage: %v", op.Type, err)
-			}
-
-			if cc != nil {
-				// storage type is patched post-Babylon, but pre-Babylon ops are unpatched,
-				// we always output post-babylon storage
-				if etl.NeedsBabylonUpgradeContract(cc, ctx.Params) && ctx.Params.IsPreBabylonHeight(op.Height) {
-					if acc, err := ctx.Indexer.LookupAccountId(ctx, cc.CreatorId); err == nil {
-						prim = prim.MigrateToBabylonStorage(acc.Address.Bytes())
-					}
-				}
-			}
-
-			if args.WithUnpack() && prim.IsPackedAny() {
-				if up, err := prim.UnpackAll(); err == nil {
-					prim = up
-				}
-			}
+			prim := decodeOpStorage(ctx, cc, op.Storage, op.Height, args)
 
 			o.Storage = &StorageValue{}
 			val := micheline.NewValue(sTyp, prim)
@@ -546,6 +563,26 @@ func NewOp(ctx *server.Context, op *model.Op, block *model.Block, cc *model.Cont
 			}
 		}
 
+		// handle storage diff against storage before this op
+		if args.WithStorageDiff() && len(op.Storage) > 0 && sTyp.IsValid() {
+			var prev micheline.Prim
+			if op.Type != model.OpTypeOrigination {
+				if store, err := ctx.Indexer.LookupPreviousStorage(ctx, op); err == nil {
+					prev = decodeOpStorage(ctx, cc, store.Storage, store.Height, args)
+				} else {
+					log.Debugf("explorer op: %s: loading previous storage: %v", o.Hash, err)
+				}
+			}
+			if prev.IsValid() || op.Type == model.OpTypeOrigination {
+				diff, err := DiffStorage(sTyp, prev, decodeOpStorage(ctx, cc, op.Storage, op.Height, args))
+				if err != nil {
+					log.Errorf("explorer op: %s: storage diff: %v", o.Hash, err)
+				} else {
+					o.StorageDiff = diff
+				}
+			}
+		}
+
 		// handle bigmap diffs
 		if args.WithStorage() && len(op.BigmapUpdates) > 0 {
 			var (
@@ -726,7 +763,15 @@ func (o Op) RESTPath(r *mux.Router) string {
 	return path.String()
 }
 
+func (o Op) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, o.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{ident}":       {Summary: "Read operation by hash or id", Args: OpsRequest{}, Response: OpList{}},
+		"POST /explorer/ops": {Summary: "Read operations by hash or id", Args: BatchOpsRequest{}, Response: BatchResult{}},
+	})
+}
+
 func (o Op) RegisterDirectRoutes(r *mux.Router) error {
+	r.HandleFunc("/explorer/ops", server.C(BatchLookupOps)).Methods("POST")
 	return nil
 }
 
@@ -740,17 +785,18 @@ func (t Op) RegisterRoutes(r *mux.Router) error {
 type OpsRequest struct {
 	ListRequest // offset, limit, cursor, order
 
-	Block    string        `schema:"block"`    // height or hash for time-lock
-	Since    string        `schema:"since"`    // block hash or height for updates
-	Unpack   bool          `schema:"unpack"`   // unpack packed key/values
-	Prim     bool          `schema:"prim"`     // for prim/value rendering
-	Meta     bool          `schema:"meta"`     // include account metadata
-	Rights   bool          `schema:"rights"`   // include block rights
-	Merge    bool          `schema:"merge"`    // merge batch lists and internal ops
-	Storage  bool          `schema:"storage"`  // embed storage update
-	Address  tezos.Address `schema:"address"`  // filter by any address
-	Sender   tezos.Address `schema:"sender"`   // filter by sender
-	Receiver tezos.Address `schema:"receiver"` // filter by receiver
+	Block       string        `schema:"block"`        // height or hash for time-lock
+	Since       string        `schema:"since"`        // block hash or height for updates
+	Unpack      bool          `schema:"unpack"`       // unpack packed key/values
+	Prim        bool          `schema:"prim"`         // for prim/value rendering
+	Meta        bool          `schema:"meta"`         // include account metadata
+	Rights      bool          `schema:"rights"`       // include block rights
+	Merge       bool          `schema:"merge"`        // merge batch lists and internal ops
+	Storage     bool          `schema:"storage"`      // embed storage update
+	StorageDiff bool          `schema:"storage_diff"` // embed storage diff
+	Address     tezos.Address `schema:"address"`      // filter by any address
+	Sender      tezos.Address `schema:"sender"`       // filter by sender
+	Receiver    tezos.Address `schema:"receiver"`     // filter by receiver
 
 	// decoded type condition
 	TypeMode pack.FilterMode  `schema:"-"`
@@ -771,10 +817,11 @@ func (r *OpsRequest) WithHeight() int64 {
 	}
 	return 0
 }
-func (r *OpsRequest) WithMeta() bool    { return r != nil && r.Meta }
-func (r *OpsRequest) WithRights() bool  { return r != nil && r.Rights }
-func (r *OpsRequest) WithMerge() bool   { return r != nil && r.Merge }
-func (r *OpsRequest) WithStorage() bool { return r != nil && r.Storage }
+func (r *OpsRequest) WithMeta() bool        { return r != nil && r.Meta }
+func (r *OpsRequest) WithRights() bool      { return r != nil && r.Rights }
+func (r *OpsRequest) WithMerge() bool       { return r != nil && r.Merge }
+func (r *OpsRequest) WithStorage() bool     { return r != nil && r.Storage }
+func (r *OpsRequest) WithStorageDiff() bool { return r != nil && r.StorageDiff }
 
 // implement ParsableRequest interface
 func (r *OpsRequest) Parse(ctx *server.Context) {
@@ -793,6 +840,7 @@ func (r *OpsRequest) Parse(ctx *server.Context) {
 				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
 			}
 		}
+		checkPruned(ctx, b.Height)
 		r.BlockHeight = b.Height
 		r.BlockHash = b.Hash.Clone()
 	}
@@ -811,6 +859,7 @@ func (r *OpsRequest) Parse(ctx *server.Context) {
 				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
 			}
 		}
+		checkPruned(ctx, b.Height)
 		r.SinceHeight = b.Height
 		r.SinceHash = b.Hash.Clone()
 	}
@@ -0,0 +1,356 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/vec"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+// number of most recent operations included in a portfolio
+const portfolioRecentOps = 10
+
+type Portfolio struct {
+	Address          tezos.Address       `json:"address"`
+	SpendableBalance float64             `json:"spendable_balance"`
+	FrozenBalance    float64             `json:"frozen_balance"`
+	UnclaimedBalance float64             `json:"unclaimed_balance"`
+	TotalBalance     float64             `json:"total_balance"`
+	Baker            *PortfolioBaker     `json:"baker,omitempty"`
+	Tokens           []PortfolioToken    `json:"tokens"`
+	Managed          []*Account          `json:"managed"`
+	Recent           []PortfolioActivity `json:"recent"`
+
+	modified time.Time `json:"-"`
+	expires  time.Time `json:"-"`
+}
+
+func (p Portfolio) LastModified() time.Time { return p.modified }
+func (p Portfolio) Expires() time.Time      { return p.expires }
+
+type PortfolioBaker struct {
+	Address        tezos.Address  `json:"address"`
+	DelegatedSince int64          `json:"delegated_since"`
+	IsActive       bool           `json:"is_active"`
+	Fee            float64        `json:"fee"`
+	EstimatedYield float64        `json:"estimated_yield"`
+	Metadata       *ShortMetadata `json:"metadata,omitempty"`
+}
+
+type PortfolioToken struct {
+	Contract tezos.Address `json:"contract"`
+	TokenId  int64         `json:"token_id"`
+	Standard string        `json:"standard"`
+	Symbol   string        `json:"symbol"`
+	Decimals int           `json:"decimals"`
+	Balance  string        `json:"balance"`
+	Metadata *Metadata     `json:"metadata"`
+}
+
+type PortfolioActivity struct {
+	Hash      tezos.OpHash  `json:"hash"`
+	Type      model.OpType  `json:"type"`
+	Height    int64         `json:"height"`
+	Timestamp time.Time     `json:"time"`
+	Sender    tezos.Address `json:"sender"`
+	Receiver  tezos.Address `json:"receiver"`
+	Volume    float64       `json:"volume"`
+	Fee       float64       `json:"fee"`
+	IsSuccess bool          `json:"is_success"`
+}
+
+// GetPortfolio returns balances, delegation, token holdings, managed contracts
+// and recent activity of an account in a single response.
+func GetPortfolio(ctx *server.Context) (interface{}, int) {
+	args := &AccountRequest{}
+	ctx.ParseRequestArgs(args)
+	acc := loadAccount(ctx)
+
+	p := &Portfolio{
+		Address:          acc.Address,
+		SpendableBalance: ctx.Params.ConvertValue(acc.SpendableBalance),
+		FrozenBalance:    ctx.Params.ConvertValue(acc.FrozenBond),
+		UnclaimedBalance: ctx.Params.ConvertValue(acc.UnclaimedBalance),
+		modified:         ctx.Indexer.LookupBlockTime(ctx, acc.LastSeen),
+		expires:          ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+	}
+
+	// bakers hold frozen deposits and rewards in addition to bonds
+	if acc.IsBaker {
+		if bkr, err := ctx.Indexer.LookupBakerId(ctx, acc.RowId); err == nil {
+			p.FrozenBalance += ctx.Params.ConvertValue(bkr.FrozenBalance())
+		}
+	}
+	p.TotalBalance = p.SpendableBalance + p.FrozenBalance + p.UnclaimedBalance
+
+	if acc.BakerId > 0 && acc.BakerId != acc.RowId {
+		p.Baker = portfolioBaker(ctx, acc)
+	}
+	p.Tokens = portfolioTokens(ctx, acc)
+
+	managed, err := ctx.Indexer.ListManaged(ctx, acc.RowId, 0, ctx.Cfg.ClampExplore(0), 0, pack.OrderAsc)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read managed accounts", err))
+	}
+	p.Managed = make([]*Account, 0, len(managed))
+	for _, v := range managed {
+		p.Managed = append(p.Managed, NewAccount(ctx, v, args))
+	}
+
+	ops, err := ctx.Indexer.ListAccountOps(ctx, etl.ListRequest{
+		Account: acc,
+		Limit:   portfolioRecentOps,
+		Order:   pack.OrderDesc,
+	})
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read account operations", err))
+	}
+	p.Recent = make([]PortfolioActivity, 0, len(ops))
+	for _, v := range ops {
+		p.Recent = append(p.Recent, PortfolioActivity{
+			Hash:      v.Hash,
+			Type:      v.Type,
+			Height:    v.Height,
+			Timestamp: v.Timestamp,
+			Sender:    ctx.Indexer.LookupAddress(ctx, v.SenderId),
+			Receiver:  ctx.Indexer.LookupAddress(ctx, v.ReceiverId),
+			Volume:    ctx.Params.ConvertValue(v.Volume),
+			Fee:       ctx.Params.ConvertValue(v.Fee),
+			IsSuccess: v.IsSuccess,
+		})
+	}
+	return p, http.StatusOK
+}
+
+// portfolioBaker returns the current baker of acc with fee from metadata and
+// an annualized yield estimate based on the last completed cycle.
+func portfolioBaker(ctx *server.Context, acc *model.Account) *PortfolioBaker {
+	bkr, err := ctx.Indexer.LookupBakerId(ctx, acc.BakerId)
+	if err != nil {
+		return nil
+	}
+	b := &PortfolioBaker{
+		Address:        bkr.Address,
+		DelegatedSince: acc.DelegatedSince,
+		IsActive:       bkr.IsActive,
+	}
+	if md, ok := lookupMetadataById(ctx, bkr.AccountId, 0, false); ok {
+		b.Fee = md.Fee
+		b.Metadata = md.Short()
+	}
+
+	table, err := ctx.Indexer.Table(index.IncomeTableKey)
+	if err != nil {
+		return b
+	}
+	cycle := ctx.Params.CycleFromHeight(ctx.Tip.BestHeight) - 1
+	var income model.Income
+	err = pack.NewQuery("api.portfolio.income", table).
+		AndEqual("account_id", bkr.AccountId).
+		AndEqual("cycle", cycle).
+		WithLimit(1).
+		Execute(ctx.Context, &income)
+	if err != nil || income.ActiveStake <= 0 {
+		return b
+	}
+	p := ctx.Params.ForCycle(cycle)
+	cycleTime := time.Duration(p.BlocksPerCycle) * p.BlockTime()
+	if cycleTime <= 0 {
+		return b
+	}
+	cyclesPerYear := float64(365*24*time.Hour) / float64(cycleTime)
+	gross := float64(income.TotalIncome-income.TotalLoss) / float64(income.ActiveStake)
+	b.EstimatedYield = math.Round(gross*cyclesPerYear*(1-b.Fee)*10000) / 10000
+	return b
+}
+
+// portfolioTokens returns non-zero ledger balances of acc in token contracts
+// with known asset metadata. Ledger keys for acc are derived from each ledger
+// bigmap's key type and looked up by key id in a single query. The request
+// is charged one cost unit per token contract and candidate ledger key.
+func portfolioTokens(ctx *server.Context, acc *model.Account) []PortfolioToken {
+	// group asset metadata by contract
+	assets := make(map[model.AccountID][]*Metadata)
+	for _, md := range allMetadataById(ctx) {
+		if md.Standard == "" || md.AccountId == 0 {
+			continue
+		}
+		assets[md.AccountId] = append(assets[md.AccountId], md)
+	}
+	if len(assets) == 0 {
+		return []PortfolioToken{}
+	}
+	ids := make([]uint64, 0, len(assets))
+	for id := range assets {
+		ids = append(ids, id.Value())
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	ctx.ChargeCost(int64(len(ids)))
+
+	// load live bigmaps of all token contracts
+	allocTable, err := ctx.Indexer.Table(index.BigmapAllocTableKey)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "missing bigmap table", err))
+	}
+	allocs := make(map[model.AccountID][]*model.BigmapAlloc)
+	err = pack.NewQuery("api.portfolio.bigmaps", allocTable).
+		AndIn("account_id", ids).
+		AndEqual("delete_height", 0).
+		Stream(ctx, func(r pack.Row) error {
+			a := &model.BigmapAlloc{}
+			if err := r.Decode(a); err != nil {
+				return err
+			}
+			allocs[a.AccountId] = append(allocs[a.AccountId], a)
+			return nil
+		})
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read contract bigmaps", err))
+	}
+
+	// derive candidate ledger keys of acc, the first ledger bigmap that holds
+	// a key decides the balance of a token
+	type candidate struct {
+		md    *Metadata
+		keyId uint64
+	}
+	addr, _ := acc.Address.MarshalBinary()
+	cands := make([][]candidate, 0)
+	keyIds := make([]uint64, 0)
+	for _, id := range ids {
+		list := allocs[model.AccountID(id)]
+		sort.Slice(list, func(i, j int) bool { return list[i].BigmapId < list[j].BigmapId })
+		mds := assets[model.AccountID(id)]
+		sort.Slice(mds, func(i, j int) bool { return mds[i].tokenId() < mds[j].tokenId() })
+		for _, md := range mds {
+			tokenId := md.tokenId()
+			c := make([]candidate, 0, 1)
+			for _, alloc := range list {
+				key, ok := ledgerKey(alloc.GetKeyType(), addr, tokenId)
+				if !ok {
+					continue
+				}
+				kid := model.GetKeyId(alloc.BigmapId, key.Hash())
+				c = append(c, candidate{md, kid})
+				keyIds = append(keyIds, kid)
+			}
+			if len(c) > 0 {
+				cands = append(cands, c)
+			}
+		}
+	}
+	tokens := make([]PortfolioToken, 0)
+	if len(keyIds) == 0 {
+		return tokens
+	}
+	ctx.ChargeCost(int64(len(keyIds)))
+
+	// lookup ledger entries by key id
+	valueTable, err := ctx.Indexer.Table(index.BigmapValueTableKey)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "missing bigmap value table", err))
+	}
+	values := make(map[uint64][]byte, len(keyIds))
+	kv := &model.BigmapKV{}
+	err = pack.NewQuery("api.portfolio.ledger", valueTable).
+		WithFields("key_id", "value").
+		AndIn("key_id", vec.UniqueUint64Slice(keyIds)).
+		Stream(ctx, func(r pack.Row) error {
+			if err := r.Decode(kv); err != nil {
+				return err
+			}
+			values[kv.KeyId] = append([]byte(nil), kv.Value...)
+			return nil
+		})
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read token balance", err))
+	}
+
+	for _, c := range cands {
+		for _, v := range c {
+			buf, ok := values[v.keyId]
+			if !ok {
+				continue
+			}
+			amount, ok := ledgerAmount(buf)
+			if !ok || amount.Sign() == 0 {
+				break
+			}
+			tokens = append(tokens, PortfolioToken{
+				Contract: v.md.Address,
+				TokenId:  v.md.tokenId(),
+				Standard: v.md.Standard,
+				Symbol:   v.md.Symbol,
+				Decimals: v.md.Decimals,
+				Balance:  amount.String(),
+				Metadata: v.md,
+			})
+			break
+		}
+	}
+	return tokens
+}
+
+func (m Metadata) tokenId() int64 {
+	if m.AssetId == nil {
+		return 0
+	}
+	return *m.AssetId
+}
+
+// ledgerKey builds the bigmap key for holder address addr and token id
+// when typ is a supported ledger key type.
+func ledgerKey(typ micheline.Type, addr []byte, tokenId int64) (micheline.Key, bool) {
+	var prim micheline.Prim
+	switch {
+	case typ.OpCode == micheline.T_ADDRESS:
+		prim = micheline.NewBytes(addr)
+	case typ.OpCode == micheline.T_PAIR && len(typ.Args) == 2:
+		l, r := typ.Args[0].OpCode, typ.Args[1].OpCode
+		switch {
+		case l == micheline.T_ADDRESS && r == micheline.T_NAT:
+			prim = micheline.NewPair(micheline.NewBytes(addr), micheline.NewNat(big.NewInt(tokenId)))
+		case l == micheline.T_NAT && r == micheline.T_ADDRESS:
+			prim = micheline.NewPair(micheline.NewNat(big.NewInt(tokenId)), micheline.NewBytes(addr))
+		default:
+			return micheline.Key{}, false
+		}
+	default:
+		return micheline.Key{}, false
+	}
+	key, err := micheline.NewKey(typ, prim)
+	return key, err == nil
+}
+
+// ledgerAmount extracts a balance from a ledger value which is either a
+// plain number or a pair holding the balance next to allowances.
+func ledgerAmount(buf []byte) (*big.Int, bool) {
+	prim := micheline.Prim{}
+	if err := prim.UnmarshalBinary(buf); err != nil {
+		return nil, false
+	}
+	if prim.Type == micheline.PrimInt {
+		return prim.Int, true
+	}
+	if prim.OpCode == micheline.D_PAIR {
+		for _, v := range prim.Args {
+			if v.Type == micheline.PrimInt {
+				return v.Int, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"time"
 
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
 	"blockwatch.cc/tzindex/server"
 )
 
@@ -36,9 +38,34 @@ func (rx Ranks) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/traffic", server.C(GetTrafficList)).Methods("GET")
 	r.HandleFunc("/volume", server.C(GetVolumeList)).Methods("GET")
 	r.HandleFunc("/balances", server.C(GetRichList)).Methods("GET")
+	r.HandleFunc("/distribution", server.C(GetDistribution)).Methods("GET")
 	return nil
 }
 
+func (rx Ranks) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, rx.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /traffic":      {Summary: "List accounts by 24h transaction count", Args: ListRequest{}, Response: []RankListItem{}},
+		"GET /volume":       {Summary: "List accounts by 24h transaction volume", Args: ListRequest{}, Response: []RankListItem{}},
+		"GET /balances":     {Summary: "List accounts by balance", Args: RankRequest{}, Response: []RankListItem{}},
+		"GET /distribution": {Summary: "Read holder distribution", Args: RankRequest{}, Response: Distribution{}},
+	})
+}
+
+type RankRequest struct {
+	ListRequest        // offset, limit, cursor, order
+	Block       string `schema:"block"` // historic state at block height or hash
+
+	// decoded values
+	BlockHeight int64           `schema:"-"`
+	BlockHash   tezos.BlockHash `schema:"-"`
+}
+
+func (r *RankRequest) Parse(ctx *server.Context) {
+	if len(r.Block) > 0 {
+		r.BlockHash, r.BlockHeight = lookupBlockId(ctx, r.Block)
+	}
+}
+
 type RankListItem struct {
 	Rank    int     `json:"rank"`
 	Address string  `json:"address"`
@@ -116,12 +143,16 @@ func GetVolumeList(ctx *server.Context) (interface{}, int) {
 }
 
 func GetRichList(ctx *server.Context) (interface{}, int) {
-	args := &ListRequest{}
+	args := &RankRequest{}
 	ctx.ParseRequestArgs(args)
 	tip := ctx.Tip
 	params := ctx.Params
 	args.Limit = ctx.Cfg.ClampExplore(args.Limit)
 
+	if args.BlockHeight > 0 {
+		return getHistoricRichList(ctx, args)
+	}
+
 	list, err := ctx.Indexer.TopRich(ctx.Context, int(args.Limit), int(args.Offset))
 	if err != nil {
 		panic(server.EInternal(server.EC_DATABASE, "cannot construct rank list", err))
@@ -142,3 +173,92 @@ func GetRichList(ctx *server.Context) (interface{}, int) {
 	}
 	return resp, http.StatusOK
 }
+
+// getHistoricRichList serves the top holders at a past block from balance
+// history. Ranks are limited to the top holders kept in distribution snapshots.
+func getHistoricRichList(ctx *server.Context, args *RankRequest) (interface{}, int) {
+	dist := loadDistribution(ctx, args.BlockHeight)
+	resp := &RankList{
+		list:     make([]RankListItem, 0),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: dist.Timestamp,
+	}
+	var (
+		rank int
+		last int64
+	)
+	for i, v := range dist.Top {
+		// equal balances share a rank
+		if v.Balance != last {
+			rank = i + 1
+			last = v.Balance
+		}
+		if i < int(args.Offset) {
+			continue
+		}
+		if len(resp.list) == int(args.Limit) {
+			break
+		}
+		resp.list = append(resp.list, RankListItem{
+			Rank:    rank,
+			Address: ctx.Indexer.LookupAddress(ctx, v.AccountId).String(),
+			Balance: ctx.Params.ConvertValue(v.Balance),
+		})
+	}
+	return resp, http.StatusOK
+}
+
+type Distribution struct {
+	Height   int64     `json:"height"`
+	Cycle    int64     `json:"cycle"`
+	Time     time.Time `json:"time"`
+	NHolders int64     `json:"n_holders"`
+	Total    float64   `json:"total"`
+	Gini     float64   `json:"gini"`
+	NDust    int64     `json:"n_dust"`
+	N1k      int64     `json:"n_1k"`
+	N10k     int64     `json:"n_10k"`
+	N100k    int64     `json:"n_100k"`
+	N1M      int64     `json:"n_1m"`
+	NWhale   int64     `json:"n_whale"`
+
+	expires time.Time `json:"-"`
+}
+
+var _ server.Resource = (*Distribution)(nil)
+
+func (d Distribution) LastModified() time.Time { return d.Time }
+func (d Distribution) Expires() time.Time      { return d.expires }
+
+func GetDistribution(ctx *server.Context) (interface{}, int) {
+	args := &RankRequest{}
+	ctx.ParseRequestArgs(args)
+	height := args.BlockHeight
+	if height == 0 {
+		height = ctx.Tip.BestHeight
+	}
+	dist := loadDistribution(ctx, height)
+	return &Distribution{
+		Height:   dist.Height,
+		Cycle:    dist.Cycle,
+		Time:     dist.Timestamp,
+		NHolders: dist.NHolders,
+		Total:    ctx.Params.ConvertValue(dist.Total),
+		Gini:     dist.Gini,
+		NDust:    dist.NDust,
+		N1k:      dist.N1k,
+		N10k:     dist.N10k,
+		N100k:    dist.N100k,
+		N1M:      dist.N1M,
+		NWhale:   dist.NWhale,
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+	}, http.StatusOK
+}
+
+func loadDistribution(ctx *server.Context, height int64) *model.Distribution {
+	dist, err := ctx.Indexer.BalanceDistribution(ctx, height)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot build balance distribution", err))
+	}
+	return dist
+}
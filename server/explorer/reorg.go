@@ -0,0 +1,189 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+func init() {
+	server.Register(Reorgs{})
+}
+
+var _ server.RESTful = (*Reorgs)(nil)
+
+type Reorgs struct{}
+
+func (rx Reorgs) RESTPrefix() string {
+	return "/explorer/reorgs"
+}
+
+func (rx Reorgs) RESTPath(r *mux.Router) string {
+	return rx.RESTPrefix()
+}
+
+func (rx Reorgs) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, rx.RESTPrefix(), map[string]server.ApiDoc{
+		"GET ": {Summary: "List chain reorganizations", Args: ReorgRequest{}, Response: ReorgList{}},
+	})
+}
+
+func (rx Reorgs) RegisterDirectRoutes(r *mux.Router) error {
+	return nil
+}
+
+func (rx Reorgs) RegisterRoutes(r *mux.Router) error {
+	r.HandleFunc("", server.C(ListReorgs)).Methods("GET")
+	return nil
+}
+
+type Reorg struct {
+	Id         uint64           `json:"id"`
+	Time       time.Time        `json:"time"`
+	ForkHeight int64            `json:"fork_height"`
+	ForkBlock  tezos.BlockHash  `json:"fork_block"`
+	Depth      int              `json:"depth"`
+	NAttached  int              `json:"n_attached"`
+	OldTip     tezos.BlockHash  `json:"old_tip"`
+	NewTip     tezos.BlockHash  `json:"new_tip"`
+	IsRollback bool             `json:"is_rollback"`
+	NOps       int              `json:"n_ops"`
+	Reverted   map[string]int64 `json:"reverted"`
+	Orphans    []OrphanBlock    `json:"orphans"`
+}
+
+type OrphanBlock struct {
+	Hash     tezos.BlockHash  `json:"hash"`
+	Height   int64            `json:"height"`
+	Time     time.Time        `json:"time"`
+	NOps     int              `json:"n_ops"`
+	Ops      []tezos.OpHash   `json:"ops,omitempty"`
+	Reverted map[string]int64 `json:"reverted,omitempty"`
+}
+
+func NewReorg(r *model.Reorg) *Reorg {
+	rr := &Reorg{
+		Id:         r.RowId,
+		Time:       r.Timestamp,
+		ForkHeight: r.ForkHeight,
+		ForkBlock:  r.ForkHash,
+		Depth:      r.Depth,
+		NAttached:  r.NAttached,
+		OldTip:     r.OldTip,
+		NewTip:     r.NewTip,
+		IsRollback: r.IsRollback,
+		NOps:       r.NOps,
+		Reverted:   r.Reverted(),
+		Orphans:    make([]OrphanBlock, 0, len(r.Orphans)),
+	}
+	for _, v := range r.Orphans {
+		rr.Orphans = append(rr.Orphans, OrphanBlock{
+			Hash:   v.Hash,
+			Height: v.Height,
+			Time:   v.Time,
+			NOps:   v.NOps,
+		})
+	}
+	return rr
+}
+
+type ReorgList struct {
+	list     []*Reorg
+	expires  time.Time
+	modified time.Time
+}
+
+var _ server.Resource = (*ReorgList)(nil)
+
+func (l ReorgList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l ReorgList) LastModified() time.Time      { return l.modified }
+func (l ReorgList) Expires() time.Time           { return l.expires }
+
+type ReorgRequest struct {
+	ListRequest // offset, limit, cursor, order
+
+	Since int64 `schema:"since"` // min fork height
+	Until int64 `schema:"until"` // max fork height
+}
+
+func ListReorgs(ctx *server.Context) (interface{}, int) {
+	args := &ReorgRequest{}
+	ctx.ParseRequestArgs(args)
+	r := etl.ListRequest{
+		Since:  args.Since,
+		Until:  args.Until,
+		Cursor: args.Cursor,
+		Offset: args.Offset,
+		Limit:  ctx.Cfg.ClampExplore(args.Limit),
+		Order:  args.Order,
+	}
+	items, err := ctx.Indexer.ListReorgs(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read reorgs", err))
+	}
+	resp := &ReorgList{
+		list:     make([]*Reorg, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Tip.BestTime,
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, NewReorg(v))
+	}
+	return resp, http.StatusOK
+}
+
+type OrphanInfo struct {
+	OrphanBlock
+	RowId uint64 `json:"row_id"`
+	Reorg *Reorg `json:"reorg"`
+
+	expires time.Time `json:"-"`
+}
+
+func (o OrphanInfo) LastModified() time.Time { return o.Reorg.Time }
+func (o OrphanInfo) Expires() time.Time      { return o.expires }
+
+var _ server.Resource = (*OrphanInfo)(nil)
+
+func ReadOrphanInfo(ctx *server.Context) (interface{}, int) {
+	blockIdent, ok := mux.Vars(ctx.Request)["ident"]
+	if !ok || blockIdent == "" {
+		panic(server.EBadRequest(server.EC_RESOURCE_ID_MISSING, "missing block hash", nil))
+	}
+	hash, err := tezos.ParseBlockHash(blockIdent)
+	if err != nil {
+		panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid block hash", err))
+	}
+	reorg, orphan, err := ctx.Indexer.LookupOrphan(ctx, hash)
+	if err != nil {
+		switch err {
+		case index.ErrNoOrphanEntry:
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "block was not orphaned", err))
+		default:
+			panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
+		}
+	}
+	return &OrphanInfo{
+		OrphanBlock: OrphanBlock{
+			Hash:     orphan.Hash,
+			Height:   orphan.Height,
+			Time:     orphan.Time,
+			NOps:     orphan.NOps,
+			Ops:      orphan.Ops,
+			Reverted: orphan.Reverted,
+		},
+		RowId:   orphan.RowId,
+		Reorg:   NewReorg(reorg),
+		expires: ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+	}, http.StatusOK
+}
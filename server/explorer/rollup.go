@@ -0,0 +1,311 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/rpc"
+	"blockwatch.cc/tzindex/server"
+)
+
+func init() {
+	server.Register(SmartRollup{})
+}
+
+var _ server.RESTful = (*SmartRollup)(nil)
+var _ server.Resource = (*SmartRollup)(nil)
+
+type SmartRollup struct {
+	Address           string          `json:"address"`
+	Creator           tezos.Address   `json:"creator"`
+	PvmKind           string          `json:"pvm_kind"`
+	ParametersType    *micheline.Prim `json:"parameters_type,omitempty"`
+	GenesisCommitment string          `json:"genesis_commitment"`
+	LastCommitment    string          `json:"last_cemented_commitment"`
+	LastCementedLevel int64           `json:"last_cemented_level"`
+	FirstSeen         int64           `json:"first_seen"`
+	LastSeen          int64           `json:"last_seen"`
+	FirstSeenTime     time.Time       `json:"first_seen_time"`
+	LastSeenTime      time.Time       `json:"last_seen_time"`
+	NCommitments      int             `json:"n_commitments"`
+	NCements          int             `json:"n_cements"`
+	NRefutations      int             `json:"n_refutations"`
+	NTimeouts         int             `json:"n_timeouts"`
+	NOutboxMessages   int             `json:"n_outbox_messages"`
+	NStakers          int             `json:"n_stakers"`
+	TotalBond         float64         `json:"total_bond"`
+	TotalLostBond     float64         `json:"total_lost_bond"`
+
+	expires time.Time `json:"-"`
+}
+
+func NewSmartRollup(ctx *server.Context, r *model.SmartRollup) *SmartRollup {
+	p := ctx.Params
+	sr := &SmartRollup{
+		Address:           r.GetAddress().String(),
+		Creator:           ctx.Indexer.LookupAddress(ctx, r.CreatorId),
+		PvmKind:           r.PvmKind,
+		GenesisCommitment: r.GetGenesisCommitment().String(),
+		LastCommitment:    r.GetLastCommitment().String(),
+		LastCementedLevel: r.LastCementedLevel,
+		FirstSeen:         r.FirstSeen,
+		LastSeen:          r.LastSeen,
+		FirstSeenTime:     ctx.Indexer.LookupBlockTime(ctx.Context, r.FirstSeen),
+		LastSeenTime:      ctx.Indexer.LookupBlockTime(ctx.Context, r.LastSeen),
+		NCommitments:      r.NCommitments,
+		NCements:          r.NCements,
+		NRefutations:      r.NRefutations,
+		NTimeouts:         r.NTimeouts,
+		NOutboxMessages:   r.NOutboxMessages,
+		NStakers:          r.NStakers,
+		TotalBond:         p.ConvertValue(r.TotalBond),
+		TotalLostBond:     p.ConvertValue(r.TotalLostBond),
+		expires:           ctx.Tip.BestTime.Add(p.BlockTime()),
+	}
+	if len(r.ParametersType) > 0 {
+		typ := micheline.Prim{}
+		if err := typ.UnmarshalBinary(r.ParametersType); err == nil {
+			sr.ParametersType = &typ
+		}
+	}
+	return sr
+}
+
+func (r SmartRollup) LastModified() time.Time {
+	return r.LastSeenTime
+}
+
+func (r SmartRollup) Expires() time.Time {
+	return r.expires
+}
+
+func (r SmartRollup) RESTPrefix() string {
+	return "/explorer/rollup"
+}
+
+func (r SmartRollup) RESTPath(rt *mux.Router) string {
+	path, _ := rt.Get("rollup").URLPath("ident", r.Address)
+	return path.String()
+}
+
+func (r SmartRollup) RegisterDirectRoutes(rt *mux.Router) error {
+	return nil
+}
+
+func (r SmartRollup) RegisterRoutes(rt *mux.Router) error {
+	rt.HandleFunc("/{ident}", server.C(ReadSmartRollup)).Methods("GET").Name("rollup")
+	rt.HandleFunc("/{ident}/commitments", server.C(ListSmartRollupCommitments)).Methods("GET")
+	rt.HandleFunc("/{ident}/inbox", server.C(ListSmartRollupInbox)).Methods("GET")
+	rt.HandleFunc("/{ident}/outbox", server.C(ListSmartRollupOutbox)).Methods("GET")
+	return nil
+}
+
+type SmartRollupCommitment struct {
+	RowId        uint64        `json:"id"`
+	Hash         string        `json:"hash"`
+	Predecessor  string        `json:"predecessor,omitempty"`
+	StateHash    string        `json:"state_hash,omitempty"`
+	InboxLevel   int64         `json:"inbox_level"`
+	NumTicks     int64         `json:"num_ticks"`
+	Staker       tezos.Address `json:"staker"`
+	Bond         float64       `json:"bond"`
+	OpHash       tezos.OpHash  `json:"op_hash"`
+	Height       int64         `json:"height"`
+	Time         time.Time     `json:"time"`
+	IsCemented   bool          `json:"is_cemented"`
+	CementHeight int64         `json:"cement_height,omitempty"`
+}
+
+type SmartRollupMessage struct {
+	RowId      uint64         `json:"id"`
+	Sender     tezos.Address  `json:"sender"`
+	OpHash     tezos.OpHash   `json:"op_hash"`
+	Height     int64          `json:"height"`
+	Time       time.Time      `json:"time"`
+	Index      int            `json:"index"`
+	Commitment string         `json:"cemented_commitment,omitempty"`
+	Data       tezos.HexBytes `json:"data"`
+}
+
+type SmartRollupCommitmentList struct {
+	list     []SmartRollupCommitment
+	modified time.Time
+	expires  time.Time
+}
+
+func (l SmartRollupCommitmentList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l SmartRollupCommitmentList) LastModified() time.Time      { return l.modified }
+func (l SmartRollupCommitmentList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*SmartRollupCommitmentList)(nil)
+
+type SmartRollupMessageList struct {
+	list     []SmartRollupMessage
+	modified time.Time
+	expires  time.Time
+}
+
+func (l SmartRollupMessageList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l SmartRollupMessageList) LastModified() time.Time      { return l.modified }
+func (l SmartRollupMessageList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*SmartRollupMessageList)(nil)
+
+func loadSmartRollup(ctx *server.Context) *model.SmartRollup {
+	if ident, ok := mux.Vars(ctx.Request)["ident"]; !ok || ident == "" {
+		panic(server.EBadRequest(server.EC_RESOURCE_ID_MISSING, "missing rollup address", nil))
+	} else {
+		addr, err := rpc.ParseSmartRollupHash(ident)
+		if err != nil || addr.Type != rpc.SmartRollupHashTypeAddress {
+			panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid rollup address", err))
+		}
+		r, err := ctx.Indexer.LookupSmartRollup(ctx, addr)
+		if err != nil {
+			switch err {
+			case index.ErrNoRollupEntry:
+				panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such rollup", err))
+			default:
+				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
+			}
+		}
+		return r
+	}
+}
+
+func ReadSmartRollup(ctx *server.Context) (interface{}, int) {
+	r := loadSmartRollup(ctx)
+	return NewSmartRollup(ctx, r), http.StatusOK
+}
+
+func ListSmartRollupCommitments(ctx *server.Context) (interface{}, int) {
+	args := &ContractRequest{}
+	ctx.ParseRequestArgs(args)
+	rollup := loadSmartRollup(ctx)
+
+	r := etl.ListRequest{
+		RollupId: rollup.RowId,
+		Since:    args.SinceHeight + 1,
+		Until:    args.BlockHeight,
+		Cursor:   args.Cursor,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+	}
+	if args.Sender.IsValid() {
+		acc, err := ctx.Indexer.LookupAccount(ctx, args.Sender)
+		if err != nil {
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such staker", err))
+		}
+		r.SenderId = acc.RowId
+	}
+
+	items, err := ctx.Indexer.ListRollupCommitments(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read rollup commitments", err))
+	}
+
+	resp := &SmartRollupCommitmentList{
+		list:     make([]SmartRollupCommitment, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, rollup.LastSeen),
+	}
+	for _, v := range items {
+		c := SmartRollupCommitment{
+			RowId:        v.RowId.Value(),
+			Hash:         v.GetHash().String(),
+			InboxLevel:   v.InboxLevel,
+			NumTicks:     v.NumTicks,
+			Staker:       ctx.Indexer.LookupAddress(ctx, v.StakerId),
+			Bond:         ctx.Params.ConvertValue(v.Bond),
+			OpHash:       ctx.Indexer.LookupOpHash(ctx, v.OpId),
+			Height:       v.Height,
+			Time:         ctx.Indexer.LookupBlockTime(ctx, v.Height),
+			IsCemented:   v.IsCemented,
+			CementHeight: v.CementHeight,
+		}
+		if len(v.Predecessor) > 0 {
+			c.Predecessor = v.GetPredecessor().String()
+		}
+		if len(v.StateHash) > 0 {
+			c.StateHash = v.GetStateHash().String()
+		}
+		resp.list = append(resp.list, c)
+	}
+	return resp, http.StatusOK
+}
+
+// ListSmartRollupInbox lists external messages added to the shared inbox
+// since the rollup was originated.
+func ListSmartRollupInbox(ctx *server.Context) (interface{}, int) {
+	return listSmartRollupMessages(ctx, false)
+}
+
+// ListSmartRollupOutbox lists outbox messages executed on L1.
+func ListSmartRollupOutbox(ctx *server.Context) (interface{}, int) {
+	return listSmartRollupMessages(ctx, true)
+}
+
+func listSmartRollupMessages(ctx *server.Context, outbox bool) (interface{}, int) {
+	args := &ContractRequest{}
+	ctx.ParseRequestArgs(args)
+	rollup := loadSmartRollup(ctx)
+
+	r := etl.ListRequest{
+		Since:  args.SinceHeight + 1,
+		Until:  args.BlockHeight,
+		Cursor: args.Cursor,
+		Offset: args.Offset,
+		Limit:  ctx.Cfg.ClampExplore(args.Limit),
+		Order:  args.Order,
+	}
+	if outbox {
+		r.RollupId = rollup.RowId
+	} else if r.Since < rollup.FirstSeen {
+		r.Since = rollup.FirstSeen
+	}
+	if args.Sender.IsValid() {
+		acc, err := ctx.Indexer.LookupAccount(ctx, args.Sender)
+		if err != nil {
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such sender", err))
+		}
+		r.SenderId = acc.RowId
+	}
+
+	items, err := ctx.Indexer.ListRollupMessages(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read rollup messages", err))
+	}
+
+	resp := &SmartRollupMessageList{
+		list:     make([]SmartRollupMessage, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Tip.BestTime,
+	}
+	for _, v := range items {
+		m := SmartRollupMessage{
+			RowId:  v.RowId.Value(),
+			Sender: ctx.Indexer.LookupAddress(ctx, v.SenderId),
+			OpHash: ctx.Indexer.LookupOpHash(ctx, v.OpId),
+			Height: v.Height,
+			Time:   ctx.Indexer.LookupBlockTime(ctx, v.Height),
+			Index:  v.Index,
+			Data:   v.Data,
+		}
+		if len(v.Commitment) > 0 {
+			m.Commitment = v.GetCommitment().String()
+		}
+		resp.list = append(resp.list, m)
+	}
+	return resp, http.StatusOK
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+func init() {
+	server.Register(SaplingPool{})
+}
+
+var _ server.RESTful = (*SaplingPool)(nil)
+var _ server.Resource = (*SaplingPool)(nil)
+
+type SaplingPool struct {
+	PoolId          int64         `json:"pool_id"`
+	Contract        tezos.Address `json:"contract"`
+	MemoSize        int           `json:"memo_size"`
+	NumCommitments  int64         `json:"n_commitments"`
+	NumNullifiers   int64         `json:"n_nullifiers"`
+	NumTxs          int64         `json:"n_txs"`
+	Value           float64       `json:"value"`
+	TotalShielded   float64       `json:"total_shielded"`
+	TotalUnshielded float64       `json:"total_unshielded"`
+	FirstBlock      int64         `json:"first_block"`
+	LastBlock       int64         `json:"last_block"`
+	FirstTime       time.Time     `json:"first_time"`
+	LastTime        time.Time     `json:"last_time"`
+
+	expires time.Time `json:"-"`
+}
+
+func NewSaplingPool(ctx *server.Context, p *model.SaplingPool) *SaplingPool {
+	params := ctx.Params
+	return &SaplingPool{
+		PoolId:          p.PoolId,
+		Contract:        ctx.Indexer.LookupAddress(ctx, p.AccountId),
+		MemoSize:        p.MemoSize,
+		NumCommitments:  p.NCommitments,
+		NumNullifiers:   p.NNullifiers,
+		NumTxs:          p.NTxs,
+		Value:           params.ConvertValue(p.Value),
+		TotalShielded:   params.ConvertValue(p.TotalShielded),
+		TotalUnshielded: params.ConvertValue(p.TotalUnshielded),
+		FirstBlock:      p.FirstBlock,
+		LastBlock:       p.LastBlock,
+		FirstTime:       ctx.Indexer.LookupBlockTime(ctx.Context, p.FirstBlock),
+		LastTime:        ctx.Indexer.LookupBlockTime(ctx.Context, p.LastBlock),
+		expires:         ctx.Tip.BestTime.Add(params.BlockTime()),
+	}
+}
+
+func (p SaplingPool) LastModified() time.Time {
+	return p.LastTime
+}
+
+func (p SaplingPool) Expires() time.Time {
+	return p.expires
+}
+
+func (p SaplingPool) RESTPrefix() string {
+	return "/explorer/sapling"
+}
+
+func (p SaplingPool) RESTPath(r *mux.Router) string {
+	path, _ := r.Get("sapling").URLPath("pool", strconv.FormatInt(p.PoolId, 10))
+	return path.String()
+}
+
+func (p SaplingPool) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, p.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{pool}":        {Summary: "Read sapling pool", Response: SaplingPool{}},
+		"GET /{pool}/txs":    {Summary: "List sapling pool transactions", Args: ContractRequest{}, Response: []SaplingTx{}},
+		"GET /{pool}/series": {Summary: "List sapling pool size history", Args: ContractRequest{}, Response: []SaplingPoolSize{}},
+	})
+}
+
+func (p SaplingPool) RegisterDirectRoutes(r *mux.Router) error {
+	return nil
+}
+
+func (p SaplingPool) RegisterRoutes(r *mux.Router) error {
+	r.HandleFunc("/{pool}", server.C(ReadSaplingPool)).Methods("GET").Name("sapling")
+	r.HandleFunc("/{pool}/txs", server.C(ListSaplingTxs)).Methods("GET")
+	r.HandleFunc("/{pool}/series", server.C(ListSaplingSeries)).Methods("GET")
+	return nil
+}
+
+type SaplingTx struct {
+	RowId        uint64       `json:"id"`
+	OpHash       tezos.OpHash `json:"op_hash"`
+	Height       int64        `json:"height"`
+	Time         time.Time    `json:"time"`
+	MemoSize     int          `json:"memo_size"`
+	NCommitments int          `json:"n_commitments"`
+	NNullifiers  int          `json:"n_nullifiers"`
+	Shielded     float64      `json:"shielded"`
+	Unshielded   float64      `json:"unshielded"`
+}
+
+// SaplingPoolSize is a point in the pool size time series.
+type SaplingPoolSize struct {
+	Height       int64     `json:"height"`
+	Time         time.Time `json:"time"`
+	NCommitments int64     `json:"n_commitments"`
+	NNullifiers  int64     `json:"n_nullifiers"`
+	Value        float64   `json:"value"`
+}
+
+type SaplingTxList struct {
+	list     []SaplingTx
+	modified time.Time
+	expires  time.Time
+}
+
+func (l SaplingTxList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l SaplingTxList) LastModified() time.Time      { return l.modified }
+func (l SaplingTxList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*SaplingTxList)(nil)
+
+type SaplingSeries struct {
+	list     []SaplingPoolSize
+	modified time.Time
+	expires  time.Time
+}
+
+func (l SaplingSeries) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l SaplingSeries) LastModified() time.Time      { return l.modified }
+func (l SaplingSeries) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*SaplingSeries)(nil)
+
+func loadSaplingPool(ctx *server.Context) *model.SaplingPool {
+	if ident, ok := mux.Vars(ctx.Request)["pool"]; !ok || ident == "" {
+		panic(server.EBadRequest(server.EC_RESOURCE_ID_MISSING, "missing sapling pool id", nil))
+	} else {
+		id, err := strconv.ParseInt(ident, 10, 64)
+		if err != nil || id < 0 {
+			panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid sapling pool id", err))
+		}
+		p, err := ctx.Indexer.LookupSaplingPool(ctx, id)
+		if err != nil {
+			switch err {
+			case index.ErrNoSaplingEntry:
+				panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such sapling pool", err))
+			default:
+				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
+			}
+		}
+		return p
+	}
+}
+
+func ReadSaplingPool(ctx *server.Context) (interface{}, int) {
+	return NewSaplingPool(ctx, loadSaplingPool(ctx)), http.StatusOK
+}
+
+func listSaplingTxs(ctx *server.Context, pool *model.SaplingPool, args *ContractRequest) []*model.SaplingTx {
+	r := etl.ListRequest{
+		BigmapId: pool.PoolId,
+		Since:    args.SinceHeight + 1,
+		Until:    args.BlockHeight,
+		Cursor:   args.Cursor,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+	}
+	items, err := ctx.Indexer.ListSaplingTxs(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read sapling txs", err))
+	}
+	return items
+}
+
+func ListSaplingTxs(ctx *server.Context) (interface{}, int) {
+	args := &ContractRequest{}
+	ctx.ParseRequestArgs(args)
+	pool := loadSaplingPool(ctx)
+	items := listSaplingTxs(ctx, pool, args)
+
+	resp := &SaplingTxList{
+		list:     make([]SaplingTx, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, pool.LastBlock),
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, SaplingTx{
+			RowId:        v.RowId.Value(),
+			OpHash:       ctx.Indexer.LookupOpHash(ctx, v.OpId),
+			Height:       v.Height,
+			Time:         v.Time,
+			MemoSize:     v.MemoSize,
+			NCommitments: v.NCommitments,
+			NNullifiers:  v.NNullifiers,
+			Shielded:     ctx.Params.ConvertValue(v.Shielded),
+			Unshielded:   ctx.Params.ConvertValue(v.Unshielded),
+		})
+	}
+	return resp, http.StatusOK
+}
+
+// ListSaplingSeries returns pool size after each update, oldest first by default.
+func ListSaplingSeries(ctx *server.Context) (interface{}, int) {
+	args := &ContractRequest{
+		ListRequest: ListRequest{
+			Order: pack.OrderAsc,
+		},
+	}
+	ctx.ParseRequestArgs(args)
+	pool := loadSaplingPool(ctx)
+	items := listSaplingTxs(ctx, pool, args)
+
+	resp := &SaplingSeries{
+		list:     make([]SaplingPoolSize, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, pool.LastBlock),
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, SaplingPoolSize{
+			Height:       v.Height,
+			Time:         v.Time,
+			NCommitments: v.PoolCommitments,
+			NNullifiers:  v.PoolNullifiers,
+			Value:        ctx.Params.ConvertValue(v.PoolValue),
+		})
+	}
+	return resp, http.StatusOK
+}
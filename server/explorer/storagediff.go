@@ -0,0 +1,170 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"blockwatch.cc/tzgo/micheline"
+)
+
+// storage diff actions
+const (
+	StorageDiffUpdate = "update" // value changed
+	StorageDiffAdd    = "add"    // map key, list or set element added
+	StorageDiffRemove = "remove" // map key, list or set element removed
+	StorageDiffBigmap = "bigmap" // bigmap pointer changed
+)
+
+type StorageDiff struct {
+	Path   string      `json:"path"`
+	Action string      `json:"action"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+}
+
+// DiffStorage compares two storage values typed against storage type typ.
+// Paths use the same labels as rendered storage values joined by dots. An
+// empty old value (e.g. on origination) reports the full storage as added.
+func DiffStorage(typ micheline.Type, old, new micheline.Prim) ([]StorageDiff, error) {
+	n, err := micheline.NewValuePtr(typ, new).Map()
+	if err != nil {
+		return nil, err
+	}
+	diff := make([]StorageDiff, 0)
+	if !old.IsValid() {
+		return append(diff, StorageDiff{Action: StorageDiffAdd, New: n}), nil
+	}
+	o, err := micheline.NewValuePtr(typ, old).Map()
+	if err != nil {
+		return nil, err
+	}
+	td := typ.Typedef("")
+	diffStorageNode(&td, "", o, n, &diff)
+	return diff, nil
+}
+
+func diffStorageNode(td *micheline.Typedef, path string, old, new interface{}, diff *[]StorageDiff) {
+	if isEqualJSON(old, new) {
+		return
+	}
+	switch {
+	case old == nil:
+		*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffAdd, New: new})
+		return
+	case new == nil:
+		*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffRemove, Old: old})
+		return
+	}
+
+	// bigmap pointers render as numeric ids
+	if td != nil && td.Type == micheline.T_BIG_MAP.String() {
+		oid, ok1 := old.(int64)
+		nid, ok2 := new.(int64)
+		if ok1 && ok2 {
+			*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffBigmap, Old: oid, New: nid})
+			return
+		}
+	}
+
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			p := joinStoragePath(path, k)
+			switch {
+			case !inOld:
+				*diff = append(*diff, StorageDiff{Path: p, Action: StorageDiffAdd, New: nv})
+			case !inNew:
+				*diff = append(*diff, StorageDiff{Path: p, Action: StorageDiffRemove, Old: ov})
+			default:
+				diffStorageNode(childTypedef(td, k), p, ov, nv, diff)
+			}
+		}
+		return
+
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		// compare list and set elements as multisets
+		count := make(map[string]int)
+		for _, v := range n {
+			count[string(marshalJSON(v))]++
+		}
+		for _, v := range o {
+			key := string(marshalJSON(v))
+			if count[key] > 0 {
+				count[key]--
+				continue
+			}
+			*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffRemove, Old: v})
+		}
+		for _, v := range n {
+			key := string(marshalJSON(v))
+			if count[key] > 0 {
+				count[key]--
+				*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffAdd, New: v})
+			}
+		}
+		return
+	}
+
+	*diff = append(*diff, StorageDiff{Path: path, Action: StorageDiffUpdate, Old: old, New: new})
+}
+
+// childTypedef returns the type of field name below td. Map values share the
+// value type, struct fields and union branches are matched by name.
+func childTypedef(td *micheline.Typedef, name string) *micheline.Typedef {
+	if td == nil {
+		return nil
+	}
+	switch td.Type {
+	case micheline.T_MAP.String(), micheline.T_BIG_MAP.String():
+		if len(td.Args) > 1 {
+			return &td.Args[1]
+		}
+	case micheline.TypeStruct, micheline.TypeUnion:
+		for i := range td.Args {
+			if td.Args[i].Name == name {
+				return &td.Args[i]
+			}
+		}
+	}
+	return nil
+}
+
+func joinStoragePath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func marshalJSON(v interface{}) []byte {
+	buf, _ := json.Marshal(v)
+	return buf
+}
+
+func isEqualJSON(a, b interface{}) bool {
+	return bytes.Equal(marshalJSON(a), marshalJSON(b))
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+)
+
+const testStorageType = `{"prim":"pair","args":[
+	{"prim":"nat","annots":["%counter"]},
+	{"prim":"map","args":[{"prim":"string"},{"prim":"nat"}],"annots":["%ledger"]},
+	{"prim":"list","args":[{"prim":"string"}],"annots":["%admins"]},
+	{"prim":"big_map","args":[{"prim":"string"},{"prim":"bytes"}],"annots":["%metadata"]}
+]}`
+
+func testStorage(t *testing.T, s string) micheline.Prim {
+	t.Helper()
+	var p micheline.Prim
+	if s == "" {
+		return p
+	}
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDiffStorage(t *testing.T) {
+	typ := micheline.NewType(testStorage(t, testStorageType))
+	base := `{"prim":"Pair","args":[{"int":"1"},[{"prim":"Elt","args":[{"string":"a"},{"int":"10"}]},{"prim":"Elt","args":[{"string":"b"},{"int":"20"}]}],[{"string":"x"},{"string":"y"}],{"int":"5"}]}`
+
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []StorageDiff
+	}{
+		{
+			name: "unchanged",
+			old:  base,
+			new:  base,
+			want: []StorageDiff{},
+		},
+		{
+			name: "scalar update",
+			old:  base,
+			new:  `{"prim":"Pair","args":[{"int":"2"},[{"prim":"Elt","args":[{"string":"a"},{"int":"10"}]},{"prim":"Elt","args":[{"string":"b"},{"int":"20"}]}],[{"string":"x"},{"string":"y"}],{"int":"5"}]}`,
+			want: []StorageDiff{
+				{Path: "counter", Action: StorageDiffUpdate, Old: "1", New: "2"},
+			},
+		},
+		{
+			name: "map keys",
+			old:  base,
+			new:  `{"prim":"Pair","args":[{"int":"1"},[{"prim":"Elt","args":[{"string":"a"},{"int":"11"}]},{"prim":"Elt","args":[{"string":"c"},{"int":"30"}]}],[{"string":"x"},{"string":"y"}],{"int":"5"}]}`,
+			want: []StorageDiff{
+				{Path: "ledger.a", Action: StorageDiffUpdate, Old: "10", New: "11"},
+				{Path: "ledger.b", Action: StorageDiffRemove, Old: "20"},
+				{Path: "ledger.c", Action: StorageDiffAdd, New: "30"},
+			},
+		},
+		{
+			name: "list elements",
+			old:  base,
+			new:  `{"prim":"Pair","args":[{"int":"1"},[{"prim":"Elt","args":[{"string":"a"},{"int":"10"}]},{"prim":"Elt","args":[{"string":"b"},{"int":"20"}]}],[{"string":"y"},{"string":"z"},{"string":"y"}],{"int":"5"}]}`,
+			want: []StorageDiff{
+				{Path: "admins", Action: StorageDiffRemove, Old: "x"},
+				{Path: "admins", Action: StorageDiffAdd, New: "y"},
+				{Path: "admins", Action: StorageDiffAdd, New: "z"},
+			},
+		},
+		{
+			name: "bigmap pointer",
+			old:  base,
+			new:  `{"prim":"Pair","args":[{"int":"1"},[{"prim":"Elt","args":[{"string":"a"},{"int":"10"}]},{"prim":"Elt","args":[{"string":"b"},{"int":"20"}]}],[{"string":"x"},{"string":"y"}],{"int":"7"}]}`,
+			want: []StorageDiff{
+				{Path: "metadata", Action: StorageDiffBigmap, Old: 5, New: 7},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, err := DiffStorage(typ, testStorage(t, tc.old), testStorage(t, tc.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// numbers render as strings, compare the JSON representation
+			if got, want := marshalJSON(diff), marshalJSON(tc.want); string(got) != string(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+
+	// origination reports the full storage as added
+	diff, err := DiffStorage(typ, micheline.Prim{}, testStorage(t, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Action != StorageDiffAdd || diff[0].Path != "" || diff[0].New == nil {
+		t.Errorf("got %#v, want full storage added", diff)
+	}
+}
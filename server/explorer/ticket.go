@@ -0,0 +1,310 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package explorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/vec"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+func init() {
+	server.Register(Ticket{})
+}
+
+var _ server.RESTful = (*Ticket)(nil)
+var _ server.Resource = (*Ticket)(nil)
+
+type Ticket struct {
+	Id           uint64         `json:"id"`
+	Ticketer     tezos.Address  `json:"ticketer"`
+	Type         micheline.Prim `json:"type"`
+	Content      micheline.Prim `json:"content"`
+	Hash         tezos.ExprHash `json:"hash"`
+	FirstBlock   int64          `json:"first_block"`
+	LastBlock    int64          `json:"last_block"`
+	FirstTime    time.Time      `json:"first_time"`
+	LastTime     time.Time      `json:"last_time"`
+	TotalSupply  int64          `json:"total_supply"`
+	TotalMint    int64          `json:"total_mint"`
+	TotalBurn    int64          `json:"total_burn"`
+	NumHolders   int            `json:"n_holders"`
+	NumTransfers int            `json:"n_transfers"`
+
+	expires time.Time `json:"-"`
+}
+
+func NewTicket(ctx *server.Context, t *model.TicketType) *Ticket {
+	return &Ticket{
+		Id:           t.RowId.Value(),
+		Ticketer:     ctx.Indexer.LookupAddress(ctx, t.Ticketer),
+		Type:         t.GetType(),
+		Content:      t.GetContent(),
+		Hash:         t.GetHash(),
+		FirstBlock:   t.FirstBlock,
+		LastBlock:    t.LastBlock,
+		FirstTime:    ctx.Indexer.LookupBlockTime(ctx.Context, t.FirstBlock),
+		LastTime:     ctx.Indexer.LookupBlockTime(ctx.Context, t.LastBlock),
+		TotalSupply:  t.TotalSupply,
+		TotalMint:    t.TotalMint,
+		TotalBurn:    t.TotalBurn,
+		NumHolders:   t.NHolders,
+		NumTransfers: t.NTransfers,
+		expires:      ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+	}
+}
+
+func (t Ticket) LastModified() time.Time {
+	return t.LastTime
+}
+
+func (t Ticket) Expires() time.Time {
+	return t.expires
+}
+
+func (t Ticket) RESTPrefix() string {
+	return "/explorer/ticket"
+}
+
+func (t Ticket) RESTPath(r *mux.Router) string {
+	path, _ := r.Get("ticket").URLPath("id", strconv.FormatUint(t.Id, 10))
+	return path.String()
+}
+
+func (t Ticket) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	return describe(method, path, t.RESTPrefix(), map[string]server.ApiDoc{
+		"GET /{id}":         {Summary: "Read ticket type", Response: Ticket{}},
+		"GET /{id}/holders": {Summary: "List ticket holders", Args: ContractRequest{}, Response: []TicketBalance{}},
+		"GET /{id}/updates": {Summary: "List ticket transfers", Args: ContractRequest{}, Response: []TicketUpdate{}},
+	})
+}
+
+func (t Ticket) RegisterDirectRoutes(r *mux.Router) error {
+	return nil
+}
+
+func (t Ticket) RegisterRoutes(r *mux.Router) error {
+	r.HandleFunc("/{id}", server.C(ReadTicket)).Methods("GET").Name("ticket")
+	r.HandleFunc("/{id}/holders", server.C(ListTicketHolders)).Methods("GET")
+	r.HandleFunc("/{id}/updates", server.C(ListTicketUpdates)).Methods("GET")
+	return nil
+}
+
+type TicketBalance struct {
+	Ticket     *Ticket       `json:"ticket,omitempty"`
+	TicketId   uint64        `json:"ticket_id"`
+	Account    tezos.Address `json:"account"`
+	Balance    int64         `json:"balance"`
+	FirstBlock int64         `json:"first_block"`
+	LastBlock  int64         `json:"last_block"`
+	NTransfers int           `json:"n_transfers"`
+}
+
+type TicketUpdate struct {
+	RowId    uint64        `json:"id"`
+	TicketId uint64        `json:"ticket_id"`
+	Account  tezos.Address `json:"account"`
+	Holder   string        `json:"holder,omitempty"`
+	Amount   int64         `json:"amount"`
+	OpHash   tezos.OpHash  `json:"op_hash"`
+	Height   int64         `json:"height"`
+	Time     time.Time     `json:"time"`
+}
+
+type TicketBalanceList struct {
+	list     []TicketBalance
+	modified time.Time
+	expires  time.Time
+}
+
+func (l TicketBalanceList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l TicketBalanceList) LastModified() time.Time      { return l.modified }
+func (l TicketBalanceList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*TicketBalanceList)(nil)
+
+type TicketUpdateList struct {
+	list     []TicketUpdate
+	modified time.Time
+	expires  time.Time
+}
+
+func (l TicketUpdateList) MarshalJSON() ([]byte, error) { return json.Marshal(l.list) }
+func (l TicketUpdateList) LastModified() time.Time      { return l.modified }
+func (l TicketUpdateList) Expires() time.Time           { return l.expires }
+
+var _ server.Resource = (*TicketUpdateList)(nil)
+
+func loadTicket(ctx *server.Context) *model.TicketType {
+	if ident, ok := mux.Vars(ctx.Request)["id"]; !ok || ident == "" {
+		panic(server.EBadRequest(server.EC_RESOURCE_ID_MISSING, "missing ticket id", nil))
+	} else {
+		id, err := strconv.ParseUint(ident, 10, 64)
+		if err != nil {
+			panic(server.EBadRequest(server.EC_RESOURCE_ID_MALFORMED, "invalid ticket id", err))
+		}
+		t, err := ctx.Indexer.LookupTicketType(ctx, model.TicketTypeID(id))
+		if err != nil {
+			switch err {
+			case index.ErrNoTicketEntry:
+				panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such ticket", err))
+			default:
+				panic(server.EInternal(server.EC_DATABASE, err.Error(), nil))
+			}
+		}
+		return t
+	}
+}
+
+func ReadTicket(ctx *server.Context) (interface{}, int) {
+	return NewTicket(ctx, loadTicket(ctx)), http.StatusOK
+}
+
+func ListTicketHolders(ctx *server.Context) (interface{}, int) {
+	args := &ListRequest{}
+	ctx.ParseRequestArgs(args)
+	ticket := loadTicket(ctx)
+
+	r := etl.ListRequest{
+		TicketId: ticket.RowId,
+		Cursor:   args.Cursor,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+	}
+	items, err := ctx.Indexer.ListTicketBalances(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read ticket holders", err))
+	}
+
+	resp := &TicketBalanceList{
+		list:     make([]TicketBalance, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, ticket.LastBlock),
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, TicketBalance{
+			TicketId:   v.TicketId.Value(),
+			Account:    ctx.Indexer.LookupAddress(ctx, v.AccountId),
+			Balance:    v.Balance,
+			FirstBlock: v.FirstBlock,
+			LastBlock:  v.LastBlock,
+			NTransfers: v.NTransfers,
+		})
+	}
+	return resp, http.StatusOK
+}
+
+func ListTicketUpdates(ctx *server.Context) (interface{}, int) {
+	args := &ContractRequest{}
+	ctx.ParseRequestArgs(args)
+	ticket := loadTicket(ctx)
+
+	r := etl.ListRequest{
+		TicketId: ticket.RowId,
+		Since:    args.SinceHeight + 1,
+		Until:    args.BlockHeight,
+		Cursor:   args.Cursor,
+		Offset:   args.Offset,
+		Limit:    ctx.Cfg.ClampExplore(args.Limit),
+		Order:    args.Order,
+	}
+	if args.Sender.IsValid() {
+		acc, err := ctx.Indexer.LookupAccount(ctx, args.Sender)
+		if err != nil {
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "no such account", err))
+		}
+		r.Account = acc
+	}
+	items, err := ctx.Indexer.ListTicketUpdates(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read ticket updates", err))
+	}
+
+	resp := &TicketUpdateList{
+		list:     make([]TicketUpdate, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, ticket.LastBlock),
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, TicketUpdate{
+			RowId:    v.RowId.Value(),
+			TicketId: v.TicketId.Value(),
+			Account:  ctx.Indexer.LookupAddress(ctx, v.AccountId),
+			Holder:   v.Holder,
+			Amount:   v.Amount,
+			OpHash:   ctx.Indexer.LookupOpHash(ctx, v.OpId),
+			Height:   v.Height,
+			Time:     v.Time,
+		})
+	}
+	return resp, http.StatusOK
+}
+
+// ListAccountTickets lists all tickets currently held by an account.
+func ListAccountTickets(ctx *server.Context) (interface{}, int) {
+	args := &AccountRequest{
+		ListRequest: ListRequest{
+			Order: pack.OrderAsc,
+		},
+	}
+	ctx.ParseRequestArgs(args)
+	acc := loadAccount(ctx)
+
+	r := etl.ListRequest{
+		Account: acc,
+		Cursor:  args.Cursor,
+		Offset:  args.Offset,
+		Limit:   ctx.Cfg.ClampExplore(args.Limit),
+		Order:   args.Order,
+	}
+	items, err := ctx.Indexer.ListTicketBalances(ctx, r)
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read account tickets", err))
+	}
+
+	// resolve ticket types in a single batch
+	ids := make([]uint64, 0, len(items))
+	for _, v := range items {
+		ids = append(ids, v.TicketId.Value())
+	}
+	types, err := ctx.Indexer.LookupTicketTypeIds(ctx, vec.UniqueUint64Slice(ids))
+	if err != nil {
+		panic(server.EInternal(server.EC_DATABASE, "cannot read ticket types", err))
+	}
+	typeMap := make(map[model.TicketTypeID]*Ticket, len(types))
+	for _, v := range types {
+		typeMap[v.RowId] = NewTicket(ctx, v)
+	}
+
+	resp := &TicketBalanceList{
+		list:     make([]TicketBalance, 0, len(items)),
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+		modified: ctx.Indexer.LookupBlockTime(ctx, acc.LastSeen),
+	}
+	for _, v := range items {
+		resp.list = append(resp.list, TicketBalance{
+			Ticket:     typeMap[v.TicketId],
+			TicketId:   v.TicketId.Value(),
+			Account:    acc.Address,
+			Balance:    v.Balance,
+			FirstBlock: v.FirstBlock,
+			LastBlock:  v.LastBlock,
+			NTransfers: v.NTransfers,
+		})
+	}
+	return resp, http.StatusOK
+}
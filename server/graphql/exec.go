@@ -0,0 +1,508 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"blockwatch.cc/tzindex/server"
+)
+
+// QueryError is returned for invalid queries, as opposed to errors
+// from resolving data.
+type QueryError struct {
+	msg string
+}
+
+func (e *QueryError) Error() string {
+	return "graphql: " + e.msg
+}
+
+func errorf(format string, args ...interface{}) error {
+	return &QueryError{fmt.Sprintf(format, args...)}
+}
+
+// result is a JSON object that keeps fields in selection order.
+type result struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newResult() *result {
+	return &result{vals: make(map[string]interface{})}
+}
+
+func (r *result) set(key string, val interface{}) {
+	if _, ok := r.vals[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.vals[key] = val
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(r.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+type executor struct {
+	ctx    *server.Context
+	schema *Schema
+	doc    *Document
+	vars   map[string]interface{}
+}
+
+func newExecutor(ctx *server.Context, schema *Schema, doc *Document, op *Operation, vars map[string]interface{}) (*executor, error) {
+	e := &executor{
+		ctx:    ctx,
+		schema: schema,
+		doc:    doc,
+		vars:   make(map[string]interface{}),
+	}
+	for _, v := range op.Vars {
+		val, ok := vars[v.Name]
+		if !ok {
+			if v.Default == nil && strings.HasSuffix(v.Type, "!") {
+				return nil, errorf("missing required variable $%s", v.Name)
+			}
+			val = v.Default
+		}
+		e.vars[v.Name] = val
+	}
+	return e, nil
+}
+
+// selectOperation picks the operation to run by name.
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, errorf("operation name required for documents with multiple operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, v := range doc.Operations {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, errorf("unknown operation %q", name)
+}
+
+// resolveValue replaces variables in literal values.
+func (e *executor) resolveValue(v Value) (Value, error) {
+	switch val := v.(type) {
+	case Variable:
+		x, ok := e.vars[string(val)]
+		if !ok {
+			return nil, errorf("undefined variable $%s", val)
+		}
+		return x, nil
+	case []Value:
+		list := make([]Value, len(val))
+		for i := range val {
+			x, err := e.resolveValue(val[i])
+			if err != nil {
+				return nil, err
+			}
+			list[i] = x
+		}
+		return list, nil
+	case []interface{}:
+		list := make([]Value, len(val))
+		for i := range val {
+			list[i] = val[i]
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+func coerce(v Value, typ Type) (interface{}, error) {
+	if v == nil {
+		if typ.NonNull {
+			return nil, errorf("null value for non-null type %s", typ)
+		}
+		return nil, nil
+	}
+	if typ.List {
+		list, ok := v.([]Value)
+		if !ok {
+			list = []Value{v}
+		}
+		elem := Type{Name: typ.Name, NonNull: true}
+		res := make([]interface{}, len(list))
+		for i := range list {
+			x, err := coerce(list[i], elem)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = x
+		}
+		return res, nil
+	}
+	switch typ.Name {
+	case Int:
+		switch n := v.(type) {
+		case int64:
+			return n, nil
+		case float64:
+			if n == math.Trunc(n) {
+				return int64(n), nil
+			}
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case Float:
+		switch n := v.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		case json.Number:
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case String:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case Enum:
+			return string(s), nil
+		}
+	case Boolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, errorf("invalid value %v for type %s", v, typ)
+}
+
+func (e *executor) coerceArgs(obj *Object, def *FieldDef, f *Field) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for _, a := range f.Args {
+		if def.Arg(a.Name) == nil {
+			return nil, errorf("unknown argument %q on field %s.%s", a.Name, obj.Name, def.Name)
+		}
+	}
+	for _, ad := range def.Args {
+		var (
+			val   Value = ad.Default
+			found bool
+		)
+		for _, a := range f.Args {
+			if a.Name == ad.Name {
+				v, err := e.resolveValue(a.Value)
+				if err != nil {
+					return nil, err
+				}
+				val, found = v, true
+				break
+			}
+		}
+		if !found && val == nil && !ad.Type.NonNull {
+			continue
+		}
+		x, err := coerce(val, ad.Type)
+		if err != nil {
+			return nil, errorf("argument %q on field %s.%s: %s", ad.Name, obj.Name, def.Name, strings.TrimPrefix(err.Error(), "graphql: "))
+		}
+		args[ad.Name] = x
+	}
+	return args, nil
+}
+
+// include evaluates @skip and @include directives.
+func (e *executor) include(dirs []*Directive) (bool, error) {
+	for _, d := range dirs {
+		switch d.Name {
+		case "skip", "include":
+			if len(d.Args) != 1 || d.Args[0].Name != "if" {
+				return false, errorf("directive @%s requires a single 'if' argument", d.Name)
+			}
+			v, err := e.resolveValue(d.Args[0].Value)
+			if err != nil {
+				return false, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return false, errorf("directive @%s requires a boolean", d.Name)
+			}
+			if b == (d.Name == "skip") {
+				return false, nil
+			}
+		default:
+			return false, errorf("unknown directive @%s", d.Name)
+		}
+	}
+	return true, nil
+}
+
+// collectFields flattens fragments and merges fields with the same response
+// key into a single field in order of first appearance.
+func (e *executor) collectFields(obj *Object, sels []Selection, fields []*Field, visited map[string]bool) ([]*Field, error) {
+	for _, s := range sels {
+		switch sel := s.(type) {
+		case *Field:
+			if ok, err := e.include(sel.Directives); err != nil || !ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			merged := false
+			for i, f := range fields {
+				if f.Key() != sel.Key() {
+					continue
+				}
+				if f.Name != sel.Name {
+					return nil, errorf("conflicting fields for response key %q", sel.Key())
+				}
+				clone := *f
+				clone.Selections = append(append([]Selection{}, f.Selections...), sel.Selections...)
+				fields[i] = &clone
+				merged = true
+				break
+			}
+			if !merged {
+				fields = append(fields, sel)
+			}
+		case *InlineFragment:
+			if sel.On != "" && sel.On != obj.Name {
+				if _, ok := e.schema.Types[sel.On]; !ok {
+					return nil, errorf("unknown type %q", sel.On)
+				}
+				continue
+			}
+			if ok, err := e.include(sel.Directives); err != nil || !ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			var err error
+			if fields, err = e.collectFields(obj, sel.Selections, fields, visited); err != nil {
+				return nil, err
+			}
+		case *FragmentSpread:
+			if visited[sel.Name] {
+				continue
+			}
+			frag, ok := e.doc.Fragments[sel.Name]
+			if !ok {
+				return nil, errorf("unknown fragment %q", sel.Name)
+			}
+			if ok, err := e.include(sel.Directives); err != nil || !ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if frag.On != obj.Name {
+				if _, ok := e.schema.Types[frag.On]; !ok {
+					return nil, errorf("unknown type %q", frag.On)
+				}
+				continue
+			}
+			visited[sel.Name] = true
+			var err error
+			fields, err = e.collectFields(obj, frag.Selections, fields, visited)
+			delete(visited, sel.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fields, nil
+}
+
+func (e *executor) fieldDef(obj *Object, f *Field) (*FieldDef, error) {
+	def, ok := obj.Fields[f.Name]
+	if !ok {
+		return nil, errorf("unknown field %q on type %s", f.Name, obj.Name)
+	}
+	if def.Type.IsScalar() && len(f.Selections) > 0 {
+		return nil, errorf("field %s.%s of type %s must not have a selection", obj.Name, f.Name, def.Type)
+	}
+	if !def.Type.IsScalar() && len(f.Selections) == 0 {
+		return nil, errorf("field %s.%s of type %s must have a selection", obj.Name, f.Name, def.Type)
+	}
+	return def, nil
+}
+
+// MaxDepth is the max nesting level of object fields in a query.
+const MaxDepth = 8
+
+// Cost statically computes the cost of a selection set. Object fields add
+// their base cost and list fields multiply their children's cost by the
+// requested page size. Cost is charged against the dispatcher budget
+// before any data is read. Queries nested deeper than MaxDepth are rejected
+// and costs saturate at math.MaxInt64 so they never wrap around.
+func (e *executor) Cost(obj *Object, sels []Selection) (int64, error) {
+	return e.cost(obj, sels, 1)
+}
+
+func (e *executor) cost(obj *Object, sels []Selection, depth int) (int64, error) {
+	if depth > MaxDepth {
+		return 0, errorf("query exceeds max depth %d", MaxDepth)
+	}
+	fields, err := e.collectFields(obj, sels, nil, make(map[string]bool))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range fields {
+		if f.Name == "__typename" {
+			continue
+		}
+		def, err := e.fieldDef(obj, f)
+		if err != nil {
+			return 0, err
+		}
+		if def.Type.IsScalar() {
+			total = addCost(total, def.Cost)
+			continue
+		}
+		args, err := e.coerceArgs(obj, def, f)
+		if err != nil {
+			return 0, err
+		}
+		child, err := e.cost(e.schema.Types[def.Type.Name], f.Selections, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		if def.Type.List {
+			limit, _ := args["limit"].(int64)
+			n := e.ctx.Cfg.ClampExplore64(limit)
+			if def.Arg("limit") == nil {
+				n = int64(e.ctx.Cfg.Http.MaxExploreCount)
+			}
+			// every list item costs at least one unit
+			if child < 1 {
+				child = 1
+			}
+			total = addCost(total, addCost(def.Cost, mulCost(n, child)))
+		} else {
+			total = addCost(total, addCost(def.Cost, child))
+		}
+	}
+	return total, nil
+}
+
+// addCost adds non-negative costs and saturates at math.MaxInt64.
+func addCost(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// mulCost multiplies non-negative costs and saturates at math.MaxInt64.
+func mulCost(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > math.MaxInt64/b {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// Execute resolves a selection set for a batch of parent objects. Every field
+// is resolved once for all parents so that relations can batch lookups.
+func (e *executor) Execute(obj *Object, parents []interface{}, sels []Selection) ([]*result, error) {
+	results := make([]*result, len(parents))
+	for i := range results {
+		results[i] = newResult()
+	}
+	fields, err := e.collectFields(obj, sels, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f.Name == "__typename" {
+			for _, r := range results {
+				r.set(f.Key(), obj.Name)
+			}
+			continue
+		}
+		def, err := e.fieldDef(obj, f)
+		if err != nil {
+			return nil, err
+		}
+		args, err := e.coerceArgs(obj, def, f)
+		if err != nil {
+			return nil, err
+		}
+		vals, err := def.Resolve(e.ctx, parents, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", obj.Name, def.Name, err)
+		}
+		if len(vals) != len(parents) {
+			return nil, fmt.Errorf("%s.%s: resolver returned %d results for %d objects", obj.Name, def.Name, len(vals), len(parents))
+		}
+		if def.Type.IsScalar() {
+			for i, r := range results {
+				r.set(f.Key(), vals[i])
+			}
+			continue
+		}
+
+		// flatten child objects across all parents, then resolve them as one batch
+		child := e.schema.Types[def.Type.Name]
+		var (
+			flat   []interface{}
+			bounds = make([][2]int, len(vals))
+		)
+		for i, v := range vals {
+			start := len(flat)
+			if def.Type.List {
+				if list, ok := v.([]interface{}); ok {
+					flat = append(flat, list...)
+				}
+			} else if v != nil {
+				flat = append(flat, v)
+			}
+			bounds[i] = [2]int{start, len(flat)}
+		}
+		sub, err := e.Execute(child, flat, f.Selections)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range results {
+			b := bounds[i]
+			switch {
+			case def.Type.List && vals[i] == nil:
+				r.set(f.Key(), nil)
+			case def.Type.List:
+				r.set(f.Key(), sub[b[0]:b[1]])
+			case b[0] == b[1]:
+				r.set(f.Key(), nil)
+			default:
+				r.set(f.Key(), sub[b[0]])
+			}
+		}
+	}
+	return results, nil
+}
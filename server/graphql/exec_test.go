@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzindex/server"
+)
+
+// nestedOps returns a query with n levels of account operations below the
+// root account lookup.
+func nestedOps(n int, limit string) string {
+	var b strings.Builder
+	b.WriteString(`{ account(address: "tz1") { `)
+	for i := 0; i < n; i++ {
+		b.WriteString("operations" + limit + " { sender { ")
+	}
+	b.WriteString("address")
+	for i := 0; i < n; i++ {
+		b.WriteString(" } }")
+	}
+	b.WriteString(" } }")
+	return b.String()
+}
+
+func TestQueryCost(t *testing.T) {
+	ctx := &server.Context{
+		Cfg: &server.Config{
+			Http: server.HttpConfig{DefaultExploreCount: 10, MaxExploreCount: 100},
+		},
+	}
+	tests := []struct {
+		name  string
+		query string
+		min   int64 // lower bound of the expected cost
+		err   string
+	}{
+		{"lookup", `{ account(address: "tz1") { address } }`, 1, ""},
+		{"one list", nestedOps(1, ""), 10, ""},
+		{"list limit", nestedOps(1, "(limit: 100)"), 100, ""},
+		{"nested lists", nestedOps(3, "(limit: 100)"), 1000000, ""},
+		{"too deep", nestedOps(4, ""), 0, "max depth"},
+		{"too deep fragment", `{ ...f } fragment f on Query ` + nestedOps(4, ""), 0, "max depth"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := Parse(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			op, err := selectOperation(doc, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := newExecutor(ctx, schema, doc, op, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cost, err := e.Cost(schema.Query, op.Selections)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got cost=%d err=%v, want error %q", cost, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cost < tc.min {
+				t.Errorf("got cost %d, want at least %d", cost, tc.min)
+			}
+		})
+	}
+}
+
+func TestCostSaturates(t *testing.T) {
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"add", addCost(3, 4), 7},
+		{"add overflow", addCost(math.MaxInt64-1, 2), math.MaxInt64},
+		{"add saturated", addCost(math.MaxInt64, math.MaxInt64), math.MaxInt64},
+		{"mul", mulCost(100, 100), 10000},
+		{"mul zero", mulCost(0, math.MaxInt64), 0},
+		{"mul overflow", mulCost(100, math.MaxInt64/50), math.MaxInt64},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, tc.got, tc.want)
+		}
+	}
+
+	// ten nested lists of 100 items overflow int64 without saturation
+	cost := int64(1)
+	for i := 0; i < 10; i++ {
+		cost = addCost(1, mulCost(100, cost))
+		if cost <= 0 {
+			t.Fatalf("level %d: cost wrapped to %d", i, cost)
+		}
+	}
+	if cost != math.MaxInt64 {
+		t.Errorf("got cost %d, want saturation at %d", cost, int64(math.MaxInt64))
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"blockwatch.cc/tzindex/server"
+)
+
+var schema = NewTzSchema()
+
+func init() {
+	if err := schema.Check(); err != nil {
+		panic(err)
+	}
+	server.Register(GraphQL{})
+}
+
+var _ server.RESTful = (*GraphQL)(nil)
+var _ server.Describer = (*GraphQL)(nil)
+
+type GraphQL struct{}
+
+func (g GraphQL) LastModified() time.Time {
+	return time.Now().UTC()
+}
+
+func (g GraphQL) Expires() time.Time {
+	return time.Time{}
+}
+
+func (g GraphQL) RESTPrefix() string {
+	return "/graphql"
+}
+
+func (g GraphQL) RESTPath(r *mux.Router) string {
+	return g.RESTPrefix()
+}
+
+func (g GraphQL) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	switch path {
+	case g.RESTPrefix():
+		return []server.ApiDoc{{Summary: "Execute GraphQL query", Args: QueryRequest{}}}
+	case g.RESTPrefix() + "/schema":
+		return []server.ApiDoc{{Summary: "Read GraphQL schema", Response: ""}}
+	}
+	return nil
+}
+
+func (g GraphQL) RegisterDirectRoutes(r *mux.Router) error {
+	r.HandleFunc(g.RESTPrefix(), server.C(Query)).Methods("GET", "POST")
+	r.HandleFunc(g.RESTPrefix()+"/schema", server.C(GetSchema)).Methods("GET")
+	return nil
+}
+
+func (g GraphQL) RegisterRoutes(r *mux.Router) error {
+	return nil
+}
+
+type QueryRequest struct {
+	Query         string                 `json:"query"         schema:"query"`
+	OperationName string                 `json:"operationName" schema:"operationName"`
+	Variables     map[string]interface{} `json:"variables"     schema:"-"`
+}
+
+type QueryResponse struct {
+	Data     *result `json:"data"`
+	modified time.Time
+	expires  time.Time
+}
+
+func (r QueryResponse) LastModified() time.Time { return r.modified }
+func (r QueryResponse) Expires() time.Time      { return r.expires }
+
+var _ server.Resource = (*QueryResponse)(nil)
+
+func GetSchema(ctx *server.Context) (interface{}, int) {
+	return schema.SDL(), http.StatusOK
+}
+
+// Query executes a GraphQL query. The query cost is computed before execution
+// and charged against the dispatcher's query budget.
+func Query(ctx *server.Context) (interface{}, int) {
+	args := &QueryRequest{}
+	ctx.ParseRequestArgs(args)
+	if ctx.Request.Method == http.MethodGet {
+		if v := ctx.Request.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &args.Variables); err != nil {
+				panic(server.EBadRequest(server.EC_BAD_URL_QUERY, "invalid variables", err))
+			}
+		}
+	}
+	if args.Query == "" {
+		panic(server.EBadRequest(server.EC_PARAM_REQUIRED, "missing query", nil))
+	}
+
+	doc, err := Parse(args.Query)
+	if err != nil {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, err.Error(), nil))
+	}
+	op, err := selectOperation(doc, args.OperationName)
+	if err != nil {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, err.Error(), nil))
+	}
+	e, err := newExecutor(ctx, schema, doc, op, args.Variables)
+	if err != nil {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, err.Error(), nil))
+	}
+
+	cost, err := e.Cost(schema.Query, op.Selections)
+	if err != nil {
+		panic(server.EBadRequest(server.EC_PARAM_INVALID, err.Error(), nil))
+	}
+	log.Debugf("graphql: query cost %d", cost)
+	ctx.ChargeCost(cost)
+
+	res, err := e.Execute(schema.Query, []interface{}{nil}, op.Selections)
+	if err != nil {
+		var qerr *QueryError
+		if errors.As(err, &qerr) {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, qerr.Error(), nil))
+		}
+		panic(server.EInternal(server.EC_DATABASE, "graphql query failed", err))
+	}
+	return &QueryResponse{
+		Data:     res[0],
+		modified: ctx.Tip.BestTime,
+		expires:  ctx.Tip.BestTime.Add(ctx.Params.BlockTime()),
+	}, http.StatusOK
+}
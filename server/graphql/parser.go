@@ -0,0 +1,657 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL executable document. Only query operations
+// are supported, the index is read-only.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	Type       string
+	Name       string
+	Vars       []*VarDef
+	Selections []Selection
+}
+
+type VarDef struct {
+	Name    string
+	Type    string
+	Default Value
+}
+
+// Selection is one of *Field, *FragmentSpread or *InlineFragment
+type Selection interface{}
+
+type Field struct {
+	Alias      string
+	Name       string
+	Args       []*Argument
+	Directives []*Directive
+	Selections []Selection
+}
+
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type Argument struct {
+	Name  string
+	Value Value
+}
+
+type Directive struct {
+	Name string
+	Args []*Argument
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+type InlineFragment struct {
+	On         string
+	Directives []*Directive
+	Selections []Selection
+}
+
+type Fragment struct {
+	Name       string
+	On         string
+	Selections []Selection
+}
+
+// Value is a literal input value. Literals are decoded into Go types
+// int64, float64, string, bool, nil, Enum, Variable, []Value and
+// map[string]Value.
+type Value interface{}
+
+type Enum string
+
+type Variable string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+	tok token
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range l.src[:l.tok.pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("graphql: %s at line %d col %d", fmt.Sprintf(format, args...), line, col)
+}
+
+// next advances to the next token, skipping whitespace, commas and comments.
+func (l *lexer) next() error {
+skip:
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+			continue
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		case c == 0xEF && strings.HasPrefix(l.src[l.pos:], "\xEF\xBB\xBF"):
+			l.pos += 3
+			continue
+		}
+		break skip
+	}
+	start := l.pos
+	l.tok = token{pos: start}
+	if l.pos >= len(l.src) {
+		l.tok.kind = tokEOF
+		return nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		l.tok.kind, l.tok.val = tokPunct, string(c)
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			return l.errorf("unexpected '.'")
+		}
+		l.pos += 3
+		l.tok.kind, l.tok.val = tokPunct, "..."
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		l.tok.kind, l.tok.val = tokName, l.src[start:l.pos]
+	case c == '-' || isDigit(c):
+		l.pos++
+		l.tok.kind = tokInt
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			switch {
+			case isDigit(c):
+			case c == '.' || c == 'e' || c == 'E':
+				l.tok.kind = tokFloat
+			case (c == '+' || c == '-') && l.tok.kind == tokFloat:
+			default:
+				l.tok.val = l.src[start:l.pos]
+				return nil
+			}
+			l.pos++
+		}
+		l.tok.val = l.src[start:l.pos]
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			end := strings.Index(l.src[l.pos+3:], `"""`)
+			if end < 0 {
+				return l.errorf("unterminated block string")
+			}
+			l.tok.kind, l.tok.val = tokString, strings.TrimSpace(l.src[l.pos+3:l.pos+3+end])
+			l.pos += end + 6
+			return nil
+		}
+		var b strings.Builder
+		l.pos++
+		for {
+			if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+				return l.errorf("unterminated string")
+			}
+			c := l.src[l.pos]
+			if c == '"' {
+				l.pos++
+				break
+			}
+			if c != '\\' {
+				r, n := utf8.DecodeRuneInString(l.src[l.pos:])
+				b.WriteRune(r)
+				l.pos += n
+				continue
+			}
+			if l.pos+1 >= len(l.src) {
+				return l.errorf("unterminated string")
+			}
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+5 > len(l.src) {
+					return l.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(l.src[l.pos+1:l.pos+5], 16, 32)
+				if err != nil {
+					return l.errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return l.errorf("invalid escape sequence '\\%c'", e)
+			}
+			l.pos++
+		}
+		l.tok.kind, l.tok.val = tokString, b.String()
+	default:
+		return l.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type parser struct {
+	lexer
+}
+
+// Parse parses a GraphQL executable document.
+func Parse(src string) (*Document, error) {
+	p := &parser{lexer{src: src}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		Fragments: make(map[string]*Fragment),
+	}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			sel, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: sel})
+		case p.tok.kind == tokName && p.tok.val == "fragment":
+			frag, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[frag.Name]; ok {
+				return nil, p.errorf("duplicate fragment %q", frag.Name)
+			}
+			doc.Fragments[frag.Name] = frag
+		case p.tok.kind == tokName:
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		default:
+			return nil, p.errorf("unexpected %q", p.tok.val)
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("graphql: document contains no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.next()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %q, found end of document", punct)
+		}
+		return p.errorf("expected %q, found %q", punct, p.tok.val)
+	}
+	return p.next()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.errorf("expected name, found %q", p.tok.val)
+	}
+	n := p.tok.val
+	return n, p.next()
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: p.tok.val}
+	switch op.Type {
+	case "query":
+	case "mutation", "subscription":
+		return nil, p.errorf("%s operations are not supported", op.Type)
+	default:
+		return nil, p.errorf("unexpected %q", op.Type)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.Name = p.tok.val
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			v, err := p.parseVarDef()
+			if err != nil {
+				return nil, err
+			}
+			op.Vars = append(op.Vars, v)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = sel
+	return op, nil
+}
+
+func (p *parser) parseVarDef() (*VarDef, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	n, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	v := &VarDef{Name: n, Type: typ}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		if v.Default, err = p.parseValue(true); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (p *parser) parseType() (string, error) {
+	var typ string
+	if ok, err := p.skip("["); err != nil {
+		return "", err
+	} else if ok {
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		if typ, err = p.name(); err != nil {
+			return "", err
+		}
+	}
+	if ok, err := p.skip("!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	n, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokName || p.tok.val != "on" {
+		return nil, p.errorf("expected 'on'")
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: n, On: on, Selections: sel}, nil
+}
+
+func (p *parser) parseSelectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sel []Selection
+	for !p.peek("}") {
+		if p.tok.kind == tokEOF {
+			return nil, p.errorf("unterminated selection set")
+		}
+		s, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+	}
+	if len(sel) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return sel, p.next()
+}
+
+func (p *parser) parseSelection() (Selection, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokName && p.tok.val != "on" {
+			spread := &FragmentSpread{Name: p.tok.val}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if spread.Directives, err = p.parseDirectives(); err != nil {
+				return nil, err
+			}
+			return spread, nil
+		}
+		frag := &InlineFragment{}
+		if p.tok.kind == tokName {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if frag.On, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if frag.Directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		if frag.Selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+		return frag, nil
+	}
+
+	f := &Field{}
+	n, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.Alias = n
+		if n, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	f.Name = n
+	if f.Args, err = p.parseArguments(false); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.Selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseArguments(isConst bool) ([]*Argument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var args []*Argument
+	for !p.peek(")") {
+		n, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.parseValue(isConst)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &Argument{Name: n, Value: v})
+	}
+	return args, p.next()
+}
+
+func (p *parser) parseDirectives() ([]*Directive, error) {
+	var dirs []*Directive
+	for p.peek("@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.parseArguments(false)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, &Directive{Name: n, Args: args})
+	}
+	return dirs, nil
+}
+
+func (p *parser) parseValue(isConst bool) (Value, error) {
+	t := p.tok
+	switch t.kind {
+	case tokPunct:
+		switch t.val {
+		case "$":
+			if isConst {
+				return nil, p.errorf("unexpected variable in constant value")
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			n, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			return Variable(n), nil
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			list := make([]Value, 0)
+			for !p.peek("]") {
+				if p.tok.kind == tokEOF {
+					return nil, p.errorf("unterminated list")
+				}
+				v, err := p.parseValue(isConst)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, p.next()
+		case "{":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			obj := make(map[string]Value)
+			for !p.peek("}") {
+				n, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[n], err = p.parseValue(isConst); err != nil {
+					return nil, err
+				}
+			}
+			return obj, p.next()
+		}
+	case tokInt:
+		i, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid int %q", t.val)
+		}
+		return i, p.next()
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float %q", t.val)
+		}
+		return f, p.next()
+	case tokString:
+		return t.val, p.next()
+	case tokName:
+		var v Value
+		switch t.val {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = Enum(t.val)
+		}
+		return v, p.next()
+	}
+	return nil, p.errorf("unexpected %q", t.val)
+}
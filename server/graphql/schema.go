@@ -0,0 +1,275 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"blockwatch.cc/tzindex/server"
+)
+
+// Built-in scalar type names
+const (
+	Int     = "Int"
+	Float   = "Float"
+	String  = "String"
+	Boolean = "Boolean"
+)
+
+func isScalar(name string) bool {
+	switch name {
+	case Int, Float, String, Boolean:
+		return true
+	}
+	return false
+}
+
+// Type references a scalar or object type, optionally as list.
+type Type struct {
+	Name    string
+	List    bool
+	NonNull bool
+}
+
+func (t Type) String() string {
+	s := t.Name
+	if t.List {
+		s = "[" + s + "!]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+func (t Type) IsScalar() bool {
+	return isScalar(t.Name)
+}
+
+type Arg struct {
+	Name    string
+	Type    Type
+	Default Value
+}
+
+// Resolver resolves a field for a batch of parent objects at once and returns
+// one result per parent. This lets relations look up all referenced objects
+// with a single query. List fields return a []interface{} per parent.
+type Resolver func(ctx *server.Context, parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+type FieldDef struct {
+	Name    string
+	Type    Type
+	Args    []*Arg
+	Resolve Resolver
+	Cost    int64 // cost of resolving this field (lists: per request, not per item)
+}
+
+func (f *FieldDef) Arg(name string) *Arg {
+	for _, v := range f.Args {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+type Object struct {
+	Name   string
+	Fields map[string]*FieldDef
+	order  []string
+}
+
+func NewObject(name string) *Object {
+	return &Object{
+		Name:   name,
+		Fields: make(map[string]*FieldDef),
+	}
+}
+
+// AddField adds or replaces a field definition.
+func (o *Object) AddField(f *FieldDef) *Object {
+	if _, ok := o.Fields[f.Name]; !ok {
+		o.order = append(o.order, f.Name)
+	}
+	o.Fields[f.Name] = f
+	return o
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// ObjectFromModel generates an object type with one scalar field per JSON
+// tagged struct field of model. Types implementing encoding.TextMarshaler
+// (addresses, hashes, enums, time) render as String and byte slices as hex.
+// Nested structs, maps and other slices are skipped.
+func ObjectFromModel(name string, model interface{}) *Object {
+	obj := NewObject(name)
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if tag == "-" || tag == "" {
+			continue
+		}
+		scalar, conv := scalarOf(sf.Type)
+		if conv == nil {
+			continue
+		}
+		idx := i
+		obj.AddField(&FieldDef{
+			Name: tag,
+			Type: Type{Name: scalar},
+			Resolve: func(_ *server.Context, parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+				res := make([]interface{}, len(parents))
+				for i, p := range parents {
+					v := reflect.ValueOf(p)
+					if v.Kind() == reflect.Ptr {
+						if v.IsNil() {
+							continue
+						}
+						v = v.Elem()
+					}
+					res[i] = conv(v.Field(idx))
+				}
+				return res, nil
+			},
+		})
+	}
+	return obj
+}
+
+func scalarOf(t reflect.Type) (string, func(reflect.Value) interface{}) {
+	switch {
+	case t.Implements(textMarshalerType), reflect.PtrTo(t).Implements(textMarshalerType):
+		return String, func(v reflect.Value) interface{} {
+			var m encoding.TextMarshaler
+			if v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+				m = v.Addr().Interface().(encoding.TextMarshaler)
+			} else if t.Implements(textMarshalerType) {
+				m = v.Interface().(encoding.TextMarshaler)
+			} else {
+				return nil
+			}
+			buf, err := m.MarshalText()
+			if err != nil || len(buf) == 0 {
+				return nil
+			}
+			return string(buf)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean, func(v reflect.Value) interface{} { return v.Bool() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int, func(v reflect.Value) interface{} { return v.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Int, func(v reflect.Value) interface{} { return v.Uint() }
+	case reflect.Float32, reflect.Float64:
+		return Float, func(v reflect.Value) interface{} { return v.Float() }
+	case reflect.String:
+		return String, func(v reflect.Value) interface{} { return v.String() }
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return String, func(v reflect.Value) interface{} {
+				if v.Len() == 0 {
+					return nil
+				}
+				return hex.EncodeToString(v.Bytes())
+			}
+		}
+	}
+	return "", nil
+}
+
+type Schema struct {
+	Query *Object
+	Types map[string]*Object
+}
+
+func NewSchema() *Schema {
+	return &Schema{
+		Query: NewObject("Query"),
+		Types: make(map[string]*Object),
+	}
+}
+
+func (s *Schema) AddType(o *Object) *Object {
+	s.Types[o.Name] = o
+	return o
+}
+
+// Check validates that all field types are defined.
+func (s *Schema) Check() error {
+	objs := []*Object{s.Query}
+	for _, v := range s.Types {
+		objs = append(objs, v)
+	}
+	for _, o := range objs {
+		for _, f := range o.Fields {
+			if f.Resolve == nil {
+				return fmt.Errorf("graphql: missing resolver for %s.%s", o.Name, f.Name)
+			}
+			if f.Type.IsScalar() {
+				continue
+			}
+			if _, ok := s.Types[f.Type.Name]; !ok {
+				return fmt.Errorf("graphql: undefined type %s for %s.%s", f.Type.Name, o.Name, f.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// SDL renders the schema in GraphQL schema definition language.
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	writeObject(&b, s.Query)
+	names := make([]string, 0, len(s.Types))
+	for n := range s.Types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		b.WriteByte('\n')
+		writeObject(&b, s.Types[n])
+	}
+	return b.String()
+}
+
+func writeObject(b *strings.Builder, o *Object) {
+	fmt.Fprintf(b, "type %s {\n", o.Name)
+	for _, n := range o.order {
+		f := o.Fields[n]
+		b.WriteString("  ")
+		b.WriteString(f.Name)
+		if len(f.Args) > 0 {
+			args := make([]string, 0, len(f.Args))
+			for _, a := range f.Args {
+				s := a.Name + ": " + a.Type.String()
+				if a.Default != nil {
+					if str, ok := a.Default.(string); ok {
+						s += fmt.Sprintf(" = %q", str)
+					} else {
+						s += fmt.Sprintf(" = %v", a.Default)
+					}
+				}
+				args = append(args, s)
+			}
+			b.WriteString("(" + strings.Join(args, ", ") + ")")
+		}
+		b.WriteString(": " + f.Type.String() + "\n")
+	}
+	b.WriteString("}\n")
+}
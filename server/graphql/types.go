@@ -0,0 +1,457 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graphql
+
+import (
+	"errors"
+	"sort"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+// field costs
+const (
+	costLookup = 1  // single row lookup
+	costList   = 10 // list query, children are charged per item
+)
+
+var listArgs = []*Arg{
+	{Name: "limit", Type: Type{Name: Int}},
+	{Name: "cursor", Type: Type{Name: Int}},
+	{Name: "order", Type: Type{Name: String}, Default: "desc"},
+}
+
+// listRequest translates list arguments into an indexer list request
+// using the same semantics as the table API (cursor is a row id).
+func listRequest(ctx *server.Context, args map[string]interface{}) (etl.ListRequest, error) {
+	r := etl.ListRequest{
+		Order: pack.OrderDesc,
+	}
+	if v, ok := args["limit"].(int64); ok && v > 0 {
+		r.Limit = uint(v)
+	}
+	r.Limit = ctx.Cfg.ClampExplore(r.Limit)
+	if v, ok := args["cursor"].(int64); ok && v > 0 {
+		r.Cursor = uint64(v)
+	}
+	if v, ok := args["order"].(string); ok {
+		o, err := pack.ParseOrderType(v)
+		if err != nil {
+			return r, errorf("invalid order %q", v)
+		}
+		r.Order = o
+	}
+	return r, nil
+}
+
+func isNotFound(err error) bool {
+	switch {
+	case errors.Is(err, index.ErrNoAccountEntry),
+		errors.Is(err, index.ErrNoContractEntry),
+		errors.Is(err, index.ErrNoBlockEntry),
+		errors.Is(err, index.ErrNoOpEntry),
+		errors.Is(err, index.ErrNoBigmapAlloc),
+		errors.Is(err, etl.ErrInvalidHash):
+		return true
+	}
+	return false
+}
+
+// lookupAccounts loads all referenced accounts with a single query.
+func lookupAccounts(ctx *server.Context, ids []model.AccountID) (map[model.AccountID]*model.Account, error) {
+	uniq := make([]uint64, 0, len(ids))
+	seen := make(map[model.AccountID]struct{})
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		uniq = append(uniq, id.Value())
+	}
+	res := make(map[model.AccountID]*model.Account, len(uniq))
+	if len(uniq) == 0 {
+		return res, nil
+	}
+	sort.Slice(uniq, func(i, j int) bool { return uniq[i] < uniq[j] })
+	accs, err := ctx.Indexer.LookupAccountIds(ctx, uniq)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	for _, v := range accs {
+		res[v.RowId] = v
+	}
+	return res, nil
+}
+
+// accountRef builds a resolver for an account id field on parent objects.
+func accountRef(id func(interface{}) model.AccountID) Resolver {
+	return func(ctx *server.Context, parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+		ids := make([]model.AccountID, len(parents))
+		for i, p := range parents {
+			ids[i] = id(p)
+		}
+		accs, err := lookupAccounts(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]interface{}, len(parents))
+		for i, id := range ids {
+			if acc, ok := accs[id]; ok {
+				res[i] = acc
+			}
+		}
+		return res, nil
+	}
+}
+
+// each resolves a field for every parent separately.
+func each(fn func(ctx *server.Context, parent interface{}, args map[string]interface{}) (interface{}, error)) Resolver {
+	return func(ctx *server.Context, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		res := make([]interface{}, len(parents))
+		for i, p := range parents {
+			v, err := fn(ctx, p, args)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = v
+		}
+		return res, nil
+	}
+}
+
+func opList(ops []*model.Op) []interface{} {
+	res := make([]interface{}, len(ops))
+	for i := range ops {
+		res[i] = ops[i]
+	}
+	return res
+}
+
+// NewTzSchema builds the indexer schema. Object fields are generated from
+// model types, relations are resolved through indexer queries.
+func NewTzSchema() *Schema {
+	s := NewSchema()
+	account := s.AddType(ObjectFromModel("Account", model.Account{}))
+	contract := s.AddType(ObjectFromModel("Contract", model.Contract{}))
+	op := s.AddType(ObjectFromModel("Op", model.Op{}))
+	block := s.AddType(ObjectFromModel("Block", model.Block{}))
+	bigmap := s.AddType(ObjectFromModel("Bigmap", model.BigmapAlloc{}))
+	s.AddType(ObjectFromModel("BigmapKey", model.BigmapKV{}))
+
+	// root fields
+	s.Query.AddField(&FieldDef{
+		Name: "account",
+		Type: Type{Name: "Account"},
+		Args: []*Arg{{Name: "address", Type: Type{Name: String, NonNull: true}}},
+		Cost: costLookup,
+		Resolve: each(func(ctx *server.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			addr, err := tezos.ParseAddress(args["address"].(string))
+			if err != nil {
+				return nil, errorf("invalid address")
+			}
+			acc, err := ctx.Indexer.LookupAccount(ctx, addr)
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return acc, nil
+		}),
+	})
+	s.Query.AddField(&FieldDef{
+		Name: "contract",
+		Type: Type{Name: "Contract"},
+		Args: []*Arg{{Name: "address", Type: Type{Name: String, NonNull: true}}},
+		Cost: costLookup,
+		Resolve: each(func(ctx *server.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			addr, err := tezos.ParseAddress(args["address"].(string))
+			if err != nil {
+				return nil, errorf("invalid address")
+			}
+			cc, err := ctx.Indexer.LookupContract(ctx, addr)
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return cc, nil
+		}),
+	})
+	s.Query.AddField(&FieldDef{
+		Name: "block",
+		Type: Type{Name: "Block"},
+		Args: []*Arg{{Name: "id", Type: Type{Name: String, NonNull: true}}},
+		Cost: costLookup,
+		Resolve: each(func(ctx *server.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			b, err := ctx.Indexer.LookupBlock(ctx, args["id"].(string))
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return b, nil
+		}),
+	})
+	s.Query.AddField(&FieldDef{
+		Name: "op",
+		Type: Type{Name: "Op", List: true},
+		Args: []*Arg{{Name: "hash", Type: Type{Name: String, NonNull: true}}},
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			ops, err := ctx.Indexer.LookupOp(ctx, args["hash"].(string))
+			if err != nil {
+				if isNotFound(err) || errors.Is(err, index.ErrInvalidOpID) {
+					return []interface{}{}, nil
+				}
+				return nil, err
+			}
+			return opList(ops), nil
+		}),
+	})
+	s.Query.AddField(&FieldDef{
+		Name: "bigmap",
+		Type: Type{Name: "Bigmap"},
+		Args: []*Arg{{Name: "id", Type: Type{Name: Int, NonNull: true}}},
+		Cost: costLookup,
+		Resolve: each(func(ctx *server.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			alloc, err := ctx.Indexer.LookupBigmapAlloc(ctx, args["id"].(int64))
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return alloc, nil
+		}),
+	})
+
+	// account relations
+	account.AddField(&FieldDef{
+		Name:    "baker",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Account).BakerId }),
+	})
+	account.AddField(&FieldDef{
+		Name:    "creator",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Account).CreatorId }),
+	})
+	account.AddField(&FieldDef{
+		Name: "contract",
+		Type: Type{Name: "Contract"},
+		Cost: costLookup,
+		Resolve: each(func(ctx *server.Context, p interface{}, _ map[string]interface{}) (interface{}, error) {
+			acc := p.(*model.Account)
+			if !acc.IsContract {
+				return nil, nil
+			}
+			cc, err := ctx.Indexer.LookupContractId(ctx, acc.RowId)
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return cc, nil
+		}),
+	})
+	account.AddField(&FieldDef{
+		Name: "operations",
+		Type: Type{Name: "Op", List: true},
+		Args: listArgs,
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, p interface{}, args map[string]interface{}) (interface{}, error) {
+			r, err := listRequest(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			r.Account = p.(*model.Account)
+			ops, err := ctx.Indexer.ListAccountOps(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			return opList(ops), nil
+		}),
+	})
+
+	// contract relations
+	contract.AddField(&FieldDef{
+		Name:    "account",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Contract).AccountId }),
+	})
+	contract.AddField(&FieldDef{
+		Name:    "creator",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Contract).CreatorId }),
+	})
+	contract.AddField(&FieldDef{
+		Name: "calls",
+		Type: Type{Name: "Op", List: true},
+		Args: listArgs,
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, p interface{}, args map[string]interface{}) (interface{}, error) {
+			r, err := listRequest(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			acc, err := ctx.Indexer.LookupAccountId(ctx, p.(*model.Contract).AccountId)
+			if err != nil {
+				return nil, err
+			}
+			r.Account = acc
+			ops, err := ctx.Indexer.ListContractCalls(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			return opList(ops), nil
+		}),
+	})
+	contract.AddField(&FieldDef{
+		Name: "bigmaps",
+		Type: Type{Name: "Bigmap", List: true},
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, p interface{}, _ map[string]interface{}) (interface{}, error) {
+			allocs, err := ctx.Indexer.ListContractBigmaps(ctx, p.(*model.Contract).AccountId, 0)
+			if err != nil {
+				return nil, err
+			}
+			res := make([]interface{}, len(allocs))
+			for i := range allocs {
+				res[i] = allocs[i]
+			}
+			return res, nil
+		}),
+	})
+
+	// op relations
+	for name, id := range map[string]func(interface{}) model.AccountID{
+		"sender":   func(p interface{}) model.AccountID { return p.(*model.Op).SenderId },
+		"receiver": func(p interface{}) model.AccountID { return p.(*model.Op).ReceiverId },
+		"creator":  func(p interface{}) model.AccountID { return p.(*model.Op).CreatorId },
+		"baker":    func(p interface{}) model.AccountID { return p.(*model.Op).BakerId },
+	} {
+		op.AddField(&FieldDef{
+			Name:    name,
+			Type:    Type{Name: "Account"},
+			Cost:    costLookup,
+			Resolve: accountRef(id),
+		})
+	}
+	op.AddField(&FieldDef{
+		Name: "block",
+		Type: Type{Name: "Block"},
+		Cost: costLookup,
+		Resolve: func(ctx *server.Context, parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+			blocks := make(map[int64]*model.Block)
+			res := make([]interface{}, len(parents))
+			for i, p := range parents {
+				height := p.(*model.Op).Height
+				b, ok := blocks[height]
+				if !ok {
+					var err error
+					b, err = ctx.Indexer.BlockByHeight(ctx, height)
+					if err != nil && !isNotFound(err) {
+						return nil, err
+					}
+					blocks[height] = b
+				}
+				if b != nil {
+					res[i] = b
+				}
+			}
+			return res, nil
+		},
+	})
+
+	// block relations
+	block.AddField(&FieldDef{
+		Name:    "baker",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Block).BakerId }),
+	})
+	block.AddField(&FieldDef{
+		Name:    "proposer",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.Block).ProposerId }),
+	})
+	block.AddField(&FieldDef{
+		Name: "ops",
+		Type: Type{Name: "Op", List: true},
+		Args: listArgs,
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, p interface{}, args map[string]interface{}) (interface{}, error) {
+			r, err := listRequest(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			b := p.(*model.Block)
+			r.Since = b.Height
+			if r.Cursor > 0 {
+				// block op lists page by op_n, translate the row id cursor
+				ops, err := ctx.Indexer.LookupOpIds(ctx, []uint64{r.Cursor})
+				if err != nil {
+					if isNotFound(err) {
+						return []interface{}{}, nil
+					}
+					return nil, err
+				}
+				r.Cursor = uint64(b.Height<<16) | uint64(ops[0].OpN)
+			}
+			ops, err := ctx.Indexer.ListBlockOps(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			return opList(ops), nil
+		}),
+	})
+
+	// bigmap relations
+	bigmap.AddField(&FieldDef{
+		Name:    "contract",
+		Type:    Type{Name: "Account"},
+		Cost:    costLookup,
+		Resolve: accountRef(func(p interface{}) model.AccountID { return p.(*model.BigmapAlloc).AccountId }),
+	})
+	bigmap.AddField(&FieldDef{
+		Name: "keys",
+		Type: Type{Name: "BigmapKey", List: true},
+		Args: listArgs,
+		Cost: costList,
+		Resolve: each(func(ctx *server.Context, p interface{}, args map[string]interface{}) (interface{}, error) {
+			r, err := listRequest(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			r.BigmapId = p.(*model.BigmapAlloc).BigmapId
+			items, err := ctx.Indexer.ListBigmapKeys(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			res := make([]interface{}, len(items))
+			for i := range items {
+				res[i] = items[i]
+			}
+			return res, nil
+		}),
+	})
+	return s
+}
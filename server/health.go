@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"blockwatch.cc/tzindex/etl"
+)
+
+// HealthCheck is the result of a single readiness condition.
+type HealthCheck struct {
+	Ok      bool        `json:"ok"`
+	Value   interface{} `json:"value,omitempty"`
+	Limit   interface{} `json:"limit,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Skipped bool        `json:"skipped,omitempty"`
+}
+
+// HealthStatus is returned by liveness and readiness endpoints.
+type HealthStatus struct {
+	Ok     bool                   `json:"ok"`
+	Status etl.State              `json:"status"`
+	Mode   etl.Mode               `json:"mode"`
+	Height int64                  `json:"height"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthLive reports whether the process is running and the indexer has not
+// failed. It is served directly without going through the request queue.
+func HealthLive(w http.ResponseWriter, r *http.Request) {
+	status := srv.cfg.Crawler.Status()
+	resp := HealthStatus{
+		Ok:     status.Status != etl.STATE_FAILED && !srv.IsShutdown(),
+		Status: status.Status,
+		Mode:   status.Mode,
+		Height: status.Indexed,
+	}
+	writeHealth(w, resp)
+}
+
+// HealthReady reports whether the server should receive traffic. Each check
+// is listed with its current value and configured limit.
+func HealthReady(w http.ResponseWriter, r *http.Request) {
+	cfg := srv.cfg.Health
+	status := srv.cfg.Crawler.Status()
+	resp := HealthStatus{
+		Ok:     true,
+		Status: status.Status,
+		Mode:   status.Mode,
+		Height: status.Indexed,
+		Checks: make(map[string]HealthCheck),
+	}
+	add := func(name string, c HealthCheck) {
+		resp.Checks[name] = c
+		resp.Ok = resp.Ok && c.Ok
+	}
+
+	// a stopped crawler serves the database as is
+	indexing := status.Status != etl.STATE_STOPPED
+
+	// server state
+	add("server", HealthCheck{
+		Ok:    !srv.IsShutdown() && !srv.IsOffline(),
+		Value: !srv.IsShutdown() && !srv.IsOffline(),
+	})
+
+	// crawler state
+	add("state", HealthCheck{
+		Ok:      !indexing || status.Status == etl.STATE_SYNCHRONIZED,
+		Value:   status.Status,
+		Limit:   etl.STATE_SYNCHRONIZED,
+		Skipped: !indexing,
+	})
+
+	// block lag against node head
+	lag := HealthCheck{Ok: true, Skipped: true}
+	if indexing && cfg.MaxBlockLag > 0 && status.Blocks >= 0 {
+		n := status.Blocks - status.Indexed
+		lag = HealthCheck{Ok: n <= cfg.MaxBlockLag, Value: n, Limit: cfg.MaxBlockLag}
+	}
+	add("block_lag", lag)
+
+	// time since last block
+	age := HealthCheck{Ok: true, Skipped: true}
+	if indexing && cfg.MaxBlockAge > 0 {
+		d := time.Since(srv.cfg.Crawler.Time()).Truncate(time.Second)
+		age = HealthCheck{Ok: d <= cfg.MaxBlockAge, Value: d.String(), Limit: cfg.MaxBlockAge.String()}
+	}
+	add("block_age", age)
+
+	// node reachability
+	node := HealthCheck{Ok: true, Skipped: true}
+	if srv.cfg.Client != nil && cfg.RpcTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RpcTimeout)
+		start := time.Now()
+		_, err := srv.cfg.Client.GetTipHeader(ctx)
+		cancel()
+		node = HealthCheck{Ok: err == nil, Value: time.Since(start).Truncate(time.Millisecond).String(), Limit: cfg.RpcTimeout.String()}
+		if err != nil {
+			node.Message = err.Error()
+		}
+	}
+	add("rpc", node)
+
+	// request queue saturation
+	queue := HealthCheck{Ok: true, Skipped: true}
+	if cfg.MaxQueueRatio > 0 && cap(jobQueue) > 0 {
+		ratio := float64(len(jobQueue)) / float64(cap(jobQueue))
+		queue = HealthCheck{Ok: ratio <= cfg.MaxQueueRatio, Value: ratio, Limit: cfg.MaxQueueRatio}
+	}
+	add("queue", queue)
+
+	// free disk space
+	disk := HealthCheck{Ok: true, Skipped: true}
+	if cfg.MinDiskFree > 0 && cfg.DiskPath != "" {
+		free, err := diskFree(cfg.DiskPath)
+		if err == nil {
+			disk = HealthCheck{Ok: free >= cfg.MinDiskFree, Value: free, Limit: cfg.MinDiskFree}
+		} else {
+			disk.Message = err.Error()
+		}
+	}
+	add("disk", disk)
+
+	writeHealth(w, resp)
+}
+
+func writeHealth(w http.ResponseWriter, resp HealthStatus) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ApiDoc describes a single route for the OpenAPI specification.
+type ApiDoc struct {
+	Path     string      // concrete path, defaults to the route template
+	Summary  string      // short description
+	Args     interface{} // request argument struct with schema tags
+	Response interface{} // response value or type, nil for generic JSON
+	Columns  []string    // column names accepted by the columns argument
+	Filters  []string    // column names accepted as query filters
+}
+
+// Describer is optionally implemented by RESTful models to document their
+// routes. It is called for every route below the model's REST prefix with
+// the method and full path template and may return multiple concrete routes
+// (e.g. one per table). Routes without docs are listed with path parameters
+// only.
+type Describer interface {
+	RESTDescribe(ctx *Context, method, path string) []ApiDoc
+}
+
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*JSONSchema `json:"schemas"`
+}
+
+type Operation struct {
+	OperationId string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Explode     *bool       `json:"explode,omitempty"`
+	Schema      *JSONSchema `json:"schema"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *JSONSchema `json:"schema"`
+}
+
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	openapiOnce sync.Once
+	openapiSpec *OpenAPI
+
+	routeVarRegexp = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+	timeType            = reflect.TypeOf(time.Time{})
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// OpenAPI returns the API specification generated from the registered routes.
+// Routes and enabled indexes are static after startup, so the spec is built
+// only once.
+func (s *RestServer) OpenAPI(ctx *Context) *OpenAPI {
+	openapiOnce.Do(func() {
+		openapiSpec = buildOpenAPI(ctx, s.router)
+	})
+	return openapiSpec
+}
+
+func buildOpenAPI(ctx *Context, router *mux.Router) *OpenAPI {
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "TzIndex API",
+			Version: ApiVersion,
+		},
+		Paths: make(map[string]map[string]*Operation),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]*JSONSchema),
+		},
+	}
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || tpl == "/" || strings.HasPrefix(tpl, "/debug") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if method == "OPTIONS" {
+				continue
+			}
+			var docs []ApiDoc
+			if d, ok := findModel(tpl).(Describer); ok {
+				docs = d.RESTDescribe(ctx, method, tpl)
+			}
+			if len(docs) == 0 {
+				docs = []ApiDoc{{}}
+			}
+			for _, doc := range docs {
+				spec.addOperation(method, tpl, doc)
+			}
+		}
+		return nil
+	})
+	return spec
+}
+
+// findModel returns the model with the longest REST prefix matching path.
+func findModel(path string) RESTful {
+	var (
+		best RESTful
+		n    int
+	)
+	for prefix, m := range models {
+		if strings.HasPrefix(path, prefix) && len(prefix) > n {
+			best, n = m, len(prefix)
+		}
+	}
+	return best
+}
+
+func (s *OpenAPI) addOperation(method, tpl string, doc ApiDoc) {
+	path := doc.Path
+	if path == "" {
+		path = tpl
+	}
+	path = routeVarRegexp.ReplaceAllString(path, "{$1}")
+	op := &Operation{
+		OperationId: operationId(method, path),
+		Summary:     doc.Summary,
+		Responses:   make(map[string]*Response),
+	}
+	if fields := strings.Split(strings.TrimPrefix(path, "/"), "/"); len(fields) > 0 {
+		op.Tags = []string{strings.Split(fields[0], ".")[0]}
+	}
+
+	// path parameters
+	for _, m := range routeVarRegexp.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &JSONSchema{Type: "string"},
+		})
+	}
+
+	// query parameters
+	if doc.Args != nil {
+		op.Parameters = append(op.Parameters, queryParams(reflect.TypeOf(doc.Args), doc.Columns)...)
+	}
+	for _, c := range doc.Filters {
+		if hasParam(op.Parameters, c) {
+			continue
+		}
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        c,
+			In:          "query",
+			Description: "filter by " + c + ", use " + c + ".{mode} for eq, ne, gt, gte, lt, lte, in, nin, rg, re",
+			Schema:      &JSONSchema{Type: "string"},
+		})
+	}
+
+	// response
+	resp := &Response{Description: "OK"}
+	if method == "PUT" || method == "DELETE" {
+		resp.Description = "No Content"
+		op.Responses["204"] = resp
+	} else {
+		schema := &JSONSchema{}
+		if doc.Response != nil {
+			schema = s.schemaOf(reflect.TypeOf(doc.Response))
+		}
+		resp.Content = map[string]*MediaType{
+			"application/json": {Schema: schema},
+		}
+		op.Responses["200"] = resp
+	}
+	op.Responses["default"] = &Response{
+		Description: "Error",
+		Content: map[string]*MediaType{
+			"application/json": {Schema: s.schemaOf(reflect.TypeOf(ErrorResponse{}))},
+		},
+	}
+
+	if _, ok := s.Paths[path]; !ok {
+		s.Paths[path] = make(map[string]*Operation)
+	}
+	s.Paths[path][strings.ToLower(method)] = op
+}
+
+func hasParam(params []*Parameter, name string) bool {
+	for _, p := range params {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func operationId(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, r := range path {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			if upper {
+				b.WriteString(strings.ToUpper(string(r)))
+				upper = false
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+// queryParams lists query parameters from schema tags on an argument struct.
+func queryParams(typ reflect.Type, columns []string) []*Parameter {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	params := make([]*Parameter, 0)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("schema"), ",")[0]
+		if f.Anonymous && tag == "" {
+			params = append(params, queryParams(f.Type, columns)...)
+			continue
+		}
+		if f.PkgPath != "" || tag == "" || tag == "-" {
+			continue
+		}
+		p := &Parameter{
+			Name:   tag,
+			In:     "query",
+			Schema: paramSchema(f.Type),
+		}
+		if p.Schema.Type == "array" {
+			explode := false
+			p.Explode = &explode
+			if tag == "columns" && len(columns) > 0 {
+				p.Schema.Items.Enum = columns
+			}
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func paramSchema(typ reflect.Type) *JSONSchema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return &JSONSchema{Type: "string"}
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice:
+		return &JSONSchema{Type: "array", Items: paramSchema(typ.Elem())}
+	}
+	return &JSONSchema{Type: "string"}
+}
+
+// schemaOf returns a JSON schema for typ. Named structs are added to the
+// component schemas and referenced. Types with custom JSON marshalers are
+// rendered as generic values since their encoding is unknown.
+func (s *OpenAPI) schemaOf(typ reflect.Type) *JSONSchema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case typ.Implements(jsonMarshalerType), reflect.PtrTo(typ).Implements(jsonMarshalerType):
+		return &JSONSchema{}
+	case typ.Implements(textMarshalerType), reflect.PtrTo(typ).Implements(textMarshalerType):
+		return &JSONSchema{Type: "string"}
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string"}
+		}
+		return &JSONSchema{Type: "array", Items: s.schemaOf(typ.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: s.schemaOf(typ.Elem())}
+	case reflect.Struct:
+		name := typ.Name()
+		if name == "" {
+			return s.structSchema(typ)
+		}
+		if pkg := typ.PkgPath(); pkg != "" {
+			name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+		}
+		if _, ok := s.Components.Schemas[name]; !ok {
+			// reserve the name to stop recursion
+			s.Components.Schemas[name] = &JSONSchema{Type: "object"}
+			s.Components.Schemas[name] = s.structSchema(typ)
+		}
+		return &JSONSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &JSONSchema{}
+}
+
+func (s *OpenAPI) structSchema(typ reflect.Type) *JSONSchema {
+	schema := &JSONSchema{
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, v := range s.structSchema(ft).Properties {
+					schema.Properties[n] = v
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		schema.Properties[tag] = s.schemaOf(f.Type)
+	}
+	return schema
+}
@@ -27,6 +27,7 @@ type Options interface {
 	WithRights() bool
 	WithMerge() bool
 	WithStorage() bool
+	WithStorageDiff() bool
 }
 
 type Resource interface {
@@ -62,6 +63,10 @@ func NewRouter() *mux.Router {
 		}
 	}
 
+	// register health checks directly (i.e. without going through dispatcher)
+	router.HandleFunc("/health/live", HealthLive).Methods("GET", "HEAD")
+	router.HandleFunc("/health/ready", HealthReady).Methods("GET", "HEAD")
+
 	// register debug routes directly (i.e. without going through dispatcher)
 	router.HandleFunc("/debug/pprof/", pprof.Index)
 	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
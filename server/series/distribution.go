@@ -0,0 +1,280 @@
+// Copyright (c) 2020-2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package series
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+var (
+	// all series field names as list
+	distributionSeriesNames util.StringList
+)
+
+func init() {
+	fields, err := pack.Fields(&model.Distribution{})
+	if err != nil {
+		log.Fatalf("distribution field type error: %v\n", err)
+	}
+	// strip row_id (first field) and unnamed data fields
+	for _, v := range fields.Aliases()[1:] {
+		if v != "" {
+			distributionSeriesNames.AddUnique(v)
+		}
+	}
+	distributionSeriesNames.AddUnique("count")
+}
+
+// configurable marshalling helper
+type DistributionSeries struct {
+	model.Distribution
+
+	columns util.StringList // cond. cols & order when brief
+	params  *tezos.Params   // blockchain amount conversion
+	verbose bool            // cond. marshal
+	null    bool
+}
+
+var _ SeriesBucket = (*DistributionSeries)(nil)
+
+func (s *DistributionSeries) Init(params *tezos.Params, columns []string, verbose bool) {
+	s.params = params
+	s.columns = columns
+	s.verbose = verbose
+}
+
+func (s *DistributionSeries) IsEmpty() bool {
+	return s.Distribution.Height == 0 || s.Distribution.Timestamp.IsZero()
+}
+
+func (s *DistributionSeries) Add(m SeriesModel) {
+	o := m.(*model.Distribution)
+	s.Distribution = *o
+}
+
+func (s *DistributionSeries) Reset() {
+	s.Distribution.Timestamp = time.Time{}
+	s.null = false
+}
+
+func (s *DistributionSeries) Null(ts time.Time) SeriesBucket {
+	s.Reset()
+	s.Timestamp = ts
+	s.null = true
+	return s
+}
+
+func (s *DistributionSeries) Zero(ts time.Time) SeriesBucket {
+	s.Reset()
+	s.Timestamp = ts
+	return s
+}
+
+func (s *DistributionSeries) SetTime(ts time.Time) SeriesBucket {
+	s.Timestamp = ts
+	return s
+}
+
+func (s *DistributionSeries) Time() time.Time {
+	return s.Timestamp
+}
+
+func (s *DistributionSeries) Clone() SeriesBucket {
+	c := &DistributionSeries{
+		Distribution: s.Distribution,
+	}
+	c.columns = s.columns
+	c.params = s.params
+	c.verbose = s.verbose
+	c.null = s.null
+	return c
+}
+
+func (s *DistributionSeries) Interpolate(m SeriesBucket, ts time.Time) SeriesBucket {
+	// unused, sematically there is one distribution entry per cycle
+	return s
+}
+
+func (s *DistributionSeries) MarshalJSON() ([]byte, error) {
+	if s.verbose {
+		return s.MarshalJSONVerbose()
+	} else {
+		return s.MarshalJSONBrief()
+	}
+}
+
+func (s *DistributionSeries) MarshalJSONVerbose() ([]byte, error) {
+	dist := struct {
+		Height    int64     `json:"height"`
+		Cycle     int64     `json:"cycle"`
+		Timestamp time.Time `json:"time"`
+		Count     int       `json:"count"`
+		NHolders  int64     `json:"n_holders"`
+		Total     float64   `json:"total"`
+		Gini      float64   `json:"gini"`
+		NDust     int64     `json:"n_dust"`
+		N1k       int64     `json:"n_1k"`
+		N10k      int64     `json:"n_10k"`
+		N100k     int64     `json:"n_100k"`
+		N1M       int64     `json:"n_1m"`
+		NWhale    int64     `json:"n_whale"`
+	}{
+		Height:    s.Height,
+		Cycle:     s.Cycle,
+		Timestamp: s.Timestamp,
+		Count:     1,
+		NHolders:  s.NHolders,
+		Total:     s.params.ConvertValue(s.Total),
+		Gini:      s.Gini,
+		NDust:     s.NDust,
+		N1k:       s.N1k,
+		N10k:      s.N10k,
+		N100k:     s.N100k,
+		N1M:       s.N1M,
+		NWhale:    s.NWhale,
+	}
+	return json.Marshal(dist)
+}
+
+func (s *DistributionSeries) MarshalJSONBrief() ([]byte, error) {
+	dec := s.params.Decimals
+	buf := make([]byte, 0, 512)
+	buf = append(buf, '[')
+	for i, v := range s.columns {
+		if s.null {
+			switch v {
+			case "time":
+				buf = strconv.AppendInt(buf, util.UnixMilliNonZero(s.Timestamp), 10)
+			default:
+				buf = append(buf, null...)
+			}
+		} else {
+			switch v {
+			case "height":
+				buf = strconv.AppendInt(buf, s.Height, 10)
+			case "cycle":
+				buf = strconv.AppendInt(buf, s.Cycle, 10)
+			case "time":
+				buf = strconv.AppendInt(buf, util.UnixMilliNonZero(s.Timestamp), 10)
+			case "count":
+				buf = strconv.AppendInt(buf, 1, 10)
+			case "n_holders":
+				buf = strconv.AppendInt(buf, s.NHolders, 10)
+			case "total":
+				buf = strconv.AppendFloat(buf, s.params.ConvertValue(s.Total), 'f', dec, 64)
+			case "gini":
+				buf = strconv.AppendFloat(buf, s.Gini, 'f', -1, 64)
+			case "n_dust":
+				buf = strconv.AppendInt(buf, s.NDust, 10)
+			case "n_1k":
+				buf = strconv.AppendInt(buf, s.N1k, 10)
+			case "n_10k":
+				buf = strconv.AppendInt(buf, s.N10k, 10)
+			case "n_100k":
+				buf = strconv.AppendInt(buf, s.N100k, 10)
+			case "n_1m":
+				buf = strconv.AppendInt(buf, s.N1M, 10)
+			case "n_whale":
+				buf = strconv.AppendInt(buf, s.NWhale, 10)
+			default:
+				continue
+			}
+		}
+		if i < len(s.columns)-1 {
+			buf = append(buf, ',')
+		}
+	}
+	buf = append(buf, ']')
+	return buf, nil
+}
+
+func (s *DistributionSeries) MarshalCSV() ([]string, error) {
+	dec := s.params.Decimals
+	res := make([]string, len(s.columns))
+	for i, v := range s.columns {
+		if s.null {
+			switch v {
+			case "time":
+				res[i] = strconv.Quote(s.Timestamp.Format(time.RFC3339))
+			default:
+				continue
+			}
+		}
+		switch v {
+		case "height":
+			res[i] = strconv.FormatInt(s.Height, 10)
+		case "cycle":
+			res[i] = strconv.FormatInt(s.Cycle, 10)
+		case "time":
+			res[i] = strconv.Quote(s.Timestamp.Format(time.RFC3339))
+		case "count":
+			res[i] = strconv.FormatInt(1, 10)
+		case "n_holders":
+			res[i] = strconv.FormatInt(s.NHolders, 10)
+		case "total":
+			res[i] = strconv.FormatFloat(s.params.ConvertValue(s.Total), 'f', dec, 64)
+		case "gini":
+			res[i] = strconv.FormatFloat(s.Gini, 'f', -1, 64)
+		case "n_dust":
+			res[i] = strconv.FormatInt(s.NDust, 10)
+		case "n_1k":
+			res[i] = strconv.FormatInt(s.N1k, 10)
+		case "n_10k":
+			res[i] = strconv.FormatInt(s.N10k, 10)
+		case "n_100k":
+			res[i] = strconv.FormatInt(s.N100k, 10)
+		case "n_1m":
+			res[i] = strconv.FormatInt(s.N1M, 10)
+		case "n_whale":
+			res[i] = strconv.FormatInt(s.NWhale, 10)
+		default:
+			continue
+		}
+	}
+	return res, nil
+}
+
+func (s *DistributionSeries) BuildQuery(ctx *server.Context, args *SeriesRequest) pack.Query {
+	// access table
+	table, err := ctx.Indexer.Table(args.Series)
+	if err != nil {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("cannot access table '%s'", args.Series), err))
+	}
+
+	// translate long column names to short names used in pack tables
+	var srcNames []string
+	// time is auto-added from parser
+	if len(args.Columns) == 1 {
+		// use all series columns
+		args.Columns = distributionSeriesNames
+	}
+	// resolve short column names
+	srcNames = make([]string, 0, len(args.Columns))
+	for _, v := range args.Columns {
+		// ignore count column
+		if v == "count" {
+			continue
+		}
+		// ignore non-series columns
+		if !distributionSeriesNames.Contains(v) {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid time-series column '%s'", v), nil))
+		}
+		srcNames = append(srcNames, v)
+	}
+
+	// build table query, no dynamic filter conditions
+	return pack.NewQuery(ctx.RequestID, table).
+		WithFields(srcNames...).
+		WithOrder(args.Order).
+		AndRange("time", args.From.Time(), args.To.Time())
+}
@@ -245,6 +245,7 @@ func init() {
 }
 
 var _ server.RESTful = (*SeriesRequest)(nil)
+var _ server.Describer = (*SeriesRequest)(nil)
 
 // build packdb query from request
 type SeriesRequest struct {
@@ -297,6 +298,34 @@ func (t SeriesRequest) RegisterRoutes(r *mux.Router) error {
 	return nil
 }
 
+// seriesColumns lists column names for all series served by StreamSeries.
+func seriesColumns() map[string][]string {
+	return map[string][]string{
+		"block":        blockSeriesNames,
+		"op":           opSeriesNames,
+		"flow":         flowSeriesNames,
+		"chain":        chainSeriesNames,
+		"supply":       supplySeriesNames,
+		"balance":      balanceSeriesNames,
+		"distribution": distributionSeriesNames,
+	}
+}
+
+// RESTDescribe documents one route per series with its columns.
+func (t SeriesRequest) RESTDescribe(_ *server.Context, method, path string) []server.ApiDoc {
+	docs := make([]server.ApiDoc, 0)
+	for name, cols := range seriesColumns() {
+		docs = append(docs, server.ApiDoc{
+			Path:     strings.Replace(path, "{series}", name, 1),
+			Summary:  "Stream " + name + " time-series",
+			Args:     SeriesRequest{},
+			Response: [][]interface{}{},
+			Columns:  cols,
+		})
+	}
+	return docs
+}
+
 func (r *SeriesRequest) Parse(ctx *server.Context) {
 	// prevent duplicate columns
 	if len(r.Columns) > 0 {
@@ -395,6 +424,11 @@ func StreamSeries(ctx *server.Context) (interface{}, int) {
 	case "supply":
 		args.bucket = &SupplySeries{}
 		args.model = &model.Supply{}
+	case "distribution":
+		// one snapshot per cycle
+		args.FillMode = FillModeLast
+		args.bucket = &DistributionSeries{}
+		args.model = &model.Distribution{}
 	case "balance":
 		args.FillMode = FillModeLast
 		args.bucket = &BalanceSeries{}
@@ -92,7 +92,7 @@ func (s *RestServer) SetOffline(off bool) {
 
 func (s *RestServer) Start() {
 	// run the server dispatcher
-	s.dispatcher = NewDispatcher(s.cfg.Http.MaxWorkers, s.cfg.Http.MaxQueue)
+	s.dispatcher = NewDispatcher(s.cfg.Http.MaxWorkers, s.cfg.Http.MaxQueue, s.cfg.Http.MaxQueryBudget, s.cfg.Http.MaxStreams)
 	s.dispatcher.Run()
 
 	go func() {
@@ -132,6 +132,28 @@ func C(f ApiCall) func(http.ResponseWriter, *http.Request) {
 	return wrapper(f)
 }
 
+// S wraps long-running streaming calls. Streams are served on the connection's
+// goroutine instead of a dispatcher worker so they cannot starve regular
+// requests. At most MaxStreams streams run concurrently, more are rejected
+// with 429.
+func S(f ApiCall) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		api := NewContext(ctx, r, w, f, srv)
+
+		select {
+		case streamSlots <- struct{}{}:
+			defer func() { <-streamSlots }()
+			api.serve()
+		default:
+			api.handleError(ETooManyRequests(EC_ACCESS_RATE_LIMITED, "too many concurrent streams", nil))
+		}
+		api.sendResponse()
+	}
+}
+
 func wrapper(f ApiCall) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var (
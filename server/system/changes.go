@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package system
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/cdc"
+	"blockwatch.cc/tzindex/server"
+)
+
+const maxChangesLimit = 50000
+
+type ChangesRequest struct {
+	Since  uint64          `schema:"since"`
+	After  tezos.BlockHash `schema:"after_block"`
+	Limit  uint            `schema:"limit"`
+	Follow bool            `schema:"follow"`
+}
+
+// GetChanges streams change records after sequence number `since` as
+// newline delimited JSON. With `follow=true` the stream stays open and
+// delivers new records as blocks are indexed until the server write timeout
+// elapses. The sequence number of the last record is returned as cursor
+// trailer to resume the stream. Instead of a sequence number, clients may
+// pass `after_block` to start after the change set of a block they already
+// hold. Positions removed by change log retention are reported as not found.
+func GetChanges(ctx *server.Context) (interface{}, int) {
+	changes := ctx.Indexer.Changes()
+	if changes == nil {
+		panic(server.EForbidden(server.EC_ACCESS_DENIED, "change capture disabled, set cdc.enable to enable", nil))
+	}
+	args := &ChangesRequest{}
+	ctx.ParseRequestArgs(args)
+	limit := int(args.Limit)
+	if limit <= 0 || limit > maxChangesLimit {
+		limit = maxChangesLimit
+	}
+
+	if args.After.IsValid() {
+		seq, err := changes.Find(args.After)
+		if err != nil {
+			panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "block not found in change log", err))
+		}
+		args.Since = seq
+	}
+	if args.Since+1 < changes.First() {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, "position is no longer retained in change log", cdc.ErrTrimmed))
+	}
+
+	// end follow streams before the server closes the connection so that
+	// clients receive the cursor trailer
+	var deadline <-chan time.Time
+	if args.Follow && ctx.Cfg.Http.WriteTimeout > 0 {
+		timer := time.NewTimer(ctx.Cfg.Http.WriteTimeout - time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	ctx.StreamResponseHeaders(http.StatusOK, "application/x-ndjson")
+	enc := json.NewEncoder(ctx.ResponseWriter)
+	enc.SetEscapeHTML(false)
+	flusher, _ := ctx.ResponseWriter.(http.Flusher)
+
+	var (
+		count int
+		err   error
+	)
+	seq := args.Since
+	for {
+		wait := changes.Wait()
+		n := 0
+		err = changes.Read(seq, limit-count, func(r cdc.Record) error {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			seq = r.Seq
+			n++
+			return nil
+		})
+		count += n
+		if err != nil || count >= limit || !args.Follow {
+			break
+		}
+		if flusher != nil && n > 0 {
+			flusher.Flush()
+		}
+		if n > 0 {
+			// keep reading until the deadline
+			select {
+			case <-deadline:
+			default:
+				continue
+			}
+			break
+		}
+		select {
+		case <-ctx.Context.Done():
+			err = io.EOF
+		case <-deadline:
+		case <-wait:
+			continue
+		}
+		break
+	}
+
+	ctx.StreamTrailer(strconv.FormatUint(seq, 10), count, err)
+	return nil, -1
+}
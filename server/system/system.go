@@ -56,6 +56,8 @@ func (t SystemRequest) RegisterRoutes(r *mux.Router) error {
 	r.HandleFunc("/tables", server.C(GetTableStats)).Methods("GET")
 	r.HandleFunc("/caches", server.C(GetCacheStats)).Methods("GET")
 	r.HandleFunc("/sysstat", server.C(GetSysStats)).Methods("GET")
+	r.HandleFunc("/openapi.json", server.C(GetOpenAPI)).Methods("GET")
+	r.HandleFunc("/changes", server.S(GetChanges)).Methods("GET")
 
 	// actions
 	r.HandleFunc("/tables/snapshot", server.C(SnapshotDatabases)).Methods("PUT")
@@ -88,6 +90,10 @@ func GetSysStats(ctx *server.Context) (interface{}, int) {
 	return s, http.StatusOK
 }
 
+func GetOpenAPI(ctx *server.Context) (interface{}, int) {
+	return ctx.Server.OpenAPI(ctx), http.StatusOK
+}
+
 func GetConfig(ctx *server.Context) (interface{}, int) {
 	return config.AllSettings(), http.StatusOK
 }
@@ -62,6 +62,8 @@ func init() {
 		"last_out_time",
 		"delegated_since_time",
 	}...)
+
+	registerTable("account", accAllAliases, StreamAccountTable)
 }
 
 // configurable marshalling helper
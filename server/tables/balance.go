@@ -49,6 +49,8 @@ func init() {
         "valid_from_time",
         "valid_until_time",
     )
+
+    registerTable("balance", balanceAllAliases, StreamBalanceTable)
 }
 
 // configurable marshalling helper
@@ -49,6 +49,8 @@ func init() {
 	ballotAllAliases = append(ballotAllAliases, "source")
 	ballotAllAliases = append(ballotAllAliases, "op")
 	ballotAllAliases = append(ballotAllAliases, "proposal")
+
+	registerTable("ballot", ballotAllAliases, StreamBallotTable)
 }
 
 // configurable marshalling helper
@@ -61,6 +61,8 @@ func init() {
 		"key_type",
 		"value_type",
 	}...)
+
+	registerTable("bigmaps", bigmapAllocAllAliases, StreamBigmapAllocTable)
 }
 
 // configurable marshalling helper
@@ -48,6 +48,8 @@ func init() {
 	bigmapUpdateSourceNames["hash"] = "-"
 	bigmapUpdateAllAliases = append(bigmapUpdateAllAliases, "op")
 	bigmapUpdateAllAliases = append(bigmapUpdateAllAliases, "hash")
+
+	registerTable("bigmap_updates", bigmapUpdateAllAliases, StreamBigmapUpdateTable)
 }
 
 // configurable marshalling helper
@@ -43,6 +43,8 @@ func init() {
 	bigmapValueSourceNames["time"] = "h"
 	bigmapValueAllAliases = append(bigmapValueAllAliases, "hash")
 	bigmapValueAllAliases = append(bigmapValueAllAliases, "time")
+
+	registerTable("bigmap_values", bigmapValueAllAliases, StreamBigmapValueTable)
 }
 
 // configurable marshalling helper
@@ -54,6 +54,8 @@ func init() {
 		"proposer",
 		"protocol",
 	)
+
+	registerTable("block", blockAllAliases, StreamBlockTable)
 }
 
 // configurable marshalling helper
@@ -36,6 +36,8 @@ func init() {
 	}
 	chainSourceNames = fields.NameMapReverse()
 	chainAllAliases = fields.Aliases()
+
+	registerTable("chain", chainAllAliases, StreamChainTable)
 }
 
 // configurable marshalling helper
@@ -39,6 +39,8 @@ func init() {
 	constantSourceNames["creator"] = "C"
 	constantSourceNames["time"] = "h"
 	constantAllAliases = append(constantAllAliases, "creator", "time")
+
+	registerTable("constant", constantAllAliases, StreamConstantTable)
 }
 
 // configurable marshalling helper
@@ -46,6 +46,8 @@ func init() {
 	contractSourceNames["first_seen_time"] = "f"
 	contractSourceNames["last_seen_time"] = "l"
 	contractAllAliases = append(contractAllAliases, "creator")
+
+	registerTable("contract", contractAllAliases, StreamContractTable)
 }
 
 // configurable marshalling helper
@@ -0,0 +1,425 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tables
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"blockwatch.cc/packdb/encoding/csv"
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+var (
+	// long -> short form for fixed custom table columns
+	customSourceNames map[string]string
+	// fixed columns as list
+	customAllAliases = []string{
+		"row_id",
+		"height",
+		"time",
+		"op_id",
+		"op",
+		"account_id",
+		"contract",
+		"sender_id",
+		"sender",
+		"bigmap_id",
+		"action",
+		"key_hash",
+	}
+)
+
+func init() {
+	fields, err := pack.Fields(&model.CustomRow{})
+	if err != nil {
+		log.Fatalf("custom row field type error: %v\n", err)
+	}
+	customSourceNames = fields.NameMapReverse()
+	customSourceNames["op"] = "o"
+	customSourceNames["contract"] = "A"
+	customSourceNames["sender"] = "S"
+}
+
+// customSchema translates between public column names defined in a custom
+// index config and typed storage slots.
+type customSchema struct {
+	sourceNames map[string]string             // long -> short form
+	allAliases  []string                      // all public columns in order
+	columns     map[string]index.CustomColumn // custom columns by name
+}
+
+func newCustomSchema(cfg index.CustomIndexConfig) *customSchema {
+	s := &customSchema{
+		sourceNames: make(map[string]string),
+		allAliases:  make([]string, len(customAllAliases), len(customAllAliases)+len(cfg.Columns)),
+		columns:     make(map[string]index.CustomColumn),
+	}
+	copy(s.allAliases, customAllAliases)
+	for _, n := range customAllAliases {
+		s.sourceNames[n] = customSourceNames[n]
+	}
+	for _, v := range cfg.Columns {
+		s.sourceNames[v.Name] = customSourceNames[v.Field()]
+		s.allAliases = append(s.allAliases, v.Name)
+		s.columns[v.Name] = v
+	}
+	return s
+}
+
+// configurable marshalling helper
+type CustomRow struct {
+	model.CustomRow
+	verbose bool            // cond. marshal
+	columns util.StringList // cond. cols & order
+	schema  *customSchema
+	ctx     *server.Context
+}
+
+func (r *CustomRow) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 512)
+	if r.verbose {
+		buf = append(buf, '{')
+	} else {
+		buf = append(buf, '[')
+	}
+	for i, v := range r.columns {
+		if r.verbose {
+			buf = strconv.AppendQuote(buf, v)
+			buf = append(buf, ':')
+		}
+		buf = r.appendValue(buf, v, true)
+		if i < len(r.columns)-1 {
+			buf = append(buf, ',')
+		}
+	}
+	if r.verbose {
+		buf = append(buf, '}')
+	} else {
+		buf = append(buf, ']')
+	}
+	return buf, nil
+}
+
+func (r *CustomRow) MarshalCSV() ([]string, error) {
+	res := make([]string, len(r.columns))
+	for i, v := range r.columns {
+		res[i] = string(r.appendValue(nil, v, false))
+	}
+	return res, nil
+}
+
+func (r *CustomRow) appendValue(buf []byte, name string, isJSON bool) []byte {
+	switch name {
+	case "row_id":
+		return strconv.AppendUint(buf, r.RowId.Value(), 10)
+	case "height":
+		return strconv.AppendInt(buf, r.Height, 10)
+	case "time":
+		return strconv.AppendInt(buf, util.UnixMilliNonZero(r.Time), 10)
+	case "op_id":
+		return strconv.AppendUint(buf, r.OpId.Value(), 10)
+	case "op":
+		return strconv.AppendQuote(buf, r.ctx.Indexer.LookupOpHash(r.ctx, r.OpId).String())
+	case "account_id":
+		return strconv.AppendUint(buf, r.AccountId.Value(), 10)
+	case "contract":
+		return strconv.AppendQuote(buf, r.ctx.Indexer.LookupAddress(r.ctx, r.AccountId).String())
+	case "sender_id":
+		return strconv.AppendUint(buf, r.SenderId.Value(), 10)
+	case "sender":
+		return strconv.AppendQuote(buf, r.ctx.Indexer.LookupAddress(r.ctx, r.SenderId).String())
+	case "bigmap_id":
+		return strconv.AppendInt(buf, r.BigmapId, 10)
+	case "action":
+		return strconv.AppendQuote(buf, r.Action)
+	case "key_hash":
+		if r.KeyHash.IsValid() {
+			return strconv.AppendQuote(buf, r.KeyHash.String())
+		}
+	default:
+		col, ok := r.schema.columns[name]
+		if !ok {
+			break
+		}
+		switch v := r.Slot(col.Type, col.Slot()).(type) {
+		case *int64:
+			return strconv.AppendInt(buf, *v, 10)
+		case *string:
+			return strconv.AppendQuote(buf, *v)
+		case *[]byte:
+			return strconv.AppendQuote(buf, hex.EncodeToString(*v))
+		case *bool:
+			return strconv.AppendBool(buf, *v)
+		}
+	}
+	if isJSON {
+		return append(buf, null...)
+	}
+	return buf
+}
+
+// StreamCustomTable serves tables of user-defined contract indexes. Column
+// names are taken from the index configuration.
+func StreamCustomTable(ctx *server.Context, args *TableRequest) (interface{}, int) {
+	// access table and index config
+	table, err := ctx.Indexer.Table(args.Table)
+	if err != nil {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("cannot access table '%s'", args.Table), err))
+	}
+	idx, err := ctx.Indexer.Index(args.Table)
+	if err != nil {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("cannot access index '%s'", args.Table), err))
+	}
+	custom, ok := idx.(*index.CustomIndex)
+	if !ok {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("no such table '%s'", args.Table), nil))
+	}
+	schema := newCustomSchema(custom.Config())
+
+	// translate long column names to short names used in pack tables
+	srcNames := make([]string, 0, len(args.Columns))
+	if len(args.Columns) == 0 {
+		args.Columns = schema.allAliases
+	}
+	for _, v := range args.Columns {
+		n, ok := schema.sourceNames[v]
+		if !ok {
+			panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", v), nil))
+		}
+		srcNames = append(srcNames, n)
+	}
+	val := &CustomRow{
+		verbose: args.Verbose,
+		columns: util.StringList(args.Columns),
+		schema:  schema,
+		ctx:     ctx,
+	}
+
+	// build table query
+	q := pack.Query{
+		Name:   ctx.RequestID,
+		Fields: table.Fields().Select(srcNames...),
+		Limit:  int(args.Limit),
+		Order:  args.Order,
+	}
+
+	// build dynamic filter conditions from query (will panic on error)
+	for key, val := range ctx.Request.URL.Query() {
+		keys := strings.Split(key, ".")
+		prefix := keys[0]
+		mode := pack.FilterModeEqual
+		if len(keys) > 1 {
+			mode = pack.ParseFilterMode(keys[1])
+			if !mode.IsValid() {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s'", keys[1]), nil))
+			}
+		}
+		switch prefix {
+		case "columns", "limit", "order", "verbose", "filename":
+			// skip these fields
+		case "cursor":
+			// add row id condition: id > cursor (new cursor == last row id)
+			id, err := strconv.ParseUint(val[0], 10, 64)
+			if err != nil {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid cursor value '%s'", val), err))
+			}
+			cursorMode := pack.FilterModeGt
+			if args.Order == pack.OrderDesc {
+				cursorMode = pack.FilterModeLt
+			}
+			q.Conditions.AddAndCondition(&pack.Condition{
+				Field: table.Fields().Pk(),
+				Mode:  cursorMode,
+				Value: id,
+				Raw:   val[0], // debugging aid
+			})
+		case "contract", "sender":
+			field := schema.sourceNames[prefix]
+			switch mode {
+			case pack.FilterModeEqual, pack.FilterModeNotEqual, pack.FilterModeIn, pack.FilterModeNotIn:
+				// resolve account ids and compile condition
+				ids := make([]uint64, 0)
+				for _, v := range strings.Split(val[0], ",") {
+					addr, err := tezos.ParseAddress(v)
+					if err != nil || !addr.IsValid() {
+						panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid address '%s'", v), err))
+					}
+					acc, err := ctx.Indexer.LookupAccount(ctx, addr)
+					if err != nil && err != index.ErrNoAccountEntry {
+						panic(err)
+					}
+					// skip not found account
+					if acc == nil || acc.RowId == 0 {
+						continue
+					}
+					ids = append(ids, acc.RowId.Value())
+				}
+				var value interface{} = ids
+				if mode == pack.FilterModeEqual || mode == pack.FilterModeNotEqual {
+					// Note: when not found we insert an always false condition
+					value = uint64(math.MaxUint64)
+					if len(ids) > 0 {
+						value = ids[0]
+					}
+				}
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find(field), // account id
+					Mode:  mode,
+					Value: value,
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		case "key_hash":
+			switch mode {
+			case pack.FilterModeEqual, pack.FilterModeNotEqual:
+				hash, err := tezos.ParseExprHash(val[0])
+				if err != nil || !hash.IsValid() {
+					panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid exprhash '%s'", val[0]), err))
+				}
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find("H"),
+					Mode:  mode,
+					Value: hash.Bytes(),
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		case "op":
+			switch mode {
+			case pack.FilterModeEqual:
+				ops, err := ctx.Indexer.LookupOp(ctx, val[0])
+				if err != nil || len(ops) == 0 {
+					panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("no such operation '%s'", val[0]), err))
+				}
+				ids := make([]uint64, 0, len(ops))
+				for _, v := range ops {
+					ids = append(ids, v.RowId.Value())
+				}
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find("o"),
+					Mode:  pack.FilterModeIn,
+					Value: ids,
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		default:
+			// translate long column name used in query to short column name used in packs
+			if short, ok := schema.sourceNames[prefix]; !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", prefix), nil))
+			} else {
+				key = strings.Replace(key, prefix, short, 1)
+			}
+
+			// the same field name may appear multiple times, in which case conditions
+			// are combined like any other condition with logical AND
+			for _, v := range val {
+				if cond, err := pack.ParseCondition(key, v, table.Fields()); err != nil {
+					panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid %s filter value '%s'", key, v), err))
+				} else {
+					q.Conditions.AddAndCondition(&cond)
+				}
+			}
+		}
+	}
+
+	var (
+		count  int
+		lastId uint64
+	)
+
+	// prepare response stream
+	ctx.StreamResponseHeaders(http.StatusOK, mimetypes[args.Format])
+
+	switch args.Format {
+	case "json":
+		enc := json.NewEncoder(ctx.ResponseWriter)
+		enc.SetIndent("", "")
+		enc.SetEscapeHTML(false)
+
+		// open JSON array
+		io.WriteString(ctx.ResponseWriter, "[")
+		// close JSON array on panic
+		defer func() {
+			if e := recover(); e != nil {
+				io.WriteString(ctx.ResponseWriter, "]")
+				panic(e)
+			}
+		}()
+
+		// run query and stream results
+		var needComma bool
+		err = table.Stream(ctx, q, func(r pack.Row) error {
+			if needComma {
+				io.WriteString(ctx.ResponseWriter, ",")
+			} else {
+				needComma = true
+			}
+			if err := r.Decode(val); err != nil {
+				return err
+			}
+			if err := enc.Encode(val); err != nil {
+				return err
+			}
+			count++
+			lastId = val.RowId.Value()
+			if args.Limit > 0 && count == int(args.Limit) {
+				return io.EOF
+			}
+			return nil
+		})
+		// close JSON bracket
+		io.WriteString(ctx.ResponseWriter, "]")
+
+	case "csv":
+		enc := csv.NewEncoder(ctx.ResponseWriter)
+		// use custom header columns and order
+		err = enc.EncodeHeader(args.Columns, nil)
+		if err == nil {
+			// run query and stream results
+			err = table.Stream(ctx, q, func(r pack.Row) error {
+				if err := r.Decode(val); err != nil {
+					return err
+				}
+				if err := enc.EncodeRecord(val); err != nil {
+					return err
+				}
+				count++
+				lastId = val.RowId.Value()
+				if args.Limit > 0 && count == int(args.Limit) {
+					return io.EOF
+				}
+				return nil
+			})
+		}
+	}
+
+	// without new records, cursor remains the same as input (may be empty)
+	cursor := args.Cursor
+	if lastId > 0 {
+		cursor = strconv.FormatUint(lastId, 10)
+	}
+
+	// write error (except EOF), cursor and count as http trailer
+	ctx.StreamTrailer(cursor, count, err)
+
+	// streaming return
+	return nil, -1
+}
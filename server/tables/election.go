@@ -43,6 +43,8 @@ func init() {
 	electionSourceNames["last_voting_period"] = "n"
 	electionAllAliases = append(electionAllAliases, "proposal")
 	electionAllAliases = append(electionAllAliases, "last_voting_period")
+
+	registerTable("election", electionAllAliases, StreamElectionTable)
 }
 
 // configurable marshalling helper
@@ -46,6 +46,8 @@ func init() {
 	flowSourceNames["counterparty"] = "R"
 	flowAllAliases = append(flowAllAliases, "address")
 	flowAllAliases = append(flowAllAliases, "counterparty")
+
+	registerTable("flow", flowAllAliases, StreamFlowTable)
 }
 
 // configurable marshalling helper
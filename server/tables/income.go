@@ -45,6 +45,8 @@ func init() {
 	incomeSourceNames["start_time"] = "c"
 	incomeSourceNames["end_time"] = "c"
 	incomeAllAliases = append(incomeAllAliases, "address", "start_time", "end_time")
+
+	registerTable("income", incomeAllAliases, StreamIncomeTable)
 }
 
 // configurable marshalling helper
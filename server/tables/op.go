@@ -79,6 +79,8 @@ func init() {
 		"entrypoint",
 		"big_map_diff",
 	)
+
+	registerTable("op", opAllAliases, StreamOpTable)
 }
 
 type OpSorter []*model.Op
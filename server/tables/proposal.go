@@ -47,6 +47,8 @@ func init() {
 
 	proposalAllAliases = append(proposalAllAliases, "source")
 	proposalAllAliases = append(proposalAllAliases, "op")
+
+	registerTable("proposal", proposalAllAliases, StreamProposalTable)
 }
 
 // configurable marshalling helper
@@ -45,6 +45,8 @@ func init() {
 	rightSourceNames["height"] = "c"
 	rightAllAliases = append(rightAllAliases, "address")
 	rightAllAliases = append(rightAllAliases, "height")
+
+	registerTable("rights", rightAllAliases, StreamRightsTable)
 }
 
 // configurable marshalling helper
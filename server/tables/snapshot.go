@@ -46,6 +46,8 @@ func init() {
 	snapAllAliases = append(snapAllAliases, "address")
 	snapAllAliases = append(snapAllAliases, "baker")
 	snapAllAliases = append(snapAllAliases, "since_time")
+
+	registerTable("snapshot", snapAllAliases, StreamSnapshotTable)
 }
 
 // configurable marshalling helper
@@ -36,6 +36,8 @@ func init() {
 	}
 	supplySourceNames = fields.NameMapReverse()
 	supplyAllAliases = fields.Aliases()
+
+	registerTable("supply", supplyAllAliases, StreamSupplyTable)
 }
 
 // configurable marshalling helper
@@ -11,6 +11,8 @@ import (
 
 	"blockwatch.cc/packdb/pack"
 	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzindex/etl"
+	"blockwatch.cc/tzindex/etl/index"
 	"blockwatch.cc/tzindex/server"
 )
 
@@ -26,6 +28,7 @@ func init() {
 }
 
 var _ server.RESTful = (*TableRequest)(nil)
+var _ server.Describer = (*TableRequest)(nil)
 
 // build packdb query from request
 type TableRequest struct {
@@ -66,6 +69,58 @@ func (t TableRequest) RegisterRoutes(r *mux.Router) error {
 	return nil
 }
 
+// tableHandler streams rows of a table.
+type tableHandler func(*server.Context, *TableRequest) (interface{}, int)
+
+type tableInfo struct {
+	columns []string
+	stream  tableHandler
+}
+
+// tableRegistry holds all built-in tables served by StreamTable, filled by
+// registerTable during package init.
+var tableRegistry = make(map[string]tableInfo)
+
+// registerTable makes a table available for streaming. Columns are the
+// table's field aliases plus any derived columns.
+func registerTable(name string, columns []string, stream tableHandler) {
+	tableRegistry[name] = tableInfo{columns: columns, stream: stream}
+}
+
+// tableColumns lists column aliases for all built-in tables and for custom
+// index tables enabled in ix.
+func tableColumns(ix *etl.Indexer) map[string][]string {
+	cols := make(map[string][]string, len(tableRegistry))
+	for name, t := range tableRegistry {
+		cols[name] = t.columns
+	}
+	if ix == nil {
+		return cols
+	}
+	for _, idx := range ix.Indexes() {
+		if custom, ok := idx.(*index.CustomIndex); ok {
+			cols[custom.Key()] = newCustomSchema(custom.Config()).allAliases
+		}
+	}
+	return cols
+}
+
+// RESTDescribe documents one route per table with its columns and filters.
+func (t TableRequest) RESTDescribe(ctx *server.Context, method, path string) []server.ApiDoc {
+	docs := make([]server.ApiDoc, 0)
+	for name, cols := range tableColumns(ctx.Indexer) {
+		docs = append(docs, server.ApiDoc{
+			Path:     strings.Replace(path, "{table}", name, 1),
+			Summary:  "Stream " + name + " table rows",
+			Args:     TableRequest{},
+			Response: [][]interface{}{},
+			Columns:  cols,
+			Filters:  cols,
+		})
+	}
+	return docs
+}
+
 func (t *TableRequest) Parse(ctx *server.Context) {
 	t.Limit = ctx.Cfg.ClampList(t.Limit)
 
@@ -100,46 +155,11 @@ func (t *TableRequest) Parse(ctx *server.Context) {
 func StreamTable(ctx *server.Context) (interface{}, int) {
 	args := &TableRequest{}
 	ctx.ParseRequestArgs(args)
-	switch args.Table {
-	case "block":
-		return StreamBlockTable(ctx, args)
-	case "chain":
-		return StreamChainTable(ctx, args)
-	case "supply":
-		return StreamSupplyTable(ctx, args)
-	case "op":
-		return StreamOpTable(ctx, args)
-	case "flow":
-		return StreamFlowTable(ctx, args)
-	case "contract":
-		return StreamContractTable(ctx, args)
-	case "account":
-		return StreamAccountTable(ctx, args)
-	case "rights":
-		return StreamRightsTable(ctx, args)
-	case "snapshot":
-		return StreamSnapshotTable(ctx, args)
-	case "election":
-		return StreamElectionTable(ctx, args)
-	case "proposal":
-		return StreamProposalTable(ctx, args)
-	case "vote":
-		return StreamVoteTable(ctx, args)
-	case "ballot":
-		return StreamBallotTable(ctx, args)
-	case "income":
-		return StreamIncomeTable(ctx, args)
-	case "bigmaps":
-		return StreamBigmapAllocTable(ctx, args)
-	case "bigmap_values":
-		return StreamBigmapValueTable(ctx, args)
-	case "bigmap_updates":
-		return StreamBigmapUpdateTable(ctx, args)
-	case "constant":
-		return StreamConstantTable(ctx, args)
-	case "balance":
-		return StreamBalanceTable(ctx, args)
-	default:
-		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("no such table '%s'", args.Table), nil))
+	if t, ok := tableRegistry[args.Table]; ok {
+		return t.stream(ctx, args)
+	}
+	if strings.HasPrefix(args.Table, index.CustomIndexPrefix) {
+		return StreamCustomTable(ctx, args)
 	}
+	panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("no such table '%s'", args.Table), nil))
 }
@@ -0,0 +1,727 @@
+// Copyright (c) 2022 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tables
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"blockwatch.cc/packdb/encoding/csv"
+	"blockwatch.cc/packdb/pack"
+	"blockwatch.cc/packdb/util"
+	"blockwatch.cc/tzgo/tezos"
+	"blockwatch.cc/tzindex/etl/index"
+	"blockwatch.cc/tzindex/etl/model"
+	"blockwatch.cc/tzindex/server"
+)
+
+var (
+	// long -> short form
+	ticketTypeSourceNames    map[string]string
+	ticketBalanceSourceNames map[string]string
+	ticketUpdateSourceNames  map[string]string
+	// all aliases as list
+	ticketTypeAllAliases    []string
+	ticketBalanceAllAliases []string
+	ticketUpdateAllAliases  []string
+)
+
+func init() {
+	fields, err := pack.Fields(&model.TicketType{})
+	if err != nil {
+		log.Fatalf("ticket type field type error: %v\n", err)
+	}
+	ticketTypeSourceNames = fields.NameMapReverse()
+	ticketTypeAllAliases = fields.Aliases()
+	ticketTypeSourceNames["ticketer"] = "A"
+	ticketTypeAllAliases = append(ticketTypeAllAliases, "ticketer")
+
+	fields, err = pack.Fields(&model.TicketBalance{})
+	if err != nil {
+		log.Fatalf("ticket balance field type error: %v\n", err)
+	}
+	ticketBalanceSourceNames = fields.NameMapReverse()
+	ticketBalanceAllAliases = fields.Aliases()
+	ticketBalanceSourceNames["address"] = "A"
+	ticketBalanceAllAliases = append(ticketBalanceAllAliases, "address")
+
+	fields, err = pack.Fields(&model.TicketUpdate{})
+	if err != nil {
+		log.Fatalf("ticket update field type error: %v\n", err)
+	}
+	ticketUpdateSourceNames = fields.NameMapReverse()
+	ticketUpdateAllAliases = fields.Aliases()
+	ticketUpdateSourceNames["address"] = "A"
+	ticketUpdateSourceNames["op"] = "o"
+	ticketUpdateAllAliases = append(ticketUpdateAllAliases, "address", "op")
+
+	registerTable("ticket_type", ticketTypeAllAliases, StreamTicketTable)
+	registerTable("ticket_balance", ticketBalanceAllAliases, StreamTicketTable)
+	registerTable("ticket_update", ticketUpdateAllAliases, StreamTicketTable)
+}
+
+// ticketRow is implemented by all ticket table marshalling helpers
+type ticketRow interface {
+	json.Marshaler
+	csv.Marshaler
+	id() uint64
+}
+
+// configurable marshalling helper
+type TicketType struct {
+	model.TicketType
+	verbose bool            // cond. marshal
+	columns util.StringList // cond. cols & order when brief
+	ctx     *server.Context
+}
+
+func (t *TicketType) id() uint64 {
+	return t.RowId.Value()
+}
+
+func (t *TicketType) MarshalJSON() ([]byte, error) {
+	if t.verbose {
+		return t.MarshalJSONVerbose()
+	} else {
+		return t.MarshalJSONBrief()
+	}
+}
+
+func (t *TicketType) MarshalJSONVerbose() ([]byte, error) {
+	val := struct {
+		RowId       uint64 `json:"row_id"`
+		TicketerId  uint64 `json:"ticketer_id"`
+		Ticketer    string `json:"ticketer"`
+		Type        string `json:"type"`
+		Content     string `json:"content"`
+		Hash        string `json:"hash"`
+		FirstBlock  int64  `json:"first_block"`
+		LastBlock   int64  `json:"last_block"`
+		TotalSupply int64  `json:"total_supply"`
+		TotalMint   int64  `json:"total_mint"`
+		TotalBurn   int64  `json:"total_burn"`
+		NHolders    int    `json:"n_holders"`
+		NTransfers  int    `json:"n_transfers"`
+	}{
+		RowId:       t.RowId.Value(),
+		TicketerId:  t.Ticketer.Value(),
+		Ticketer:    t.ctx.Indexer.LookupAddress(t.ctx, t.Ticketer).String(),
+		Type:        hex.EncodeToString(t.Type),
+		Content:     hex.EncodeToString(t.Content),
+		Hash:        t.GetHash().String(),
+		FirstBlock:  t.FirstBlock,
+		LastBlock:   t.LastBlock,
+		TotalSupply: t.TotalSupply,
+		TotalMint:   t.TotalMint,
+		TotalBurn:   t.TotalBurn,
+		NHolders:    t.NHolders,
+		NTransfers:  t.NTransfers,
+	}
+	return json.Marshal(val)
+}
+
+func (t *TicketType) MarshalJSONBrief() ([]byte, error) {
+	buf := make([]byte, 0, 1024)
+	buf = append(buf, '[')
+	for i, v := range t.columns {
+		switch v {
+		case "row_id":
+			buf = strconv.AppendUint(buf, t.RowId.Value(), 10)
+		case "ticketer_id":
+			buf = strconv.AppendUint(buf, t.Ticketer.Value(), 10)
+		case "ticketer":
+			buf = strconv.AppendQuote(buf, t.ctx.Indexer.LookupAddress(t.ctx, t.Ticketer).String())
+		case "type":
+			buf = strconv.AppendQuote(buf, hex.EncodeToString(t.Type))
+		case "content":
+			buf = strconv.AppendQuote(buf, hex.EncodeToString(t.Content))
+		case "hash":
+			buf = strconv.AppendQuote(buf, t.GetHash().String())
+		case "first_block":
+			buf = strconv.AppendInt(buf, t.FirstBlock, 10)
+		case "last_block":
+			buf = strconv.AppendInt(buf, t.LastBlock, 10)
+		case "total_supply":
+			buf = strconv.AppendInt(buf, t.TotalSupply, 10)
+		case "total_mint":
+			buf = strconv.AppendInt(buf, t.TotalMint, 10)
+		case "total_burn":
+			buf = strconv.AppendInt(buf, t.TotalBurn, 10)
+		case "n_holders":
+			buf = strconv.AppendInt(buf, int64(t.NHolders), 10)
+		case "n_transfers":
+			buf = strconv.AppendInt(buf, int64(t.NTransfers), 10)
+		default:
+			continue
+		}
+		if i < len(t.columns)-1 {
+			buf = append(buf, ',')
+		}
+	}
+	buf = append(buf, ']')
+	return buf, nil
+}
+
+func (t *TicketType) MarshalCSV() ([]string, error) {
+	res := make([]string, len(t.columns))
+	for i, v := range t.columns {
+		switch v {
+		case "row_id":
+			res[i] = strconv.FormatUint(t.RowId.Value(), 10)
+		case "ticketer_id":
+			res[i] = strconv.FormatUint(t.Ticketer.Value(), 10)
+		case "ticketer":
+			res[i] = strconv.Quote(t.ctx.Indexer.LookupAddress(t.ctx, t.Ticketer).String())
+		case "type":
+			res[i] = strconv.Quote(hex.EncodeToString(t.Type))
+		case "content":
+			res[i] = strconv.Quote(hex.EncodeToString(t.Content))
+		case "hash":
+			res[i] = strconv.Quote(t.GetHash().String())
+		case "first_block":
+			res[i] = strconv.FormatInt(t.FirstBlock, 10)
+		case "last_block":
+			res[i] = strconv.FormatInt(t.LastBlock, 10)
+		case "total_supply":
+			res[i] = strconv.FormatInt(t.TotalSupply, 10)
+		case "total_mint":
+			res[i] = strconv.FormatInt(t.TotalMint, 10)
+		case "total_burn":
+			res[i] = strconv.FormatInt(t.TotalBurn, 10)
+		case "n_holders":
+			res[i] = strconv.Itoa(t.NHolders)
+		case "n_transfers":
+			res[i] = strconv.Itoa(t.NTransfers)
+		default:
+			continue
+		}
+	}
+	return res, nil
+}
+
+// configurable marshalling helper
+type TicketBalance struct {
+	model.TicketBalance
+	verbose bool            // cond. marshal
+	columns util.StringList // cond. cols & order when brief
+	ctx     *server.Context
+}
+
+func (b *TicketBalance) id() uint64 {
+	return b.RowId.Value()
+}
+
+func (b *TicketBalance) MarshalJSON() ([]byte, error) {
+	if b.verbose {
+		return b.MarshalJSONVerbose()
+	} else {
+		return b.MarshalJSONBrief()
+	}
+}
+
+func (b *TicketBalance) MarshalJSONVerbose() ([]byte, error) {
+	val := struct {
+		RowId      uint64 `json:"row_id"`
+		TicketId   uint64 `json:"ticket_id"`
+		AccountId  uint64 `json:"account_id"`
+		Address    string `json:"address"`
+		Balance    int64  `json:"balance"`
+		FirstBlock int64  `json:"first_block"`
+		LastBlock  int64  `json:"last_block"`
+		NTransfers int    `json:"n_transfers"`
+	}{
+		RowId:      b.RowId.Value(),
+		TicketId:   b.TicketId.Value(),
+		AccountId:  b.AccountId.Value(),
+		Address:    b.ctx.Indexer.LookupAddress(b.ctx, b.AccountId).String(),
+		Balance:    b.Balance,
+		FirstBlock: b.FirstBlock,
+		LastBlock:  b.LastBlock,
+		NTransfers: b.NTransfers,
+	}
+	return json.Marshal(val)
+}
+
+func (b *TicketBalance) MarshalJSONBrief() ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '[')
+	for i, v := range b.columns {
+		switch v {
+		case "row_id":
+			buf = strconv.AppendUint(buf, b.RowId.Value(), 10)
+		case "ticket_id":
+			buf = strconv.AppendUint(buf, b.TicketId.Value(), 10)
+		case "account_id":
+			buf = strconv.AppendUint(buf, b.AccountId.Value(), 10)
+		case "address":
+			buf = strconv.AppendQuote(buf, b.ctx.Indexer.LookupAddress(b.ctx, b.AccountId).String())
+		case "balance":
+			buf = strconv.AppendInt(buf, b.Balance, 10)
+		case "first_block":
+			buf = strconv.AppendInt(buf, b.FirstBlock, 10)
+		case "last_block":
+			buf = strconv.AppendInt(buf, b.LastBlock, 10)
+		case "n_transfers":
+			buf = strconv.AppendInt(buf, int64(b.NTransfers), 10)
+		default:
+			continue
+		}
+		if i < len(b.columns)-1 {
+			buf = append(buf, ',')
+		}
+	}
+	buf = append(buf, ']')
+	return buf, nil
+}
+
+func (b *TicketBalance) MarshalCSV() ([]string, error) {
+	res := make([]string, len(b.columns))
+	for i, v := range b.columns {
+		switch v {
+		case "row_id":
+			res[i] = strconv.FormatUint(b.RowId.Value(), 10)
+		case "ticket_id":
+			res[i] = strconv.FormatUint(b.TicketId.Value(), 10)
+		case "account_id":
+			res[i] = strconv.FormatUint(b.AccountId.Value(), 10)
+		case "address":
+			res[i] = strconv.Quote(b.ctx.Indexer.LookupAddress(b.ctx, b.AccountId).String())
+		case "balance":
+			res[i] = strconv.FormatInt(b.Balance, 10)
+		case "first_block":
+			res[i] = strconv.FormatInt(b.FirstBlock, 10)
+		case "last_block":
+			res[i] = strconv.FormatInt(b.LastBlock, 10)
+		case "n_transfers":
+			res[i] = strconv.Itoa(b.NTransfers)
+		default:
+			continue
+		}
+	}
+	return res, nil
+}
+
+// configurable marshalling helper
+type TicketUpdate struct {
+	model.TicketUpdate
+	verbose bool            // cond. marshal
+	columns util.StringList // cond. cols & order when brief
+	ctx     *server.Context
+}
+
+func (u *TicketUpdate) id() uint64 {
+	return u.RowId.Value()
+}
+
+func (u *TicketUpdate) MarshalJSON() ([]byte, error) {
+	if u.verbose {
+		return u.MarshalJSONVerbose()
+	} else {
+		return u.MarshalJSONBrief()
+	}
+}
+
+func (u *TicketUpdate) MarshalJSONVerbose() ([]byte, error) {
+	val := struct {
+		RowId     uint64 `json:"row_id"`
+		TicketId  uint64 `json:"ticket_id"`
+		AccountId uint64 `json:"account_id"`
+		Address   string `json:"address"`
+		Amount    int64  `json:"amount"`
+		Height    int64  `json:"height"`
+		Time      int64  `json:"time"`
+		OpId      uint64 `json:"op_id"`
+		Op        string `json:"op"`
+		Holder    string `json:"holder"`
+	}{
+		RowId:     u.RowId.Value(),
+		TicketId:  u.TicketId.Value(),
+		AccountId: u.AccountId.Value(),
+		Address:   u.ctx.Indexer.LookupAddress(u.ctx, u.AccountId).String(),
+		Amount:    u.Amount,
+		Height:    u.Height,
+		Time:      util.UnixMilliNonZero(u.Time),
+		OpId:      u.OpId.Value(),
+		Op:        u.ctx.Indexer.LookupOpHash(u.ctx, u.OpId).String(),
+		Holder:    u.Holder,
+	}
+	return json.Marshal(val)
+}
+
+func (u *TicketUpdate) MarshalJSONBrief() ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '[')
+	for i, v := range u.columns {
+		switch v {
+		case "row_id":
+			buf = strconv.AppendUint(buf, u.RowId.Value(), 10)
+		case "ticket_id":
+			buf = strconv.AppendUint(buf, u.TicketId.Value(), 10)
+		case "account_id":
+			buf = strconv.AppendUint(buf, u.AccountId.Value(), 10)
+		case "address":
+			buf = strconv.AppendQuote(buf, u.ctx.Indexer.LookupAddress(u.ctx, u.AccountId).String())
+		case "amount":
+			buf = strconv.AppendInt(buf, u.Amount, 10)
+		case "height":
+			buf = strconv.AppendInt(buf, u.Height, 10)
+		case "time":
+			buf = strconv.AppendInt(buf, util.UnixMilliNonZero(u.Time), 10)
+		case "op_id":
+			buf = strconv.AppendUint(buf, u.OpId.Value(), 10)
+		case "op":
+			buf = strconv.AppendQuote(buf, u.ctx.Indexer.LookupOpHash(u.ctx, u.OpId).String())
+		case "holder":
+			buf = strconv.AppendQuote(buf, u.Holder)
+		default:
+			continue
+		}
+		if i < len(u.columns)-1 {
+			buf = append(buf, ',')
+		}
+	}
+	buf = append(buf, ']')
+	return buf, nil
+}
+
+func (u *TicketUpdate) MarshalCSV() ([]string, error) {
+	res := make([]string, len(u.columns))
+	for i, v := range u.columns {
+		switch v {
+		case "row_id":
+			res[i] = strconv.FormatUint(u.RowId.Value(), 10)
+		case "ticket_id":
+			res[i] = strconv.FormatUint(u.TicketId.Value(), 10)
+		case "account_id":
+			res[i] = strconv.FormatUint(u.AccountId.Value(), 10)
+		case "address":
+			res[i] = strconv.Quote(u.ctx.Indexer.LookupAddress(u.ctx, u.AccountId).String())
+		case "amount":
+			res[i] = strconv.FormatInt(u.Amount, 10)
+		case "height":
+			res[i] = strconv.FormatInt(u.Height, 10)
+		case "time":
+			res[i] = strconv.FormatInt(util.UnixMilliNonZero(u.Time), 10)
+		case "op_id":
+			res[i] = strconv.FormatUint(u.OpId.Value(), 10)
+		case "op":
+			res[i] = strconv.Quote(u.ctx.Indexer.LookupOpHash(u.ctx, u.OpId).String())
+		case "holder":
+			res[i] = strconv.Quote(u.Holder)
+		default:
+			continue
+		}
+	}
+	return res, nil
+}
+
+// StreamTicketTable serves the ticket_type, ticket_balance and ticket_update
+// tables which share the same filter logic.
+func StreamTicketTable(ctx *server.Context, args *TableRequest) (interface{}, int) {
+	// access table
+	table, err := ctx.Indexer.Table(args.Table)
+	if err != nil {
+		panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("cannot access table '%s'", args.Table), err))
+	}
+
+	// select table specific names and return type marshalling
+	var (
+		sourceNames map[string]string
+		allAliases  []string
+		val         ticketRow
+	)
+	switch args.Table {
+	case index.TicketTypeTableKey:
+		sourceNames, allAliases = ticketTypeSourceNames, ticketTypeAllAliases
+		val = &TicketType{verbose: args.Verbose, ctx: ctx}
+	case index.TicketBalanceTableKey:
+		sourceNames, allAliases = ticketBalanceSourceNames, ticketBalanceAllAliases
+		val = &TicketBalance{verbose: args.Verbose, ctx: ctx}
+	default:
+		sourceNames, allAliases = ticketUpdateSourceNames, ticketUpdateAllAliases
+		val = &TicketUpdate{verbose: args.Verbose, ctx: ctx}
+	}
+
+	// translate long column names to short names used in pack tables
+	var srcNames []string
+	if len(args.Columns) > 0 {
+		// resolve short column names
+		srcNames = make([]string, 0, len(args.Columns))
+		for _, v := range args.Columns {
+			n, ok := sourceNames[v]
+			if !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", v), nil))
+			}
+			if n != "-" {
+				srcNames = append(srcNames, n)
+			}
+		}
+	} else {
+		// use all table columns in order and reverse lookup their long names
+		srcNames = table.Fields().Names()
+		args.Columns = allAliases
+	}
+	switch v := val.(type) {
+	case *TicketType:
+		v.columns = util.StringList(args.Columns)
+	case *TicketBalance:
+		v.columns = util.StringList(args.Columns)
+	case *TicketUpdate:
+		v.columns = util.StringList(args.Columns)
+	}
+
+	// build table query
+	q := pack.Query{
+		Name:   ctx.RequestID,
+		Fields: table.Fields().Select(srcNames...),
+		Limit:  int(args.Limit),
+		Order:  args.Order,
+	}
+
+	// build dynamic filter conditions from query (will panic on error)
+	for key, val := range ctx.Request.URL.Query() {
+		keys := strings.Split(key, ".")
+		prefix := keys[0]
+		mode := pack.FilterModeEqual
+		if len(keys) > 1 {
+			mode = pack.ParseFilterMode(keys[1])
+			if !mode.IsValid() {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s'", keys[1]), nil))
+			}
+		}
+		switch prefix {
+		case "columns", "limit", "order", "verbose", "filename":
+			// skip these fields
+		case "cursor":
+			// add row id condition: id > cursor (new cursor == last row id)
+			id, err := strconv.ParseUint(val[0], 10, 64)
+			if err != nil {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid cursor value '%s'", val), err))
+			}
+			cursorMode := pack.FilterModeGt
+			if args.Order == pack.OrderDesc {
+				cursorMode = pack.FilterModeLt
+			}
+			q.Conditions.AddAndCondition(&pack.Condition{
+				Field: table.Fields().Pk(),
+				Mode:  cursorMode,
+				Value: id,
+				Raw:   val[0], // debugging aid
+			})
+		case "ticketer", "address":
+			if _, ok := sourceNames[prefix]; !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", prefix), nil))
+			}
+			field := "A" // account
+			switch mode {
+			case pack.FilterModeEqual, pack.FilterModeNotEqual:
+				// single-address lookup and compile condition
+				addr, err := tezos.ParseAddress(val[0])
+				if err != nil || !addr.IsValid() {
+					panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid address '%s'", val[0]), err))
+				}
+				acc, err := ctx.Indexer.LookupAccount(ctx, addr)
+				if err != nil && err != index.ErrNoAccountEntry {
+					panic(err)
+				}
+				// Note: when not found we insert an always false condition
+				if acc == nil || acc.RowId == 0 {
+					q.Conditions.AddAndCondition(&pack.Condition{
+						Field: table.Fields().Find(field), // account id
+						Mode:  mode,
+						Value: uint64(math.MaxUint64),
+						Raw:   "account not found", // debugging aid
+					})
+				} else {
+					// add id as extra condition
+					q.Conditions.AddAndCondition(&pack.Condition{
+						Field: table.Fields().Find(field), // account id
+						Mode:  mode,
+						Value: acc.RowId,
+						Raw:   val[0], // debugging aid
+					})
+				}
+			case pack.FilterModeIn, pack.FilterModeNotIn:
+				// multi-address lookup and compile condition
+				ids := make([]uint64, 0)
+				for _, v := range strings.Split(val[0], ",") {
+					addr, err := tezos.ParseAddress(v)
+					if err != nil || !addr.IsValid() {
+						panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid address '%s'", v), err))
+					}
+					acc, err := ctx.Indexer.LookupAccount(ctx, addr)
+					if err != nil && err != index.ErrNoAccountEntry {
+						panic(err)
+					}
+					// skip not found account
+					if acc == nil || acc.RowId == 0 {
+						continue
+					}
+					// collect list of account ids
+					ids = append(ids, acc.RowId.Value())
+				}
+				// Note: when list is empty (no accounts were found, the match will
+				//       always be false and return no result as expected)
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find(field), // account id
+					Mode:  mode,
+					Value: ids,
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		case "hash":
+			if _, ok := sourceNames[prefix]; !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", prefix), nil))
+			}
+			switch mode {
+			case pack.FilterModeEqual, pack.FilterModeNotEqual:
+				hash, err := tezos.ParseExprHash(val[0])
+				if err != nil || !hash.IsValid() {
+					panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid exprhash '%s'", val[0]), err))
+				}
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find("H"),
+					Mode:  mode,
+					Value: hash.Bytes(),
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		case "op":
+			if _, ok := sourceNames[prefix]; !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", prefix), nil))
+			}
+			switch mode {
+			case pack.FilterModeEqual:
+				ops, err := ctx.Indexer.LookupOp(ctx, val[0])
+				if err != nil || len(ops) == 0 {
+					panic(server.ENotFound(server.EC_RESOURCE_NOTFOUND, fmt.Sprintf("no such operation '%s'", val[0]), err))
+				}
+				ids := make([]uint64, 0, len(ops))
+				for _, v := range ops {
+					ids = append(ids, v.RowId.Value())
+				}
+				q.Conditions.AddAndCondition(&pack.Condition{
+					Field: table.Fields().Find("o"),
+					Mode:  pack.FilterModeIn,
+					Value: ids,
+					Raw:   val[0], // debugging aid
+				})
+			default:
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid filter mode '%s' for column '%s'", mode, prefix), nil))
+			}
+		default:
+			// translate long column name used in query to short column name used in packs
+			if short, ok := sourceNames[prefix]; !ok {
+				panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("unknown column '%s'", prefix), nil))
+			} else {
+				key = strings.Replace(key, prefix, short, 1)
+			}
+
+			// the same field name may appear multiple times, in which case conditions
+			// are combined like any other condition with logical AND
+			for _, v := range val {
+				if cond, err := pack.ParseCondition(key, v, table.Fields()); err != nil {
+					panic(server.EBadRequest(server.EC_PARAM_INVALID, fmt.Sprintf("invalid %s filter value '%s'", key, v), err))
+				} else {
+					q.Conditions.AddAndCondition(&cond)
+				}
+			}
+		}
+	}
+
+	var (
+		count  int
+		lastId uint64
+	)
+
+	// prepare response stream
+	ctx.StreamResponseHeaders(http.StatusOK, mimetypes[args.Format])
+
+	switch args.Format {
+	case "json":
+		enc := json.NewEncoder(ctx.ResponseWriter)
+		enc.SetIndent("", "")
+		enc.SetEscapeHTML(false)
+
+		// open JSON array
+		io.WriteString(ctx.ResponseWriter, "[")
+		// close JSON array on panic
+		defer func() {
+			if e := recover(); e != nil {
+				io.WriteString(ctx.ResponseWriter, "]")
+				panic(e)
+			}
+		}()
+
+		// run query and stream results
+		var needComma bool
+		err = table.Stream(ctx, q, func(r pack.Row) error {
+			if needComma {
+				io.WriteString(ctx.ResponseWriter, ",")
+			} else {
+				needComma = true
+			}
+			if err := r.Decode(val); err != nil {
+				return err
+			}
+			if err := enc.Encode(val); err != nil {
+				return err
+			}
+			count++
+			lastId = val.id()
+			if args.Limit > 0 && count == int(args.Limit) {
+				return io.EOF
+			}
+			return nil
+		})
+		// close JSON bracket
+		io.WriteString(ctx.ResponseWriter, "]")
+
+	case "csv":
+		enc := csv.NewEncoder(ctx.ResponseWriter)
+		// use custom header columns and order
+		if len(args.Columns) > 0 {
+			err = enc.EncodeHeader(args.Columns, nil)
+		}
+		if err == nil {
+			// run query and stream results
+			err = table.Stream(ctx, q, func(r pack.Row) error {
+				if err := r.Decode(val); err != nil {
+					return err
+				}
+				if err := enc.EncodeRecord(val); err != nil {
+					return err
+				}
+				count++
+				lastId = val.id()
+				if args.Limit > 0 && count == int(args.Limit) {
+					return io.EOF
+				}
+				return nil
+			})
+		}
+	}
+
+	// without new records, cursor remains the same as input (may be empty)
+	cursor := args.Cursor
+	if lastId > 0 {
+		cursor = strconv.FormatUint(lastId, 10)
+	}
+
+	// write error (except EOF), cursor and count as http trailer
+	ctx.StreamTrailer(cursor, count, err)
+
+	// streaming return
+	return nil, -1
+}
@@ -41,6 +41,8 @@ func init() {
 	// add extra translations
 	voteSourceNames["proposal"] = "P"
 	voteAllAliases = append(voteAllAliases, "proposal")
+
+	registerTable("vote", voteAllAliases, StreamVoteTable)
 }
 
 // configurable marshalling helper